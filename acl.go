@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	winacl "github.com/kgoins/go-winacl/pkg"
+)
+
+func aclMain(args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "diff":
+			return aclDiffMain(args[1:])
+		case "explain":
+			return aclExplainMain(args[1:])
+		}
+	}
+	return aclShowMain(args)
+}
+
+func aclShowMain(args []string) error {
+	var sidMaps stringList
+	flags := flag.NewFlagSet("acl", flag.ExitOnError)
+	format := flags.String("format", "dacl", "Output format: [dacl|json|sddl]")
+	kind := flags.String("kind", "auto", "Rights vocabulary: [auto|file|ds]")
+	flags.Var(&sidMaps, "sids", "File of SID names to resolve principals with. May be repeated")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: ino acl [options] <descriptor>\n")
+		fmt.Fprintf(flags.Output(), "       ino acl diff [options] <before> <after>\n")
+		fmt.Fprintf(flags.Output(), "       ino acl explain [options] <descriptor>\n\n")
+		fmt.Fprintf(flags.Output(), "Print a security descriptor. The descriptor may be a file, - for stdin,\n")
+		fmt.Fprintf(flags.Output(), "or given inline, as binary, base64, hex or SDDL\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	for _, path := range sidMaps {
+		if err := loadSIDMap(path); err != nil {
+			return err
+		}
+	}
+
+	sd, err := loadDescriptor(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("%s %s", flags.Arg(0), err)
+	}
+
+	objectKind, ok := parseObjectKind(*kind, sd)
+	if !ok {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	switch *format {
+	case "dacl":
+		jsPrint(newDACL(sd, objectKind))
+	case "json":
+		jsPrint(sd)
+	case "sddl":
+		fmt.Println(sd.ToSDDL())
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+	return nil
+}
+
+func aclDiffMain(args []string) error {
+	flags := flag.NewFlagSet("acl diff", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "Print the differences as JSON")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: ino acl diff [options] <before> <after>\n\n")
+		fmt.Fprintf(flags.Output(), "Compare two security descriptors. Exits 1 when they differ\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	differ, err := printACLDiff(os.Stdout, flags.Arg(0), flags.Arg(1), *asJSON)
+	if err != nil {
+		return err
+	}
+	if differ {
+		os.Exit(1)
+	}
+	return nil
+}
+
+// printACLDiff writes the differences between two descriptors to w, as
+// text or JSON, and reports whether there are any
+func printACLDiff(w io.Writer, beforeSource, afterSource string, asJSON bool) (bool, error) {
+	before, err := loadDescriptor(beforeSource)
+	if err != nil {
+		return false, fmt.Errorf("%s %s", beforeSource, err)
+	}
+	after, err := loadDescriptor(afterSource)
+	if err != nil {
+		return false, fmt.Errorf("%s %s", afterSource, err)
+	}
+
+	diff := winacl.Diff(before, after)
+	if asJSON {
+		serialized, err := json.Marshal(diff)
+		if err != nil {
+			return false, err
+		}
+		fmt.Fprintln(w, string(serialized))
+	} else {
+		fmt.Fprint(w, diff.String())
+	}
+	return !diff.Empty(), nil
+}
+
+func aclExplainMain(args []string) error {
+	var sidMaps stringList
+	flags := flag.NewFlagSet("acl explain", flag.ExitOnError)
+	asTable := flags.Bool("table", false, "Print the ACEs as an aligned table")
+	asJSON := flags.Bool("json", false, "Print the explanation as JSON")
+	kind := flags.String("kind", "auto", "Rights vocabulary: [auto|file|ds]")
+	flags.Var(&sidMaps, "sids", "File of SID names to resolve principals with. May be repeated")
+	parent := flags.String("parent", "", "Name of the parent that inherited ACEs came from")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: ino acl explain [options] <descriptor>\n\n")
+		fmt.Fprintf(flags.Output(), "Explain a security descriptor in plain English. The descriptor may be a\n")
+		fmt.Fprintf(flags.Output(), "file, - for stdin, or given inline, as binary, base64, hex or SDDL\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	for _, path := range sidMaps {
+		if err := loadSIDMap(path); err != nil {
+			return err
+		}
+	}
+
+	sd, err := loadDescriptor(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("%s %s", flags.Arg(0), err)
+	}
+
+	objectKind, ok := parseObjectKind(*kind, sd)
+	if !ok {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	opts := winacl.ExplainOptions{
+		Kind:          objectKind,
+		Resolve:       sidResolve,
+		InheritedFrom: *parent,
+	}
+
+	switch {
+	case *asJSON:
+		jsPrint(struct {
+			Statements []string
+			DACL       []winacl.Explanation
+			SACL       []winacl.Explanation `json:",omitempty"`
+		}{sd.Explain(opts), sd.DACL.Explain(opts), sd.SACL.Explain(opts)})
+	case *asTable:
+		return winacl.WriteExplanationTable(os.Stdout, sd.DACL.Explain(opts))
+	default:
+		for _, statement := range sd.Explain(opts) {
+			fmt.Println(statement)
+		}
+	}
+	return nil
+}
+
+// parseObjectKind interprets a -kind flag. Object ACEs only exist in
+// directory service descriptors, so auto relies on the DACL's revision
+func parseObjectKind(kind string, sd winacl.NtSecurityDescriptor) (winacl.ObjectKind, bool) {
+	switch kind {
+	case "file":
+		return winacl.FileObject, true
+	case "ds":
+		return winacl.DirectoryServiceObject, true
+	case "auto":
+		if sd.DACL.Header.Revision == winacl.ACLRevisionDS {
+			return winacl.DirectoryServiceObject, true
+		}
+		return winacl.FileObject, true
+	}
+	return winacl.FileObject, false
+}
+
+// loadDescriptor reads a security descriptor from a file, from stdin when
+// source is -, or from source itself when no such file exists. It may be
+// held as raw self-relative bytes, their hex or base64 encoding, or SDDL
+func loadDescriptor(source string) (winacl.NtSecurityDescriptor, error) {
+	var data []byte
+	var err error
+	switch {
+	case source == "-":
+		data, err = io.ReadAll(os.Stdin)
+	case fileExists(source):
+		data, err = os.ReadFile(source)
+	default:
+		data = []byte(source)
+	}
+	if err != nil {
+		return winacl.NtSecurityDescriptor{}, err
+	}
+
+	text := strings.TrimSpace(string(data))
+	if sddlPrefix.MatchString(text) {
+		return winacl.NewNtSecurityDescriptorFromSDDL(text)
+	}
+
+	packed := strings.Join(strings.Fields(text), "")
+	if decoded, err := hex.DecodeString(strings.TrimPrefix(packed, "0x")); err == nil && len(decoded) > 0 {
+		data = decoded
+	} else if decoded, err := base64.StdEncoding.DecodeString(packed); err == nil && len(decoded) > 0 {
+		data = decoded
+	}
+	return winacl.NewNtSecurityDescriptor(data)
+}
+
+// sddlPrefix matches the start of an SDDL string
+var sddlPrefix = regexp.MustCompile(`^[OGDS]:`)
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
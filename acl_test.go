@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	winacl "github.com/kgoins/go-winacl/pkg"
+)
+
+func TestLoadDescriptor(t *testing.T) {
+	const sddl = "O:BAG:SYD:P(A;OICI;FA;;;SY)(A;OICI;0x1200a9;;;BU)"
+	sd, err := winacl.NewNtSecurityDescriptorFromSDDL(sddl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := sd.ToBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := buf.Bytes()
+	encoded := hex.EncodeToString(raw)
+
+	// hex dumps are often wrapped, or split into bytes
+	var wrapped strings.Builder
+	for i := 0; i < len(encoded); i += 32 {
+		end := i + 32
+		if end > len(encoded) {
+			end = len(encoded)
+		}
+		wrapped.WriteString(encoded[i:end] + "\n")
+	}
+	var pairs []string
+	for i := 0; i < len(encoded); i += 2 {
+		pairs = append(pairs, encoded[i:i+2])
+	}
+	want := sd.ToSDDL()
+
+	sources := []struct {
+		name string
+		data string
+	}{
+		{"SDDL", sddl},
+		{"SDDL with a newline", sddl + "\n"},
+		{"hex", encoded},
+		{"hex with 0x", "0x" + encoded},
+		{"wrapped hex", "0x" + wrapped.String()},
+		{"spaced hex", strings.Join(pairs, " ")},
+		{"base64", base64.StdEncoding.EncodeToString(raw)},
+		{"raw", string(raw)},
+	}
+	for _, source := range sources {
+		t.Run(source.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "sd")
+			if err := os.WriteFile(path, []byte(source.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := loadDescriptor(path)
+			if err != nil {
+				t.Fatalf("from a file: %v", err)
+			}
+			if got.ToSDDL() != want {
+				t.Errorf("from a file, got %s", got.ToSDDL())
+			}
+
+			if source.name == "raw" {
+				return
+			}
+			got, err = loadDescriptor(source.data)
+			if err != nil {
+				t.Fatalf("inline: %v", err)
+			}
+			if got.ToSDDL() != want {
+				t.Errorf("inline, got %s", got.ToSDDL())
+			}
+		})
+	}
+
+	for _, bad := range []string{"", "not a descriptor", "0x0100", "0xzz", "D:(A;;ZZ;;;SY)", "AQ=="} {
+		if _, err := loadDescriptor(bad); err == nil {
+			t.Errorf("loaded %q", bad)
+		}
+	}
+	if _, err := loadDescriptor(t.TempDir()); err == nil {
+		t.Errorf("loaded a directory's name")
+	}
+}
+
+func TestParseObjectKind(t *testing.T) {
+	tests := []struct {
+		kind string
+		sddl string
+		want winacl.ObjectKind
+		ok   bool
+	}{
+		{"auto", "D:(A;;FA;;;SY)", winacl.FileObject, true},
+		{"auto", "D:(OA;;CR;0e10c968-78fb-11d2-90d4-00c04f79dc55;;AU)", winacl.DirectoryServiceObject, true},
+		{"auto", "O:BA", winacl.FileObject, true},
+		{"file", "D:(OA;;CR;0e10c968-78fb-11d2-90d4-00c04f79dc55;;AU)", winacl.FileObject, true},
+		{"ds", "D:(A;;GA;;;SY)", winacl.DirectoryServiceObject, true},
+		{"registry", "D:(A;;GA;;;SY)", winacl.FileObject, false},
+	}
+	for _, test := range tests {
+		sd, err := winacl.NewNtSecurityDescriptorFromSDDL(test.sddl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok := parseObjectKind(test.kind, sd)
+		if got != test.want || ok != test.ok {
+			t.Errorf("%s %s: got %v and %t, want %v and %t", test.kind, test.sddl, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestLoadSIDMap(t *testing.T) {
+	sids := []string{"S-1-5-21-1-2-3-1105", "S-1-5-21-1-2-3-1106", "S-1-5-21-1-2-3-1107", "S-1-5-21-1-2-3-1108"}
+	t.Cleanup(func() {
+		for _, sid := range sids {
+			delete(knownSIDs, sid)
+		}
+	})
+
+	tests := []struct {
+		name string
+		data string
+		want map[string]string
+	}{
+		{
+			"JSON",
+			`{"S-1-5-21-1-2-3-1105": "CORP\\alice", "s-1-5-21-1-2-3-1106": "bob"}`,
+			map[string]string{sids[0]: `CORP\alice`, sids[1]: "bob"},
+		},
+		{
+			"lines",
+			"# exported names\n\nS-1-5-21-1-2-3-1105\tCORP\\alice\ns-1-5-21-1-2-3-1106,bob\n" +
+				"S-1-5-21-1-2-3-1107=Domain Users \nS-1-5-21-1-2-3-1108 svc sql\n",
+			map[string]string{sids[0]: `CORP\alice`, sids[1]: "bob", sids[2]: "Domain Users", sids[3]: "svc sql"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, sid := range sids {
+				delete(knownSIDs, sid)
+			}
+			path := filepath.Join(t.TempDir(), "sids")
+			if err := os.WriteFile(path, []byte(test.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := loadSIDMap(path); err != nil {
+				t.Fatal(err)
+			}
+			for sid, want := range test.want {
+				if got := knownSIDs[sid]; got != want {
+					t.Errorf("%s: got %q, want %q", sid, got, want)
+				}
+			}
+		})
+	}
+
+	bad := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"a name without a SID", "S-1-5-21-1-2-3-1105 alice\nbob\n", "sids:2: expected a SID and a name"},
+		{"a SID without a name", "S-1-5-21-1-2-3-1105\n", "sids:1: expected a SID and a name"},
+		{"truncated JSON", `{"S-1-5-21-1-2-3-1105": `, "unexpected EOF"},
+		{"JSON that is not an object of names", `{"S-1-5-21-1-2-3-1105": 1}`, "cannot unmarshal"},
+	}
+	for _, test := range bad {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "sids")
+			if err := os.WriteFile(path, []byte(test.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := loadSIDMap(path); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("got error %v, want %q", err, test.err)
+			}
+		})
+	}
+	if err := loadSIDMap(filepath.Join(t.TempDir(), "missing")); !os.IsNotExist(err) {
+		t.Errorf("got error %v for a missing file", err)
+	}
+}
+
+func TestPrintACLDiff(t *testing.T) {
+	const (
+		before = "O:BAG:SYD:(A;;FA;;;SY)(A;;0x1200a9;;;BU)"
+		after  = "O:SYG:SYD:(A;;FA;;;SY)(A;;0x1301bf;;;BU)(D;;WD;;;WD)"
+	)
+
+	var out bytes.Buffer
+	differ, err := printACLDiff(&out, before, after, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `Owner: S-1-5-32-544 -> S-1-5-18
+DACL:
+  + [2] (D;;WD;;;WD) World/Everyone
+  ~ [1] (A;;CCSWWPLORC;;;BU) -> [1] (A;;CCDCLCSWRPWPLOCRSDRC;;;BU) Built-in Users +DELETE_CHILD +READ_PROP +CONTROL_ACCESS +DELETE
+`
+	if !differ || out.String() != want {
+		t.Errorf("got %t and\n%s\nwant\n%s", differ, out.String(), want)
+	}
+
+	out.Reset()
+	if _, err := printACLDiff(&out, before, after, true); err != nil {
+		t.Fatal(err)
+	}
+	var diff struct {
+		Owner struct{ From, To string }
+		DACL  struct{ Added, Modified, Removed []json.RawMessage }
+	}
+	if err := json.Unmarshal(out.Bytes(), &diff); err != nil {
+		t.Fatalf("%v in %s", err, out.String())
+	}
+	if diff.Owner.From != "S-1-5-32-544" || diff.Owner.To != "S-1-5-18" ||
+		len(diff.DACL.Added) != 1 || len(diff.DACL.Modified) != 1 || len(diff.DACL.Removed) != 0 {
+		t.Errorf("got JSON %s", out.String())
+	}
+
+	// the order of ACEs does not matter
+	out.Reset()
+	differ, err = printACLDiff(&out, before, "O:BAG:SYD:(A;;0x1200a9;;;BU)(A;;FA;;;SY)", false)
+	if err != nil || differ || out.Len() != 0 {
+		t.Errorf("got %t, error %v and %q for reordered ACEs", differ, err, out.String())
+	}
+
+	if _, err := printACLDiff(&out, before, "not a descriptor", false); err == nil || !strings.HasPrefix(err.Error(), "not a descriptor ") {
+		t.Errorf("got error %v for a bad descriptor", err)
+	}
+}
+
+// TestACLDiffExitStatus runs ino acl diff in a child process, as it exits
+// 1 when the descriptors differ
+func TestACLDiffExitStatus(t *testing.T) {
+	if args := os.Getenv("INO_ACL_DIFF_ARGS"); args != "" {
+		aclMain(append([]string{"diff"}, strings.Split(args, "|")...))
+		os.Exit(0)
+	}
+
+	tests := []struct {
+		name   string
+		after  string
+		status int
+	}{
+		{"same", "O:BAG:SYD:(A;;FA;;;SY)", 0},
+		{"different", "O:BAG:SYD:(A;;FA;;;SY)(A;;FA;;;WD)", 1},
+		{"no second descriptor", "", 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args := "O:BAG:SYD:(A;;FA;;;SY)"
+			if test.after != "" {
+				args += "|" + test.after
+			}
+			cmd := exec.Command(os.Args[0], "-test.run=^TestACLDiffExitStatus$")
+			cmd.Env = append(os.Environ(), "INO_ACL_DIFF_ARGS="+args)
+			out, err := cmd.CombinedOutput()
+
+			status := 0
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				status = exitErr.ExitCode()
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if status != test.status {
+				t.Errorf("exited %d, want %d, printing\n%s", status, test.status, out)
+			}
+		})
+	}
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	winacl "github.com/kgoins/go-winacl/pkg"
+)
+
+const (
+	enrollmentGUID     = "0e10c968-78fb-11d2-90d4-00c04f79dc55"
+	autoEnrollmentGUID = "a05b8cc2-17bc-4802-a710-e7c15ab866a2"
+
+	// msPKI-Certificate-Name-Flag
+	enrolleeSuppliesSubject        = 0x00000001
+	enrolleeSuppliesSubjectAltName = 0x00010000
+
+	// msPKI-Enrollment-Flag
+	pendAllRequests = 0x00000002
+)
+
+// Extended Key Usages relevant to certificate abuse
+const (
+	ekuClientAuth     = "1.3.6.1.5.5.7.3.2"
+	ekuPKINITClient   = "1.3.6.1.5.2.3.4"
+	ekuSmartCardLogon = "1.3.6.1.4.1.311.20.2.2"
+	ekuAnyPurpose     = "2.5.29.37.0"
+	ekuRequestAgent   = "1.3.6.1.4.1.311.20.2.1"
+)
+
+// EKUNames maps common Extended Key Usage OIDs to their names
+var EKUNames = map[string]string{
+	ekuClientAuth:              "Client Authentication",
+	ekuPKINITClient:            "PKINIT Client Authentication",
+	ekuSmartCardLogon:          "Smart Card Logon",
+	ekuAnyPurpose:              "Any Purpose",
+	ekuRequestAgent:            "Certificate Request Agent",
+	"1.3.6.1.5.5.7.3.1":        "Server Authentication",
+	"1.3.6.1.5.5.7.3.3":        "Code Signing",
+	"1.3.6.1.5.5.7.3.4":        "Secure Email",
+	"1.3.6.1.5.5.7.3.8":        "Time Stamping",
+	"1.3.6.1.5.5.7.3.9":        "OCSP Signing",
+	"1.3.6.1.4.1.311.10.3.4":   "Encrypting File System",
+	"1.3.6.1.4.1.311.10.3.4.1": "File Recovery",
+	"1.3.6.1.4.1.311.10.3.12":  "Document Signing",
+	"1.3.6.1.4.1.311.21.5":     "Private Key Archival",
+	"1.3.6.1.4.1.311.21.6":     "Key Recovery Agent",
+}
+
+// adcsAttributes are the attributes requested for certificate templates and
+// enrollment services
+var adcsAttributes = []string{
+	"objectClass",
+	"cn",
+	"dNSHostName",
+	"nTSecurityDescriptor",
+	"certificateTemplates",
+	"msPKI-Certificate-Name-Flag",
+	"msPKI-Enrollment-Flag",
+	"msPKI-RA-Signature",
+	"msPKI-Certificate-Application-Policy",
+	"pKIExtendedKeyUsage",
+}
+
+// templateAttributeGUIDs are the schemaIDGUIDs of the attributes that
+// decide what a template issues, each with a bit of its own. Writing any
+// one of them is as good as modifying the template, while other property
+// writes are not
+var templateAttributeGUIDs = map[string]uint32{
+	"ea1dddc4-60ff-416e-8cc0-17cee534bce7": 1 << 0, // msPKI-Certificate-Name-Flag
+	"d15ef7d8-f226-46db-ae79-b34e560bd12c": 1 << 1, // msPKI-Enrollment-Flag
+	"bab04ac2-0435-4709-9307-28380e7c7001": 1 << 2, // msPKI-Private-Key-Flag
+	"fe17e04b-937d-4f7e-8e0e-9292c8d5683e": 1 << 3, // msPKI-RA-Signature
+	"dbd90548-aa37-4202-9966-8c537ba5ce32": 1 << 4, // msPKI-Certificate-Application-Policy
+	"18976af6-3b9e-11d2-90cc-00c04fd91ab1": 1 << 5, // pKIExtendedKeyUsage
+}
+
+// allTemplateAttributes has the bit of every templateAttributeGUIDs entry
+const allTemplateAttributes = 1<<6 - 1
+
+// everyoneSID is the group every principal is a member of, so a right it
+// is denied is denied to all
+const everyoneSID = "S-1-1-0"
+
+// lowPrivilegedSIDs matches principals that any domain user is a member of
+var lowPrivilegedSIDs = regexp.MustCompile(`^(S-1-1-0|S-1-5-7|S-1-5-11|S-1-5-32-545|S-1-5-32-546|S-1-5-21-[0-9-]+-(513|514|515))$`)
+
+// TemplateReport contains the enrollment rights and abusable settings of
+// a certificate template
+type TemplateReport struct {
+	Name                    string   `json:"Name"`
+	DN                      string   `json:"DN"`
+	CertificateAuthorities  []string `json:"CertificateAuthorities"`
+	EnrolleeSuppliesSubject bool     `json:"EnrolleeSuppliesSubject"`
+	ManagerApproval         bool     `json:"ManagerApproval"`
+	AuthorizedSignatures    int64    `json:"AuthorizedSignatures"`
+	EKUs                    []string `json:"EKUs"`
+	Enroll                  []string `json:"Enroll"`
+	AutoEnroll              []string `json:"AutoEnroll"`
+	Modify                  []string `json:"Modify"`
+	Findings                []string `json:"Findings"`
+
+	ekus          []string
+	enrollSIDs    []winacl.SID
+	modifySIDs    []winacl.SID
+	hasDescriptor bool
+}
+
+// CAReport contains the enrollment rights and published templates of an
+// Enterprise CA's enrollment service
+type CAReport struct {
+	Name        string   `json:"Name"`
+	DN          string   `json:"DN"`
+	DNSHostName string   `json:"DNSHostName"`
+	Templates   []string `json:"Templates"`
+	Enroll      []string `json:"Enroll"`
+	Modify      []string `json:"Modify"`
+}
+
+// adcsAnalyzer gathers certificate templates and enrollment services.
+// Every entry must be seen before a template can be tied to the CAs
+// that publish it, so findings are only produced by reports()
+type adcsAnalyzer struct {
+	templates []*TemplateReport
+	cas       []*CAReport
+}
+
+func (a *adcsAnalyzer) add(entry *directoryEntry) error {
+	classes := strings.ToLower(strings.Join(entry.strings("objectClass"), " "))
+	switch {
+	case strings.Contains(classes, "pkicertificatetemplate"):
+		template, err := newTemplateReport(entry)
+		if err != nil {
+			return err
+		}
+		a.templates = append(a.templates, template)
+
+	case strings.Contains(classes, "pkienrollmentservice"):
+		ca, err := newCAReport(entry)
+		if err != nil {
+			return err
+		}
+		a.cas = append(a.cas, ca)
+	}
+	return nil
+}
+
+func (a *adcsAnalyzer) handle(entry *directoryEntry) {
+	err := a.add(entry)
+	if err != nil {
+		log.Printf("#adcsAnalyzer - %s - %s\n", entry.DN, err)
+	}
+}
+
+func (a *adcsAnalyzer) print() {
+	for _, report := range a.reports() {
+		jsPrint(report)
+	}
+}
+
+// reports returns CA reports followed by template reports, with each
+// template's findings. When the input holds no enrollment services at
+// all, every template is assumed to be published
+func (a *adcsAnalyzer) reports() (out []interface{}) {
+	for _, ca := range a.cas {
+		out = append(out, ca)
+	}
+
+	for _, template := range a.templates {
+		template.CertificateAuthorities = []string{}
+		for _, ca := range a.cas {
+			for _, name := range ca.Templates {
+				if strings.EqualFold(name, template.Name) {
+					template.CertificateAuthorities = append(template.CertificateAuthorities, ca.Name)
+				}
+			}
+		}
+		if len(template.CertificateAuthorities) > 0 || len(a.cas) == 0 {
+			template.Findings = template.findings()
+		}
+		out = append(out, template)
+	}
+	return
+}
+
+func newTemplateReport(entry *directoryEntry) (*TemplateReport, error) {
+	template := &TemplateReport{}
+	template.Name = string(entry.first("cn"))
+	template.DN = entry.DN
+	template.Findings = []string{}
+
+	nameFlag := uint32(entry.int("msPKI-Certificate-Name-Flag"))
+	template.EnrolleeSuppliesSubject = nameFlag&(enrolleeSuppliesSubject|enrolleeSuppliesSubjectAltName) != 0
+	enrollmentFlag := uint32(entry.int("msPKI-Enrollment-Flag"))
+	template.ManagerApproval = enrollmentFlag&pendAllRequests != 0
+	template.AuthorizedSignatures = entry.int("msPKI-RA-Signature")
+
+	// schema version 2 and later templates are governed by their
+	// application policies rather than pKIExtendedKeyUsage
+	template.ekus = entry.strings("msPKI-Certificate-Application-Policy")
+	if len(template.ekus) == 0 {
+		template.ekus = entry.strings("pKIExtendedKeyUsage")
+	}
+	template.EKUs = []string{}
+	for _, eku := range template.ekus {
+		if name := EKUNames[eku]; name != "" {
+			eku = name
+		}
+		template.EKUs = append(template.EKUs, eku)
+	}
+
+	raw := entry.first("nTSecurityDescriptor")
+	if raw == nil {
+		return template, nil
+	}
+	sd, err := winacl.NewNtSecurityDescriptor(raw)
+	if err != nil {
+		return template, err
+	}
+
+	template.hasDescriptor = true
+	template.enrollSIDs = extendedRightHolders(sd, enrollmentGUID)
+	template.modifySIDs = modifyRightHolders(sd)
+	template.Enroll = resolveSIDs(template.enrollSIDs)
+	template.AutoEnroll = resolveSIDs(extendedRightHolders(sd, autoEnrollmentGUID))
+	template.Modify = resolveSIDs(template.modifySIDs)
+	return template, nil
+}
+
+func newCAReport(entry *directoryEntry) (*CAReport, error) {
+	ca := &CAReport{}
+	ca.Name = string(entry.first("cn"))
+	ca.DN = entry.DN
+	ca.DNSHostName = string(entry.first("dNSHostName"))
+	ca.Templates = entry.strings("certificateTemplates")
+
+	raw := entry.first("nTSecurityDescriptor")
+	if raw == nil {
+		return ca, nil
+	}
+	sd, err := winacl.NewNtSecurityDescriptor(raw)
+	if err != nil {
+		return ca, err
+	}
+	ca.Enroll = resolveSIDs(extendedRightHolders(sd, enrollmentGUID))
+	ca.Modify = resolveSIDs(modifyRightHolders(sd))
+	return ca, nil
+}
+
+// findings flags ESC1 through ESC4 style misconfigurations
+//
+// https://posts.specterops.io/certified-pre-owned-d95910965cd2
+func (t *TemplateReport) findings() []string {
+	findings := []string{}
+	if !t.hasDescriptor {
+		return findings
+	}
+
+	lowEnroll := lowPrivileged(t.enrollSIDs)
+	unattended := !t.ManagerApproval && t.AuthorizedSignatures == 0
+	anyPurpose := len(t.ekus) == 0 || t.hasEKU(ekuAnyPurpose)
+	authentication := anyPurpose || t.hasEKU(ekuClientAuth) || t.hasEKU(ekuPKINITClient) || t.hasEKU(ekuSmartCardLogon)
+
+	if len(lowEnroll) > 0 && unattended {
+		enrollees := strings.Join(lowEnroll, ", ")
+		if t.EnrolleeSuppliesSubject && authentication {
+			findings = append(findings, fmt.Sprintf(
+				"ESC1: %s can enroll, supply an arbitrary subject and authenticate with the certificate", enrollees))
+		}
+		if anyPurpose {
+			findings = append(findings, fmt.Sprintf(
+				"ESC2: %s can enroll for a certificate usable for any purpose", enrollees))
+		}
+		if t.hasEKU(ekuRequestAgent) {
+			findings = append(findings, fmt.Sprintf(
+				"ESC3: %s can enroll for an enrollment agent certificate", enrollees))
+		}
+	}
+
+	if lowModify := lowPrivileged(t.modifySIDs); len(lowModify) > 0 {
+		findings = append(findings, fmt.Sprintf(
+			"ESC4: %s can modify the template", strings.Join(lowModify, ", ")))
+	}
+	return findings
+}
+
+func (t *TemplateReport) hasEKU(oid string) bool {
+	for _, eku := range t.ekus {
+		if eku == oid {
+			return true
+		}
+	}
+	return false
+}
+
+// extendedRightHolders returns the SIDs granted an extended right, either
+// explicitly, through all extended rights, or through full control
+func extendedRightHolders(sd winacl.NtSecurityDescriptor, rightGUID string) []winacl.SID {
+	return rightHolders(sd, func(ace winacl.ACE) uint32 {
+		mask := ace.AccessMask.Raw()
+		if mask&winacl.AccessMaskGenericAll != 0 {
+			return winacl.ADSRightDSControlAccess
+		}
+		if mask&winacl.ADSRightDSControlAccess == 0 {
+			return 0
+		}
+		if objectAce, ok := ace.ObjectAce.(winacl.AdvancedAce); ok {
+			objectType := objectAce.ObjectType.String()
+			if objectType != "" && objectType != rightGUID {
+				return 0
+			}
+		}
+		return winacl.ADSRightDSControlAccess
+	})
+}
+
+// modifyRightHolders returns the owner and the SIDs allowed to change an
+// object's attributes or security. A property write limited to one
+// attribute only counts when the attribute is one of
+// templateAttributeGUIDs
+func modifyRightHolders(sd winacl.NtSecurityDescriptor) []winacl.SID {
+	holders := newSIDSet()
+	if sd.Owner.String() != "" {
+		holders.add(sd.Owner)
+	}
+	for _, sid := range rightHolders(sd, modifyRights) {
+		holders.add(sid)
+	}
+	return holders.sids
+}
+
+// modifyRights returns the rights of an ACE that modify a template:
+// WRITE_DAC, WRITE_OWNER, and the bits of the templateAttributeGUIDs it
+// may write
+func modifyRights(ace winacl.ACE) uint32 {
+	mask := ace.AccessMask.Raw()
+	rights := mask & (winacl.AccessMaskWriteDACL | winacl.AccessMaskWriteOwner)
+	if mask&winacl.AccessMaskGenericAll != 0 {
+		rights |= winacl.AccessMaskWriteDACL | winacl.AccessMaskWriteOwner | allTemplateAttributes
+	}
+	if mask&winacl.AccessMaskGenericWrite != 0 {
+		rights |= allTemplateAttributes
+	}
+	if mask&winacl.ADSRightDSWriteProp != 0 {
+		rights |= templateAttributes(ace)
+	}
+	return rights
+}
+
+// templateAttributes returns the bits of the templateAttributeGUIDs a
+// property write ACE covers: all of them, one, or none
+func templateAttributes(ace winacl.ACE) uint32 {
+	objectAce, ok := ace.ObjectAce.(winacl.AdvancedAce)
+	if !ok || objectAce.ObjectType.String() == "" {
+		return allTemplateAttributes
+	}
+	return templateAttributeGUIDs[objectAce.ObjectType.String()]
+}
+
+// rightHolders returns the SIDs allowed any of the rights that rights picks
+// from an ACE, less those the SID or Everyone is denied. Like the file
+// DACL path, denials are taken from allowed rights whatever the order of
+// the ACEs, and group memberships other than Everyone are not known.
+// Inherit-only ACEs do not apply to the object itself and are skipped
+func rightHolders(sd winacl.NtSecurityDescriptor, rights func(winacl.ACE) uint32) []winacl.SID {
+	allowed := newSIDSet()
+	allowedRights := make(map[string]uint32)
+	deniedRights := make(map[string]uint32)
+	for _, ace := range sd.DACL.Aces {
+		if ace.Header.Flags&winacl.ACEHeaderFlagsInheritOnlyAce != 0 {
+			continue
+		}
+		sid := ace.ObjectAce.GetPrincipal()
+		switch ace.Header.Type {
+		case winacl.AceTypeAccessAllowed, winacl.AceTypeAccessAllowedObject:
+			if mask := rights(ace); mask != 0 {
+				allowed.add(sid)
+				allowedRights[sid.String()] |= mask
+			}
+		case winacl.AceTypeAccessDenied, winacl.AceTypeAccessDeniedObject:
+			deniedRights[sid.String()] |= rights(ace)
+		}
+	}
+
+	holders := newSIDSet()
+	for _, sid := range allowed.sids {
+		denied := deniedRights[sid.String()] | deniedRights[everyoneSID]
+		if allowedRights[sid.String()]&^denied != 0 {
+			holders.add(sid)
+		}
+	}
+	return holders.sids
+}
+
+func lowPrivileged(sids []winacl.SID) (out []string) {
+	for _, sid := range sids {
+		if lowPrivilegedSIDs.MatchString(sid.String()) {
+			out = append(out, sidResolve(sid))
+		}
+	}
+	return
+}
+
+// sidSet is an insertion-ordered set of SIDs
+type sidSet struct {
+	seen map[string]bool
+	sids []winacl.SID
+}
+
+func newSIDSet() *sidSet {
+	return &sidSet{seen: make(map[string]bool)}
+}
+
+func (s *sidSet) add(sid winacl.SID) {
+	str := sid.String()
+	if !s.seen[str] {
+		s.seen[str] = true
+		s.sids = append(s.sids, sid)
+	}
+}
+
+func resolveSIDs(sids []winacl.SID) []string {
+	names := []string{}
+	for _, sid := range sids {
+		names = append(names, sidResolve(sid))
+	}
+	return names
+}
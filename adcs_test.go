@@ -0,0 +1,286 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	winacl "github.com/kgoins/go-winacl/pkg"
+)
+
+func TestModifyRightHolders(t *testing.T) {
+	const owner = "O:S-1-5-21-1-2-3-512G:S-1-5-21-1-2-3-512"
+	tests := []struct {
+		name string
+		sddl string
+		want []string
+	}{
+		{
+			"property writes to every attribute",
+			owner + "D:(A;;WP;;;AU)",
+			[]string{"S-1-5-21-1-2-3-512", "S-1-5-11"},
+		},
+		{
+			"a property write to msPKI-Certificate-Name-Flag",
+			owner + "D:(OA;;WP;ea1dddc4-60ff-416e-8cc0-17cee534bce7;;AU)",
+			[]string{"S-1-5-21-1-2-3-512", "S-1-5-11"},
+		},
+		{
+			"a property write to pKIExtendedKeyUsage",
+			owner + "D:(OA;;WP;18976af6-3b9e-11d2-90cc-00c04fd91ab1;;S-1-5-21-1-2-3-513)",
+			[]string{"S-1-5-21-1-2-3-512", "S-1-5-21-1-2-3-513"},
+		},
+		{
+			"a property write to msPKI-Enrollment-Flag",
+			owner + "D:(OA;;WP;d15ef7d8-f226-46db-ae79-b34e560bd12c;;AU)",
+			[]string{"S-1-5-21-1-2-3-512", "S-1-5-11"},
+		},
+		{
+			"a property write to displayName only",
+			owner + "D:(OA;;WP;bf967953-0de6-11d0-a285-00aa003049e2;;AU)",
+			[]string{"S-1-5-21-1-2-3-512"},
+		},
+		{
+			"an enrollment right",
+			owner + "D:(OA;;CR;0e10c968-78fb-11d2-90d4-00c04f79dc55;;AU)",
+			[]string{"S-1-5-21-1-2-3-512"},
+		},
+		{
+			"write DACL",
+			owner + "D:(A;;WD;;;AU)",
+			[]string{"S-1-5-21-1-2-3-512", "S-1-5-11"},
+		},
+		{
+			"an inherit only write",
+			owner + "D:(A;CIIO;GA;;;AU)",
+			[]string{"S-1-5-21-1-2-3-512"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sd, err := winacl.NewNtSecurityDescriptorFromSDDL(test.sddl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, sid := range modifyRightHolders(sd) {
+				got = append(got, sid.String())
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestModifyRightDenials(t *testing.T) {
+	const owner = "O:S-1-5-21-1-2-3-512G:S-1-5-21-1-2-3-512"
+	tests := []struct {
+		name string
+		sddl string
+		want []string
+	}{
+		{"a denied full control", owner + "D:(D;;GA;;;AU)(A;;GA;;;AU)", []string{"S-1-5-21-1-2-3-512"}},
+		{"a denial to Everyone", owner + "D:(A;;WD;;;AU)(D;;WD;;;WD)", []string{"S-1-5-21-1-2-3-512"}},
+		{"a denial of some rights", owner + "D:(D;;WD;;;AU)(A;;GA;;;AU)", []string{"S-1-5-21-1-2-3-512", "S-1-5-11"}},
+		{
+			"a denied write to one template attribute",
+			owner + "D:(OD;;WP;ea1dddc4-60ff-416e-8cc0-17cee534bce7;;AU)(A;;WP;;;AU)",
+			[]string{"S-1-5-21-1-2-3-512", "S-1-5-11"},
+		},
+		{
+			"the same template attribute denied",
+			owner + "D:(OD;;WP;ea1dddc4-60ff-416e-8cc0-17cee534bce7;;AU)(OA;;WP;ea1dddc4-60ff-416e-8cc0-17cee534bce7;;AU)",
+			[]string{"S-1-5-21-1-2-3-512"},
+		},
+		{"an inherit only denial", owner + "D:(D;CIIO;GA;;;AU)(A;;GA;;;AU)", []string{"S-1-5-21-1-2-3-512", "S-1-5-11"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sd, err := winacl.NewNtSecurityDescriptorFromSDDL(test.sddl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, sid := range modifyRightHolders(sd) {
+				got = append(got, sid.String())
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestExtendedRightHolders(t *testing.T) {
+	tests := []struct {
+		name string
+		sddl string
+		want []string
+	}{
+		{"the enrollment right", "D:(OA;;CR;" + enrollmentGUID + ";;AU)", []string{"S-1-5-11"}},
+		{"all extended rights", "D:(A;;CR;;;S-1-5-21-1-2-3-513)", []string{"S-1-5-21-1-2-3-513"}},
+		{"full control", "D:(A;;GA;;;S-1-5-21-1-2-3-513)", []string{"S-1-5-21-1-2-3-513"}},
+		{"another extended right", "D:(OA;;CR;" + autoEnrollmentGUID + ";;AU)", []string{}},
+		{"property writes", "D:(A;;WP;;;AU)", []string{}},
+		{"an inherit only grant", "D:(OA;CIIO;CR;" + enrollmentGUID + ";;AU)", []string{}},
+		{"a denied enrollment", "D:(OD;;CR;" + enrollmentGUID + ";;AU)(OA;;CR;" + enrollmentGUID + ";;AU)", []string{}},
+		{"a denial after the grant", "D:(OA;;CR;" + enrollmentGUID + ";;AU)(OD;;CR;" + enrollmentGUID + ";;AU)", []string{}},
+		{"a denial to Everyone", "D:(OA;;CR;" + enrollmentGUID + ";;AU)(D;;CR;;;WD)", []string{}},
+		{"another right denied", "D:(OD;;CR;" + autoEnrollmentGUID + ";;AU)(OA;;CR;" + enrollmentGUID + ";;AU)", []string{"S-1-5-11"}},
+		{"another principal denied", "D:(OD;;CR;" + enrollmentGUID + ";;DA)(OA;;CR;" + enrollmentGUID + ";;AU)", []string{"S-1-5-11"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sd, err := winacl.NewNtSecurityDescriptorFromSDDL("O:S-1-5-21-1-2-3-512G:S-1-5-21-1-2-3-512" + test.sddl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, sid := range extendedRightHolders(sd, enrollmentGUID) {
+				got = append(got, sid.String())
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+// templateEntry is a certificate template with a descriptor from SDDL,
+// and its other attributes given as name and value pairs
+func templateEntry(t *testing.T, name, sddl string, attrs ...string) *directoryEntry {
+	t.Helper()
+	entry := &directoryEntry{
+		DN: "CN=" + name + ",CN=Certificate Templates,CN=Public Key Services,CN=Services,CN=Configuration,DC=corp,DC=local",
+		Attrs: map[string][][]byte{
+			"objectclass": {[]byte("top"), []byte("pKICertificateTemplate")},
+			"cn":          {[]byte(name)},
+		},
+	}
+	if sddl != "" {
+		sd, err := winacl.NewNtSecurityDescriptorFromSDDL(sddl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, err := sd.ToBuffer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry.Attrs["ntsecuritydescriptor"] = [][]byte{raw.Bytes()}
+	}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		key := strings.ToLower(attrs[i])
+		entry.Attrs[key] = append(entry.Attrs[key], []byte(attrs[i+1]))
+	}
+	return entry
+}
+
+// escs are the ESC numbers of a template's findings
+func escs(template *TemplateReport) []string {
+	got := []string{}
+	for _, finding := range template.Findings {
+		got = append(got, strings.SplitN(finding, ":", 2)[0])
+	}
+	return got
+}
+
+func TestTemplateFindings(t *testing.T) {
+	const (
+		owner       = "O:S-1-5-21-1-2-3-512G:S-1-5-21-1-2-3-512"
+		usersEnroll = owner + "D:(OA;;CR;" + enrollmentGUID + ";;DU)(A;;GA;;;DA)"
+		adminEnroll = owner + "D:(OA;;CR;" + enrollmentGUID + ";;DA)(A;;GA;;;DA)"
+		nameFlag    = "msPKI-Certificate-Name-Flag"
+		policy      = "msPKI-Certificate-Application-Policy"
+	)
+	tests := []struct {
+		name  string
+		sddl  string
+		attrs []string
+		want  []string
+	}{
+		{"ESC1", usersEnroll, []string{nameFlag, "1", policy, ekuClientAuth}, []string{"ESC1"}},
+		{"ESC1 through a subject alternative name", usersEnroll, []string{nameFlag, "65536", policy, ekuSmartCardLogon}, []string{"ESC1"}},
+		{"ESC1 through pKIExtendedKeyUsage", usersEnroll, []string{nameFlag, "1", "pKIExtendedKeyUsage", ekuPKINITClient}, []string{"ESC1"}},
+		{"ESC1 and ESC2 without EKUs", usersEnroll, []string{nameFlag, "1"}, []string{"ESC1", "ESC2"}},
+		{"ESC2", usersEnroll, []string{policy, ekuAnyPurpose}, []string{"ESC2"}},
+		{"ESC3", usersEnroll, []string{policy, ekuRequestAgent}, []string{"ESC3"}},
+		{"ESC4", owner + "D:(A;;WD;;;AU)", []string{policy, ekuClientAuth}, []string{"ESC4"}},
+		{"ESC4 through a template attribute", owner + "D:(OA;;WP;18976af6-3b9e-11d2-90cc-00c04fd91ab1;;DU)", nil, []string{"ESC4"}},
+
+		{"manager approval", usersEnroll, []string{nameFlag, "1", policy, ekuClientAuth, "msPKI-Enrollment-Flag", "2"}, []string{}},
+		{"manager approval with other flags", usersEnroll, []string{policy, ekuAnyPurpose, "msPKI-Enrollment-Flag", "43"}, []string{}},
+		{"authorized signatures", usersEnroll, []string{nameFlag, "1", policy, ekuRequestAgent, "msPKI-RA-Signature", "1"}, []string{}},
+		{"no client authentication EKU", usersEnroll, []string{nameFlag, "1", policy, "1.3.6.1.5.5.7.3.1"}, []string{}},
+		{"code signing only", usersEnroll, []string{nameFlag, "1", policy, "1.3.6.1.5.5.7.3.3"}, []string{}},
+		{"privileged enrollment", adminEnroll, []string{nameFlag, "1", policy, ekuClientAuth}, []string{}},
+		{"a subject built from the directory", usersEnroll, []string{policy, ekuClientAuth}, []string{}},
+		{"a denied enrollment", owner + "D:(OD;;CR;" + enrollmentGUID + ";;DU)(OA;;CR;" + enrollmentGUID + ";;DU)", []string{nameFlag, "1", policy, ekuClientAuth}, []string{}},
+		{"a denied write", owner + "D:(D;;GA;;;AU)(A;;WD;;;AU)", nil, []string{}},
+		{"no descriptor", "", []string{nameFlag, "1", policy, ekuClientAuth}, []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			analyzer := &adcsAnalyzer{}
+			if err := analyzer.add(templateEntry(t, "Template", test.sddl, test.attrs...)); err != nil {
+				t.Fatal(err)
+			}
+			reports := analyzer.reports()
+			if len(reports) != 1 {
+				t.Fatalf("got %d reports", len(reports))
+			}
+			if got := escs(reports[0].(*TemplateReport)); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestADCSPublishedTemplates(t *testing.T) {
+	const sddl = "O:S-1-5-21-1-2-3-512G:S-1-5-21-1-2-3-512D:(OA;;CR;" + enrollmentGUID + ";;AU)"
+	analyzer := &adcsAnalyzer{}
+	entries := []*directoryEntry{
+		templateEntry(t, "Published", sddl, "msPKI-Certificate-Name-Flag", "1"),
+		templateEntry(t, "Unpublished", sddl, "msPKI-Certificate-Name-Flag", "1"),
+		{
+			DN: "CN=corp-CA,CN=Enrollment Services,CN=Public Key Services,CN=Services,CN=Configuration,DC=corp,DC=local",
+			Attrs: map[string][][]byte{
+				"objectclass":          {[]byte("top"), []byte("pKIEnrollmentService")},
+				"cn":                   {[]byte("corp-CA")},
+				"dnshostname":          {[]byte("ca.corp.local")},
+				"certificatetemplates": {[]byte("published"), []byte("User")},
+			},
+		},
+	}
+	for _, entry := range entries {
+		if err := analyzer.add(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reports := analyzer.reports()
+	if len(reports) != 3 {
+		t.Fatalf("got %d reports", len(reports))
+	}
+	if ca, ok := reports[0].(*CAReport); !ok || ca.Name != "corp-CA" {
+		t.Errorf("got %+v first, want the CA", reports[0])
+	}
+
+	published := reports[1].(*TemplateReport)
+	if !reflect.DeepEqual(published.CertificateAuthorities, []string{"corp-CA"}) {
+		t.Errorf("published by %v", published.CertificateAuthorities)
+	}
+	if got := escs(published); !reflect.DeepEqual(got, []string{"ESC1", "ESC2"}) {
+		t.Errorf("got %v for the published template", got)
+	}
+
+	unpublished := reports[2].(*TemplateReport)
+	if len(unpublished.CertificateAuthorities) != 0 || len(unpublished.Findings) != 0 {
+		t.Errorf("an unpublished template is published by %v, with findings %q",
+			unpublished.CertificateAuthorities, unpublished.Findings)
+	}
+}
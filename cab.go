@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Cabinet header flags and folder compression types
+const (
+	cabFlagPrevCabinet = 0x0001
+	cabFlagNextCabinet = 0x0002
+	cabFlagReserve     = 0x0004
+	cabAttribUTF8      = 0x80
+	cabCompressNone    = 0
+	cabCompressMSZIP   = 1
+	cabCompressQuantum = 2
+	cabCompressLZX     = 3
+)
+
+// cabinetNames maps the names files are stored under in a cabinet to the
+// names they are installed as. An MSI stores files in its cabinets by
+// the keys of its File table
+type cabinetNames map[string]string
+
+// cabFolder is a run of CFDATA blocks compressed as one stream. Files
+// are at offsets within the decompressed folder, so it is decompressed
+// once, when a file in it is first opened
+type cabFolder struct {
+	r           io.ReaderAt
+	offset      int64
+	blocks      int
+	compression uint16
+	dataReserve int
+
+	once sync.Once
+	data []byte
+	err  error
+}
+
+// newCabFS reads a cabinet's folders and files into an indexFS. Files
+// continued from or into another cabinet are skipped
+func newCabFS(r io.ReaderAt, size int64, names cabinetNames) (*indexFS, error) {
+	header := make([]byte, 36)
+	_, err := r.ReadAt(header, 0)
+	if err != nil {
+		return nil, err
+	}
+	filesOffset := int64(binary.LittleEndian.Uint32(header[16:]))
+	folderCount := int(binary.LittleEndian.Uint16(header[26:]))
+	fileCount := int(binary.LittleEndian.Uint16(header[28:]))
+	flags := binary.LittleEndian.Uint16(header[30:])
+
+	offset := int64(36)
+	var folderReserve, dataReserve int
+	if flags&cabFlagReserve != 0 {
+		reserve := make([]byte, 4)
+		_, err := r.ReadAt(reserve, offset)
+		if err != nil {
+			return nil, err
+		}
+		offset += 4 + int64(binary.LittleEndian.Uint16(reserve))
+		folderReserve, dataReserve = int(reserve[2]), int(reserve[3])
+	}
+	for _, flag := range []uint16{cabFlagPrevCabinet, cabFlagNextCabinet} {
+		if flags&flag == 0 {
+			continue
+		}
+		// the cabinet and disk names of the neighbouring cabinet
+		for i := 0; i < 2; i++ {
+			_, length, err := cabString(r, offset)
+			if err != nil {
+				return nil, err
+			}
+			offset += length
+		}
+	}
+
+	folders := make([]*cabFolder, folderCount)
+	entry := make([]byte, 8+folderReserve)
+	for i := range folders {
+		_, err := r.ReadAt(entry, offset)
+		if err != nil {
+			return nil, err
+		}
+		offset += int64(len(entry))
+		folders[i] = &cabFolder{
+			r:           r,
+			offset:      int64(binary.LittleEndian.Uint32(entry)),
+			blocks:      int(binary.LittleEndian.Uint16(entry[4:])),
+			compression: binary.LittleEndian.Uint16(entry[6:]) & 0xF,
+			dataReserve: dataReserve,
+		}
+	}
+
+	cab := newIndexFS()
+	offset = filesOffset
+	record := make([]byte, 16)
+	for i := 0; i < fileCount; i++ {
+		_, err := r.ReadAt(record, offset)
+		if err != nil {
+			return nil, err
+		}
+		fileSize := int64(binary.LittleEndian.Uint32(record))
+		folderOffset := int64(binary.LittleEndian.Uint32(record[4:]))
+		folder := int(binary.LittleEndian.Uint16(record[8:]))
+		date, clock := binary.LittleEndian.Uint16(record[10:]), binary.LittleEndian.Uint16(record[12:])
+		attribs := binary.LittleEndian.Uint16(record[14:])
+		name, length, err := cabString(r, offset+16)
+		if err != nil {
+			return nil, err
+		}
+		offset += 16 + length
+
+		// folders 0xFFFD to 0xFFFF are continued from or into another
+		// cabinet
+		if folder >= len(folders) {
+			continue
+		}
+		if attribs&cabAttribUTF8 == 0 {
+			name = latin1(name)
+		}
+		if installed, ok := names[name]; ok {
+			name = installed
+		}
+		source := folders[folder]
+		cab.add(name, &indexEntry{
+			size:    fileSize,
+			modTime: dosTime(date, clock),
+			open: func() (*io.SectionReader, error) {
+				data, err := source.decompress()
+				if err != nil {
+					return nil, err
+				}
+				if folderOffset+fileSize > int64(len(data)) {
+					return nil, errors.New("file is beyond the end of its folder")
+				}
+				return io.NewSectionReader(bytes.NewReader(data), folderOffset, fileSize), nil
+			},
+		})
+	}
+	return cab, nil
+}
+
+// cabString reads a NUL terminated string, returning it and the bytes
+// it took up
+func cabString(r io.ReaderAt, offset int64) (string, int64, error) {
+	buf := make([]byte, 256)
+	n, err := r.ReadAt(buf, offset)
+	if i := bytes.IndexByte(buf[:n], 0); i >= 0 {
+		return string(buf[:i]), int64(i + 1), nil
+	}
+	if err == nil {
+		err = errors.New("cabinet string is not terminated")
+	}
+	return "", 0, err
+}
+
+// latin1 decodes a name stored in the cabinet's legacy code page
+func latin1(s string) string {
+	runes := make([]rune, len(s))
+	for i := 0; i < len(s); i++ {
+		runes[i] = rune(s[i])
+	}
+	return string(runes)
+}
+
+// dosTime converts an MS-DOS date and time, as cabinets and FAT store them
+func dosTime(date, clock uint16) time.Time {
+	return time.Date(1980+int(date>>9), time.Month(date>>5&0xF), int(date&0x1F),
+		int(clock>>11), int(clock>>5&0x3F), int(clock&0x1F)*2, 0, time.UTC)
+}
+
+func (f *cabFolder) decompress() ([]byte, error) {
+	f.once.Do(func() {
+		f.data, f.err = f.read()
+	})
+	return f.data, f.err
+}
+
+// read decompresses the folder's blocks. Each MSZIP block is a deflate
+// stream prefixed by "CK", whose dictionary is the previous 32K of output
+func (f *cabFolder) read() ([]byte, error) {
+	switch f.compression {
+	case cabCompressNone, cabCompressMSZIP:
+	case cabCompressQuantum:
+		return nil, errors.New("Quantum compressed cabinets are unsupported")
+	case cabCompressLZX:
+		return nil, errors.New("LZX compressed cabinets are unsupported")
+	default:
+		return nil, fmt.Errorf("cabinet compression type %d", f.compression)
+	}
+
+	var out []byte
+	offset := f.offset
+	header := make([]byte, 8)
+	for i := 0; i < f.blocks; i++ {
+		_, err := f.r.ReadAt(header, offset)
+		if err != nil {
+			return nil, err
+		}
+		compressed := int(binary.LittleEndian.Uint16(header[4:]))
+		uncompressed := int(binary.LittleEndian.Uint16(header[6:]))
+		block := make([]byte, compressed)
+		_, err = f.r.ReadAt(block, offset+8+int64(f.dataReserve))
+		if err != nil {
+			return nil, err
+		}
+		offset += 8 + int64(f.dataReserve) + int64(compressed)
+
+		if f.compression == cabCompressNone {
+			out = append(out, block...)
+			continue
+		}
+		if !bytes.HasPrefix(block, []byte("CK")) {
+			return nil, fmt.Errorf("MSZIP block %d has no signature", i)
+		}
+		dict := out
+		if len(dict) > 32*1024 {
+			dict = dict[len(dict)-32*1024:]
+		}
+		inflated := make([]byte, uncompressed)
+		_, err = io.ReadFull(flate.NewReaderDict(bytes.NewReader(block[2:]), dict), inflated)
+		if err != nil {
+			return nil, fmt.Errorf("MSZIP block %d: %s", i, err)
+		}
+		out = append(out, inflated...)
+	}
+	return out, nil
+}
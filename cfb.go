@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+	"unicode/utf16"
+)
+
+// cfbSignature starts an OLE compound file, the format of MSI packages
+const cfbSignature = "\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1"
+
+// Compound file sector chain markers, and directory entry types
+const (
+	cfbMaxSector   = 0xFFFFFFFA
+	cfbNoStream    = 0xFFFFFFFF
+	cfbTypeStorage = 1
+	cfbTypeStream  = 2
+	cfbTypeRoot    = 5
+	cfbHeaderDIFAT = 109
+)
+
+// cfbFile is an OLE compound file: a FAT file system in a file, whose
+// streams are chains of sectors. Streams smaller than the cutoff are
+// chains of mini sectors within the root entry's mini stream instead
+type cfbFile struct {
+	r              io.ReaderAt
+	sectorSize     int64
+	miniSectorSize int64
+	miniCutoff     int64
+	fat            []uint32
+	miniFAT        []uint32
+	miniStream     io.ReaderAt
+	entries        []*cfbEntry
+}
+
+// cfbEntry is a storage or stream of a compound file. Storages hold
+// their children in a red-black tree, rooted at child and linked by the
+// left and right of each sibling
+type cfbEntry struct {
+	id          uint32
+	name        string
+	kind        byte
+	left, right uint32
+	child       uint32
+	start       uint32
+	size        int64
+	modTime     time.Time
+}
+
+func newCFBFile(r io.ReaderAt) (*cfbFile, error) {
+	header := make([]byte, 512)
+	_, err := r.ReadAt(header, 0)
+	if err != nil {
+		return nil, err
+	}
+	if string(header[:8]) != cfbSignature {
+		return nil, errors.New("not a compound file")
+	}
+	sectorShift := binary.LittleEndian.Uint16(header[0x1E:])
+	miniShift := binary.LittleEndian.Uint16(header[0x20:])
+	if sectorShift != 9 && sectorShift != 12 || miniShift >= sectorShift {
+		return nil, fmt.Errorf("compound file sector shift of %d", sectorShift)
+	}
+	c := &cfbFile{
+		r:              r,
+		sectorSize:     1 << sectorShift,
+		miniSectorSize: 1 << miniShift,
+		miniCutoff:     int64(binary.LittleEndian.Uint32(header[0x38:])),
+	}
+	fatCount := int(binary.LittleEndian.Uint32(header[0x2C:]))
+	directoryStart := binary.LittleEndian.Uint32(header[0x30:])
+	miniFATStart := binary.LittleEndian.Uint32(header[0x3C:])
+	difatStart := binary.LittleEndian.Uint32(header[0x44:])
+	difatCount := int(binary.LittleEndian.Uint32(header[0x48:]))
+
+	// the sectors of the FAT are listed by the header, then by a chain
+	// of DIFAT sectors, the last entry of each being the next
+	difat := make([]uint32, 0, cfbHeaderDIFAT)
+	for i := 0; i < cfbHeaderDIFAT; i++ {
+		difat = append(difat, binary.LittleEndian.Uint32(header[0x4C+i*4:]))
+	}
+	perSector := int(c.sectorSize / 4)
+	for sector, i := difatStart, 0; sector <= cfbMaxSector && i < difatCount; i++ {
+		data, err := c.readSector(sector)
+		if err != nil {
+			return nil, fmt.Errorf("DIFAT: %s", err)
+		}
+		for j := 0; j < perSector-1; j++ {
+			difat = append(difat, binary.LittleEndian.Uint32(data[j*4:]))
+		}
+		sector = binary.LittleEndian.Uint32(data[(perSector-1)*4:])
+	}
+	if fatCount > len(difat) {
+		return nil, errors.New("compound file FAT is not fully listed")
+	}
+	for _, sector := range difat[:fatCount] {
+		data, err := c.readSector(sector)
+		if err != nil {
+			return nil, fmt.Errorf("FAT: %s", err)
+		}
+		for j := 0; j < perSector; j++ {
+			c.fat = append(c.fat, binary.LittleEndian.Uint32(data[j*4:]))
+		}
+	}
+
+	directory, err := c.chain(directoryStart, false)
+	if err != nil {
+		return nil, fmt.Errorf("directory: %s", err)
+	}
+	data := make([]byte, int64(len(directory))*c.sectorSize)
+	_, err = (&cfbStream{r: r, sectors: directory, sectorSize: c.sectorSize, size: int64(len(data))}).ReadAt(data, 0)
+	if err != nil {
+		return nil, fmt.Errorf("directory: %s", err)
+	}
+	for i := 0; i+128 <= len(data); i += 128 {
+		c.entries = append(c.entries, c.newEntry(uint32(i/128), data[i:i+128]))
+	}
+	if len(c.entries) == 0 || c.entries[0].kind != cfbTypeRoot {
+		return nil, errors.New("compound file has no root entry")
+	}
+
+	if miniFATStart <= cfbMaxSector {
+		sectors, err := c.chain(miniFATStart, false)
+		if err != nil {
+			return nil, fmt.Errorf("mini FAT: %s", err)
+		}
+		for _, offset := range sectors {
+			data := make([]byte, c.sectorSize)
+			_, err := r.ReadAt(data, offset)
+			if err != nil {
+				return nil, fmt.Errorf("mini FAT: %s", err)
+			}
+			for j := 0; j < perSector; j++ {
+				c.miniFAT = append(c.miniFAT, binary.LittleEndian.Uint32(data[j*4:]))
+			}
+		}
+	}
+	root := c.entries[0]
+	sectors, err := c.chain(root.start, false)
+	if err != nil {
+		return nil, fmt.Errorf("mini stream: %s", err)
+	}
+	c.miniStream = &cfbStream{r: r, sectors: sectors, sectorSize: c.sectorSize, size: root.size}
+	return c, nil
+}
+
+func (c *cfbFile) newEntry(id uint32, b []byte) *cfbEntry {
+	nameLength := int(binary.LittleEndian.Uint16(b[64:]))
+	if nameLength > 64 {
+		nameLength = 64
+	}
+	units := make([]uint16, 0, nameLength/2)
+	for i := 0; i+1 < nameLength; i += 2 {
+		unit := binary.LittleEndian.Uint16(b[i:])
+		if unit == 0 {
+			break
+		}
+		units = append(units, unit)
+	}
+
+	// version 3 files, with 512 byte sectors, may leave garbage in the
+	// high half of the size
+	size := int64(binary.LittleEndian.Uint64(b[120:]))
+	if c.sectorSize == 512 {
+		size &= 0xFFFFFFFF
+	}
+	return &cfbEntry{
+		id:      id,
+		name:    string(utf16.Decode(units)),
+		kind:    b[66],
+		left:    binary.LittleEndian.Uint32(b[68:]),
+		right:   binary.LittleEndian.Uint32(b[72:]),
+		child:   binary.LittleEndian.Uint32(b[76:]),
+		modTime: filetimeToTime(binary.LittleEndian.Uint64(b[108:])),
+		start:   binary.LittleEndian.Uint32(b[116:]),
+		size:    size,
+	}
+}
+
+func (c *cfbFile) readSector(sector uint32) ([]byte, error) {
+	data := make([]byte, c.sectorSize)
+	_, err := c.r.ReadAt(data, (int64(sector)+1)*c.sectorSize)
+	return data, err
+}
+
+// chain follows a chain of sectors through the FAT, or of mini sectors
+// through the mini FAT, returning the offset of each. Sector n of the
+// file follows the header, at (n+1) sectors in
+func (c *cfbFile) chain(start uint32, mini bool) ([]int64, error) {
+	fat, size, base := c.fat, c.sectorSize, c.sectorSize
+	if mini {
+		fat, size, base = c.miniFAT, c.miniSectorSize, 0
+	}
+	var offsets []int64
+	for sector := start; sector <= cfbMaxSector; sector = fat[sector] {
+		if int(sector) >= len(fat) || len(offsets) > len(fat) {
+			return nil, fmt.Errorf("sector chain is corrupt at %d", sector)
+		}
+		offsets = append(offsets, base+int64(sector)*size)
+	}
+	return offsets, nil
+}
+
+// children returns the entries of a storage, from the tree of its
+// children
+func (c *cfbFile) children(storage *cfbEntry) []*cfbEntry {
+	var children []*cfbEntry
+	seen := make(map[uint32]bool)
+	stack := []uint32{storage.child}
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if id == cfbNoStream || int(id) >= len(c.entries) || seen[id] {
+			continue
+		}
+		seen[id] = true
+		entry := c.entries[id]
+		children = append(children, entry)
+		stack = append(stack, entry.right, entry.left)
+	}
+	return children
+}
+
+// open returns a reader for the data of a stream
+func (c *cfbFile) open(entry *cfbEntry) (*io.SectionReader, error) {
+	if entry.size < c.miniCutoff && len(c.miniFAT) > 0 {
+		sectors, err := c.chain(entry.start, true)
+		if err != nil {
+			return nil, err
+		}
+		stream := &cfbStream{r: c.miniStream, sectors: sectors, sectorSize: c.miniSectorSize, size: entry.size}
+		return io.NewSectionReader(stream, 0, entry.size), nil
+	}
+	sectors, err := c.chain(entry.start, false)
+	if err != nil {
+		return nil, err
+	}
+	stream := &cfbStream{r: c.r, sectors: sectors, sectorSize: c.sectorSize, size: entry.size}
+	return io.NewSectionReader(stream, 0, entry.size), nil
+}
+
+// cfbStream reads a stream from the offsets of its sectors
+type cfbStream struct {
+	r          io.ReaderAt
+	sectors    []int64
+	sectorSize int64
+	size       int64
+}
+
+func (s *cfbStream) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) && off < s.size {
+		index := off / s.sectorSize
+		if index >= int64(len(s.sectors)) {
+			return n, errors.New("stream is longer than its sector chain")
+		}
+		within := off % s.sectorSize
+		want := p[n:]
+		if int64(len(want)) > s.sectorSize-within {
+			want = want[:s.sectorSize-within]
+		}
+		if int64(len(want)) > s.size-off {
+			want = want[:s.size-off]
+		}
+		read, err := s.r.ReadAt(want, s.sectors[index]+within)
+		n += read
+		off += int64(read)
+		if err != nil {
+			return n, err
+		}
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
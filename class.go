@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+// PE classes, from the characteristics and subsystem of an image
+const (
+	classEXE    = "exe"
+	classDLL    = "dll"
+	classNative = "native"
+	classEFI    = "efi"
+)
+
+// peClasses are the classes -type accepts
+var peClasses = map[string]bool{
+	classEXE:    true,
+	classDLL:    true,
+	classNative: true,
+	classEFI:    true,
+}
+
+// PE header fields used to classify an image
+const (
+	imageFileDLL              = 0x2000
+	imageSubsystemNative      = 1
+	imageSubsystemEFIFirst    = 10
+	imageSubsystemEFILast     = 13
+	imageOptionalSubsystemOff = 68
+)
+
+// peClass reads the headers of a file, returning its class, or "" when it
+// is not a PE. Images for the native subsystem are kernel drivers and
+// modules, and programs like smss.exe that run before Win32 is up. EFI
+// images are applications and drivers for UEFI firmware, and DLLs are
+// any other image with the DLL flag, like .cpl, .ocx and resource-only
+// .mui files
+func peClass(r io.ReaderAt) string {
+	dos := make([]byte, 64)
+	n, _ := r.ReadAt(dos, 0)
+	if n < len(dos) || dos[0] != 'M' || dos[1] != 'Z' {
+		return ""
+	}
+	ntOffset := int64(binary.LittleEndian.Uint32(dos[0x3C:]))
+
+	// the signature, then the file header, then the optional header
+	nt := make([]byte, 4+20+imageOptionalSubsystemOff+2)
+	n, _ = r.ReadAt(nt, ntOffset)
+	if n < 24 || string(nt[:4]) != "PE\x00\x00" {
+		return ""
+	}
+	characteristics := binary.LittleEndian.Uint16(nt[22:])
+	optionalSize := int(binary.LittleEndian.Uint16(nt[20:]))
+
+	var subsystem uint16
+	if optionalSize >= imageOptionalSubsystemOff+2 && n == len(nt) {
+		subsystem = binary.LittleEndian.Uint16(nt[24+imageOptionalSubsystemOff:])
+	}
+	switch {
+	case subsystem >= imageSubsystemEFIFirst && subsystem <= imageSubsystemEFILast:
+		return classEFI
+	case subsystem == imageSubsystemNative:
+		return classNative
+	case characteristics&imageFileDLL != 0:
+		return classDLL
+	}
+	return classEXE
+}
+
+// fileClass returns the class of a file on the host, or "" when it is
+// not a PE
+func fileClass(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	return peClass(f)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testImage builds the DOS, file and optional headers of an image with
+// the given characteristics and subsystem
+func testImage(characteristics, subsystem uint16) []byte {
+	image := make([]byte, 64+4+20+224)
+	image[0], image[1] = 'M', 'Z'
+	binary.LittleEndian.PutUint32(image[0x3C:], 64)
+	copy(image[64:], "PE\x00\x00")
+	binary.LittleEndian.PutUint16(image[64+4+16:], 224)
+	binary.LittleEndian.PutUint16(image[64+4+18:], characteristics)
+	binary.LittleEndian.PutUint16(image[64+4+20+imageOptionalSubsystemOff:], subsystem)
+	return image
+}
+
+func TestPEClass(t *testing.T) {
+	const (
+		gui = 2
+		cui = 3
+	)
+	truncated := testImage(imageFileDLL, imageSubsystemNative)[:64+4+20]
+	noOptional := testImage(imageFileDLL, imageSubsystemNative)
+	binary.LittleEndian.PutUint16(noOptional[64+4+16:], 0)
+	badSignature := testImage(0, gui)
+	copy(badSignature[64:], "NE")
+
+	tests := []struct {
+		name  string
+		image []byte
+		want  string
+	}{
+		{"exe", testImage(0x0102, gui), classEXE},
+		{"console exe", testImage(0x0102, cui), classEXE},
+		{"dll", testImage(0x2102, gui), classDLL},
+		{"native", testImage(0x0102, imageSubsystemNative), classNative},
+		{"native dll", testImage(0x2102, imageSubsystemNative), classNative},
+		{"efi application", testImage(0x0102, imageSubsystemEFIFirst), classEFI},
+		{"efi runtime driver", testImage(0x2102, imageSubsystemEFILast), classEFI},
+		// without a subsystem, the DLL flag still tells DLLs apart
+		{"truncated optional header", truncated, classDLL},
+		{"no optional header", noOptional, classDLL},
+		{"not a PE", []byte("#!/bin/sh\n" + string(make([]byte, 64))), ""},
+		{"DOS stub only", testImage(0, gui)[:64], ""},
+		{"bad signature", badSignature, ""},
+		{"empty", nil, ""},
+		// the fixtures' PE is an executable, whatever it is named
+		{"test PE", testPE(t), classEXE},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := peClass(bytes.NewReader(test.image)); got != test.want {
+				t.Errorf("got class %q, want %q", got, test.want)
+			}
+		})
+	}
+
+	t.Run("files", func(t *testing.T) {
+		dir := t.TempDir()
+		name := filepath.Join(dir, "a.exe")
+		if err := os.WriteFile(name, testImage(0x0102, gui), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if got := fileClass(name); got != classEXE {
+			t.Errorf("got class %q for a file", got)
+		}
+		if got := fileClass(filepath.Join(dir, "missing.exe")); got != "" {
+			t.Errorf("got class %q for a missing file", got)
+		}
+	})
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// errNotContainer is returned for files in no supported container format
+var errNotContainer = errors.New("not a ZIP, ISO 9660, UDF, CAB or MSI")
+
+// containerExtensions are the file names that are opened as containers
+// while scanning. Containers are recognised by their content, so this
+// only avoids reading the start of every other file
+var containerExtensions = map[string]bool{
+	".zip":        true,
+	".jar":        true,
+	".nupkg":      true,
+	".vsix":       true,
+	".appx":       true,
+	".appxbundle": true,
+	".msix":       true,
+	".msixbundle": true,
+	".iso":        true,
+	".udf":        true,
+	".cab":        true,
+	".msu":        true,
+	".msi":        true,
+	".msm":        true,
+	".msp":        true,
+}
+
+func isContainerName(name string) bool {
+	return containerExtensions[strings.ToLower(path.Ext(name))]
+}
+
+// openContainer opens a ZIP, ISO 9660 or UDF image, cabinet or MSI as a
+// file system. sys is the FileInfo.Sys of the container within its
+// parent, which lets cabinets inside an MSI take their file names from
+// its File table
+func openContainer(r io.ReaderAt, size int64, sys interface{}) (fs.FS, error) {
+	magic := make([]byte, 8)
+	n, _ := r.ReadAt(magic, 0)
+	magic = magic[:n]
+
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")), bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+		return zip.NewReader(r, size)
+	case bytes.HasPrefix(magic, []byte("MSCF")):
+		names, _ := sys.(cabinetNames)
+		return newCabFS(r, size, names)
+	case bytes.HasPrefix(magic, []byte(cfbSignature)):
+		return newMSIFS(r, size)
+	}
+
+	// optical discs start with 32K of system area, then a volume
+	// recognition sequence naming their file systems
+	isISO, isUDF := recogniseVolume(r)
+	if isUDF {
+		udf, err := newUDFFS(r, size)
+		if err == nil || !isISO {
+			return udf, err
+		}
+	}
+	if isISO {
+		return newISOFS(r, size)
+	}
+	return nil, errNotContainer
+}
+
+// maxContainerNesting is how many containers deep files are scanned, as
+// in a ZIP in a ZIP. Deeper containers are recorded as errors
+const maxContainerNesting = 8
+
+var (
+	// memoryBudget is how many bytes readerOf may hold in memory at once.
+	// A container read into memory is held while the files within it are
+	// read, so the budget is shared by a container and its contents
+	memoryBudget int64 = 1 << 30
+
+	// inMemory is the bytes readerOf holds
+	inMemory int64
+)
+
+// readerOf returns a file's contents as an io.ReaderAt, reading them
+// into memory when the file cannot seek. release returns the memory to
+// the budget once the reader is no longer used. A file that does not fit
+// in what is left of the budget is not read
+func readerOf(f fs.File) (r io.ReaderAt, release func(), err error) {
+	if r, ok := f.(io.ReaderAt); ok {
+		return r, func() {}, nil
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	size := info.Size()
+	if size > memoryBudget-inMemory {
+		return nil, nil, fmt.Errorf("%d bytes do not fit in the %d bytes left to read files into memory", size, memoryBudget-inMemory)
+	}
+	inMemory += size
+	release = func() { inMemory -= size }
+
+	data, err := io.ReadAll(io.LimitReader(f, size))
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	return bytes.NewReader(data), release, nil
+}
+
+// indexFS is a read-only fs.FS over a list of paths, for containers that
+// store files by path rather than in directories. Directories are
+// implied by the paths of the files beneath them
+type indexFS struct {
+	entries map[string]*indexEntry
+}
+
+// indexEntry is a file or directory of an indexFS. open returns the data
+// of a file
+type indexEntry struct {
+	name     string
+	size     int64
+	modTime  time.Time
+	sys      interface{}
+	open     func() (*io.SectionReader, error)
+	children []fs.DirEntry
+}
+
+func newIndexFS() *indexFS {
+	return &indexFS{entries: map[string]*indexEntry{
+		".": {name: "/"},
+	}}
+}
+
+// add adds a file, and any directories above it. Paths are cleaned of
+// backslashes and leading slashes first, and a later file of the same
+// path is dropped
+func (x *indexFS) add(name string, entry *indexEntry) {
+	name = path.Clean("/" + strings.ReplaceAll(name, "\\", "/"))[1:]
+	if name == "" || x.entries[name] != nil {
+		return
+	}
+	entry.name = path.Base(name)
+	x.entries[name] = entry
+
+	for dir := path.Dir(name); ; dir = path.Dir(dir) {
+		parent, exists := x.entries[dir]
+		if !exists {
+			parent = &indexEntry{name: path.Base(dir)}
+			x.entries[dir] = parent
+		}
+		parent.children = append(parent.children, fs.FileInfoToDirEntry(entry))
+		if exists {
+			break
+		}
+		entry = parent
+	}
+}
+
+func (x *indexFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	entry := x.entries[name]
+	if entry == nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if entry.IsDir() {
+		children := append([]fs.DirEntry{}, entry.children...)
+		sort.Slice(children, func(i, j int) bool {
+			return children[i].Name() < children[j].Name()
+		})
+		return &indexDir{entry: entry, entries: children}, nil
+	}
+	data, err := entry.open()
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &indexFile{SectionReader: data, entry: entry}, nil
+}
+
+func (e *indexEntry) Name() string       { return e.name }
+func (e *indexEntry) Size() int64        { return e.size }
+func (e *indexEntry) ModTime() time.Time { return e.modTime }
+func (e *indexEntry) IsDir() bool        { return e.open == nil }
+func (e *indexEntry) Sys() interface{}   { return e.sys }
+
+func (e *indexEntry) Mode() fs.FileMode {
+	if e.IsDir() {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+type indexFile struct {
+	*io.SectionReader
+	entry *indexEntry
+}
+
+func (f *indexFile) Stat() (fs.FileInfo, error) { return f.entry, nil }
+func (f *indexFile) Close() error               { return nil }
+
+type indexDir struct {
+	entry   *indexEntry
+	entries []fs.DirEntry
+}
+
+func (d *indexDir) Stat() (fs.FileInfo, error) { return d.entry, nil }
+func (d *indexDir) Close() error               { return nil }
+
+func (d *indexDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.entry.name, Err: errors.New("is a directory")}
+}
+
+func (d *indexDir) ReadDir(count int) ([]fs.DirEntry, error) {
+	return readDirEntries(&d.entries, count)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// zipOf makes a ZIP of files, each stored uncompressed
+func zipOf(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	w := zip.NewWriter(&b)
+	for name, data := range files {
+		f, err := w.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(data)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// scanTestContainer scans a container as -dir would at the top of a walk
+func scanTestContainer(t *testing.T, name string, data []byte) *recorder {
+	t.Helper()
+	r := record(t)
+	name = filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(name, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	scanContainer(f, filepath.Base(name), (&selector{maxDepth: -1}).within(filepath.Base(name)))
+	return r
+}
+
+func TestContainerNesting(t *testing.T) {
+	// n ZIPs, each holding the next, which the first holds 1 deep
+	nested := func(n int) []byte {
+		data := zipOf(t, map[string][]byte{"readme.txt": []byte("hi")})
+		for i := n; i > 1; i-- {
+			data = zipOf(t, map[string][]byte{fmt.Sprintf("%d.zip", i): data})
+		}
+		return data
+	}
+
+	r := scanTestContainer(t, "1.zip", nested(maxContainerNesting))
+	if len(r.errors) != 0 {
+		t.Errorf("got errors %v for %d containers", r.errors, maxContainerNesting)
+	}
+
+	r = scanTestContainer(t, "1.zip", nested(maxContainerNesting+1))
+	chain := []string{"1.zip"}
+	for i := 2; i <= maxContainerNesting+1; i++ {
+		chain = append(chain, fmt.Sprintf("%d.zip", i))
+	}
+	if len(r.errors) != 1 || r.errors[0].Path != strings.Join(chain, "!/") || r.errors[0].Phase != phaseContainer {
+		t.Errorf("got errors %v", r.errors)
+	}
+}
+
+func TestContainerMemoryBudget(t *testing.T) {
+	readme := bytes.Repeat([]byte("hi"), 100)
+	inner := zipOf(t, map[string][]byte{"readme.txt": readme})
+	middle := zipOf(t, map[string][]byte{"inner.zip": inner})
+	outer := zipOf(t, map[string][]byte{"middle.zip": middle})
+	defer func(budget int64) { memoryBudget = budget }(memoryBudget)
+
+	// the middle and inner ZIPs are held in memory while the file
+	// within them is read
+	memoryBudget = int64(len(middle) + len(inner) + len(readme))
+	r := scanTestContainer(t, "outer.zip", outer)
+	if len(r.errors) != 0 {
+		t.Errorf("got errors %v within the budget", r.errors)
+	}
+
+	memoryBudget = int64(len(middle) + len(inner) - 1)
+	r = scanTestContainer(t, "outer.zip", outer)
+	if len(r.errors) != 1 || r.errors[0].Path != "outer.zip!/middle.zip!/inner.zip" || r.errors[0].Phase != phaseOpen {
+		t.Errorf("got errors %v past the budget", r.errors)
+	}
+	if inMemory != 0 {
+		t.Errorf("%d bytes are still held after the scan", inMemory)
+	}
+}
+
+// The containers of testdata are generated by testdata/mkcontainers.py,
+// each holding the same PE under one or more names
+func TestScanContainers(t *testing.T) {
+	tests := []struct {
+		image string
+		want  []string
+	}{
+		{"nested.zip.gz", []string{
+			"nested.zip!/bin/app.exe",
+			"nested.zip!/pkg/inner.nupkg!/lib/net45/inner.dll",
+			"nested.zip!/stored.dll",
+		}},
+		{"plain.cab.gz", []string{"plain.cab!/sub/foo.dll"}},
+		{"reserve.cab.gz", []string{"reserve.cab!/foo.dll"}},
+		// the cabinet's files are named by the MSI's File table
+		{"a.msi.gz", []string{"a.msi!/Data1.cab!/BAR.EXE", "a.msi!/Data1.cab!/foo.dll"}},
+		{"bundle.zip.gz", []string{
+			"bundle.zip!/installers/setup.msi!/Data1.cab!/BAR.EXE",
+			"bundle.zip!/installers/setup.msi!/Data1.cab!/foo.dll",
+		}},
+		{"plain.iso.gz", []string{"plain.iso!/SYSTEM32/KERNEL32.DLL", "plain.iso!/SYSTEM32/UNICODE.DLL"}},
+		{"joliet.iso.gz", []string{"joliet.iso!/System32/kernel32.dll", "joliet.iso!/System32/Ünïcode Läng Name.dll"}},
+		{"disc.udf.gz", []string{"disc.udf!/System32/kernel32.dll", "disc.udf!/System32/Ünïcode Läng €.dll"}},
+	}
+
+	for _, test := range tests {
+		name := strings.TrimSuffix(test.image, ".gz")
+		t.Run(name, func(t *testing.T) {
+			r := record(t)
+			if err := scanImage(openTestData(t, test.image), name, 0, 0, "/", &selector{maxDepth: -1}); err != nil {
+				t.Fatal(err)
+			}
+			if got := r.paths(); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+			if len(r.errors) != 0 {
+				t.Errorf("got errors %v", r.errors)
+			}
+			for _, report := range r.reports {
+				if report.Type != "directory" && report.ImpHash == "" {
+					t.Errorf("%s has no imphash", report.Path)
+				}
+			}
+		})
+	}
+}
+
+// TestWalkContainers scans an MSI in a directory, as -dir does
+func TestWalkContainers(t *testing.T) {
+	r := record(t)
+	msi := openTestData(t, "a.msi.gz")
+	scanPaths([]string{filepath.Dir(msi.Name())}, &selector{maxDepth: -1, symlinks: symlinksFiles})
+	want := []string{msi.Name() + "!/Data1.cab!/BAR.EXE", msi.Name() + "!/Data1.cab!/foo.dll"}
+	if got := r.paths(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	winacl "github.com/kgoins/go-winacl/pkg"
+)
+
+type DACL struct {
+	Owner    string               `json:"Owner"`
+	Group    string               `json:"Group"`
+	Aces     []ReadableAce        `json:"Aces"`
+	Findings []winacl.LintFinding `json:"Findings,omitempty"`
+
+	Descriptor *winacl.NtSecurityDescriptor `json:"Descriptor,omitempty"`
+}
+
+type ReadableAce struct {
+	Principal           string   `json:"Principal"`
+	Rights              []string `json:"Rights"`
+	Type                string   `json:"Type,omitempty"`
+	ObjectType          string   `json:"ObjectType,omitempty"`
+	InheritedObjectType string   `json:"InheritedObjectType,omitempty"`
+
+	// SID and Mask are the principal and rights as the ACE holds them, for
+	// tools that match principals by SID or test rights by bit
+	SID  string `json:"SID,omitempty"`
+	Mask uint32 `json:"Mask,omitempty"`
+	// InheritOnly ACEs only apply to the children of a directory
+	InheritOnly bool `json:"InheritOnly,omitempty"`
+}
+
+// knownSIDs holds names for SIDs learned from the input itself, such as
+// the objectSid and sAMAccountName pairs of an LDIF export
+var knownSIDs = make(map[string]string)
+
+// loadSIDMap adds SID names from a file to knownSIDs. The file holds either
+// a JSON object of SIDs to names, or one SID per line followed by its name,
+// separated by a tab, comma, equals sign or space
+func loadSIDMap(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	if start, err := reader.Peek(1); err == nil && start[0] == '{' {
+		names := make(map[string]string)
+		if err := json.NewDecoder(reader).Decode(&names); err != nil {
+			return err
+		}
+		for sid, name := range names {
+			knownSIDs[strings.ToUpper(sid)] = name
+		}
+		return nil
+	}
+
+	scanner := bufio.NewScanner(reader)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		i := strings.IndexAny(text, "\t,= ")
+		if i < 0 || !strings.HasPrefix(strings.ToUpper(text), "S-1-") {
+			return fmt.Errorf("%s:%d: expected a SID and a name", path, line)
+		}
+		knownSIDs[strings.ToUpper(text[:i])] = strings.TrimSpace(text[i+1:])
+	}
+	return scanner.Err()
+}
+
+// newDACL converts a security descriptor into its readable form, along with
+// any misconfigurations found by linting it as the given kind of object
+func newDACL(sd winacl.NtSecurityDescriptor, kind winacl.ObjectKind) DACL {
+	dacl := DACL{}
+	dacl.Owner = sidResolve(sd.Owner)
+	dacl.Group = sidResolve(sd.Group)
+	for _, ace := range sd.DACL.Aces {
+		dacl.Aces = append(dacl.Aces, newReadableAce(ace))
+	}
+	dacl.Findings = sd.Lint(kind)
+	if printDescriptor {
+		dacl.Descriptor = &sd
+	}
+	return dacl
+}
+
+// newFileDACL predicts the DACL of a file created in a directory by someone
+// who does not supply a security descriptor of their own. The creator is
+// unknown, so is left as CREATOR OWNER
+func newFileDACL(dirSD winacl.NtSecurityDescriptor) DACL {
+	sd := winacl.NewChildSecurityDescriptor(dirSD, winacl.NewObjectOptions{
+		AutoInherit: true,
+		Mapping:     winacl.FileGenericMapping,
+	})
+	return newDACL(sd, winacl.FileObject)
+}
+
+func newReadableAce(ace winacl.ACE) ReadableAce {
+	var rAce ReadableAce
+
+	perms := ace.AccessMask.String()
+	rAce.Rights = strings.Split(perms, " ")
+	rAce.Type = ace.GetTypeString()
+	rAce.Mask = ace.AccessMask.Raw()
+	rAce.InheritOnly = ace.Header.Flags&winacl.ACEHeaderFlagsInheritOnlyAce != 0
+
+	switch ace.ObjectAce.(type) {
+	case winacl.BasicAce:
+		sid := ace.ObjectAce.GetPrincipal()
+		rAce.Principal = sidResolve(sid)
+		rAce.SID = sid.String()
+
+	case winacl.AdvancedAce:
+		aa := ace.ObjectAce.(winacl.AdvancedAce)
+		sid := aa.GetPrincipal()
+		rAce.Principal = sidResolve(sid)
+		rAce.SID = sid.String()
+		rAce.ObjectType = aa.ObjectType.Resolve()
+		rAce.InheritedObjectType = aa.InheritedObjectType.Resolve()
+	}
+	return rAce
+}
+
+// knownSIDResolve returns the name learned or supplied for a SID, falling
+// back to the well-known SID tables
+func knownSIDResolve(sid winacl.SID) (string, bool) {
+	if name, ok := knownSIDs[sid.String()]; ok {
+		return name, true
+	}
+	res := sid.Resolve()
+	return res, !strings.HasPrefix(res, "S-1-")
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// loadReports gathers the reports graphs and dependency trees are built
+// from. Each argument is a dataset printed by ino as JSON, - for one on
+// stdin, or a file or directory that is scanned as -dir would scan it
+func loadReports(args []string, sel *selector) ([]*Report, error) {
+	var reports, scanned []*Report
+	var paths []string
+	for _, arg := range args {
+		if arg != "-" && !isDataset(arg) {
+			paths = append(paths, arg)
+			continue
+		}
+		var r io.Reader = os.Stdin
+		if arg != "-" {
+			f, err := os.Open(arg)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			r = f
+		}
+		read, err := readReports(r)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s", arg, err)
+		}
+		reports = append(reports, read...)
+	}
+
+	if paths != nil {
+		printed := output
+		collected := &collector{}
+		output = collected
+		scanPaths(paths, sel)
+		output = printed
+		scanned = collected.reports
+	}
+	return append(reports, scanned...), nil
+}
+
+// isDataset reports whether a file holds a dataset printed by ino, rather
+// than a PE or a container to scan. Datasets printed as CSV or YAML are
+// recognised by their header or document marker, so that readReports can
+// reject them
+func isDataset(name string) bool {
+	f, err := os.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+	r := bufio.NewReader(f)
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return false
+		}
+		switch c {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		r.UnreadByte()
+		header, _ := r.Peek(len(csvHeader))
+		return c == '{' || c == '[' || bytes.HasPrefix(header, []byte("---")) || string(header) == csvHeader
+	}
+}
+
+// csvHeader starts a dataset printed as CSV
+var csvHeader = strings.Join(csvColumns, ",")
+
+// readReports decodes the reports of a dataset printed with -format
+// jsonl, json or array. Error records are skipped
+func readReports(r io.Reader) ([]*Report, error) {
+	buffered := bufio.NewReader(r)
+	decoder := json.NewDecoder(buffered)
+
+	// an array is decoded element by element, as a dataset may be large
+	first, err := firstByte(buffered)
+	if err != nil {
+		return nil, err
+	}
+	if first != '{' && first != '[' && first != 0 {
+		return nil, errors.New("is not JSON. Datasets are read as printed with -format jsonl, json or array")
+	}
+	if first == '[' {
+		if _, err := decoder.Token(); err != nil {
+			return nil, err
+		}
+	}
+
+	var reports []*Report
+	for decoder.More() {
+		report := &Report{}
+		err := decoder.Decode(report)
+		if err != nil {
+			return nil, err
+		}
+		if report.Type == "file" || report.Type == "directory" {
+			reports = append(reports, report)
+		}
+	}
+	return reports, nil
+}
+
+// firstByte peeks at the first byte of a reader that is not whitespace
+func firstByte(r *bufio.Reader) (byte, error) {
+	for {
+		b, err := r.Peek(1)
+		if err == io.EOF {
+			return 0, nil
+		} else if err != nil {
+			return 0, err
+		}
+		if !bytes.ContainsAny(b, " \t\r\n") {
+			return b[0], nil
+		}
+		r.ReadByte()
+	}
+}
+
+// collector is an encoder that keeps the reports of a scan rather than
+// printing them. Error records are printed to stderr, as JSON Lines
+type collector struct {
+	reports []*Report
+}
+
+func (c *collector) encode(record interface{}) error {
+	switch record := record.(type) {
+	case *Report:
+		c.reports = append(c.reports, record)
+	default:
+		stderr := &jsonLinesEncoder{w: os.Stderr}
+		return stderr.encode(record)
+	}
+	return nil
+}
+
+func (c *collector) close() error { return nil }
+
+// moduleIndex finds the PEs of a dataset by the names they are imported
+// by, which are the file names, ignoring case
+type moduleIndex struct {
+	byName map[string][]*Report
+}
+
+func newModuleIndex(reports []*Report) *moduleIndex {
+	m := &moduleIndex{byName: make(map[string][]*Report)}
+	for _, report := range reports {
+		if report.Type != "file" {
+			continue
+		}
+		name := strings.ToLower(report.Name)
+		m.byName[name] = append(m.byName[name], report)
+	}
+	for _, found := range m.byName {
+		sort.SliceStable(found, func(i, j int) bool { return found[i].Path < found[j].Path })
+	}
+	return m
+}
+
+// resolve finds the PE that a module loads for an import from host,
+// searching as the Windows loader would, in so far as a dataset can. The
+// loader searches the application's directory first; a dataset has no
+// one application, so the importing module's own directory stands in
+// for it, then System32, then anywhere else, by path. It returns nil
+// when host is not in the dataset, or is an API set
+func (m *moduleIndex) resolve(from *Report, host string) *Report {
+	if isAPISet(host) {
+		return nil
+	}
+	found := m.byName[strings.ToLower(host)]
+	if len(found) == 0 {
+		return nil
+	}
+	dir := strings.ToLower(from.Dir)
+	for _, report := range found {
+		if strings.ToLower(report.Dir) == dir {
+			return report
+		}
+	}
+	for _, report := range found {
+		if strings.EqualFold(baseName(report.Dir), "system32") {
+			return report
+		}
+	}
+	return found[0]
+}
+
+// isAPISet reports whether an imported name is an API set contract, as
+// api-ms-win-core-file-l1-1-0.dll or ext-ms-win-gdi-draw-l1-1-0.dll. The
+// loader maps these through the API set schema of apisetschema.dll to a
+// host DLL, rather than loading a file of that name
+func isAPISet(host string) bool {
+	host = strings.ToLower(host)
+	return strings.HasPrefix(host, "api-") || strings.HasPrefix(host, "ext-")
+}
+
+// moduleLabel is DLL or EXE, from a report's class, or from its
+// extension in datasets printed before PEs were classed
+func moduleLabel(report *Report) string {
+	switch report.Class {
+	case classDLL:
+		return labelDLL
+	case "":
+		if strings.EqualFold(path.Ext(report.Name), ".dll") {
+			return labelDLL
+		}
+	}
+	return labelEXE
+}
+
+// baseName is the last element of a path from any system, as datasets
+// may have been printed on Windows or from an image
+func baseName(p string) string {
+	return p[strings.LastIndexAny(p, `/\`)+1:]
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestLoadReports reads a dataset printed in each format
+func TestLoadReports(t *testing.T) {
+	report := peReport(`c:\app\app.exe`, imports("foo.dll", "Foo"))
+	tests := []struct {
+		format  string
+		encoder func(w *bytes.Buffer) encoder
+		ok      bool
+	}{
+		{formatJSONLines, func(w *bytes.Buffer) encoder { return &jsonLinesEncoder{w: w} }, true},
+		{formatJSON, func(w *bytes.Buffer) encoder { return &jsonEncoder{w: w} }, true},
+		{formatArray, func(w *bytes.Buffer) encoder { return &arrayEncoder{w: w} }, true},
+		{formatCSV, func(w *bytes.Buffer) encoder { return newCSVEncoder(w) }, false},
+		{formatYAML, func(w *bytes.Buffer) encoder { return &yamlEncoder{w: w} }, false},
+	}
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			var b bytes.Buffer
+			e := test.encoder(&b)
+			for _, record := range []interface{}{testError, report} {
+				if err := e.encode(record); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := e.close(); err != nil {
+				t.Fatal(err)
+			}
+			name := filepath.Join(t.TempDir(), "dataset")
+			if err := os.WriteFile(name, b.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			// CSV and YAML are recognised, so are not scanned as a PE
+			if !isDataset(name) {
+				t.Fatal("not recognised as a dataset")
+			}
+			reports, err := loadReports([]string{name}, nil)
+			if !test.ok {
+				if err == nil || !strings.Contains(err.Error(), "is not JSON") {
+					t.Errorf("got %d reports and error %v, want a rejection", len(reports), err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(reports) != 1 || reports[0].Path != report.Path || len(reports[0].Imports) != 1 {
+				t.Errorf("got reports %+v", reports)
+			}
+		})
+	}
+}
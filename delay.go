@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// PE header fields used to read the delay-load import table
+const (
+	imageOptionalMagicPE32Plus = 0x20b
+	imageDirectoryDelayImport  = 13
+	imageDelayAttributeRVA     = 1
+	delayDescriptorSize        = 32
+
+	// limits on malformed tables, which could otherwise be read forever
+	maxDelayDescriptors = 4096
+	maxDelayThunks      = 1 << 16
+	maxImportNameLength = 512
+)
+
+// peHeaders are the parts of a PE's headers needed to find its tables
+type peHeaders struct {
+	r         io.ReaderAt
+	plus      bool
+	imageBase uint64
+	dirs      []byte
+	sections  []byte
+}
+
+// readPEHeaders reads the data directories and section table of a PE
+func readPEHeaders(r io.ReaderAt) (*peHeaders, error) {
+	dos := make([]byte, 64)
+	if _, err := r.ReadAt(dos, 0); err != nil || dos[0] != 'M' || dos[1] != 'Z' {
+		return nil, fmt.Errorf("not a PE")
+	}
+	ntOffset := int64(binary.LittleEndian.Uint32(dos[0x3C:]))
+	file := make([]byte, 24)
+	if _, err := r.ReadAt(file, ntOffset); err != nil || string(file[:4]) != "PE\x00\x00" {
+		return nil, fmt.Errorf("not a PE")
+	}
+	sectionCount := int(binary.LittleEndian.Uint16(file[6:]))
+	optional := make([]byte, binary.LittleEndian.Uint16(file[20:]))
+	if _, err := r.ReadAt(optional, ntOffset+24); err != nil || len(optional) < 2 {
+		return nil, fmt.Errorf("truncated optional header")
+	}
+
+	h := &peHeaders{r: r, plus: binary.LittleEndian.Uint16(optional) == imageOptionalMagicPE32Plus}
+	dirsOffset := 96
+	if h.plus {
+		dirsOffset = 112
+	}
+	if len(optional) < dirsOffset {
+		return nil, fmt.Errorf("truncated optional header")
+	}
+	if h.plus {
+		h.imageBase = binary.LittleEndian.Uint64(optional[24:])
+	} else {
+		h.imageBase = uint64(binary.LittleEndian.Uint32(optional[28:]))
+	}
+	dirCount := int(binary.LittleEndian.Uint32(optional[dirsOffset-4:]))
+	if end := dirsOffset + dirCount*8; dirCount <= 16 && end <= len(optional) {
+		h.dirs = optional[dirsOffset:end]
+	}
+
+	h.sections = make([]byte, sectionCount*40)
+	if _, err := r.ReadAt(h.sections, ntOffset+24+int64(len(optional))); err != nil {
+		return nil, fmt.Errorf("truncated section table")
+	}
+	return h, nil
+}
+
+// directory returns the address and size of a data directory, or 0 when
+// there is none. The address is an RVA, but for the certificate table,
+// which is a file offset
+func (h *peHeaders) directory(index int) (uint32, uint32) {
+	if (index+1)*8 > len(h.dirs) {
+		return 0, 0
+	}
+	return binary.LittleEndian.Uint32(h.dirs[index*8:]), binary.LittleEndian.Uint32(h.dirs[index*8+4:])
+}
+
+// offset is the file offset of an RVA
+func (h *peHeaders) offset(rva uint32) (int64, bool) {
+	for i := 0; i < len(h.sections); i += 40 {
+		section := h.sections[i : i+40]
+		size := binary.LittleEndian.Uint32(section[8:])
+		address := binary.LittleEndian.Uint32(section[12:])
+		if raw := binary.LittleEndian.Uint32(section[16:]); raw > size {
+			size = raw
+		}
+		if rva >= address && rva-address < size {
+			return int64(binary.LittleEndian.Uint32(section[20:])) + int64(rva-address), true
+		}
+	}
+	return 0, false
+}
+
+func (h *peHeaders) readAt(b []byte, rva uint32) bool {
+	offset, ok := h.offset(rva)
+	if !ok {
+		return false
+	}
+	n, _ := h.r.ReadAt(b, offset)
+	return n == len(b)
+}
+
+func (h *peHeaders) cString(rva uint32) string {
+	offset, ok := h.offset(rva)
+	if !ok {
+		return ""
+	}
+	b := make([]byte, maxImportNameLength)
+	n, _ := h.r.ReadAt(b, offset)
+	if end := bytes.IndexByte(b[:n], 0); end >= 0 {
+		return string(b[:end])
+	}
+	return ""
+}
+
+// delayImports reads the delay-load import table of a PE, as dll!function
+// strings like peFile.Imports, with functions imported by ordinal as
+// their hex ordinal. go-pe does not read this table. A malformed table
+// ends where it breaks
+func delayImports(r io.ReaderAt) []string {
+	h, err := readPEHeaders(r)
+	if err != nil {
+		return nil
+	}
+	table, _ := h.directory(imageDirectoryDelayImport)
+	if table == 0 {
+		return nil
+	}
+
+	thunkSize := uint32(4)
+	if h.plus {
+		thunkSize = 8
+	}
+	var imports []string
+	descriptor := make([]byte, delayDescriptorSize)
+	for i := uint32(0); i < maxDelayDescriptors; i++ {
+		if !h.readAt(descriptor, table+i*delayDescriptorSize) {
+			break
+		}
+		attributes := binary.LittleEndian.Uint32(descriptor)
+		nameRVA := binary.LittleEndian.Uint32(descriptor[4:])
+		namesRVA := binary.LittleEndian.Uint32(descriptor[16:])
+		if nameRVA == 0 {
+			break
+		}
+
+		// before Visual C++ 7, the table held addresses, not RVAs
+		rva := func(address uint64) uint32 {
+			if attributes&imageDelayAttributeRVA == 0 {
+				address -= h.imageBase
+			}
+			return uint32(address)
+		}
+		dll := h.cString(rva(uint64(nameRVA)))
+		if dll == "" {
+			break
+		}
+
+		thunk := make([]byte, thunkSize)
+		for j := uint32(0); j < maxDelayThunks; j++ {
+			if !h.readAt(thunk, rva(uint64(namesRVA))+j*thunkSize) {
+				break
+			}
+			var value uint64
+			var ordinal bool
+			if h.plus {
+				value = binary.LittleEndian.Uint64(thunk)
+				ordinal = value&(1<<63) != 0
+			} else {
+				value = uint64(binary.LittleEndian.Uint32(thunk))
+				ordinal = value&(1<<31) != 0
+			}
+			if value == 0 {
+				break
+			}
+			if ordinal {
+				imports = append(imports, fmt.Sprintf("%s!%#x", dll, value&0xFFFF))
+				continue
+			}
+			// a hint, then the name
+			function := h.cString(rva(value) + 2)
+			if function == "" {
+				break
+			}
+			imports = append(imports, dll+"!"+function)
+		}
+	}
+	return imports
+}
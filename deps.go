@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// depsRelations mark the edges a dependency tree follows other than imports
+var depsRelations = map[string]string{
+	edgeDelayImports: " (delay)",
+	edgeForwards:     " (forward)",
+}
+
+// depsTree prints the modules a PE loads, as ldd does for ELF binaries
+type depsTree struct {
+	out   io.Writer
+	edges map[*graphNode][]*graphEdge
+	depth int
+
+	// path holds the modules from the root to the one being printed, and
+	// shown those whose dependencies have been printed already
+	path  map[*graphNode]bool
+	shown map[*graphNode]bool
+
+	// missing counts each module once, however often it is imported
+	missing         map[*graphNode]bool
+	modules, cycles int
+}
+
+// newDepsTree follows the imports of a graph, and its delay imports and
+// forwards when asked to, up to depth modules deep, or any depth when
+// depth is negative
+func newDepsTree(g *graph, out io.Writer, depth int, delay, forwards bool) *depsTree {
+	t := &depsTree{
+		out:   out,
+		edges: make(map[*graphNode][]*graphEdge),
+		depth: depth,
+		path:  make(map[*graphNode]bool),
+		shown: make(map[*graphNode]bool),
+
+		missing: make(map[*graphNode]bool),
+	}
+	for _, e := range g.edges {
+		switch {
+		case e.Type == edgeImports,
+			e.Type == edgeDelayImports && delay,
+			e.Type == edgeForwards && forwards:
+			t.edges[e.From] = append(t.edges[e.From], e)
+		}
+	}
+	return t
+}
+
+// print prints the tree under a module. Each module's dependencies are
+// printed the first time it is reached; later it is marked as shown
+// above, and a module that depends on one of its own importers as a cycle
+func (t *depsTree) print(root *graphNode) {
+	fmt.Fprintf(t.out, "%s => %s\n", root.Name, root.Path)
+	t.path[root] = true
+	t.shown[root] = true
+	t.children(root, "", 1)
+}
+
+func (t *depsTree) children(node *graphNode, indent string, depth int) {
+	edges := t.edges[node]
+	for i, e := range edges {
+		branch, next := "├── ", "│   "
+		if i == len(edges)-1 {
+			branch, next = "└── ", "    "
+		}
+		to := e.To
+		line := fmt.Sprintf("%s%s%s => ", indent, branch, to.Name)
+
+		switch {
+		case to.Missing:
+			t.missing[to] = true
+			fmt.Fprintf(t.out, "%snot found%s [%d]\n", line, depsRelations[e.Type], depth)
+		case to.APISet:
+			fmt.Fprintf(t.out, "%sAPI set%s [%d]\n", line, depsRelations[e.Type], depth)
+		case t.path[to]:
+			t.cycles++
+			fmt.Fprintf(t.out, "%s%s%s [%d] (cycle)\n", line, to.Path, depsRelations[e.Type], depth)
+		case t.shown[to]:
+			fmt.Fprintf(t.out, "%s%s%s [%d] (shown above)\n", line, to.Path, depsRelations[e.Type], depth)
+		default:
+			t.modules++
+			t.shown[to] = true
+			fmt.Fprintf(t.out, "%s%s%s [%d]\n", line, to.Path, depsRelations[e.Type], depth)
+			if t.depth < 0 || depth < t.depth {
+				t.path[to] = true
+				t.children(to, indent+next, depth+1)
+				delete(t.path, to)
+			}
+		}
+	}
+}
+
+func depsMain(args []string) error {
+	flags := flag.NewFlagSet("deps", flag.ExitOnError)
+	newSelector := selectorFlags(flags)
+	var roots stringList
+	flags.Var(&roots, "root", "Dataset printed by ino, or directory to scan, to resolve modules in.\nMay be repeated. Defaults to the directory of the PE")
+	delay := flags.Bool("delay", false, "Follow delay imports too")
+	forwards := flags.Bool("forwards", false, "Follow forwarded exports too")
+	depth := flags.Int("depth", -1, "Follow at most this many imports from the PE")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: ino deps [options] <pe>\n\n")
+		fmt.Fprintf(flags.Output(), "Print the tree of modules a PE loads, as ldd does, with the path each\n")
+		fmt.Fprintf(flags.Output(), "resolves to and its depth, and the modules that are missing or that form\n")
+		fmt.Fprintf(flags.Output(), "cycles. The PE is a path, or a name or path in the -root datasets\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	sel, err := newSelector()
+	if err != nil {
+		fmt.Fprintln(flags.Output(), err)
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	pe := flags.Arg(0)
+	abs, err := filepath.Abs(pe)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(abs)
+	onDisk := err == nil && info.Mode().IsRegular()
+	if len(roots) == 0 {
+		if !onDisk {
+			return fmt.Errorf("%s is not a file, and there is no -root to find it in", pe)
+		}
+		roots = append(roots, filepath.Dir(abs))
+	}
+
+	// a PE outside the roots is scanned on its own
+	sources := append([]string(nil), roots...)
+	if onDisk && !withinRoots(abs, roots) {
+		sources = append(sources, abs)
+	}
+	reports, err := loadReports(sources, sel)
+	if err != nil {
+		return err
+	}
+
+	g := newGraph(reports)
+	root := g.find(abs)
+	if root == nil {
+		root = g.find(pe)
+	}
+	if root == nil || root.Missing {
+		return fmt.Errorf("%s is not in the dataset", pe)
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	tree := newDepsTree(g, out, *depth, *delay, *forwards)
+	tree.print(root)
+	fmt.Fprintf(out, "\n%d modules, %d missing, %d cycles\n", tree.modules, len(tree.missing), tree.cycles)
+	return out.Flush()
+}
+
+// withinRoots reports whether a path is beneath one of the directories
+// given as roots, and so is scanned with them
+func withinRoots(path string, roots []string) bool {
+	for _, root := range roots {
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			continue
+		}
+		if info, err := os.Stat(abs); err != nil || !info.IsDir() {
+			continue
+		}
+		rel, err := filepath.Rel(abs, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDepsTree(t *testing.T) {
+	app := peReport(`c:\app\app.exe`, imports("a.dll", "A"), imports("b.dll", "B"), imports("gone.dll", "G"))
+	app.DelayImports = []PEFunction{imports("d.dll", "D")}
+	a := peReport(`c:\app\a.dll`, imports("b.dll", "B"), imports("gone.dll", "G"))
+	a.Forwards = []PEFunction{imports("f.dll", "F")}
+	b := peReport(`c:\app\b.dll`, imports("a.dll", "A"))
+	g := newGraph([]*Report{app, a, b, peReport(`c:\app\d.dll`), peReport(`c:\app\f.dll`)})
+
+	tests := []struct {
+		name            string
+		depth           int
+		delay, forwards bool
+		want            string
+	}{
+		{"cycles, shown above and missing", -1, false, false, `app.exe => c:\app\app.exe
+├── a.dll => c:\app\a.dll [1]
+│   ├── b.dll => c:\app\b.dll [2]
+│   │   └── a.dll => c:\app\a.dll [3] (cycle)
+│   └── gone.dll => not found [2]
+├── b.dll => c:\app\b.dll [1] (shown above)
+└── gone.dll => not found [1]
+
+2 modules, 1 missing, 1 cycles
+`},
+		{"depth", 1, false, false, `app.exe => c:\app\app.exe
+├── a.dll => c:\app\a.dll [1]
+├── b.dll => c:\app\b.dll [1]
+└── gone.dll => not found [1]
+
+2 modules, 1 missing, 0 cycles
+`},
+		{"delay imports and forwards", 2, true, true, `app.exe => c:\app\app.exe
+├── a.dll => c:\app\a.dll [1]
+│   ├── b.dll => c:\app\b.dll [2]
+│   ├── gone.dll => not found [2]
+│   └── f.dll => c:\app\f.dll (forward) [2]
+├── b.dll => c:\app\b.dll [1] (shown above)
+├── gone.dll => not found [1]
+└── d.dll => c:\app\d.dll (delay) [1]
+
+4 modules, 1 missing, 0 cycles
+`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			tree := newDepsTree(g, &out, test.depth, test.delay, test.forwards)
+			tree.print(g.find("app.exe"))
+			fmt.Fprintf(&out, "\n%d modules, %d missing, %d cycles\n", tree.modules, len(tree.missing), tree.cycles)
+			if out.String() != test.want {
+				t.Errorf("got\n%s\nwant\n%s", out.String(), test.want)
+			}
+		})
+	}
+
+	t.Run("API sets are not missing", func(t *testing.T) {
+		g := newGraph([]*Report{
+			peReport(`c:\app\app.exe`, imports("api-ms-win-core-file-l1-1-0.dll", "CreateFileW"), imports("ext-ms-win-gdi-draw-l1-1-0.dll", "BitBlt")),
+		})
+		var out bytes.Buffer
+		tree := newDepsTree(g, &out, -1, false, false)
+		tree.print(g.find("app.exe"))
+		want := `app.exe => c:\app\app.exe
+├── api-ms-win-core-file-l1-1-0.dll => API set [1]
+└── ext-ms-win-gdi-draw-l1-1-0.dll => API set [1]
+`
+		if out.String() != want || len(tree.missing) != 0 || tree.modules != 0 {
+			t.Errorf("got\n%s\nwith %d missing and %d modules, want\n%s", out.String(), len(tree.missing), tree.modules, want)
+		}
+	})
+
+	t.Run("missing modules are counted once", func(t *testing.T) {
+		g := newGraph([]*Report{
+			peReport(`c:\app\app.exe`, imports("a.dll", "A"), imports("gone.dll", "G")),
+			peReport(`c:\app\a.dll`, imports("GONE.DLL", "G")),
+		})
+		var out bytes.Buffer
+		tree := newDepsTree(g, &out, -1, false, false)
+		tree.print(g.find("app.exe"))
+		if n := strings.Count(out.String(), "not found"); n != 2 || len(tree.missing) != 1 {
+			t.Errorf("printed %d missing modules and counted %d in\n%s", n, len(tree.missing), out.String())
+		}
+	})
+}
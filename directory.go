@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"strconv"
+	"strings"
+
+	winacl "github.com/kgoins/go-winacl/pkg"
+)
+
+// ObjectReport contains the parsed security descriptors of a directory object
+type ObjectReport struct {
+	DN          string   `json:"DN"`
+	ObjectClass []string `json:"ObjectClass"`
+	ObjectSid   string   `json:"ObjectSid"`
+	DACL        DACL     `json:"DACL"`
+
+	AllowedToActOnBehalfOfOtherIdentity *DACL `json:",omitempty"`
+	GroupMSAMembership                  *DACL `json:",omitempty"`
+}
+
+// directoryEntry is a directory object as a set of raw attribute values,
+// keyed by lower-cased attribute name
+type directoryEntry struct {
+	DN    string
+	Attrs map[string][][]byte
+}
+
+// first returns the first value of an attribute, or nil if it is absent
+func (e *directoryEntry) first(attr string) []byte {
+	values := e.Attrs[strings.ToLower(attr)]
+	if len(values) == 0 {
+		return nil
+	}
+	return values[0]
+}
+
+// strings returns every value of an attribute as a string
+func (e *directoryEntry) strings(attr string) (out []string) {
+	for _, value := range e.Attrs[strings.ToLower(attr)] {
+		out = append(out, string(value))
+	}
+	return
+}
+
+// int returns the first value of an attribute as an integer, or 0 if it is
+// absent or malformed
+func (e *directoryEntry) int(attr string) int64 {
+	value, _ := strconv.ParseInt(string(e.first(attr)), 10, 64)
+	return value
+}
+
+func newObjectReport(entry *directoryEntry) (*ObjectReport, error) {
+	report := &ObjectReport{}
+	report.DN = entry.DN
+	report.ObjectClass = entry.strings("objectClass")
+
+	if raw := entry.first("objectSid"); raw != nil {
+		sid, err := sidFromBytes(raw)
+		if err != nil {
+			return report, err
+		}
+		report.ObjectSid = sid.String()
+	}
+
+	if raw := entry.first("nTSecurityDescriptor"); raw != nil {
+		sd, err := winacl.NewNtSecurityDescriptor(raw)
+		if err != nil {
+			return report, err
+		}
+		report.DACL = newDACL(sd, winacl.DirectoryServiceObject)
+	}
+
+	if raw := entry.first("msDS-AllowedToActOnBehalfOfOtherIdentity"); raw != nil {
+		sd, err := winacl.NewNtSecurityDescriptor(raw)
+		if err != nil {
+			return report, err
+		}
+		dacl := newDACL(sd, winacl.DirectoryServiceObject)
+		report.AllowedToActOnBehalfOfOtherIdentity = &dacl
+	}
+
+	if raw := entry.first("msDS-GroupMSAMembership"); raw != nil {
+		sd, err := winacl.NewNtSecurityDescriptor(raw)
+		if err != nil {
+			return report, err
+		}
+		dacl := newDACL(sd, winacl.DirectoryServiceObject)
+		report.GroupMSAMembership = &dacl
+	}
+	return report, nil
+}
+
+func printObjectReport(entry *directoryEntry) {
+	report, err := newObjectReport(entry)
+	if err != nil {
+		log.Printf("#newObjectReport - %s - %s\n", entry.DN, err)
+		return
+	}
+	jsPrint(report)
+}
+
+// learnEntrySID records the name of an entry's objectSid, so that ACEs
+// granted to it elsewhere in the same collection are readable
+func learnEntrySID(entry *directoryEntry) {
+	raw := entry.first("objectSid")
+	if raw == nil {
+		return
+	}
+	sid, err := sidFromBytes(raw)
+	if err != nil {
+		return
+	}
+
+	var name string
+	for _, attr := range []string{"sAMAccountName", "name", "cn"} {
+		if value := entry.first(attr); value != nil {
+			name = string(value)
+			break
+		}
+	}
+	if name == "" {
+		// fall back to the value of the leading RDN
+		rdn := strings.SplitN(entry.DN, ",", 2)[0]
+		name = rdn[strings.Index(rdn, "=")+1:]
+	}
+	knownSIDs[sid.String()] = name
+}
+
+func sidFromBytes(raw []byte) (winacl.SID, error) {
+	return winacl.NewSID(bytes.NewBuffer(raw), len(raw))
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"unicode/utf16"
+)
+
+// errUnknownFS is returned for volumes that hold no supported file system
+var errUnknownFS = errors.New("no NTFS or FAT file system")
+
+// openDisk returns the disk held in an image file: the virtual disk of a
+// VHD or VHDX, or otherwise the raw file or block device itself
+func openDisk(f *os.File) (disk io.ReaderAt, size int64, sectorSize int64, err error) {
+	// Stat reports no size for block devices
+	size, err = f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return
+	}
+
+	ident := make([]byte, 8)
+	f.ReadAt(ident, 0)
+	if string(ident) == "vhdxfile" {
+		vhdx, err := newVHDXDisk(f, size)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		return vhdx, vhdx.size, vhdx.sectorSize, nil
+	}
+
+	if size >= 512 {
+		f.ReadAt(ident, size-512)
+		if string(ident) == "conectix" {
+			disk, size, err = newVHDDisk(f, size)
+			return disk, size, 512, err
+		}
+	}
+	return f, size, 512, nil
+}
+
+// partition is a volume on a disk. Index is its number in the partition
+// table, counting from one, with MBR logical partitions numbered from five
+type partition struct {
+	Index  int
+	Offset int64
+	Size   int64
+}
+
+// MBR partition types
+const (
+	mbrExtendedCHS   = 0x05
+	mbrExtendedLBA   = 0x0F
+	mbrExtendedLinux = 0x85
+	mbrProtective    = 0xEE
+)
+
+// readPartitions lists the partitions of a disk from its MBR, or from
+// its GPT when the MBR is protective. A disk that holds a file system
+// rather than a partition table has no partitions
+func readPartitions(disk io.ReaderAt, size, sectorSize int64) ([]partition, error) {
+	mbr := make([]byte, 512)
+	_, err := disk.ReadAt(mbr, 0)
+	if err != nil {
+		return nil, err
+	}
+	if mbr[510] != 0x55 || mbr[511] != 0xAA || detectFS(mbr) != "" {
+		return nil, nil
+	}
+
+	var partitions []partition
+	for i := 0; i < 4; i++ {
+		entry := mbr[446+i*16:]
+		kind := entry[4]
+		start := int64(binary.LittleEndian.Uint32(entry[8:]))
+		sectors := int64(binary.LittleEndian.Uint32(entry[12:]))
+
+		switch kind {
+		case 0:
+		case mbrProtective:
+			return readGPT(disk, size)
+		case mbrExtendedCHS, mbrExtendedLBA, mbrExtendedLinux:
+			logical, err := readExtended(disk, start, sectorSize)
+			if err != nil {
+				return partitions, err
+			}
+			partitions = append(partitions, logical...)
+		default:
+			partitions = append(partitions, partition{
+				Index:  i + 1,
+				Offset: start * sectorSize,
+				Size:   sectors * sectorSize,
+			})
+		}
+	}
+	return partitions, nil
+}
+
+// readExtended follows the chain of extended boot records of an MBR
+// extended partition. Each holds one logical partition, relative to
+// itself, and a link to the next, relative to the extended partition
+func readExtended(disk io.ReaderAt, extendedStart, sectorSize int64) ([]partition, error) {
+	var partitions []partition
+	ebr := make([]byte, 512)
+	next := int64(0)
+	seen := make(map[int64]bool)
+	for index := 5; !seen[next]; index++ {
+		seen[next] = true
+		base := extendedStart + next
+		_, err := disk.ReadAt(ebr, base*sectorSize)
+		if err != nil {
+			return partitions, err
+		}
+		if ebr[510] != 0x55 || ebr[511] != 0xAA {
+			return partitions, fmt.Errorf("bad extended boot record at sector %d", base)
+		}
+
+		if ebr[446+4] != 0 {
+			partitions = append(partitions, partition{
+				Index:  index,
+				Offset: (base + int64(binary.LittleEndian.Uint32(ebr[446+8:]))) * sectorSize,
+				Size:   int64(binary.LittleEndian.Uint32(ebr[446+12:])) * sectorSize,
+			})
+		}
+
+		if ebr[462+4] == 0 {
+			break
+		}
+		next = int64(binary.LittleEndian.Uint32(ebr[462+8:]))
+	}
+	return partitions, nil
+}
+
+// readGPT lists the partitions of a GUID partition table. Its header
+// follows the protective MBR in the second logical sector, which is
+// looked for at both common sector sizes
+func readGPT(disk io.ReaderAt, size int64) ([]partition, error) {
+	header := make([]byte, 92)
+	var sectorSize int64
+	for _, candidate := range []int64{512, 4096} {
+		_, err := disk.ReadAt(header, candidate)
+		if err == nil && string(header[:8]) == "EFI PART" {
+			sectorSize = candidate
+			break
+		}
+	}
+	if sectorSize == 0 {
+		return nil, errors.New("protective MBR without a GPT header")
+	}
+
+	entriesLBA := int64(binary.LittleEndian.Uint64(header[72:]))
+	count := int64(binary.LittleEndian.Uint32(header[80:]))
+	entrySize := int64(binary.LittleEndian.Uint32(header[84:]))
+	if entrySize < 128 || entrySize > 1<<20 || count > (1<<20)/entrySize {
+		return nil, fmt.Errorf("GPT has %d entries of %d bytes", count, entrySize)
+	}
+
+	entries := make([]byte, count*entrySize)
+	_, err := disk.ReadAt(entries, entriesLBA*sectorSize)
+	if err != nil {
+		return nil, fmt.Errorf("GPT entries: %s", err)
+	}
+
+	var partitions []partition
+	for i := int64(0); i < count; i++ {
+		entry := entries[i*entrySize:]
+		if guidAt(entry) == "" {
+			continue
+		}
+		first := int64(binary.LittleEndian.Uint64(entry[32:]))
+		last := int64(binary.LittleEndian.Uint64(entry[40:]))
+		if first > last || last*sectorSize >= size {
+			continue
+		}
+		partitions = append(partitions, partition{
+			Index:  int(i) + 1,
+			Offset: first * sectorSize,
+			Size:   (last - first + 1) * sectorSize,
+		})
+	}
+	return partitions, nil
+}
+
+// detectFS names the file system whose boot sector is given, if supported
+func detectFS(boot []byte) string {
+	if len(boot) < 512 {
+		return ""
+	}
+	if string(boot[3:11]) == "NTFS    " {
+		return "NTFS"
+	}
+
+	// FAT has no signature of its own, so the BIOS parameter block is
+	// checked for sanity instead
+	bytesPerSector := binary.LittleEndian.Uint16(boot[11:])
+	sectorsPerCluster := boot[13]
+	reserved := binary.LittleEndian.Uint16(boot[14:])
+	fats := boot[16]
+	jump := boot[0] == 0xEB || boot[0] == 0xE9
+	if jump && boot[510] == 0x55 && boot[511] == 0xAA &&
+		bytesPerSector >= 512 && bytesPerSector <= 4096 && bytesPerSector&(bytesPerSector-1) == 0 &&
+		sectorsPerCluster != 0 && sectorsPerCluster&(sectorsPerCluster-1) == 0 &&
+		reserved != 0 && (fats == 1 || fats == 2) {
+		return "FAT"
+	}
+	return ""
+}
+
+// openVolume opens the file system of the volume at offset
+func openVolume(disk io.ReaderAt, offset, size int64) (fs.FS, error) {
+	boot := make([]byte, 512)
+	_, err := disk.ReadAt(boot, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	volume := io.NewSectionReader(disk, offset, size)
+	switch detectFS(boot) {
+	case "NTFS":
+		return newNTFSFS(volume)
+	case "FAT":
+		return newFATFS(volume)
+	}
+	return nil, errUnknownFS
+}
+
+// utf16String decodes a NUL padded UTF-16LE string
+func utf16String(b []byte) string {
+	units := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		unit := binary.LittleEndian.Uint16(b[i:])
+		if unit == 0 {
+			break
+		}
+		units = append(units, unit)
+	}
+	return string(utf16.Decode(units))
+}
+
+// trimPadding strips the space padding of fixed width names
+func trimPadding(b []byte) string {
+	return string(bytes.TrimRight(b, " \x00"))
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	winacl "github.com/kgoins/go-winacl/pkg"
+	"www.velocidex.com/golang/go-ntfs/parser"
+)
+
+// The images of testdata are generated by testdata/mkdisks.py. Each FAT
+// volume holds a PE as BOOT.EXE, and under Windows/System32 with a short
+// and a long file name, and the NTFS volume one as a text document
+func TestScanDisk(t *testing.T) {
+	fat := []string{
+		"/BOOT.EXE",
+		"/Windows/System32/Long File Name Library.dll",
+		"/Windows/System32/hello.exe",
+	}
+	prefixed := func(prefix string, paths ...string) []string {
+		var out []string
+		for _, p := range paths {
+			out = append(out, prefix+p)
+		}
+		return out
+	}
+	// an extended partition's logical volumes are numbered from 5
+	mbr := append(prefixed("/partition1", fat...), prefixed("/partition5", fat...)...)
+	gpt := append(prefixed("/partition1", fat...), "/partition2/Folder A/Folder B/Hello world text document.txt")
+
+	tests := []struct {
+		name      string
+		image     string
+		partition int
+		want      []string
+	}{
+		{"fixed VHD with MBR", "fixed.vhd.gz", 0, mbr},
+		{"dynamic VHD with GPT", "dynamic.vhd.gz", 0, gpt},
+		{"VHDX with MBR", "mbr.vhdx.gz", 0, mbr},
+		{"VHDX with GPT", "gpt.vhdx.gz", 0, gpt},
+		{"a logical volume", "mbr.vhdx.gz", 5, fat},
+		{"an NTFS partition", "gpt.vhdx.gz", 2, []string{"/Folder A/Folder B/Hello world text document.txt"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := record(t)
+			image := openTestData(t, test.image)
+			if err := scanImage(image, test.image, 0, test.partition, "/", &selector{maxDepth: -1}); err != nil {
+				t.Fatal(err)
+			}
+			if got := r.paths(); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+			for _, report := range r.reports {
+				if report.Type != "directory" && report.ImpHash == "" {
+					t.Errorf("%s has no imphash", report.Path)
+				}
+			}
+		})
+	}
+}
+
+func TestScanNTFSDACL(t *testing.T) {
+	r := record(t)
+	image := openTestData(t, "gpt.vhdx.gz")
+	if err := scanImage(image, "gpt.vhdx", 0, 2, "/Folder A", &selector{maxDepth: -1}); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.reports) != 2 {
+		t.Fatalf("got %d reports, want the directory and the PE", len(r.reports))
+	}
+	for _, report := range r.reports {
+		if report.DACL == nil || len(report.DACL.Aces) == 0 {
+			t.Errorf("%s has no DACL", report.Path)
+		}
+	}
+}
+
+func TestNTFSSecureLookupBounds(t *testing.T) {
+	// a corrupt $SII entry claiming a 4G descriptor is rejected before
+	// anything is allocated for it
+	s := &ntfsSecure{
+		sds:         strings.NewReader(""),
+		index:       map[uint32]sdsHeader{256: {SecurityID: 256, Length: 0xFFFFFFFF}},
+		descriptors: make(map[uint32]winacl.NtSecurityDescriptor),
+	}
+	_, err := s.lookup(256)
+	if err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("got %v, want an entry too large", err)
+	}
+}
+
+// hugeStream is an attribute stream whose corrupt runlist claims 1T
+type hugeStream struct{ *strings.Reader }
+
+func (hugeStream) Ranges() []parser.Range {
+	return []parser.Range{{Offset: 0, Length: 1 << 40}}
+}
+
+func TestNTFSReadStreamBounds(t *testing.T) {
+	_, err := readStream(hugeStream{strings.NewReader("")}, maxDescriptorSize)
+	if err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("got %v, want a stream too large", err)
+	}
+}
+
+// patchedImage writes a copy of a testdata image, changed by patch, to a
+// temporary file
+func patchedImage(t *testing.T, name string, patch func(image []byte)) *os.File {
+	t.Helper()
+	f := openTestData(t, name)
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	image, err := io.ReadAll(io.NewSectionReader(f, 0, info.Size()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	patch(image)
+	f, err = os.Create(filepath.Join(t.TempDir(), strings.TrimSuffix(name, ".gz")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	if _, err := f.Write(image); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestMalformedVHDX(t *testing.T) {
+	// setRegion changes a region's entry in both copies of the region table
+	setRegion := func(image []byte, guid string, offset uint64, length uint32) {
+		for _, table := range []int{192 * 1024, 256 * 1024} {
+			count := int(binary.LittleEndian.Uint32(image[table+8:]))
+			for i := 0; i < count; i++ {
+				entry := image[table+16+i*32:]
+				if guidAt(entry) != guid {
+					continue
+				}
+				if offset != 0 {
+					binary.LittleEndian.PutUint64(entry[16:], offset)
+				}
+				binary.LittleEndian.PutUint32(entry[24:], length)
+			}
+		}
+	}
+	region := func(image []byte, guid string) (offset uint64, length uint32) {
+		regions, err := readVHDXRegionTable(bytes.NewReader(image), 192*1024)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return regions[guid][0], uint32(regions[guid][1])
+	}
+	// setItem changes the first four bytes of a metadata item
+	setItem := func(image []byte, guid string, value uint32) {
+		offset, _ := region(image, vhdxMetadataRegion)
+		metadata := image[offset:]
+		count := int(binary.LittleEndian.Uint16(metadata[10:]))
+		for i := 0; i < count; i++ {
+			entry := metadata[32+i*32:]
+			if guidAt(entry) == guid {
+				binary.LittleEndian.PutUint32(metadata[binary.LittleEndian.Uint32(entry[16:]):], value)
+			}
+		}
+	}
+
+	tests := []struct {
+		name  string
+		patch func(image []byte)
+	}{
+		{"a metadata region shorter than its header", func(image []byte) {
+			offset, _ := region(image, vhdxMetadataRegion)
+			setRegion(image, vhdxMetadataRegion, offset, 8)
+		}},
+		{"a 4G metadata region", func(image []byte) {
+			offset, _ := region(image, vhdxMetadataRegion)
+			setRegion(image, vhdxMetadataRegion, offset, 0xFFFFFFFF)
+		}},
+		{"a 4G BAT", func(image []byte) {
+			offset, _ := region(image, vhdxBATRegion)
+			setRegion(image, vhdxBATRegion, offset, 0xFFFFFFF8)
+		}},
+		{"a BAT past the end of the file", func(image []byte) {
+			_, length := region(image, vhdxBATRegion)
+			setRegion(image, vhdxBATRegion, 1<<62, length)
+		}},
+		{"a zero sector size", func(image []byte) {
+			setItem(image, vhdxLogicalSectorSize, 0)
+		}},
+		{"a one byte sector size with 16M blocks", func(image []byte) {
+			setItem(image, vhdxLogicalSectorSize, 1)
+			setItem(image, vhdxFileParameters, 16<<20)
+		}},
+		{"a block size that is not a power of two", func(image []byte) {
+			setItem(image, vhdxFileParameters, 3<<20)
+		}},
+		{"a block size under 1M", func(image []byte) {
+			setItem(image, vhdxFileParameters, 512<<10)
+		}},
+		{"a block size over 256M", func(image []byte) {
+			setItem(image, vhdxFileParameters, 512<<20)
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			record(t)
+			image := patchedImage(t, "mbr.vhdx.gz", test.patch)
+			err := scanImage(image, "mbr.vhdx", 0, 0, "/", &selector{maxDepth: -1})
+			if err == nil || strings.Contains(err.Error(), "panic") {
+				t.Errorf("got error %v, want the VHDX rejected", err)
+			}
+		})
+	}
+}
+
+func TestMalformedVHD(t *testing.T) {
+	// setHeader changes a big endian field of the dynamic disk header
+	setHeader := func(image []byte, field int, value uint64, size int) {
+		footer := image[len(image)-512:]
+		header := image[binary.BigEndian.Uint64(footer[16:]):]
+		if size == 8 {
+			binary.BigEndian.PutUint64(header[field:], value)
+		} else {
+			binary.BigEndian.PutUint32(header[field:], uint32(value))
+		}
+	}
+
+	tests := []struct {
+		name  string
+		patch func(image []byte)
+	}{
+		{"a 16G block allocation table", func(image []byte) { setHeader(image, 28, 0xFFFFFFFF, 4) }},
+		{"a table past the end of the file", func(image []byte) { setHeader(image, 16, 1<<40, 8) }},
+		{"a negative table offset", func(image []byte) { setHeader(image, 16, 1<<63, 8) }},
+		{"too few blocks for the disk", func(image []byte) { setHeader(image, 28, 1, 4) }},
+		{"a 4G block size", func(image []byte) { setHeader(image, 32, 0xFFFFFE00, 4) }},
+		{"a negative disk size", func(image []byte) {
+			binary.BigEndian.PutUint64(image[len(image)-512+48:], 1<<63)
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			record(t)
+			image := patchedImage(t, "dynamic.vhd.gz", test.patch)
+			err := scanImage(image, "dynamic.vhd", 0, 0, "/", &selector{maxDepth: -1})
+			if err == nil || strings.Contains(err.Error(), "panic") {
+				t.Errorf("got error %v, want the VHD rejected", err)
+			}
+		})
+	}
+}
+
+func TestMalformedGPT(t *testing.T) {
+	for _, entries := range [][2]uint32{{0xFFFFFFFF, 0x80000000}, {0x10000, 0x10000}, {1, 64}} {
+		disk := make([]byte, 4096)
+		copy(disk[512:], "EFI PART")
+		binary.LittleEndian.PutUint64(disk[512+72:], 2)
+		binary.LittleEndian.PutUint32(disk[512+80:], entries[0])
+		binary.LittleEndian.PutUint32(disk[512+84:], entries[1])
+		if _, err := readGPT(bytes.NewReader(disk), int64(len(disk))); err == nil {
+			t.Errorf("read %d entries of %d bytes", entries[0], entries[1])
+		}
+	}
+}
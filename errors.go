@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Phases of reporting a file, as recorded in an ErrorRecord
+const (
+	phaseWalk      = "walk"
+	phaseOpen      = "open"
+	phaseContainer = "container"
+	phaseParse     = "parse"
+	phaseImports   = "imports"
+	phaseDACL      = "dacl"
+)
+
+// ErrorRecord is printed among the reports of a scan for each file, or
+// directory, volume or container, that could not be fully reported
+type ErrorRecord struct {
+	Type    string `json:"Type"`
+	Path    string `json:"Path"`
+	Phase   string `json:"Phase"`
+	Message string `json:"Message"`
+}
+
+// Summary counts what a scan reported. It is printed to stderr when the
+// scan ends
+type Summary struct {
+	Type        string         `json:"Type"`
+	Files       int            `json:"Files"`
+	Directories int            `json:"Directories"`
+	Errors      int            `json:"Errors"`
+	Panics      int            `json:"Panics"`
+	Timeouts    int            `json:"Timeouts"`
+	Phases      map[string]int `json:"Phases,omitempty"`
+}
+
+var (
+	// summary is counted as reports and errors are printed
+	summary = Summary{Type: "summary", Phases: make(map[string]int)}
+
+	// fileTimeout is how long a file may take to report, or 0 for no limit
+	fileTimeout time.Duration
+
+	// failOn is the comma separated phases, or any, panic or timeout,
+	// whose errors make a scan exit 1
+	failOn string
+)
+
+// phaseError is an error in a phase of reporting a file
+type phaseError struct {
+	phase string
+	err   error
+}
+
+func (e *phaseError) Error() string { return e.err.Error() }
+func (e *phaseError) Unwrap() error { return e.err }
+
+// inPhase tags an error with the phase it happened in
+func inPhase(phase string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &phaseError{phase: phase, err: err}
+}
+
+// errorFlags adds the flags that control per-file timeouts and the exit
+// code of a scan
+func errorFlags(flags *flag.FlagSet) {
+	flags.DurationVar(&fileTimeout, "timeout", 0, "Give up on a file after this long, like 30s. No limit by default")
+	flags.StringVar(&failOn, "fail-on", "", "Exit 1 when errors were recorded in these comma separated phases\n[walk|open|container|parse|imports|dacl], or for any, panic or timeout")
+}
+
+// checkFailOn validates -fail-on
+func checkFailOn() error {
+	for _, phase := range strings.Split(failOn, ",") {
+		switch phase {
+		case "", "any", "panic", "timeout", phaseWalk, phaseOpen, phaseContainer, phaseParse, phaseImports, phaseDACL:
+		default:
+			return fmt.Errorf("-fail-on: unknown phase %q", phase)
+		}
+	}
+	return nil
+}
+
+// printReport prints a report, counting it in the summary
+func printReport(report *Report) {
+	if report.Type == "directory" {
+		summary.Directories++
+	} else {
+		summary.Files++
+	}
+	emit(report)
+}
+
+// printError prints an ErrorRecord for a path. The phase is taken from
+// the error when it has one
+func printError(path, phase string, err error) {
+	var tagged *phaseError
+	if errors.As(err, &tagged) {
+		phase = tagged.phase
+	}
+	summary.Errors++
+	summary.Phases[phase]++
+	emit(ErrorRecord{Type: "error", Path: path, Phase: phase, Message: err.Error()})
+}
+
+// finishScan prints the summary to stderr, and exits 1 when -fail-on
+// matches an error that was recorded
+func finishScan() {
+	output.close()
+	serialized, _ := json.Marshal(summary)
+	fmt.Fprintln(os.Stderr, string(serialized))
+
+	for _, phase := range strings.Split(failOn, ",") {
+		switch {
+		case phase == "":
+		case phase == "any" && summary.Errors > 0,
+			phase == "panic" && summary.Panics > 0,
+			phase == "timeout" && summary.Timeouts > 0,
+			summary.Phases[phase] > 0:
+			os.Exit(1)
+		}
+	}
+	os.Exit(0)
+}
+
+// errAbandoned is returned by the readers of a file given up on
+var errAbandoned = errors.New("given up on after -timeout")
+
+// fileJob is the reporting of a single file. Its phase is what it is
+// doing, which a panic or timeout is attributed to
+type fileJob struct {
+	phase atomic.Value
+
+	// abandoned is set once runFile gives up on the job, and is locked
+	// by reads through the job's readers
+	lock      sync.RWMutex
+	abandoned bool
+}
+
+func (j *fileJob) setPhase(phase string) {
+	j.phase.Store(phase)
+}
+
+// reader wraps the reader of a file, which its owner closes once runFile
+// returns. Reads fail once the job is abandoned, and one in progress
+// holds runFile until it ends, so the file is not read after it is closed
+func (j *fileJob) reader(r io.ReaderAt) io.ReaderAt {
+	return &jobReader{job: j, r: r}
+}
+
+func (j *fileJob) abandon() {
+	j.lock.Lock()
+	j.abandoned = true
+	j.lock.Unlock()
+}
+
+type jobReader struct {
+	job *fileJob
+	r   io.ReaderAt
+}
+
+func (r *jobReader) ReadAt(p []byte, off int64) (int, error) {
+	r.job.lock.RLock()
+	defer r.job.lock.RUnlock()
+	if r.job.abandoned {
+		return 0, errAbandoned
+	}
+	return r.r.ReadAt(p, off)
+}
+
+// runFile reports a file with report, recovering from panics, so that
+// one malformed PE cannot end a scan, and giving up after -timeout. A
+// file given up on is abandoned, not stopped, so report must not print,
+// must read the file through job.reader, and must lock what it shares
+// with other files
+func runFile(report func(job *fileJob) error) error {
+	type result struct {
+		err      error
+		panicked bool
+	}
+	job := &fileJob{}
+	job.setPhase(phaseParse)
+	done := make(chan result, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- result{inPhase(job.phase.Load().(string), fmt.Errorf("panic: %v", r)), true}
+			}
+		}()
+		done <- result{err: report(job)}
+	}()
+
+	var timeout <-chan time.Time
+	if fileTimeout > 0 {
+		timer := time.NewTimer(fileTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case r := <-done:
+		if r.panicked {
+			summary.Panics++
+		}
+		return r.err
+	case <-timeout:
+		job.abandon()
+		summary.Timeouts++
+		return inPhase(job.phase.Load().(string), fmt.Errorf("timed out after %s", fileTimeout))
+	}
+}
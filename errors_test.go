@@ -0,0 +1,128 @@
+package main
+
+import (
+	"io"
+	"io/fs"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowReader takes delay over each read, counting them
+type slowReader struct {
+	r     io.ReaderAt
+	delay time.Duration
+	reads int32
+}
+
+func (r *slowReader) ReadAt(p []byte, off int64) (int, error) {
+	atomic.AddInt32(&r.reads, 1)
+	time.Sleep(r.delay)
+	return r.r.ReadAt(p, off)
+}
+
+// withTimeout sets -timeout for a test
+func withTimeout(t *testing.T, timeout time.Duration) {
+	previous := fileTimeout
+	fileTimeout = timeout
+	t.Cleanup(func() { fileTimeout = previous })
+}
+
+func TestTimeoutStopsReading(t *testing.T) {
+	r := record(t)
+	withTimeout(t, 20*time.Millisecond)
+	pe := openTestData(t, "reserve.cab.gz")
+	fsys, err := openContainer(pe, 1<<20, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := fsys.Open("foo.dll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slow := &slowReader{r: strings.NewReader(string(data)), delay: 5 * time.Millisecond}
+	reportPE(newImagePEReport("", "foo.dll"), slow, func(*Report) error { return nil })
+	if len(r.errors) != 1 || !strings.Contains(r.errors[0].Message, "timed out") {
+		t.Fatalf("got errors %v, want a timeout", r.errors)
+	}
+	if summary.Timeouts != 1 {
+		t.Errorf("got %d timeouts, want 1", summary.Timeouts)
+	}
+
+	// the report goes on in the background, but cannot read the file
+	read := atomic.LoadInt32(&slow.reads)
+	time.Sleep(50 * time.Millisecond)
+	if after := atomic.LoadInt32(&slow.reads); after != read {
+		t.Errorf("read %d times after the timeout", after-read)
+	}
+}
+
+// raceAbandoned has a file abandoned after -timeout while it calls
+// lookup, which is then called again as for the next file. Sleeping,
+// unlike waiting for the abandoned file, leaves what lookup shares
+// unsynchronized for the race detector unless it is locked. Run the
+// tests that use it with -race
+func raceAbandoned(t *testing.T, lookup func()) {
+	withTimeout(t, 10*time.Millisecond)
+	start := make(chan bool)
+	done := make(chan bool)
+	err := runFile(func(job *fileJob) error {
+		defer close(done)
+		job.setPhase(phaseDACL)
+		<-start
+		lookup()
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("got %v, want a timeout", err)
+	}
+
+	close(start)
+	time.Sleep(50 * time.Millisecond)
+	lookup()
+	<-done
+}
+
+func TestTimeoutRace(t *testing.T) {
+	fsys := openTestVolume(t, "gpt.vhdx.gz", 2)
+	f, err := fsys.Open("Folder A/Folder B/Hello world text document.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raceAbandoned(t, func() {
+		report := newImagePEReport("", "Hello world text document.txt")
+		if err := populateImageFilePerms(report, f); err != nil || report.DACL == nil {
+			t.Errorf("got DACL %v and error %v", report.DACL, err)
+		}
+	})
+}
+
+// openTestVolume opens a partition of a disk image of testdata
+func openTestVolume(t *testing.T, image string, index int) fs.FS {
+	t.Helper()
+	disk, size, sectorSize, err := openDisk(openTestData(t, image))
+	if err != nil {
+		t.Fatal(err)
+	}
+	partitions, err := readPartitions(disk, size, sectorSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range partitions {
+		if p.Index == index {
+			fsys, err := openVolume(disk, p.Offset, p.Size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return fsys
+		}
+	}
+	t.Fatalf("%s has no partition %d", image, index)
+	return nil
+}
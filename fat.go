@@ -0,0 +1,375 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FAT directory entry attributes
+const (
+	fatAttrVolumeLabel = 0x08
+	fatAttrDirectory   = 0x10
+	fatAttrLongName    = 0x0F
+)
+
+// fatFS is a read-only fs.FS over a FAT12, FAT16 or FAT32 volume, with
+// VFAT long file names. FAT stores no security descriptors
+type fatFS struct {
+	r    io.ReaderAt
+	bits int
+
+	clusterSize int64
+	dataOffset  int64
+	clusters    uint32
+	fat         []byte
+
+	// FAT12 and FAT16 keep the root directory in a fixed region, while on
+	// FAT32 it is a cluster chain like any other directory
+	rootOffset  int64
+	rootSize    int64
+	rootCluster uint32
+}
+
+func newFATFS(r io.ReaderAt) (*fatFS, error) {
+	boot := make([]byte, 512)
+	_, err := r.ReadAt(boot, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	bytesPerSector := int64(binary.LittleEndian.Uint16(boot[11:]))
+	sectorsPerCluster := int64(boot[13])
+	reserved := int64(binary.LittleEndian.Uint16(boot[14:]))
+	fats := int64(boot[16])
+	rootEntries := int64(binary.LittleEndian.Uint16(boot[17:]))
+	totalSectors := int64(binary.LittleEndian.Uint16(boot[19:]))
+	if totalSectors == 0 {
+		totalSectors = int64(binary.LittleEndian.Uint32(boot[32:]))
+	}
+	fatSectors := int64(binary.LittleEndian.Uint16(boot[22:]))
+	if fatSectors == 0 {
+		fatSectors = int64(binary.LittleEndian.Uint32(boot[36:]))
+	}
+
+	rootSectors := (rootEntries*32 + bytesPerSector - 1) / bytesPerSector
+	dataSector := reserved + fats*fatSectors + rootSectors
+	if bytesPerSector == 0 || sectorsPerCluster == 0 || dataSector >= totalSectors {
+		return nil, errors.New("bad FAT BIOS parameter block")
+	}
+
+	f := &fatFS{
+		r:           r,
+		clusterSize: bytesPerSector * sectorsPerCluster,
+		dataOffset:  dataSector * bytesPerSector,
+		clusters:    uint32((totalSectors - dataSector) / sectorsPerCluster),
+		rootOffset:  (reserved + fats*fatSectors) * bytesPerSector,
+		rootSize:    rootSectors * bytesPerSector,
+	}
+
+	// the FAT type is decided by the number of clusters alone
+	switch {
+	case f.clusters < 4085:
+		f.bits = 12
+	case f.clusters < 65525:
+		f.bits = 16
+	default:
+		f.bits = 32
+		f.rootCluster = binary.LittleEndian.Uint32(boot[44:])
+	}
+
+	f.fat = make([]byte, fatSectors*bytesPerSector)
+	_, err = r.ReadAt(f.fat, reserved*bytesPerSector)
+	if err != nil {
+		return nil, fmt.Errorf("FAT: %s", err)
+	}
+	return f, nil
+}
+
+// next returns the cluster following c in its chain, and false at the
+// end of the chain
+func (f *fatFS) next(c uint32) (uint32, bool) {
+	var next uint32
+	switch f.bits {
+	case 12:
+		i := int(c + c/2)
+		if i+1 >= len(f.fat) {
+			return 0, false
+		}
+		next = uint32(binary.LittleEndian.Uint16(f.fat[i:]))
+		if c&1 == 1 {
+			next >>= 4
+		}
+		next &= 0xFFF
+		if next >= 0xFF8 {
+			return 0, false
+		}
+	case 16:
+		i := int(c) * 2
+		if i+2 > len(f.fat) {
+			return 0, false
+		}
+		next = uint32(binary.LittleEndian.Uint16(f.fat[i:]))
+		if next >= 0xFFF8 {
+			return 0, false
+		}
+	default:
+		i := int(c) * 4
+		if i+4 > len(f.fat) {
+			return 0, false
+		}
+		next = binary.LittleEndian.Uint32(f.fat[i:]) & 0x0FFFFFFF
+		if next >= 0x0FFFFFF8 {
+			return 0, false
+		}
+	}
+	return next, next >= 2 && next < f.clusters+2
+}
+
+// chain lists the clusters of a file, stopping at loops
+func (f *fatFS) chain(start uint32) []uint32 {
+	var clusters []uint32
+	for c, ok := start, start >= 2; ok && uint32(len(clusters)) <= f.clusters; c, ok = f.next(c) {
+		clusters = append(clusters, c)
+	}
+	return clusters
+}
+
+func (f *fatFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	entry := &fatEntry{name: "/", attr: fatAttrDirectory, cluster: f.rootCluster}
+	if name != "." {
+		for _, component := range strings.Split(name, "/") {
+			if !entry.IsDir() {
+				return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+			}
+			entries, err := f.readDir(entry)
+			if err != nil {
+				return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+			}
+
+			// FAT names are case insensitive
+			var found *fatEntry
+			for _, child := range entries {
+				if strings.EqualFold(child.name, component) || strings.EqualFold(child.shortName, component) {
+					found = child
+					break
+				}
+			}
+			if found == nil {
+				return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+			}
+			entry = found
+		}
+	}
+
+	if entry.IsDir() {
+		return &fatDir{fsys: f, entry: entry}, nil
+	}
+	data := &fatChainReader{fsys: f, clusters: f.chain(entry.cluster)}
+	return &fatFile{SectionReader: io.NewSectionReader(data, 0, int64(entry.size)), entry: entry}, nil
+}
+
+// readDir parses the entries of a directory, joining each run of long
+// name entries to the short entry that follows it
+func (f *fatFS) readDir(dir *fatEntry) ([]*fatEntry, error) {
+	var raw []byte
+	if dir.cluster == 0 {
+		raw = make([]byte, f.rootSize)
+		_, err := f.r.ReadAt(raw, f.rootOffset)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		clusters := f.chain(dir.cluster)
+		raw = make([]byte, int64(len(clusters))*f.clusterSize)
+		_, err := (&fatChainReader{fsys: f, clusters: clusters}).ReadAt(raw, 0)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+	}
+
+	var entries []*fatEntry
+	var longName []byte
+	var checksum byte
+	for i := 0; i+32 <= len(raw); i += 32 {
+		record := raw[i : i+32]
+		switch {
+		case record[0] == 0:
+			return entries, nil
+		case record[0] == 0xE5:
+			longName = nil
+			continue
+		case record[11] == fatAttrLongName:
+			// long name entries are stored last part first, 13 UTF-16
+			// code units in each
+			if record[0]&0x40 != 0 {
+				longName = nil
+			}
+			part := append(append(append([]byte{}, record[1:11]...), record[14:26]...), record[28:32]...)
+			longName = append(part, longName...)
+			checksum = record[13]
+			continue
+		case record[11]&fatAttrVolumeLabel != 0:
+			longName = nil
+			continue
+		}
+
+		entry := newFATEntry(record)
+		if longName != nil && shortNameChecksum(record[:11]) == checksum {
+			entry.name = utf16String(longName)
+		}
+		longName = nil
+		if entry.name == "." || entry.name == ".." {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func shortNameChecksum(name []byte) byte {
+	var sum byte
+	for _, c := range name {
+		sum = (sum>>1 | sum<<7) + c
+	}
+	return sum
+}
+
+// fatEntry is a file or directory, from its short directory entry
+type fatEntry struct {
+	name      string
+	shortName string
+	attr      byte
+	cluster   uint32
+	size      uint32
+	modTime   time.Time
+}
+
+func newFATEntry(record []byte) *fatEntry {
+	base := []byte(trimPadding(record[0:8]))
+	ext := []byte(trimPadding(record[8:11]))
+	if len(base) > 0 && base[0] == 0x05 {
+		base[0] = 0xE5
+	}
+
+	// Windows NT records all lower case 8.3 names in the reserved byte
+	if record[12]&0x08 != 0 {
+		base = []byte(strings.ToLower(string(base)))
+	}
+	if record[12]&0x10 != 0 {
+		ext = []byte(strings.ToLower(string(ext)))
+	}
+	shortName := string(base)
+	if len(ext) > 0 {
+		shortName += "." + string(ext)
+	}
+
+	date := binary.LittleEndian.Uint16(record[24:])
+	clock := binary.LittleEndian.Uint16(record[22:])
+	return &fatEntry{
+		name:      shortName,
+		shortName: shortName,
+		attr:      record[11],
+		cluster:   uint32(binary.LittleEndian.Uint16(record[20:]))<<16 | uint32(binary.LittleEndian.Uint16(record[26:])),
+		size:      binary.LittleEndian.Uint32(record[28:]),
+		modTime:   dosTime(date, clock),
+	}
+}
+
+func (e *fatEntry) Name() string       { return e.name }
+func (e *fatEntry) ModTime() time.Time { return e.modTime }
+func (e *fatEntry) IsDir() bool        { return e.attr&fatAttrDirectory != 0 }
+func (e *fatEntry) Sys() interface{}   { return nil }
+
+func (e *fatEntry) Size() int64 {
+	if e.IsDir() {
+		return 0
+	}
+	return int64(e.size)
+}
+
+func (e *fatEntry) Mode() fs.FileMode {
+	if e.IsDir() {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+// fatChainReader reads the clusters of a chain as one stream
+type fatChainReader struct {
+	fsys     *fatFS
+	clusters []uint32
+}
+
+func (c *fatChainReader) ReadAt(p []byte, off int64) (int, error) {
+	size := int64(len(c.clusters)) * c.fsys.clusterSize
+	return readBlocks(p, off, size, c.fsys.clusterSize, func(block int64) (int64, bool) {
+		return c.fsys.dataOffset + int64(c.clusters[block]-2)*c.fsys.clusterSize, true
+	}, c.fsys.r)
+}
+
+type fatFile struct {
+	*io.SectionReader
+	entry *fatEntry
+}
+
+func (f *fatFile) Stat() (fs.FileInfo, error) { return f.entry, nil }
+func (f *fatFile) Close() error               { return nil }
+
+type fatDir struct {
+	fsys    *fatFS
+	entry   *fatEntry
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (d *fatDir) Stat() (fs.FileInfo, error) { return d.entry, nil }
+func (d *fatDir) Close() error               { return nil }
+
+func (d *fatDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.entry.name, Err: errors.New("is a directory")}
+}
+
+func (d *fatDir) ReadDir(count int) ([]fs.DirEntry, error) {
+	if !d.read {
+		children, err := d.fsys.readDir(d.entry)
+		if err != nil {
+			return nil, err
+		}
+		for _, child := range children {
+			d.entries = append(d.entries, fs.FileInfoToDirEntry(child))
+		}
+		sort.Slice(d.entries, func(i, j int) bool {
+			return d.entries[i].Name() < d.entries[j].Name()
+		})
+		d.read = true
+	}
+	return readDirEntries(&d.entries, count)
+}
+
+// readDirEntries implements fs.ReadDirFile.ReadDir over the entries yet
+// to be returned
+func readDirEntries(remaining *[]fs.DirEntry, count int) ([]fs.DirEntry, error) {
+	entries := *remaining
+	if count <= 0 {
+		*remaining = nil
+		return entries, nil
+	}
+	if len(entries) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(entries) {
+		count = len(entries)
+	}
+	*remaining = entries[count:]
+	return entries[:count], nil
+}
@@ -194,10 +194,11 @@ func (s ACE) String() string {
 		aa := s.ObjectAce.(AdvancedAce)
 		sid = aa.GetPrincipal()
 
-		switch aa.Flags {
-		case ACEInheritanceFlagsObjectTypePresent:
+		sb.WriteString(fmt.Sprintf("Flags: %s\n", s.Header.FlagsString()))
+		if aa.Flags&ACEInheritanceFlagsObjectTypePresent != 0 {
 			sb.WriteString(fmt.Sprintf("ObjectType: %s\n", aa.ObjectType.Resolve()))
-		case ACEInheritanceFlagsInheritedObjectTypePresent:
+		}
+		if aa.Flags&ACEInheritanceFlagsInheritedObjectTypePresent != 0 {
 			sb.WriteString(fmt.Sprintf("InheritedObjectType: %s\n", aa.InheritedObjectType.Resolve()))
 		}
 	}
@@ -206,6 +207,43 @@ func (s ACE) String() string {
 	return fmt.Sprintf("SID: %s\n%s", sid.String(), sb.String())
 }
 
+// size returns the number of bytes the ACE occupies when serialized.
+// Callback application data is not retained by the parser, so is not counted
+func (s ACE) size() uint16 {
+	// Header + AccessMask
+	size := 8
+	switch objectAce := s.ObjectAce.(type) {
+	case BasicAce:
+		size += objectAce.SecurityIdentifier.size()
+	case AdvancedAce:
+		size += 4 + objectAce.SecurityIdentifier.size()
+		if objectAce.Flags&ACEInheritanceFlagsObjectTypePresent != 0 {
+			size += 16
+		}
+		if objectAce.Flags&ACEInheritanceFlagsInheritedObjectTypePresent != 0 {
+			size += 16
+		}
+	default:
+		// unsupported ACE types keep the size they were parsed with
+		return s.Header.Size
+	}
+	return uint16(size)
+}
+
+// withPrincipal returns a copy of the ACE granted to a different principal
+func (s ACE) withPrincipal(sid SID) ACE {
+	switch objectAce := s.ObjectAce.(type) {
+	case BasicAce:
+		objectAce.SecurityIdentifier = sid
+		s.ObjectAce = objectAce
+	case AdvancedAce:
+		objectAce.SecurityIdentifier = sid
+		s.ObjectAce = objectAce
+	}
+	s.Header.Size = s.size()
+	return s
+}
+
 // ACEHeader represents an ACE Header
 type ACEHeader struct {
 	Type  AceType
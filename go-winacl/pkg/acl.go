@@ -65,6 +65,37 @@ func NewACL(buf *bytes.Buffer) (acl ACL, err error) {
 	return
 }
 
+const (
+	ACLRevision   = 2
+	ACLRevisionDS = 4
+)
+
+// isNull reports whether an ACL is NULL, as parsed from a descriptor with
+// no offset for it or from NO_ACCESS_CONTROL: it has neither a revision nor
+// ACEs. Use NewEmptyACL for an ACL that denies all access
+func (a ACL) isNull() bool {
+	return a.Header.Revision == 0 && len(a.Aces) == 0
+}
+
+// refresh recomputes the sizes held in the ACL's header and ACE headers,
+// and sets the revision, raising it when object ACEs are present
+func (a *ACL) refresh() {
+	if a.Header.Revision == 0 {
+		a.Header.Revision = ACLRevision
+	}
+
+	size := 8
+	for i := range a.Aces {
+		a.Aces[i].Header.Size = a.Aces[i].size()
+		size += int(a.Aces[i].Header.Size)
+		if _, ok := a.Aces[i].ObjectAce.(AdvancedAce); ok {
+			a.Header.Revision = ACLRevisionDS
+		}
+	}
+	a.Header.AceCount = uint16(len(a.Aces))
+	a.Header.Size = uint16(size)
+}
+
 func (header *ACLHeader) ToBuffer() (bytes.Buffer, error) {
 	buf := bytes.Buffer{}
 	err := binary.Write(&buf, binary.LittleEndian, header)
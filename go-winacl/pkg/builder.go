@@ -0,0 +1,397 @@
+package winacl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// NewACEAccessMask is a constructor for an ACEAccessMask holding value
+func NewACEAccessMask(value uint32) ACEAccessMask {
+	return ACEAccessMask{value}
+}
+
+// NewSIDFromString is a constructor that will parse out a SID from its
+// "S-1-" string form. Identifier authorities above 2^32 are given in hex
+func NewSIDFromString(sidString string) (SID, error) {
+	sid := SID{}
+	parts := strings.Split(sidString, "-")
+	if len(parts) < 3 || !strings.EqualFold(parts[0], "S") {
+		return sid, SIDInvalidError{fmt.Sprintf("malformed SID %q", sidString)}
+	}
+
+	revision, err := strconv.ParseUint(parts[1], 10, 8)
+	if err != nil || revision != 1 {
+		return sid, SIDInvalidError{"invalid SID revision"}
+	}
+
+	authority, err := strconv.ParseUint(parts[2], 0, 48)
+	if err != nil {
+		return sid, SIDInvalidError{fmt.Sprintf("invalid SID authority %q", parts[2])}
+	}
+
+	subAuthorities := parts[3:]
+	if len(subAuthorities) > 15 {
+		return sid, SIDInvalidError{"invalid number of subauthorities"}
+	}
+
+	sid.Revision = byte(revision)
+	sid.NumAuthorities = byte(len(subAuthorities))
+	sid.Authority = make([]byte, 6)
+	for i := 0; i < 6; i++ {
+		sid.Authority[5-i] = byte(authority >> (8 * i))
+	}
+	sid.SubAuthorities = make([]uint32, len(subAuthorities))
+	for i, part := range subAuthorities {
+		subAuthority, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return sid, SIDInvalidError{fmt.Sprintf("invalid SID subauthority %q", part)}
+		}
+		sid.SubAuthorities[i] = uint32(subAuthority)
+	}
+	return sid, nil
+}
+
+// NewGUIDFromString is a constructor that will parse out a GUID from its
+// canonical string form, with or without braces
+func NewGUIDFromString(guidString string) (guid GUID, err error) {
+	guidString = strings.Trim(guidString, "{}")
+	parts := strings.Split(guidString, "-")
+	if len(parts) != 5 || len(parts[0]) != 8 || len(parts[1]) != 4 ||
+		len(parts[2]) != 4 || len(parts[3]) != 4 || len(parts[4]) != 12 {
+		return guid, fmt.Errorf("NewGUIDFromString: malformed GUID %q", guidString)
+	}
+
+	data1, err := strconv.ParseUint(parts[0], 16, 32)
+	if err != nil {
+		return
+	}
+	data2, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return
+	}
+	data3, err := strconv.ParseUint(parts[2], 16, 16)
+	if err != nil {
+		return
+	}
+	data4, err := strconv.ParseUint(parts[3]+parts[4], 16, 64)
+	if err != nil {
+		return
+	}
+
+	guid.Data1 = uint32(data1)
+	guid.Data2 = uint16(data2)
+	guid.Data3 = uint16(data3)
+	binary.BigEndian.PutUint64(guid.Data4[:], data4)
+	return
+}
+
+// BuildBasicAce builds an ACE of any non-object type, with its
+// header size already computed
+func BuildBasicAce(aceType AceType, flags ACEHeaderFlags, mask uint32, sid SID) (ACE, error) {
+	ace := ACE{
+		Header:     ACEHeader{Type: aceType, Flags: flags},
+		AccessMask: ACEAccessMask{mask},
+		ObjectAce:  BasicAce{SecurityIdentifier: sid},
+	}
+	if !ace.isBasic() {
+		return ace, fmt.Errorf("BuildBasicAce: %s is not a basic ACE type", typeName(aceType))
+	}
+	ace.Header.Size = ace.size()
+	return ace, nil
+}
+
+// BuildAdvancedAce builds an ACE of any object type. Either GUID
+// may be nil, in which case the ACE applies to all object types
+func BuildAdvancedAce(aceType AceType, flags ACEHeaderFlags, mask uint32, objectType, inheritedObjectType *GUID, sid SID) (ACE, error) {
+	objectAce := AdvancedAce{SecurityIdentifier: sid}
+	if objectType != nil {
+		objectAce.Flags |= ACEInheritanceFlagsObjectTypePresent
+		objectAce.ObjectType = *objectType
+	}
+	if inheritedObjectType != nil {
+		objectAce.Flags |= ACEInheritanceFlagsInheritedObjectTypePresent
+		objectAce.InheritedObjectType = *inheritedObjectType
+	}
+
+	ace := ACE{
+		Header:     ACEHeader{Type: aceType, Flags: flags},
+		AccessMask: ACEAccessMask{mask},
+		ObjectAce:  objectAce,
+	}
+	if ace.isBasic() || aceType == AceTypeAccessAllowedCompound || aceType > AceTypeSystemAlarmCallbackObject {
+		return ace, fmt.Errorf("BuildAdvancedAce: %s is not an object ACE type", typeName(aceType))
+	}
+	ace.Header.Size = ace.size()
+	return ace, nil
+}
+
+// isBasic reports whether the ACE's type is one without object types
+func (s ACE) isBasic() bool {
+	switch s.Header.Type {
+	case AceTypeAccessAllowed, AceTypeAccessDenied, AceTypeSystemAudit, AceTypeSystemAlarm,
+		AceTypeAccessAllowedCallback, AceTypeAccessDeniedCallback,
+		AceTypeSystemAuditCallback, AceTypeSystemAlarmCallback:
+		return true
+	}
+	return false
+}
+
+func typeName(aceType AceType) string {
+	if name := ACETypeLookup[aceType]; name != "" {
+		return name
+	}
+	return fmt.Sprintf("ACE type %d", aceType)
+}
+
+// NewEmptyACL is a constructor for an ACL without any ACEs
+func NewEmptyACL() ACL {
+	acl := ACL{Aces: []ACE{}}
+	acl.refresh()
+	return acl
+}
+
+// AddAce appends an ACE to the end of the ACL
+func (a *ACL) AddAce(ace ACE) {
+	a.Aces = append(a.Aces, ace)
+	a.refresh()
+}
+
+// InsertAce inserts an ACE at index, moving later ACEs along
+func (a *ACL) InsertAce(index int, ace ACE) error {
+	if index < 0 || index > len(a.Aces) {
+		return fmt.Errorf("InsertAce: index %d out of range", index)
+	}
+	a.Aces = append(a.Aces, ACE{})
+	copy(a.Aces[index+1:], a.Aces[index:])
+	a.Aces[index] = ace
+	a.refresh()
+	return nil
+}
+
+// RemoveAce removes the ACE at index
+func (a *ACL) RemoveAce(index int) error {
+	if index < 0 || index >= len(a.Aces) {
+		return fmt.Errorf("RemoveAce: index %d out of range", index)
+	}
+	a.Aces = append(a.Aces[:index:index], a.Aces[index+1:]...)
+	a.refresh()
+	return nil
+}
+
+// ReplaceAce replaces the ACE at index
+func (a *ACL) ReplaceAce(index int, ace ACE) error {
+	if index < 0 || index >= len(a.Aces) {
+		return fmt.Errorf("ReplaceAce: index %d out of range", index)
+	}
+	a.Aces[index] = ace
+	a.refresh()
+	return nil
+}
+
+// RemoveAcesFor removes every ACE granted to, or denying, a principal and
+// returns how many were removed
+func (a *ACL) RemoveAcesFor(sid SID) int {
+	kept := make([]ACE, 0, len(a.Aces))
+	for _, ace := range a.Aces {
+		if ace.ObjectAce == nil || ace.ObjectAce.GetPrincipal().String() != sid.String() {
+			kept = append(kept, ace)
+		}
+	}
+	removed := len(a.Aces) - len(kept)
+	a.Aces = kept
+	a.refresh()
+	return removed
+}
+
+// Canonicalize reorders the ACL into canonical order: explicit deny ACEs,
+// explicit allow ACEs, then inherited ACEs. ACEs otherwise keep their
+// relative order
+func (a *ACL) Canonicalize() {
+	rank := func(ace ACE) int {
+		switch {
+		case ace.Header.Flags&ACEHeaderFlagsInheritedAce != 0:
+			return 2
+		case ace.isDeny():
+			return 0
+		default:
+			return 1
+		}
+	}
+	sort.SliceStable(a.Aces, func(i, j int) bool {
+		return rank(a.Aces[i]) < rank(a.Aces[j])
+	})
+	a.refresh()
+}
+
+// NewEmptyNtSecurityDescriptor is a constructor for a self-relative
+// security descriptor with an empty DACL, and without an owner, group or
+// SACL
+func NewEmptyNtSecurityDescriptor() NtSecurityDescriptor {
+	sd := NtSecurityDescriptor{}
+	sd.Header.Control = DACLPresent
+	sd.DACL = NewEmptyACL()
+	sd.refresh()
+	return sd
+}
+
+// SetOwner sets the owner of the security descriptor
+func (s *NtSecurityDescriptor) SetOwner(sid SID) {
+	s.Owner = sid
+	s.refresh()
+}
+
+// SetGroup sets the primary group of the security descriptor
+func (s *NtSecurityDescriptor) SetGroup(sid SID) {
+	s.Group = sid
+	s.refresh()
+}
+
+// SetDACL replaces the DACL of the security descriptor
+func (s *NtSecurityDescriptor) SetDACL(acl ACL) {
+	s.DACL = acl
+	s.DACL.refresh()
+	s.Header.Control |= DACLPresent
+	s.refresh()
+}
+
+// SetSACL replaces the SACL of the security descriptor
+func (s *NtSecurityDescriptor) SetSACL(acl ACL) {
+	s.SACL = acl
+	s.SACL.refresh()
+	s.Header.Control |= SACLPresent
+	s.refresh()
+}
+
+// SetControl sets or clears Control flags of the security descriptor
+func (s *NtSecurityDescriptor) SetControl(flags uint16, set bool) {
+	if set {
+		s.Header.Control |= flags
+	} else {
+		s.Header.Control &^= flags
+	}
+	s.refresh()
+}
+
+// ToBuffer serializes a SID
+func (s SID) ToBuffer() (bytes.Buffer, error) {
+	buf := bytes.Buffer{}
+	if len(s.Authority) != 6 {
+		return buf, SIDInvalidError{"invalid SID authority"}
+	}
+	buf.WriteByte(s.Revision)
+	buf.WriteByte(byte(len(s.SubAuthorities)))
+	buf.Write(s.Authority)
+	err := binary.Write(&buf, binary.LittleEndian, s.SubAuthorities)
+	return buf, err
+}
+
+// ToBuffer serializes a GUID
+func (g GUID) ToBuffer() (bytes.Buffer, error) {
+	buf := bytes.Buffer{}
+	err := binary.Write(&buf, binary.LittleEndian, g)
+	return buf, err
+}
+
+// ToBuffer serializes an ACE
+func (s ACE) ToBuffer() (bytes.Buffer, error) {
+	buf := bytes.Buffer{}
+	err := binary.Write(&buf, binary.LittleEndian, s.Header)
+	if err != nil {
+		return buf, err
+	}
+	err = binary.Write(&buf, binary.LittleEndian, s.AccessMask.value)
+	if err != nil {
+		return buf, err
+	}
+
+	var sid SID
+	switch objectAce := s.ObjectAce.(type) {
+	case BasicAce:
+		sid = objectAce.SecurityIdentifier
+	case AdvancedAce:
+		sid = objectAce.SecurityIdentifier
+		err = binary.Write(&buf, binary.LittleEndian, objectAce.Flags)
+		if err != nil {
+			return buf, err
+		}
+		if objectAce.Flags&ACEInheritanceFlagsObjectTypePresent != 0 {
+			binary.Write(&buf, binary.LittleEndian, objectAce.ObjectType)
+		}
+		if objectAce.Flags&ACEInheritanceFlagsInheritedObjectTypePresent != 0 {
+			binary.Write(&buf, binary.LittleEndian, objectAce.InheritedObjectType)
+		}
+	default:
+		return buf, fmt.Errorf("ToBuffer: cannot serialize %s", s.GetTypeString())
+	}
+
+	sidBuf, err := sid.ToBuffer()
+	if err != nil {
+		return buf, err
+	}
+	buf.Write(sidBuf.Bytes())
+
+	// keep any padding implied by the header
+	for buf.Len() < int(s.Header.Size) {
+		buf.WriteByte(0)
+	}
+	return buf, nil
+}
+
+// ToBuffer serializes an ACL, with its header and each ACE
+func (a ACL) ToBuffer() (bytes.Buffer, error) {
+	buf, err := a.Header.ToBuffer()
+	if err != nil {
+		return buf, err
+	}
+	for _, ace := range a.Aces {
+		aceBuf, err := ace.ToBuffer()
+		if err != nil {
+			return buf, err
+		}
+		buf.Write(aceBuf.Bytes())
+	}
+	for buf.Len() < int(a.Header.Size) {
+		buf.WriteByte(0)
+	}
+	return buf, nil
+}
+
+// ToBuffer serializes the security descriptor in self-relative form. Sizes
+// and offsets are recomputed first, so the descriptor may have been
+// modified directly
+func (s NtSecurityDescriptor) ToBuffer() (bytes.Buffer, error) {
+	s.DACL.Aces = append([]ACE{}, s.DACL.Aces...)
+	s.SACL.Aces = append([]ACE{}, s.SACL.Aces...)
+	s.refresh()
+
+	buf := bytes.Buffer{}
+	err := binary.Write(&buf, binary.LittleEndian, s.Header)
+	if err != nil {
+		return buf, err
+	}
+
+	sections := []struct {
+		offset uint32
+		encode func() (bytes.Buffer, error)
+	}{
+		{s.Header.OffsetSacl, s.SACL.ToBuffer},
+		{s.Header.OffsetDacl, s.DACL.ToBuffer},
+		{s.Header.OffsetOwner, s.Owner.ToBuffer},
+		{s.Header.OffsetGroup, s.Group.ToBuffer},
+	}
+	for _, section := range sections {
+		if section.offset == 0 {
+			continue
+		}
+		sectionBuf, err := section.encode()
+		if err != nil {
+			return buf, err
+		}
+		buf.Write(sectionBuf.Bytes())
+	}
+	return buf, nil
+}
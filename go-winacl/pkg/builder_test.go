@@ -0,0 +1,183 @@
+package winacl_test
+
+import (
+	"testing"
+
+	winacl "github.com/kgoins/go-winacl/pkg"
+	"github.com/stretchr/testify/require"
+)
+
+func mustSID(r *require.Assertions, sid string) winacl.SID {
+	s, err := winacl.NewSIDFromString(sid)
+	r.NoError(err)
+	return s
+}
+
+func TestNewSIDFromString(t *testing.T) {
+	r := require.New(t)
+
+	t.Run("Round trips through String", func(t *testing.T) {
+		for _, sid := range []string{"S-1-1-0", "S-1-5-32-544", "S-1-5-21-1004336348-1177238915-682003330-512", "S-1-0x1234567890AB-1"} {
+			r.Equal(sid, mustSID(r, sid).String())
+		}
+	})
+
+	t.Run("Rejects malformed SIDs", func(t *testing.T) {
+		for _, sid := range []string{"", "S-1", "X-1-5", "S-2-5-32", "S-1-5-abc", "S-1-5-4294967296"} {
+			_, err := winacl.NewSIDFromString(sid)
+			r.Error(err, sid)
+		}
+	})
+}
+
+func TestNewGUIDFromString(t *testing.T) {
+	r := require.New(t)
+
+	guid, err := winacl.NewGUIDFromString("{00299570-246d-11d0-a768-00aa006e0529}")
+	r.NoError(err)
+	r.Equal("00299570-246d-11d0-a768-00aa006e0529", guid.String())
+	r.Equal("User-Force-Change-Password", guid.Resolve())
+
+	_, err = winacl.NewGUIDFromString("00299570-246d-11d0-a768")
+	r.Error(err)
+}
+
+func TestBuilder(t *testing.T) {
+	r := require.New(t)
+
+	t.Run("Builds ACEs with their sizes", func(t *testing.T) {
+		ace, err := winacl.BuildBasicAce(winacl.AceTypeAccessAllowed, 0, 0x1f01ff, mustSID(r, "S-1-5-18"))
+		r.NoError(err)
+		r.Equal(uint16(20), ace.Header.Size)
+
+		guid, _ := winacl.NewGUIDFromString("00299570-246d-11d0-a768-00aa006e0529")
+		ace, err = winacl.BuildAdvancedAce(winacl.AceTypeAccessAllowedObject, 0, 0x100, &guid, nil, mustSID(r, "S-1-1-0"))
+		r.NoError(err)
+		r.Equal(uint16(40), ace.Header.Size)
+
+		_, err = winacl.BuildBasicAce(winacl.AceTypeAccessAllowedObject, 0, 0x100, mustSID(r, "S-1-1-0"))
+		r.Error(err)
+		_, err = winacl.BuildAdvancedAce(winacl.AceTypeAccessAllowed, 0, 0x100, nil, nil, mustSID(r, "S-1-1-0"))
+		r.Error(err)
+	})
+
+	t.Run("Keeps ACL headers consistent", func(t *testing.T) {
+		acl := winacl.NewEmptyACL()
+		r.Equal(uint16(8), acl.Header.Size)
+
+		system, _ := winacl.BuildBasicAce(winacl.AceTypeAccessAllowed, 0, 0x1f01ff, mustSID(r, "S-1-5-18"))
+		users, _ := winacl.BuildBasicAce(winacl.AceTypeAccessAllowed, 0, 0x1200a9, mustSID(r, "S-1-5-32-545"))
+		acl.AddAce(system)
+		acl.AddAce(users)
+		r.Equal(uint16(2), acl.Header.AceCount)
+		r.Equal(uint16(8+20+24), acl.Header.Size)
+
+		r.NoError(acl.RemoveAce(0))
+		r.Equal(uint16(1), acl.Header.AceCount)
+		r.Equal(uint16(8+24), acl.Header.Size)
+		r.Error(acl.RemoveAce(1))
+
+		r.NoError(acl.ReplaceAce(0, system))
+		r.Equal(uint16(8+20), acl.Header.Size)
+
+		r.NoError(acl.InsertAce(0, users))
+		r.Equal("S-1-5-32-545", acl.Aces[0].ObjectAce.GetPrincipal().String())
+		r.Equal(1, acl.RemoveAcesFor(mustSID(r, "S-1-5-32-545")))
+		r.Equal(uint16(1), acl.Header.AceCount)
+	})
+
+	t.Run("Canonicalizes ACLs", func(t *testing.T) {
+		acl := winacl.NewEmptyACL()
+		inherited, _ := winacl.BuildBasicAce(winacl.AceTypeAccessAllowed, winacl.ACEHeaderFlagsInheritedAce, 0x1f01ff, mustSID(r, "S-1-5-18"))
+		allow, _ := winacl.BuildBasicAce(winacl.AceTypeAccessAllowed, 0, 0x1200a9, mustSID(r, "S-1-5-32-545"))
+		deny, _ := winacl.BuildBasicAce(winacl.AceTypeAccessDenied, 0, 0x10000, mustSID(r, "S-1-1-0"))
+		acl.AddAce(inherited)
+		acl.AddAce(allow)
+		acl.AddAce(deny)
+
+		acl.Canonicalize()
+		r.Equal(winacl.AceTypeAccessDenied, acl.Aces[0].GetType())
+		r.Equal("S-1-5-32-545", acl.Aces[1].ObjectAce.GetPrincipal().String())
+		r.Equal("S-1-5-18", acl.Aces[2].ObjectAce.GetPrincipal().String())
+
+		for _, finding := range acl.Lint(winacl.FileObject) {
+			r.NotEqual("NON_CANONICAL", finding.Rule)
+		}
+	})
+
+	t.Run("Serializes descriptors that parse back", func(t *testing.T) {
+		sd := winacl.NewEmptyNtSecurityDescriptor()
+		sd.SetOwner(mustSID(r, "S-1-5-32-544"))
+		sd.SetGroup(mustSID(r, "S-1-5-18"))
+
+		dacl := winacl.NewEmptyACL()
+		guid, _ := winacl.NewGUIDFromString("00299570-246d-11d0-a768-00aa006e0529")
+		ace, _ := winacl.BuildAdvancedAce(winacl.AceTypeAccessAllowedObject, 0, 0x100, &guid, nil, mustSID(r, "S-1-1-0"))
+		dacl.AddAce(ace)
+		sd.SetDACL(dacl)
+
+		buf, err := sd.ToBuffer()
+		r.NoError(err)
+
+		parsed, err := winacl.NewNtSecurityDescriptor(buf.Bytes())
+		r.NoError(err)
+		r.Equal("O:S-1-5-32-544G:S-1-5-18D:(OA;;CR;00299570-246d-11d0-a768-00aa006e0529;;WD)", parsed.ToSDDL())
+		r.Equal(uint16(winacl.ACLRevisionDS), uint16(parsed.DACL.Header.Revision))
+	})
+
+	t.Run("Serializes ACLs assigned without a revision", func(t *testing.T) {
+		system, _ := winacl.BuildBasicAce(winacl.AceTypeAccessAllowed, 0, 0x1f01ff, mustSID(r, "S-1-5-18"))
+		guid, _ := winacl.NewGUIDFromString("00299570-246d-11d0-a768-00aa006e0529")
+		object, _ := winacl.BuildAdvancedAce(winacl.AceTypeAccessAllowedObject, 0, 0x100, &guid, nil, mustSID(r, "S-1-1-0"))
+
+		tests := []struct {
+			aces     []winacl.ACE
+			sddl     string
+			revision byte
+		}{
+			{[]winacl.ACE{system}, "O:S-1-5-32-544G:D:(A;;CCDCLCSWRPWPDTLOCRSDRCWDWO;;;SY)", winacl.ACLRevision},
+			{[]winacl.ACE{system, object}, "O:S-1-5-32-544G:D:(A;;CCDCLCSWRPWPDTLOCRSDRCWDWO;;;SY)(OA;;CR;00299570-246d-11d0-a768-00aa006e0529;;WD)", winacl.ACLRevisionDS},
+		}
+		for _, test := range tests {
+			sd := winacl.NtSecurityDescriptor{Owner: mustSID(r, "S-1-5-32-544")}
+			sd.Header.Control = winacl.DACLPresent
+			sd.DACL = winacl.ACL{Aces: test.aces}
+
+			buf, err := sd.ToBuffer()
+			r.NoError(err)
+			parsed, err := winacl.NewNtSecurityDescriptor(buf.Bytes())
+			r.NoError(err)
+			r.NotZero(parsed.Header.OffsetDacl)
+			r.Equal(test.revision, parsed.DACL.Header.Revision)
+			r.Equal(test.sddl, parsed.ToSDDL())
+			r.NotContains(lintRules(parsed.Lint(winacl.FileObject)), "NULL_DACL:-1")
+
+			again, err := parsed.ToBuffer()
+			r.NoError(err)
+			r.Equal(buf.Bytes(), again.Bytes())
+		}
+	})
+
+	t.Run("Serializes NULL DACLs as NULL", func(t *testing.T) {
+		sd := winacl.NtSecurityDescriptor{Owner: mustSID(r, "S-1-5-32-544")}
+		sd.Header.Control = winacl.DACLPresent
+
+		buf, err := sd.ToBuffer()
+		r.NoError(err)
+		parsed, err := winacl.NewNtSecurityDescriptor(buf.Bytes())
+		r.NoError(err)
+		r.Zero(parsed.Header.OffsetDacl)
+		r.NotZero(parsed.Header.Control & winacl.DACLPresent)
+	})
+
+	t.Run("Serializes parsed descriptors unchanged", func(t *testing.T) {
+		ntsdBytes, err := getTestNtsdBytes()
+		r.NoError(err)
+		sd, err := winacl.NewNtSecurityDescriptor(ntsdBytes)
+		r.NoError(err)
+
+		buf, err := sd.ToBuffer()
+		r.NoError(err)
+		r.Equal(ntsdBytes, buf.Bytes())
+	})
+}
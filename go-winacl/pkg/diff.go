@@ -0,0 +1,185 @@
+package winacl
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SDDiff describes how one security descriptor differs from another
+type SDDiff struct {
+	Owner          *SIDChange `json:"Owner,omitempty"`
+	Group          *SIDChange `json:"Group,omitempty"`
+	ControlAdded   []string   `json:"ControlAdded,omitempty"`
+	ControlRemoved []string   `json:"ControlRemoved,omitempty"`
+	DACL           ACLDiff    `json:"DACL"`
+	SACL           ACLDiff    `json:"SACL"`
+}
+
+// SIDChange is an owner or group that was replaced
+type SIDChange struct {
+	From string `json:"From"`
+	To   string `json:"To"`
+}
+
+// ACLDiff lists the ACEs that were added to, removed from, or had their
+// access mask changed within an ACL
+type ACLDiff struct {
+	Added    []ACEDiff `json:"Added,omitempty"`
+	Removed  []ACEDiff `json:"Removed,omitempty"`
+	Modified []ACEDiff `json:"Modified,omitempty"`
+}
+
+// ACEDiff describes a single changed ACE. Index is the ACE's position in
+// the ACL it was found in; for modified ACEs that is the newer ACL, with
+// the older position and SDDL held in FromIndex and From. FromIndex is
+// nil for added and removed ACEs
+type ACEDiff struct {
+	Index     int      `json:"Index"`
+	SDDL      string   `json:"SDDL"`
+	Principal string   `json:"Principal"`
+	FromIndex *int     `json:"FromIndex,omitempty"`
+	From      string   `json:"From,omitempty"`
+	Granted   []string `json:"Granted,omitempty"`
+	Revoked   []string `json:"Revoked,omitempty"`
+}
+
+// Diff compares two security descriptors. ACEs are matched by their type,
+// principal, object types and flags, not by their position, so reordering
+// an ACL yields no differences. A changed access mask on an otherwise
+// matching ACE is reported as a modification
+func Diff(a, b NtSecurityDescriptor) SDDiff {
+	diff := SDDiff{}
+
+	if from, to := a.Owner.String(), b.Owner.String(); from != to {
+		diff.Owner = &SIDChange{From: from, To: to}
+	}
+	if from, to := a.Group.String(), b.Group.String(); from != to {
+		diff.Group = &SIDChange{From: from, To: to}
+	}
+
+	changed := a.Header.Control ^ b.Header.Control
+	diff.ControlAdded = NtSecurityDescriptorHeader{Control: changed & b.Header.Control}.ControlStrings()
+	diff.ControlRemoved = NtSecurityDescriptorHeader{Control: changed & a.Header.Control}.ControlStrings()
+
+	diff.DACL = diffACL(a.DACL, b.DACL)
+	diff.SACL = diffACL(a.SACL, b.SACL)
+	return diff
+}
+
+// Empty reports whether the compared descriptors were equivalent
+func (d SDDiff) Empty() bool {
+	return d.Owner == nil && d.Group == nil &&
+		len(d.ControlAdded) == 0 && len(d.ControlRemoved) == 0 &&
+		d.DACL.Empty() && d.SACL.Empty()
+}
+
+// Empty reports whether the compared ACLs held equivalent ACEs
+func (d ACLDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Modified) == 0
+}
+
+// String returns a human-readable, line-per-change representation of
+// an SDDiff, in the style of a unified diff
+func (d SDDiff) String() string {
+	sb := strings.Builder{}
+	if d.Owner != nil {
+		fmt.Fprintf(&sb, "Owner: %s -> %s\n", d.Owner.From, d.Owner.To)
+	}
+	if d.Group != nil {
+		fmt.Fprintf(&sb, "Group: %s -> %s\n", d.Group.From, d.Group.To)
+	}
+	for _, flag := range d.ControlAdded {
+		fmt.Fprintf(&sb, "Control: + %s\n", flag)
+	}
+	for _, flag := range d.ControlRemoved {
+		fmt.Fprintf(&sb, "Control: - %s\n", flag)
+	}
+	d.DACL.writeTo(&sb, "DACL")
+	d.SACL.writeTo(&sb, "SACL")
+	return sb.String()
+}
+
+func (d ACLDiff) writeTo(sb *strings.Builder, name string) {
+	if d.Empty() {
+		return
+	}
+	fmt.Fprintf(sb, "%s:\n", name)
+	for _, ace := range d.Removed {
+		fmt.Fprintf(sb, "  - [%d] %s %s\n", ace.Index, ace.SDDL, ace.Principal)
+	}
+	for _, ace := range d.Added {
+		fmt.Fprintf(sb, "  + [%d] %s %s\n", ace.Index, ace.SDDL, ace.Principal)
+	}
+	for _, ace := range d.Modified {
+		fmt.Fprintf(sb, "  ~ [%d] %s -> [%d] %s %s", *ace.FromIndex, ace.From, ace.Index, ace.SDDL, ace.Principal)
+		if len(ace.Granted) > 0 {
+			fmt.Fprintf(sb, " +%s", strings.Join(ace.Granted, " +"))
+		}
+		if len(ace.Revoked) > 0 {
+			fmt.Fprintf(sb, " -%s", strings.Join(ace.Revoked, " -"))
+		}
+		sb.WriteString("\n")
+	}
+}
+
+func diffACL(a, b ACL) ACLDiff {
+	diff := ACLDiff{}
+
+	// queue up the positions of each distinct ACE in the old ACL, so that
+	// duplicates are paired off in order
+	unmatched := make(map[string][]int)
+	for i, ace := range a.Aces {
+		key := ace.matchKey()
+		unmatched[key] = append(unmatched[key], i)
+	}
+
+	for i, ace := range b.Aces {
+		key := ace.matchKey()
+		if len(unmatched[key]) == 0 {
+			diff.Added = append(diff.Added, newACEDiff(i, ace))
+			continue
+		}
+
+		j := unmatched[key][0]
+		unmatched[key] = unmatched[key][1:]
+
+		old := a.Aces[j]
+		if old.AccessMask.value == ace.AccessMask.value {
+			continue
+		}
+		modified := newACEDiff(i, ace)
+		modified.FromIndex = &j
+		modified.From = old.ToSDDL()
+		modified.Granted = ACEAccessMask{ace.AccessMask.value &^ old.AccessMask.value}.StringSlice()
+		modified.Revoked = ACEAccessMask{old.AccessMask.value &^ ace.AccessMask.value}.StringSlice()
+		diff.Modified = append(diff.Modified, modified)
+	}
+
+	for i, ace := range a.Aces {
+		for _, j := range unmatched[ace.matchKey()] {
+			if i == j {
+				diff.Removed = append(diff.Removed, newACEDiff(i, ace))
+			}
+		}
+	}
+	return diff
+}
+
+func newACEDiff(index int, ace ACE) ACEDiff {
+	return ACEDiff{
+		Index:     index,
+		SDDL:      ace.ToSDDL(),
+		Principal: ace.ObjectAce.GetPrincipal().Resolve(),
+	}
+}
+
+// matchKey identifies an ACE by everything but its access mask
+func (s ACE) matchKey() string {
+	var objectType, inheritedObjectType string
+	if aa, ok := s.ObjectAce.(AdvancedAce); ok {
+		objectType = aa.ObjectType.String()
+		inheritedObjectType = aa.InheritedObjectType.String()
+	}
+	return fmt.Sprintf("%d;%d;%s;%s;%s", s.Header.Type, s.Header.Flags,
+		objectType, inheritedObjectType, s.ObjectAce.GetPrincipal().String())
+}
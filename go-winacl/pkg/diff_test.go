@@ -0,0 +1,91 @@
+package winacl_test
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	winacl "github.com/kgoins/go-winacl/pkg"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff(t *testing.T) {
+
+	r := require.New(t)
+
+	t.Run("Ignores the order of ACEs", func(t *testing.T) {
+		a := newTestSD()
+		b := newTestSD()
+		aces := b.DACL.Aces
+		b.DACL.Aces = make([]winacl.ACE, 0, len(aces))
+		for i := len(aces) - 1; i >= 0; i-- {
+			b.DACL.Aces = append(b.DACL.Aces, aces[i])
+		}
+
+		diff := winacl.Diff(a, b)
+		r.True(diff.Empty(), diff.String())
+	})
+
+	t.Run("Reports owner changes and removed ACEs", func(t *testing.T) {
+		a := newTestSD()
+		b := newTestSD()
+		b.Owner = a.DACL.Aces[0].ObjectAce.GetPrincipal()
+		b.DACL.Aces = append([]winacl.ACE{}, a.DACL.Aces[1:]...)
+
+		diff := winacl.Diff(a, b)
+		r.NotNil(diff.Owner)
+		r.Equal(a.Owner.String(), diff.Owner.From)
+		r.Len(diff.DACL.Removed, 1)
+		r.Equal(0, diff.DACL.Removed[0].Index)
+		r.Empty(diff.DACL.Added)
+		r.Empty(diff.DACL.Modified)
+	})
+
+	t.Run("Reports changed access masks as modifications", func(t *testing.T) {
+		// revision 1, SE_SELF_RELATIVE|SE_DACL_PRESENT, then the owner,
+		// group, SACL and DACL offsets
+		header := "01" + "00" + "0480" + "14000000" + "24000000" + "00000000" + "30000000"
+		owner := "01020000000000052000000020020000" // S-1-5-32-544
+		group := "010100000000000512000000"         // S-1-5-18
+		// revision 2, 28 bytes, one ACE
+		aclHeader := "02" + "00" + "1c00" + "0100" + "0000"
+		// ACCESS_ALLOWED, no flags, 20 bytes
+		aceHeader := "00" + "00" + "1400"
+		everyone := "010100000000000100000000" // S-1-1-0
+		prefix := header + owner + group + aclHeader + aceHeader
+
+		aBytes, err := hex.DecodeString(prefix + "ff011f00" + everyone)
+		r.NoError(err)
+		bBytes, err := hex.DecodeString(prefix + "a9001200" + everyone)
+		r.NoError(err)
+
+		a, err := winacl.NewNtSecurityDescriptor(aBytes)
+		r.NoError(err)
+		b, err := winacl.NewNtSecurityDescriptor(bBytes)
+		r.NoError(err)
+
+		diff := winacl.Diff(a, b)
+		r.Len(diff.DACL.Modified, 1)
+		r.Empty(diff.DACL.Added)
+		r.Empty(diff.DACL.Removed)
+		r.Contains(diff.DACL.Modified[0].Revoked, "DELETE")
+		r.Empty(diff.DACL.Modified[0].Granted)
+
+		// the ACE was first in both ACLs, which must still be printed
+		serialized, err := json.Marshal(diff.DACL.Modified[0])
+		r.NoError(err)
+		r.Contains(string(serialized), `"FromIndex":0`)
+		r.Contains(diff.String(), "~ [0] ")
+	})
+
+	t.Run("Reports control flag changes", func(t *testing.T) {
+		a := newTestSD()
+		b := newTestSD()
+		b.Header.Control |= winacl.DACLProtected
+
+		diff := winacl.Diff(a, b)
+		r.Equal([]string{"SE_DACL_PROTECTED"}, diff.ControlAdded)
+		r.Empty(diff.ControlRemoved)
+	})
+
+}
@@ -0,0 +1,390 @@
+package winacl
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// ExplainOptions control how a security descriptor is explained
+type ExplainOptions struct {
+	// Kind selects the vocabulary used for rights
+	Kind ObjectKind
+
+	// Resolve names a principal. SID.Resolve is used when nil
+	Resolve func(SID) string
+
+	// InheritedFrom names where inherited ACEs came from, such as the
+	// distinguished name of a parent OU
+	InheritedFrom string
+}
+
+// Explanation is an ACE rendered for people, both as its parts and as a
+// single plain-English Statement
+type Explanation struct {
+	Index               int
+	Type                string
+	Principal           string
+	Rights              []string
+	ObjectType          string `json:",omitempty"`
+	InheritedObjectType string `json:",omitempty"`
+	AppliesTo           string
+	Inherited           bool
+	Statement           string
+}
+
+type rightPhrase struct {
+	mask   uint32
+	phrase string
+}
+
+// fileRightPhrases are tried in order, so that common combinations are
+// named before the rights within them
+var fileRightPhrases = []rightPhrase{
+	{0x1f01ff, "take full control of"},
+	{0x1301bf, "modify"},
+	{0x1200a9, "read and execute"},
+	{0x120089, "read"},
+	{0x120116, "write to"},
+	{0x1200a0, "execute"},
+	{FileReadData, "read data from"},
+	{FileWriteData, "write data to"},
+	{FileAppendData, "append data to"},
+	{FileReadEA, "read the extended attributes of"},
+	{FileWriteEA, "write the extended attributes of"},
+	{FileExecute, "execute"},
+	{FileDeleteChild, "delete the children of"},
+	{FileReadAttributes, "read the attributes of"},
+	{FileWriteAttributes, "write the attributes of"},
+}
+
+var dsRightPhrases = []rightPhrase{
+	{0xf01ff, "take full control of"},
+	{0x20094, "read"},
+	{0x20028, "write to"},
+	{ADSRightDSCreateChild, "create child objects in"},
+	{ADSRightDSDeleteChild, "delete child objects from"},
+	{ADSRightDSListChildrend, "list the children of"},
+	{ADSRightDSSelf, "perform all validated writes on"},
+	{ADSRightDSReadProp, "read all properties of"},
+	{ADSRightDSWriteProp, "write all properties of"},
+	{ADSRightDSDeleteTree, "delete the subtree of"},
+	{ADSRightDSListObject, "list"},
+	{ADSRightDSControlAccess, "use all extended rights on"},
+}
+
+var standardRightPhrases = []rightPhrase{
+	{AccessMaskDelete, "delete"},
+	{AccessMaskReadControl, "read the permissions of"},
+	{AccessMaskWriteDACL, "change the permissions of"},
+	{AccessMaskWriteOwner, "take ownership of"},
+	{AccessMaskSystemSecurity, "read and change the auditing of"},
+	{AccessMaskMaximumAllowed, "request maximum access to"},
+	{AccessMaskSynchronize, "synchronize with"},
+}
+
+// objectRightPhrases name the object-specific directory service rights,
+// where %s is the resolved object type
+var objectRightPhrases = map[uint32]string{
+	ADSRightDSCreateChild:   "create %s objects in",
+	ADSRightDSDeleteChild:   "delete %s objects from",
+	ADSRightDSSelf:          "perform the %s validated write on",
+	ADSRightDSReadProp:      "read the %s property of",
+	ADSRightDSWriteProp:     "write the %s property of",
+	ADSRightDSControlAccess: "use the %s extended right on",
+}
+
+// extendedRightPhrases name well-known extended rights by what they allow
+var extendedRightPhrases = map[string]string{
+	"User-Force-Change-Password":     "reset the password of",
+	"User-Change-Password":           "change the password of",
+	"DS-Replication-Get-Changes":     "replicate directory changes from",
+	"DS-Replication-Get-Changes-All": "replicate secret directory changes from",
+	"Certificate-Enrollment":         "enroll in",
+	"Certificate-AutoEnrollment":     "autoenroll in",
+	"Send-As":                        "send as",
+	"Receive-As":                     "receive as",
+}
+
+// objectSpecificRights are the directory service rights that an ACE's
+// object type narrows
+const objectSpecificRights = ADSRightDSCreateChild | ADSRightDSDeleteChild |
+	ADSRightDSSelf | ADSRightDSReadProp | ADSRightDSWriteProp | ADSRightDSControlAccess
+
+// Explain renders the descriptor as plain-English statements: its owner
+// and group, the state of its DACL, then one statement per ACE of the DACL
+// and SACL
+func (s NtSecurityDescriptor) Explain(opts ExplainOptions) []string {
+	resolve := opts.resolver()
+	var statements []string
+
+	owner, group := "no one", "none"
+	if len(s.Owner.Authority) == 6 {
+		owner = resolve(s.Owner)
+	}
+	if len(s.Group.Authority) == 6 {
+		group = resolve(s.Group)
+	}
+	statements = append(statements, fmt.Sprintf("Owned by %s, with primary group %s.", owner, group))
+
+	switch {
+	case s.Header.Control&DACLPresent == 0 || s.DACL.isNull():
+		statements = append(statements, "The DACL is NULL, so everyone has full control.")
+	case len(s.DACL.Aces) == 0:
+		statements = append(statements, "The DACL is empty, so no one is granted access.")
+	}
+	if s.Header.Control&DACLProtected != 0 {
+		statements = append(statements, "The DACL is protected, so nothing is inherited from the parent.")
+	}
+
+	for _, explanation := range s.DACL.Explain(opts) {
+		statements = append(statements, explanation.Statement)
+	}
+	if s.Header.Control&SACLPresent != 0 {
+		for _, explanation := range s.SACL.Explain(opts) {
+			statements = append(statements, explanation.Statement)
+		}
+	}
+	return statements
+}
+
+// Explain renders each ACE of the ACL
+func (a ACL) Explain(opts ExplainOptions) []Explanation {
+	explanations := make([]Explanation, 0, len(a.Aces))
+	for i, ace := range a.Aces {
+		explanations = append(explanations, ace.explain(i, opts))
+	}
+	return explanations
+}
+
+func (opts ExplainOptions) resolver() func(SID) string {
+	if opts.Resolve != nil {
+		return opts.Resolve
+	}
+	return SID.Resolve
+}
+
+func (s ACE) explain(index int, opts ExplainOptions) Explanation {
+	explanation := Explanation{
+		Index:     index,
+		Type:      s.explainType(),
+		Rights:    accessMaskNames(s.AccessMask.value, opts.Kind),
+		AppliesTo: s.appliesTo(opts.Kind),
+		Inherited: s.Header.Flags&ACEHeaderFlagsInheritedAce != 0,
+	}
+	if s.ObjectAce == nil {
+		explanation.Statement = fmt.Sprintf("Unsupported %s ACE.", s.GetTypeString())
+		return explanation
+	}
+
+	explanation.Principal = opts.resolver()(s.ObjectAce.GetPrincipal())
+	if aa, ok := s.ObjectAce.(AdvancedAce); ok {
+		if aa.Flags&ACEInheritanceFlagsObjectTypePresent != 0 {
+			explanation.ObjectType = aa.ObjectType.Resolve()
+		}
+		if aa.Flags&ACEInheritanceFlagsInheritedObjectTypePresent != 0 {
+			explanation.InheritedObjectType = aa.InheritedObjectType.Resolve()
+		}
+	}
+
+	actions := joinPhrases(s.actions(opts.Kind, explanation.ObjectType))
+	var statement string
+	switch explanation.Type {
+	case "ALLOW":
+		statement = fmt.Sprintf("%s may %s %s", explanation.Principal, actions, explanation.AppliesTo)
+	case "DENY":
+		statement = fmt.Sprintf("%s may not %s %s", explanation.Principal, actions, explanation.AppliesTo)
+	default:
+		attempts := "Attempts"
+		switch s.Header.Flags & (ACEHeaderFlagsSuccessfulAccessAceFlag | ACEHeaderFlagsFailedAccessAceFlag) {
+		case ACEHeaderFlagsSuccessfulAccessAceFlag:
+			attempts = "Successful attempts"
+		case ACEHeaderFlagsFailedAccessAceFlag:
+			attempts = "Failed attempts"
+		case ACEHeaderFlagsSuccessfulAccessAceFlag | ACEHeaderFlagsFailedAccessAceFlag:
+			attempts = "Successful and failed attempts"
+		}
+		outcome := "are audited"
+		if explanation.Type == "ALARM" {
+			outcome = "raise an alarm"
+		}
+		statement = fmt.Sprintf("%s by %s to %s %s %s", attempts, explanation.Principal, actions, explanation.AppliesTo, outcome)
+	}
+
+	if s.isCallback() {
+		statement += " when its condition is met"
+	}
+	if explanation.Inherited {
+		if opts.InheritedFrom != "" {
+			statement += fmt.Sprintf(" (inherited from %s)", opts.InheritedFrom)
+		} else {
+			statement += " (inherited)"
+		}
+	}
+	explanation.Statement = statement + "."
+	return explanation
+}
+
+func (s ACE) explainType() string {
+	switch {
+	case s.isAllow():
+		return "ALLOW"
+	case s.isDeny():
+		return "DENY"
+	case s.Header.Type == AceTypeSystemAlarm || s.Header.Type == AceTypeSystemAlarmObject ||
+		s.Header.Type == AceTypeSystemAlarmCallback || s.Header.Type == AceTypeSystemAlarmCallbackObject:
+		return "ALARM"
+	}
+	return "AUDIT"
+}
+
+func (s ACE) isCallback() bool {
+	switch s.Header.Type {
+	case AceTypeAccessAllowedCallback, AceTypeAccessDeniedCallback,
+		AceTypeAccessAllowedCallbackObject, AceTypeAccessDeniedCallbackObject,
+		AceTypeSystemAuditCallback, AceTypeSystemAlarmCallback,
+		AceTypeSystemAuditCallbackObject, AceTypeSystemAlarmCallbackObject:
+		return true
+	}
+	return false
+}
+
+// actions phrases each right of the ACE as a verb taking the object as
+// its target
+func (s ACE) actions(kind ObjectKind, objectType string) []string {
+	original := kind.GenericMapping().Map(s.AccessMask.value)
+	remaining := original
+	var actions []string
+
+	if kind == DirectoryServiceObject && objectType != "" {
+		readWrite := uint32(ADSRightDSReadProp | ADSRightDSWriteProp)
+		if remaining&readWrite == readWrite {
+			actions = append(actions, fmt.Sprintf("read and write the %s property of", objectType))
+			remaining &^= readWrite
+		}
+		for bit := uint32(1); bit <= ADSRightDSControlAccess; bit <<= 1 {
+			if remaining&bit == 0 || objectSpecificRights&bit == 0 {
+				continue
+			}
+			remaining &^= bit
+			if phrase, ok := extendedRightPhrases[objectType]; ok && bit == ADSRightDSControlAccess {
+				actions = append(actions, phrase)
+				continue
+			}
+			actions = append(actions, fmt.Sprintf(objectRightPhrases[bit], objectType))
+		}
+	}
+
+	phrases := fileRightPhrases
+	if kind == DirectoryServiceObject {
+		phrases = dsRightPhrases
+	}
+	for _, candidates := range [][]rightPhrase{phrases, standardRightPhrases} {
+		for _, right := range candidates {
+			if original&right.mask != right.mask || remaining&right.mask == 0 {
+				continue
+			}
+			// SYNCHRONIZE is only worth mentioning on its own
+			if right.mask == AccessMaskSynchronize && len(actions) > 0 {
+				remaining &^= right.mask
+				continue
+			}
+			actions = append(actions, right.phrase)
+			remaining &^= right.mask
+		}
+	}
+
+	for bit := uint32(1); bit != 0; bit <<= 1 {
+		if remaining&bit != 0 {
+			actions = append(actions, fmt.Sprintf("use right 0x%x on", bit))
+		}
+	}
+	if len(actions) == 0 {
+		actions = append(actions, "do nothing to")
+	}
+	return actions
+}
+
+// appliesTo describes the objects an ACE applies to, from its
+// inheritance flags and inherited object type
+func (s ACE) appliesTo(kind ObjectKind) string {
+	flags := s.Header.Flags & inheritanceFlags
+	inheritOnly := flags&ACEHeaderFlagsInheritOnlyAce != 0
+	oneLevel := flags&ACEHeaderFlagsNoPropogateInheritAce != 0
+
+	if kind == DirectoryServiceObject {
+		descendants := "all descendant objects"
+		if oneLevel {
+			descendants = "child objects"
+		}
+		if aa, ok := s.ObjectAce.(AdvancedAce); ok && aa.Flags&ACEInheritanceFlagsInheritedObjectTypePresent != 0 {
+			scope := "descendant"
+			if oneLevel {
+				scope = "child"
+			}
+			return fmt.Sprintf("%s %s objects", scope, aa.InheritedObjectType.Resolve())
+		}
+
+		switch {
+		case flags&ACEHeaderFlagsContainerInheritAce == 0:
+			return "this object"
+		case inheritOnly:
+			return descendants
+		default:
+			return "this object and " + descendants
+		}
+	}
+
+	var targets []string
+	if !inheritOnly {
+		targets = append(targets, "this folder")
+	}
+	if flags&ACEHeaderFlagsContainerInheritAce != 0 {
+		targets = append(targets, "subfolders")
+	}
+	if flags&ACEHeaderFlagsObjectInheritAce != 0 {
+		targets = append(targets, "files")
+	}
+
+	switch {
+	case flags&(ACEHeaderFlagsContainerInheritAce|ACEHeaderFlagsObjectInheritAce) == 0:
+		return "this object"
+	case oneLevel:
+		return joinPhrases(targets) + " (one level only)"
+	case inheritOnly:
+		return joinPhrases(targets) + " only"
+	}
+	return joinPhrases(targets)
+}
+
+// joinPhrases joins phrases as a list, such as "a, b and c"
+func joinPhrases(phrases []string) string {
+	if len(phrases) < 2 {
+		return strings.Join(phrases, "")
+	}
+	return strings.Join(phrases[:len(phrases)-1], ", ") + " and " + phrases[len(phrases)-1]
+}
+
+// WriteExplanationTable writes explanations as an aligned table
+func WriteExplanationTable(w io.Writer, explanations []Explanation) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "#\tTYPE\tPRINCIPAL\tRIGHTS\tOBJECT TYPE\tINHERITED OBJECT TYPE\tAPPLIES TO\tINHERITED")
+	for _, e := range explanations {
+		inherited := "no"
+		if e.Inherited {
+			inherited = "yes"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			e.Index, e.Type, e.Principal, strings.Join(e.Rights, ","),
+			dashIfEmpty(e.ObjectType), dashIfEmpty(e.InheritedObjectType), e.AppliesTo, inherited)
+	}
+	return tw.Flush()
+}
+
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
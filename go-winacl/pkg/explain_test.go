@@ -0,0 +1,71 @@
+package winacl_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	winacl "github.com/kgoins/go-winacl/pkg"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExplain(t *testing.T) {
+	r := require.New(t)
+
+	t.Run("Explains object-specific directory service rights", func(t *testing.T) {
+		sd, err := winacl.NewNtSecurityDescriptorFromSDDL("O:DAG:DAD:(OA;CIIOID;CR;00299570-246d-11d0-a768-00aa006e0529;bf967aba-0de6-11d0-a285-00aa003049e2;DU)")
+		r.NoError(err)
+
+		statements := sd.Explain(winacl.ExplainOptions{
+			Kind:          winacl.DirectoryServiceObject,
+			InheritedFrom: "OU=Staff",
+		})
+		r.Equal([]string{
+			"Owned by Domain Admins, with primary group Domain Admins.",
+			"Domain Users may reset the password of descendant User objects (inherited from OU=Staff).",
+		}, statements)
+	})
+
+	t.Run("Explains file rights and inheritance", func(t *testing.T) {
+		sd, err := winacl.NewNtSecurityDescriptorFromSDDL("O:BAG:SYD:P(A;OICI;FA;;;SY)(A;OICIIO;GA;;;CO)(D;OINP;FW;;;WD)(A;ID;0x1200a9;;;BU)S:(AU;SAFA;FW;;;WD)")
+		r.NoError(err)
+
+		resolve := func(sid winacl.SID) string {
+			return strings.ToUpper(sid.Resolve())
+		}
+		statements := sd.Explain(winacl.ExplainOptions{Resolve: resolve})
+		r.Equal([]string{
+			"Owned by BUILT-IN ADMINISTRATORS, with primary group LOCAL SYSTEM.",
+			"The DACL is protected, so nothing is inherited from the parent.",
+			"LOCAL SYSTEM may take full control of this folder, subfolders and files.",
+			"CREATOR OWNER may take full control of subfolders and files only.",
+			"WORLD/EVERYONE may not write to this folder and files (one level only).",
+			"BUILT-IN USERS may read and execute this object (inherited).",
+			"Successful and failed attempts by WORLD/EVERYONE to write to this object are audited.",
+		}, statements)
+	})
+
+	t.Run("Explains NULL DACLs", func(t *testing.T) {
+		sd, err := winacl.NewNtSecurityDescriptorFromSDDL("O:BAD:NO_ACCESS_CONTROL")
+		r.NoError(err)
+		r.Contains(sd.Explain(winacl.ExplainOptions{}), "The DACL is NULL, so everyone has full control.")
+	})
+
+	t.Run("Renders an aligned table", func(t *testing.T) {
+		sd, err := winacl.NewNtSecurityDescriptorFromSDDL("D:(OA;;RPWP;bf967a7f-0de6-11d0-a285-00aa003049e2;;S-1-5-21-1-2-3-517)(A;CIID;LC;;;RU)")
+		r.NoError(err)
+
+		explanations := sd.DACL.Explain(winacl.ExplainOptions{Kind: winacl.DirectoryServiceObject})
+		r.Equal("Cert Publishers may read and write the X509-Cert property of this object.", explanations[0].Statement)
+		r.Equal([]string{"READ_PROP", "WRITE_PROP"}, explanations[0].Rights)
+		r.True(explanations[1].Inherited)
+
+		buf := bytes.Buffer{}
+		r.NoError(winacl.WriteExplanationTable(&buf, explanations))
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		r.Len(lines, 3)
+		column := strings.Index(lines[0], "APPLIES TO")
+		r.Equal("this object", lines[1][column:column+len("this object")])
+		r.Equal("this object and all descendant objects", lines[2][column:column+len("this object and all descendant objects")])
+	})
+}
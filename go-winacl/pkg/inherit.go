@@ -0,0 +1,277 @@
+package winacl
+
+// GenericMapping maps the generic rights of an access mask onto the
+// specific and standard rights of a class of object
+type GenericMapping struct {
+	Read    uint32
+	Write   uint32
+	Execute uint32
+	All     uint32
+}
+
+// FileGenericMapping is the generic mapping of files and directories
+var FileGenericMapping = GenericMapping{
+	Read:    0x00120089,
+	Write:   0x00120116,
+	Execute: 0x001200a0,
+	All:     0x001f01ff,
+}
+
+// DirectoryServiceGenericMapping is the generic mapping of Active
+// Directory objects
+var DirectoryServiceGenericMapping = GenericMapping{
+	Read:    0x00020094,
+	Write:   0x00020028,
+	Execute: 0x00020004,
+	All:     0x000f01ff,
+}
+
+// GenericMapping returns the generic mapping for a kind of object
+func (k ObjectKind) GenericMapping() GenericMapping {
+	if k == DirectoryServiceObject {
+		return DirectoryServiceGenericMapping
+	}
+	return FileGenericMapping
+}
+
+const genericRights = AccessMaskGenericRead | AccessMaskGenericWrite |
+	AccessMaskGenericExecute | AccessMaskGenericAll
+
+// Map replaces the generic rights in an access mask with the specific
+// rights they stand for
+func (m GenericMapping) Map(mask uint32) uint32 {
+	if mask&AccessMaskGenericRead != 0 {
+		mask |= m.Read
+	}
+	if mask&AccessMaskGenericWrite != 0 {
+		mask |= m.Write
+	}
+	if mask&AccessMaskGenericExecute != 0 {
+		mask |= m.Execute
+	}
+	if mask&AccessMaskGenericAll != 0 {
+		mask |= m.All
+	}
+	return mask &^ genericRights
+}
+
+// NewObjectOptions describes an object being created beneath a parent
+type NewObjectOptions struct {
+	// IsContainer is set for directories and container objects
+	IsContainer bool
+	// ObjectType is the schema class of a new directory object. Inheritable
+	// ACEs restricted to other classes are not applied to the object
+	ObjectType *GUID
+	// Creator is the descriptor supplied by the creator, if any
+	Creator *NtSecurityDescriptor
+	// Owner and Group are the creator's token owner and primary group,
+	// which are used when Creator does not name them and which replace
+	// CREATOR OWNER and CREATOR GROUP. Left empty, those SIDs are kept
+	Owner SID
+	Group SID
+	// DefaultDACL is the creator's token default DACL, used when neither
+	// the parent nor the creator supply any ACEs
+	DefaultDACL *ACL
+	// AutoInherit merges inheritable ACEs with any the creator supplies,
+	// rather than the creator's ACL replacing them. It is what NTFS and
+	// Active Directory do
+	AutoInherit bool
+	// Mapping is used to resolve generic rights in effective ACEs
+	Mapping GenericMapping
+}
+
+// NewChildSecurityDescriptor computes the security descriptor that a new
+// object receives when created beneath parent, following the rules of
+// CreatePrivateObjectSecurityEx
+//
+// https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-dtyp/9d7bf243-b9e2-4bd9-9c3b-65aa5b1b6b67
+func NewChildSecurityDescriptor(parent NtSecurityDescriptor, opts NewObjectOptions) NtSecurityDescriptor {
+	child := NtSecurityDescriptor{}
+	creator := NtSecurityDescriptor{}
+	if opts.Creator != nil {
+		creator = *opts.Creator
+	}
+
+	child.Owner = opts.Owner
+	if creator.Owner.String() != "" {
+		child.Owner = creator.Owner
+	}
+	if child.Owner.String() == "" {
+		child.Owner = creatorOwner
+	}
+
+	child.Group = opts.Group
+	if creator.Group.String() != "" {
+		child.Group = creator.Group
+	}
+	if child.Group.String() == "" {
+		child.Group = creatorGroup
+	}
+
+	var dacl, sacl *ACL
+	var control uint16
+	dacl, control = computeACL(parent.DACL, parent.Header.Control&DACLPresent != 0,
+		creator.DACL, creator.Header.Control, DACLPresent, DACLDefaulted, DACLProtected, DACLAutoInherited,
+		child, opts)
+	if dacl == nil && opts.DefaultDACL != nil {
+		dacl = opts.DefaultDACL
+	}
+	if dacl != nil {
+		child.DACL = *dacl
+		child.Header.Control |= DACLPresent | control
+	}
+
+	sacl, control = computeACL(parent.SACL, parent.Header.Control&SACLPresent != 0,
+		creator.SACL, creator.Header.Control, SACLPresent, SACLDefaulted, SACLProtected, SACLAutoInherited,
+		child, opts)
+	if sacl != nil {
+		child.SACL = *sacl
+		child.Header.Control |= SACLPresent | control
+	}
+
+	child.refresh()
+	return child
+}
+
+var (
+	creatorOwner = SID{Revision: 1, NumAuthorities: 1, Authority: []byte{0, 0, 0, 0, 0, 3}, SubAuthorities: []uint32{0}}
+	creatorGroup = SID{Revision: 1, NumAuthorities: 1, Authority: []byte{0, 0, 0, 0, 0, 3}, SubAuthorities: []uint32{1}}
+)
+
+// computeACL decides between the ACEs inherited from the parent and those
+// supplied by the creator, for either the DACL or the SACL. It returns nil
+// when the new object gets no ACL at all
+func computeACL(parentACL ACL, parentPresent bool, creatorACL ACL, creatorControl, present, defaulted, protected, autoInherited uint16, child NtSecurityDescriptor, opts NewObjectOptions) (*ACL, uint16) {
+	creatorPresent := creatorControl&present != 0
+	creatorDefaulted := creatorControl&defaulted != 0
+	isProtected := creatorControl&protected != 0
+
+	var inherited []ACE
+	if parentPresent && !isProtected {
+		for _, ace := range parentACL.Aces {
+			inherited = append(inherited, inheritAce(ace, opts)...)
+		}
+	}
+
+	var control uint16
+	if opts.AutoInherit {
+		control |= autoInherited
+	}
+	if isProtected {
+		control |= protected
+	}
+
+	var aces []ACE
+	switch {
+	case creatorPresent && !creatorDefaulted && opts.AutoInherit:
+		// explicit ACEs from the creator, then what is inherited. Any ACE
+		// the creator marked as inherited is discarded
+		for _, ace := range creatorACL.Aces {
+			if ace.Header.Flags&ACEHeaderFlagsInheritedAce == 0 {
+				aces = append(aces, ace)
+			}
+		}
+		aces = append(aces, inherited...)
+
+	case creatorPresent && !creatorDefaulted:
+		aces = append(aces, creatorACL.Aces...)
+
+	case len(inherited) > 0:
+		aces = inherited
+
+	case creatorPresent:
+		// a defaulted ACL only applies when nothing is inherited
+		aces = append(aces, creatorACL.Aces...)
+
+	default:
+		return nil, 0
+	}
+
+	acl := &ACL{}
+	for _, ace := range aces {
+		acl.Aces = append(acl.Aces, expandAce(ace, child, opts)...)
+	}
+	acl.refresh()
+	return acl, control
+}
+
+// inheritAce returns the ACE a child receives from one of its parent's,
+// if any, before CREATOR OWNER and generic rights are resolved
+func inheritAce(ace ACE, opts NewObjectOptions) []ACE {
+	flags := ace.Header.Flags
+	objectInherit := flags&ACEHeaderFlagsObjectInheritAce != 0
+	containerInherit := flags&ACEHeaderFlagsContainerInheritAce != 0
+	noPropagate := flags&ACEHeaderFlagsNoPropogateInheritAce != 0
+	auditFlags := flags & (ACEHeaderFlagsSuccessfulAccessAceFlag | ACEHeaderFlagsFailedAccessAceFlag)
+
+	// ACEs restricted to a class of child only take effect on that class,
+	// but still pass through containers of other classes
+	typeMatches := true
+	if iot := ace.inheritedObjectType(); iot != "" {
+		typeMatches = opts.ObjectType != nil && opts.ObjectType.String() == iot
+	}
+
+	var childFlags ACEHeaderFlags
+	switch {
+	case opts.IsContainer && containerInherit && noPropagate:
+		if !typeMatches {
+			return nil
+		}
+		childFlags = 0
+
+	case opts.IsContainer && containerInherit:
+		childFlags = flags & (ACEHeaderFlagsObjectInheritAce | ACEHeaderFlagsContainerInheritAce)
+		if !typeMatches {
+			childFlags |= ACEHeaderFlagsInheritOnlyAce
+		}
+
+	case opts.IsContainer && objectInherit && !noPropagate:
+		childFlags = ACEHeaderFlagsObjectInheritAce | ACEHeaderFlagsInheritOnlyAce
+
+	case !opts.IsContainer && objectInherit && typeMatches:
+		childFlags = 0
+
+	default:
+		return nil
+	}
+
+	ace.Header.Flags = childFlags | auditFlags | ACEHeaderFlagsInheritedAce
+	return []ACE{ace}
+}
+
+// expandAce resolves CREATOR OWNER, CREATOR GROUP and generic rights in an
+// ACE that takes effect on the new object. When the ACE is also inherited
+// by the object's children, an inherit-only copy of the original is kept
+// for them
+func expandAce(ace ACE, child NtSecurityDescriptor, opts NewObjectOptions) []ACE {
+	if ace.ObjectAce == nil || ace.Header.Flags&ACEHeaderFlagsInheritOnlyAce != 0 {
+		return []ACE{ace}
+	}
+
+	principal := ace.ObjectAce.GetPrincipal().String()
+	substitute := (principal == creatorOwnerSID && child.Owner.String() != creatorOwnerSID) ||
+		(principal == creatorGroupSID && child.Group.String() != creatorGroupSID)
+	generic := ace.AccessMask.value&genericRights != 0
+	if !substitute && !generic {
+		return []ACE{ace}
+	}
+
+	effective := ace
+	effective.AccessMask = ACEAccessMask{opts.Mapping.Map(ace.AccessMask.value)}
+	if substitute && principal == creatorOwnerSID {
+		effective = effective.withPrincipal(child.Owner)
+	} else if substitute {
+		effective = effective.withPrincipal(child.Group)
+	}
+
+	inheritable := ace.Header.Flags & (ACEHeaderFlagsObjectInheritAce | ACEHeaderFlagsContainerInheritAce)
+	if inheritable == 0 || !opts.IsContainer {
+		effective.Header.Flags &^= inheritanceFlags
+		return []ACE{effective}
+	}
+
+	effective.Header.Flags &^= inheritanceFlags
+	inheritOnly := ace
+	inheritOnly.Header.Flags |= ACEHeaderFlagsInheritOnlyAce
+	return []ACE{effective, inheritOnly}
+}
@@ -0,0 +1,119 @@
+package winacl_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	winacl "github.com/kgoins/go-winacl/pkg"
+	"github.com/stretchr/testify/require"
+)
+
+// D:AI(A;OICI;FA;;;SY)(A;OICIIO;GA;;;CO)(A;CI;0x100116;;;BU)(A;OINP;0x1301bf;;;AU)(A;OI;0x1200a9;;;BU)
+const testParentHex = "010004841400000024000000000000003000000001020000000000052000000020020000" +
+	"010100000000000512000000040074000500000000031400ff011f00010100000000000512000000000b1400" +
+	"0000001001010000000000030000000000021800160110000102000000000005200000002102000000051400" +
+	"bf01130001010000000000050b00000000011800a900120001020000000000052000000021020000"
+
+type expectedAce struct {
+	principal string
+	flags     winacl.ACEHeaderFlags
+	mask      uint32
+}
+
+func requireAces(r *require.Assertions, acl winacl.ACL, expected []expectedAce) {
+	r.Len(acl.Aces, len(expected))
+	r.Equal(len(expected), int(acl.Header.AceCount))
+	for i, ace := range acl.Aces {
+		r.Equal(expected[i].principal, ace.ObjectAce.GetPrincipal().String(), "ACE %d", i)
+		r.Equal(expected[i].flags, ace.Header.Flags, "ACE %d", i)
+		r.Equal(expected[i].mask, ace.AccessMask.Raw(), "ACE %d", i)
+	}
+}
+
+func TestNewChildSecurityDescriptor(t *testing.T) {
+
+	r := require.New(t)
+
+	parentBytes, err := hex.DecodeString(testParentHex)
+	r.NoError(err)
+	parent, err := winacl.NewNtSecurityDescriptor(parentBytes)
+	r.NoError(err)
+
+	ownerBytes, err := hex.DecodeString("01050000000000051500000001000000020000000300000050040000")
+	r.NoError(err)
+	owner, err := winacl.NewSID(bytes.NewBuffer(ownerBytes), len(ownerBytes))
+	r.NoError(err)
+
+	t.Run("Computes the DACL of a new file", func(t *testing.T) {
+		child := winacl.NewChildSecurityDescriptor(parent, winacl.NewObjectOptions{
+			Owner:       owner,
+			AutoInherit: true,
+			Mapping:     winacl.FileGenericMapping,
+		})
+
+		r.Equal(owner.String(), child.Owner.String())
+		r.NotZero(child.Header.Control & winacl.DACLAutoInherited)
+		r.NotZero(child.Header.OffsetDacl)
+		requireAces(r, child.DACL, []expectedAce{
+			{"S-1-5-18", 0x10, 0x1f01ff},
+			{owner.String(), 0x10, 0x1f01ff},
+			{"S-1-5-11", 0x10, 0x1301bf},
+			{"S-1-5-32-545", 0x10, 0x1200a9},
+		})
+	})
+
+	t.Run("Computes the DACL of a new directory", func(t *testing.T) {
+		child := winacl.NewChildSecurityDescriptor(parent, winacl.NewObjectOptions{
+			IsContainer: true,
+			Owner:       owner,
+			AutoInherit: true,
+			Mapping:     winacl.FileGenericMapping,
+		})
+
+		requireAces(r, child.DACL, []expectedAce{
+			{"S-1-5-18", 0x13, 0x1f01ff},
+			{owner.String(), 0x10, 0x1f01ff},
+			{"S-1-3-0", 0x1b, winacl.AccessMaskGenericAll},
+			{"S-1-5-32-545", 0x12, 0x100116},
+			{"S-1-5-32-545", 0x19, 0x1200a9},
+		})
+	})
+
+	t.Run("Keeps CREATOR OWNER when no owner is known", func(t *testing.T) {
+		child := winacl.NewChildSecurityDescriptor(parent, winacl.NewObjectOptions{
+			AutoInherit: true,
+			Mapping:     winacl.FileGenericMapping,
+		})
+
+		r.Equal("S-1-3-0", child.Owner.String())
+		r.Equal("S-1-3-0", child.DACL.Aces[1].ObjectAce.GetPrincipal().String())
+		r.Equal(uint32(0x1f01ff), child.DACL.Aces[1].AccessMask.Raw())
+	})
+
+	t.Run("Does not inherit beneath a protected creator DACL", func(t *testing.T) {
+		creator := newTestSD()
+		creator.Header.Control |= winacl.DACLProtected
+
+		child := winacl.NewChildSecurityDescriptor(parent, winacl.NewObjectOptions{
+			Creator:     &creator,
+			AutoInherit: true,
+			Mapping:     winacl.FileGenericMapping,
+		})
+
+		explicit := 0
+		for _, ace := range creator.DACL.Aces {
+			if ace.Header.Flags&winacl.ACEHeaderFlagsInheritedAce == 0 {
+				explicit++
+			}
+		}
+
+		r.Equal(creator.Owner.String(), child.Owner.String())
+		r.NotZero(child.Header.Control & winacl.DACLProtected)
+		r.Len(child.DACL.Aces, explicit)
+		for _, ace := range child.DACL.Aces {
+			r.Zero(ace.Header.Flags & winacl.ACEHeaderFlagsInheritedAce)
+		}
+	})
+
+}
@@ -0,0 +1,210 @@
+package winacl
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Object ACE discriminators used in JSON
+const (
+	ObjectAceKindBasic    = "BasicAce"
+	ObjectAceKindAdvanced = "AdvancedAce"
+)
+
+// MarshalJSON encodes a SID as its "S-1-" string form
+func (s SID) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// UnmarshalJSON decodes a SID from its "S-1-" string form. An empty string
+// decodes to an empty SID
+func (s *SID) UnmarshalJSON(data []byte) error {
+	var sidString string
+	if err := json.Unmarshal(data, &sidString); err != nil {
+		return err
+	}
+	if sidString == "" {
+		*s = SID{}
+		return nil
+	}
+	sid, err := NewSIDFromString(sidString)
+	if err != nil {
+		return err
+	}
+	*s = sid
+	return nil
+}
+
+// MarshalJSON encodes a GUID as its string form. The null GUID encodes as
+// an empty string
+func (g GUID) MarshalJSON() ([]byte, error) {
+	return json.Marshal(g.String())
+}
+
+// UnmarshalJSON decodes a GUID from its string form
+func (g *GUID) UnmarshalJSON(data []byte) error {
+	var guidString string
+	if err := json.Unmarshal(data, &guidString); err != nil {
+		return err
+	}
+	if guidString == "" {
+		*g = GUID{}
+		return nil
+	}
+	guid, err := NewGUIDFromString(guidString)
+	if err != nil {
+		return err
+	}
+	*g = guid
+	return nil
+}
+
+type accessMaskJSON struct {
+	Value uint32
+	Names []string `json:",omitempty"`
+}
+
+// MarshalJSON encodes an access mask as its raw value and right names
+func (am ACEAccessMask) MarshalJSON() ([]byte, error) {
+	return json.Marshal(accessMaskJSON{am.value, am.StringSlice()})
+}
+
+// UnmarshalJSON decodes an access mask from its raw value. Names are
+// informational and ignored
+func (am *ACEAccessMask) UnmarshalJSON(data []byte) error {
+	var mask accessMaskJSON
+	if err := json.Unmarshal(data, &mask); err != nil {
+		return err
+	}
+	am.value = mask.Value
+	return nil
+}
+
+type aceHeaderJSON struct {
+	Type      AceType
+	TypeName  string `json:",omitempty"`
+	Flags     ACEHeaderFlags
+	FlagNames []string `json:",omitempty"`
+	Size      uint16
+}
+
+// MarshalJSON encodes an ACE header with the names of its type and flags
+func (ah ACEHeader) MarshalJSON() ([]byte, error) {
+	header := aceHeaderJSON{
+		Type:     ah.Type,
+		TypeName: ACETypeLookup[ah.Type],
+		Flags:    ah.Flags,
+		Size:     ah.Size,
+	}
+	for flag := 0x01; flag <= 0x80; flag <<= 1 {
+		if ah.Flags&ACEHeaderFlags(flag) != 0 {
+			if name := ACEHeaderFlagLookup[ACEHeaderFlags(flag)]; name != "" {
+				header.FlagNames = append(header.FlagNames, name)
+			}
+		}
+	}
+	return json.Marshal(header)
+}
+
+// UnmarshalJSON decodes an ACE header. Names are informational and ignored
+func (ah *ACEHeader) UnmarshalJSON(data []byte) error {
+	var header aceHeaderJSON
+	if err := json.Unmarshal(data, &header); err != nil {
+		return err
+	}
+	ah.Type = header.Type
+	ah.Flags = header.Flags
+	ah.Size = header.Size
+	return nil
+}
+
+// MarshalJSON encodes a BasicAce along with its Kind discriminator
+func (s BasicAce) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Kind               string
+		SecurityIdentifier SID
+	}{ObjectAceKindBasic, s.SecurityIdentifier})
+}
+
+type advancedAceJSON struct {
+	Kind                    string
+	Flags                   ACEInheritanceFlags
+	ObjectType              *GUID  `json:",omitempty"`
+	ObjectTypeName          string `json:",omitempty"`
+	InheritedObjectType     *GUID  `json:",omitempty"`
+	InheritedObjectTypeName string `json:",omitempty"`
+	SecurityIdentifier      SID
+}
+
+// MarshalJSON encodes an AdvancedAce along with its Kind discriminator, and
+// the resolved names of any object types present
+func (s AdvancedAce) MarshalJSON() ([]byte, error) {
+	aa := advancedAceJSON{
+		Kind:               ObjectAceKindAdvanced,
+		Flags:              s.Flags,
+		SecurityIdentifier: s.SecurityIdentifier,
+	}
+	if s.Flags&ACEInheritanceFlagsObjectTypePresent != 0 {
+		aa.ObjectType = &s.ObjectType
+		aa.ObjectTypeName = GUIDS[s.ObjectType.String()]
+	}
+	if s.Flags&ACEInheritanceFlagsInheritedObjectTypePresent != 0 {
+		aa.InheritedObjectType = &s.InheritedObjectType
+		aa.InheritedObjectTypeName = GUIDS[s.InheritedObjectType.String()]
+	}
+	return json.Marshal(aa)
+}
+
+// UnmarshalJSON decodes an ACE, using the Kind discriminator to choose the
+// concrete type of its ObjectAce
+func (s *ACE) UnmarshalJSON(data []byte) error {
+	var ace struct {
+		Header     ACEHeader
+		AccessMask ACEAccessMask
+		ObjectAce  json.RawMessage
+	}
+	if err := json.Unmarshal(data, &ace); err != nil {
+		return err
+	}
+	s.Header = ace.Header
+	s.AccessMask = ace.AccessMask
+	s.ObjectAce = nil
+
+	if len(ace.ObjectAce) == 0 || string(ace.ObjectAce) == "null" {
+		return nil
+	}
+
+	var objectAce advancedAceJSON
+	if err := json.Unmarshal(ace.ObjectAce, &objectAce); err != nil {
+		return err
+	}
+	switch objectAce.Kind {
+	case ObjectAceKindBasic:
+		s.ObjectAce = BasicAce{SecurityIdentifier: objectAce.SecurityIdentifier}
+	case ObjectAceKindAdvanced:
+		aa := AdvancedAce{
+			Flags:              objectAce.Flags,
+			SecurityIdentifier: objectAce.SecurityIdentifier,
+		}
+		if objectAce.ObjectType != nil {
+			aa.ObjectType = *objectAce.ObjectType
+		}
+		if objectAce.InheritedObjectType != nil {
+			aa.InheritedObjectType = *objectAce.InheritedObjectType
+		}
+		s.ObjectAce = aa
+	default:
+		return fmt.Errorf("UnmarshalJSON: unknown ObjectAce kind %q", objectAce.Kind)
+	}
+	return nil
+}
+
+// MarshalJSON encodes a security descriptor header with the names of its
+// Control flags
+func (ndh NtSecurityDescriptorHeader) MarshalJSON() ([]byte, error) {
+	type header NtSecurityDescriptorHeader
+	return json.Marshal(struct {
+		header
+		ControlFlags []string `json:",omitempty"`
+	}{header(ndh), ndh.ControlStrings()})
+}
@@ -0,0 +1,56 @@
+package winacl_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	winacl "github.com/kgoins/go-winacl/pkg"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSON(t *testing.T) {
+	r := require.New(t)
+
+	t.Run("Encodes SIDs, GUIDs and masks readably", func(t *testing.T) {
+		guid, _ := winacl.NewGUIDFromString("00299570-246d-11d0-a768-00aa006e0529")
+		ace, err := winacl.BuildAdvancedAce(winacl.AceTypeAccessAllowedObject, winacl.ACEHeaderFlagsContainerInheritAce, 0x100, &guid, nil, mustSID(r, "S-1-1-0"))
+		r.NoError(err)
+
+		data, err := json.Marshal(ace)
+		r.NoError(err)
+		r.JSONEq(`{
+			"Header": {"Type": 5, "TypeName": "ACCESS_ALLOWED_OBJECT", "Flags": 2, "FlagNames": ["CONTAINER_INHERIT_ACE"], "Size": 40},
+			"AccessMask": {"Value": 256, "Names": ["CONTROL_ACCESS"]},
+			"ObjectAce": {
+				"Kind": "AdvancedAce",
+				"Flags": 1,
+				"ObjectType": "00299570-246d-11d0-a768-00aa006e0529",
+				"ObjectTypeName": "User-Force-Change-Password",
+				"SecurityIdentifier": "S-1-1-0"
+			}
+		}`, string(data))
+	})
+
+	t.Run("Round trips descriptors", func(t *testing.T) {
+		sd := newTestSD()
+
+		data, err := json.Marshal(sd)
+		r.NoError(err)
+
+		var decoded winacl.NtSecurityDescriptor
+		r.NoError(json.Unmarshal(data, &decoded))
+		r.Equal(sd.ToSDDL(), decoded.ToSDDL())
+
+		expected, err := sd.ToBuffer()
+		r.NoError(err)
+		actual, err := decoded.ToBuffer()
+		r.NoError(err)
+		r.Equal(expected.Bytes(), actual.Bytes())
+	})
+
+	t.Run("Rejects unknown ObjectAce kinds", func(t *testing.T) {
+		var ace winacl.ACE
+		err := json.Unmarshal([]byte(`{"ObjectAce": {"Kind": "CompoundAce"}}`), &ace)
+		r.Error(err)
+	})
+}
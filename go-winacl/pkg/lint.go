@@ -0,0 +1,382 @@
+package winacl
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Severity ranks a LintFinding
+type Severity string
+
+const (
+	SeverityInfo   Severity = "INFO"
+	SeverityLow    Severity = "LOW"
+	SeverityMedium Severity = "MEDIUM"
+	SeverityHigh   Severity = "HIGH"
+)
+
+// ObjectKind selects how the object-specific bits of an access mask are
+// interpreted, since the same bit is a file right on one object and a
+// directory service right on another
+type ObjectKind int
+
+const (
+	FileObject ObjectKind = iota
+	DirectoryServiceObject
+)
+
+// File and directory specific access rights
+//
+// https://docs.microsoft.com/en-us/windows/win32/fileio/file-access-rights-constants
+const (
+	FileReadData        = 0x00000001
+	FileWriteData       = 0x00000002
+	FileAppendData      = 0x00000004
+	FileReadEA          = 0x00000008
+	FileWriteEA         = 0x00000010
+	FileExecute         = 0x00000020
+	FileDeleteChild     = 0x00000040
+	FileReadAttributes  = 0x00000080
+	FileWriteAttributes = 0x00000100
+
+	// Directories reuse the same bits
+	FileListDirectory   = FileReadData
+	FileAddFile         = FileWriteData
+	FileAddSubdirectory = FileAppendData
+	FileTraverse        = FileExecute
+)
+
+// FileAccessMaskLookup maps file and directory specific rights to
+// human-readable labels
+var FileAccessMaskLookup = map[uint32]string{
+	FileReadData:        "FILE_READ_DATA",
+	FileWriteData:       "FILE_WRITE_DATA",
+	FileAppendData:      "FILE_APPEND_DATA",
+	FileReadEA:          "FILE_READ_EA",
+	FileWriteEA:         "FILE_WRITE_EA",
+	FileExecute:         "FILE_EXECUTE",
+	FileDeleteChild:     "FILE_DELETE_CHILD",
+	FileReadAttributes:  "FILE_READ_ATTRIBUTES",
+	FileWriteAttributes: "FILE_WRITE_ATTRIBUTES",
+}
+
+// dsAccessMaskLookup labels the directory service rights missing from
+// ACEAccessMaskLookup
+var dsAccessMaskLookup = map[uint32]string{
+	ADSRightDSListChildrend: "LIST_CHILDREN",
+	ADSRightDSDeleteTree:    "DELETE_TREE",
+	ADSRightDSListObject:    "LIST_OBJECT",
+}
+
+// BroadPrincipals are well-known groups that nearly every user is a member of
+var BroadPrincipals = map[string]string{
+	"S-1-1-0":      "Everyone",
+	"S-1-5-4":      "INTERACTIVE",
+	"S-1-5-11":     "Authenticated Users",
+	"S-1-5-32-545": "Users",
+}
+
+const (
+	creatorOwnerSID = "S-1-3-0"
+	creatorGroupSID = "S-1-3-1"
+
+	inheritanceFlags = ACEHeaderFlagsObjectInheritAce | ACEHeaderFlagsContainerInheritAce |
+		ACEHeaderFlagsNoPropogateInheritAce | ACEHeaderFlagsInheritOnlyAce
+
+	genericWrites = AccessMaskGenericAll | AccessMaskGenericWrite |
+		AccessMaskWriteDACL | AccessMaskWriteOwner
+)
+
+// LintFinding is a potential misconfiguration within a security descriptor.
+// Index is the position of the offending ACE, or -1 when the finding
+// concerns the descriptor as a whole
+type LintFinding struct {
+	Severity Severity `json:"Severity"`
+	Rule     string   `json:"Rule"`
+	Index    int      `json:"Index"`
+	Message  string   `json:"Message"`
+}
+
+// Lint checks a security descriptor's DACL for misconfigurations, including
+// those that depend on the descriptor's control flags
+func (s NtSecurityDescriptor) Lint(kind ObjectKind) []LintFinding {
+	findings := []LintFinding{}
+
+	switch {
+	case s.Header.Control&DACLPresent == 0 || s.DACL.isNull():
+		findings = append(findings, LintFinding{SeverityHigh, "NULL_DACL", -1,
+			"the DACL is NULL, granting everyone full control"})
+	case s.Header.Control&DACLPresent != 0 && len(s.DACL.Aces) == 0:
+		findings = append(findings, LintFinding{SeverityMedium, "EMPTY_DACL", -1,
+			"the DACL is present but empty, denying everyone but the owner all access"})
+	}
+
+	if s.Header.Control&DACLProtected != 0 {
+		findings = append(findings, LintFinding{SeverityInfo, "PROTECTED_DACL", -1,
+			"the DACL is protected, blocking inheritance from the parent"})
+	}
+
+	return append(findings, s.DACL.Lint(kind)...)
+}
+
+// Lint checks the ACEs of a DACL for non-canonical ordering, redundant and
+// shadowed entries, write access for broad principals and CREATOR OWNER
+// entries that do not behave as intended
+func (a ACL) Lint(kind ObjectKind) []LintFinding {
+	findings := []LintFinding{}
+	findings = append(findings, a.lintOrder()...)
+	findings = append(findings, a.lintOverlaps()...)
+	findings = append(findings, a.lintBroadPrincipals(kind)...)
+	findings = append(findings, a.lintCreatorOwner(kind)...)
+	return findings
+}
+
+// lintOrder reports ACEs out of canonical order: explicit deny, explicit
+// allow, then inherited
+//
+// https://docs.microsoft.com/en-us/windows/win32/secauthz/order-of-aces-in-a-dacl
+func (a ACL) lintOrder() (findings []LintFinding) {
+	rank := func(ace ACE) int {
+		switch {
+		case ace.Header.Flags&ACEHeaderFlagsInheritedAce != 0:
+			return 2
+		case ace.isDeny():
+			return 0
+		default:
+			return 1
+		}
+	}
+
+	highest := 0
+	for i, ace := range a.Aces {
+		r := rank(ace)
+		if r < highest {
+			findings = append(findings, LintFinding{SeverityMedium, "NON_CANONICAL", i,
+				fmt.Sprintf("%s is out of canonical order", ace.describe())})
+			continue
+		}
+		highest = r
+	}
+	return
+}
+
+// lintOverlaps reports ACEs that can never change the outcome of an access
+// check because an earlier ACE for the same principal already decided
+// every right they cover
+func (a ACL) lintOverlaps() (findings []LintFinding) {
+	for i, ace := range a.Aces {
+		if !ace.isAllow() && !ace.isDeny() {
+			continue
+		}
+
+		for j := 0; j < i; j++ {
+			earlier := a.Aces[j]
+			if !earlier.covers(ace) {
+				continue
+			}
+
+			switch {
+			case earlier.isAllow() && ace.isAllow():
+				findings = append(findings, LintFinding{SeverityLow, "REDUNDANT", i,
+					fmt.Sprintf("%s is already granted by ACE %d", ace.describe(), j)})
+			case earlier.isDeny() && ace.isAllow():
+				findings = append(findings, LintFinding{SeverityLow, "SHADOWED", i,
+					fmt.Sprintf("%s is always overridden by the deny of ACE %d", ace.describe(), j)})
+			case earlier.isAllow() && ace.isDeny():
+				findings = append(findings, LintFinding{SeverityMedium, "SHADOWED", i,
+					fmt.Sprintf("%s has no effect, as ACE %d grants the same rights first", ace.describe(), j)})
+			default:
+				continue
+			}
+			break
+		}
+	}
+	return
+}
+
+// lintBroadPrincipals reports allow ACEs granting write-class rights to
+// principals that nearly every user is a member of. Inherit-only ACEs are
+// reported as granting them on children, not the object itself
+func (a ACL) lintBroadPrincipals(kind ObjectKind) (findings []LintFinding) {
+	for i, ace := range a.Aces {
+		if !ace.isAllow() {
+			continue
+		}
+		name, broad := BroadPrincipals[ace.ObjectAce.GetPrincipal().String()]
+		if !broad {
+			continue
+		}
+
+		target := ""
+		if ace.Header.Flags&ACEHeaderFlagsInheritOnlyAce != 0 {
+			target = " on child objects, through inheritance"
+		}
+		high, medium := ace.writeRights(kind)
+		switch {
+		case high != 0:
+			findings = append(findings, LintFinding{SeverityHigh, "BROAD_WRITE", i,
+				fmt.Sprintf("%s may %s%s", name, rightsPhrase(high, kind), target)})
+		case medium != 0:
+			findings = append(findings, LintFinding{SeverityMedium, "BROAD_WRITE", i,
+				fmt.Sprintf("%s may %s%s", name, rightsPhrase(medium, kind), target)})
+		}
+	}
+	return
+}
+
+// lintCreatorOwner reports CREATOR OWNER ACEs that are never inherited,
+// and those granting write access to whoever a broad principal is able to
+// make the creator of a child object
+func (a ACL) lintCreatorOwner(kind ObjectKind) (findings []LintFinding) {
+	createMask := uint32(FileAddFile | FileAddSubdirectory)
+	if kind == DirectoryServiceObject {
+		createMask = ADSRightDSCreateChild
+	}
+
+	var broadCreator string
+	for _, ace := range a.Aces {
+		if !ace.isAllow() || ace.Header.Flags&ACEHeaderFlagsInheritOnlyAce != 0 {
+			continue
+		}
+		name, broad := BroadPrincipals[ace.ObjectAce.GetPrincipal().String()]
+		if broad && ace.AccessMask.value&(createMask|AccessMaskGenericAll|AccessMaskGenericWrite) != 0 {
+			broadCreator = name
+			break
+		}
+	}
+
+	for i, ace := range a.Aces {
+		if !ace.isAllow() || ace.ObjectAce.GetPrincipal().String() != creatorOwnerSID {
+			continue
+		}
+
+		if ace.Header.Flags&(ACEHeaderFlagsObjectInheritAce|ACEHeaderFlagsContainerInheritAce) == 0 {
+			findings = append(findings, LintFinding{SeverityLow, "CREATOR_OWNER_NOT_INHERITABLE", i,
+				"CREATOR OWNER is granted access without inheritance flags, so the ACE never applies"})
+			continue
+		}
+
+		high, medium := ace.writeRights(kind)
+		if broadCreator != "" && high|medium != 0 {
+			findings = append(findings, LintFinding{SeverityMedium, "CREATOR_OWNER_WRITE", i,
+				fmt.Sprintf("%s may create children, and the creator of a child may %s",
+					broadCreator, rightsPhrase(high|medium, kind))})
+		}
+	}
+	return
+}
+
+func (s ACE) isAllow() bool {
+	switch s.Header.Type {
+	case AceTypeAccessAllowed, AceTypeAccessAllowedObject,
+		AceTypeAccessAllowedCallback, AceTypeAccessAllowedCallbackObject:
+		return true
+	}
+	return false
+}
+
+func (s ACE) isDeny() bool {
+	switch s.Header.Type {
+	case AceTypeAccessDenied, AceTypeAccessDeniedObject,
+		AceTypeAccessDeniedCallback, AceTypeAccessDeniedCallbackObject:
+		return true
+	}
+	return false
+}
+
+// objectType returns the ACE's object type, or an empty string if it
+// applies to the whole object
+func (s ACE) objectType() string {
+	if aa, ok := s.ObjectAce.(AdvancedAce); ok {
+		return aa.ObjectType.String()
+	}
+	return ""
+}
+
+// inheritedObjectType returns the type of child object the ACE is inherited
+// by, or an empty string if it is inherited by all children
+func (s ACE) inheritedObjectType() string {
+	if aa, ok := s.ObjectAce.(AdvancedAce); ok {
+		return aa.InheritedObjectType.String()
+	}
+	return ""
+}
+
+// covers reports whether an ACE applies to the same principal and objects
+// as other, with every right in other's access mask
+func (s ACE) covers(other ACE) bool {
+	if s.ObjectAce == nil || other.ObjectAce == nil {
+		return false
+	}
+	if s.ObjectAce.GetPrincipal().String() != other.ObjectAce.GetPrincipal().String() {
+		return false
+	}
+	if s.Header.Flags&inheritanceFlags != other.Header.Flags&inheritanceFlags {
+		return false
+	}
+	if ot := s.objectType(); ot != "" && ot != other.objectType() {
+		return false
+	}
+	if iot := s.inheritedObjectType(); iot != "" && iot != other.inheritedObjectType() {
+		return false
+	}
+	return other.AccessMask.value&^s.AccessMask.value == 0
+}
+
+// writeRights splits the write-class rights of an ACE into those that give
+// control of the object, and those of lesser impact
+func (s ACE) writeRights(kind ObjectKind) (high, medium uint32) {
+	mask := s.AccessMask.value
+	if kind == DirectoryServiceObject {
+		high = mask & (genericWrites | AccessMaskDelete | ADSRightDSDeleteTree)
+		if s.objectType() == "" {
+			high |= mask & (ADSRightDSWriteProp | ADSRightDSControlAccess)
+		} else {
+			medium |= mask & (ADSRightDSWriteProp | ADSRightDSControlAccess)
+		}
+		medium |= mask & (ADSRightDSCreateChild | ADSRightDSDeleteChild | ADSRightDSSelf)
+		return
+	}
+
+	high = mask & (genericWrites | AccessMaskDelete | FileWriteData)
+	medium = mask & (FileAppendData | FileWriteEA | FileDeleteChild | FileWriteAttributes)
+	return
+}
+
+// describe returns a short description of an ACE for lint messages
+func (s ACE) describe() string {
+	principal := "?"
+	if s.ObjectAce != nil {
+		principal = s.ObjectAce.GetPrincipal().Resolve()
+	}
+	return fmt.Sprintf("%s ACE for %s", s.GetTypeString(), principal)
+}
+
+// rightsPhrase names the rights in mask, as interpreted for kind
+func rightsPhrase(mask uint32, kind ObjectKind) string {
+	return strings.Join(accessMaskNames(mask, kind), ", ")
+}
+
+// accessMaskNames returns the label of each right in mask, lowest bit first
+func accessMaskNames(mask uint32, kind ObjectKind) []string {
+	lookup := dsAccessMaskLookup
+	if kind == FileObject {
+		lookup = FileAccessMaskLookup
+	}
+
+	var names []string
+	for bit := uint32(1); bit != 0; bit <<= 1 {
+		if mask&bit == 0 {
+			continue
+		}
+		name := lookup[bit]
+		if name == "" {
+			name = ACEAccessMaskLookup[bit]
+		}
+		if name == "" {
+			name = fmt.Sprintf("0x%x", bit)
+		}
+		names = append(names, name)
+	}
+	return names
+}
@@ -0,0 +1,92 @@
+package winacl_test
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	winacl "github.com/kgoins/go-winacl/pkg"
+	"github.com/stretchr/testify/require"
+)
+
+func lintRules(findings []winacl.LintFinding) []string {
+	rules := []string{}
+	for _, finding := range findings {
+		rules = append(rules, fmt.Sprintf("%s:%d", finding.Rule, finding.Index))
+	}
+	return rules
+}
+
+func TestLint(t *testing.T) {
+
+	r := require.New(t)
+
+	t.Run("Reports misconfigurations of a file DACL", func(t *testing.T) {
+		// D:P(A;OICIID;FA;;;SY)(D;;SD;;;WD)(A;;0x1200af;;;BU)(A;OICIIO;FA;;;CO)(A;;0xa9;;;BU)(A;;FA;;;CO)
+		ntsdBytes, err := hex.DecodeString(
+			"010004901400000024000000000000003000000001020000000000052000000020020000" +
+				"010100000000000512000000040088000600000000131400ff011f0001010000000000051200000001001400" +
+				"0000010001010000000000010000000000001800af001200010200000000000520000000210200000" +
+				"00b1400ff011f0001010000000000030000000000001800a900000001020000000000052000000021020000" +
+				"00001400ff011f00010100000000000300000000")
+		r.NoError(err)
+		ntsd, err := winacl.NewNtSecurityDescriptor(ntsdBytes)
+		r.NoError(err)
+
+		rules := lintRules(ntsd.Lint(winacl.FileObject))
+		r.Contains(rules, "PROTECTED_DACL:-1")
+		r.Contains(rules, "NON_CANONICAL:1")
+		r.Contains(rules, "BROAD_WRITE:2")
+		r.Contains(rules, "CREATOR_OWNER_WRITE:3")
+		r.Contains(rules, "REDUNDANT:4")
+		r.Contains(rules, "CREATOR_OWNER_NOT_INHERITABLE:5")
+		r.NotContains(rules, "BROAD_WRITE:4")
+	})
+
+	t.Run("Reports an empty DACL", func(t *testing.T) {
+		ntsdBytes, err := hex.DecodeString(
+			"010004801400000024000000000000003000000001020000000000052000000020020000" +
+				"0101000000000005120000000400080000000000")
+		r.NoError(err)
+		ntsd, err := winacl.NewNtSecurityDescriptor(ntsdBytes)
+		r.NoError(err)
+
+		r.Equal([]string{"EMPTY_DACL:-1"}, lintRules(ntsd.Lint(winacl.FileObject)))
+	})
+
+	t.Run("Accepts a canonical directory object DACL", func(t *testing.T) {
+		ntsd := newTestSD()
+		for _, finding := range ntsd.Lint(winacl.DirectoryServiceObject) {
+			r.NotEqual("NON_CANONICAL", finding.Rule, finding.Message)
+		}
+	})
+
+	t.Run("Reports NULL DACLs", func(t *testing.T) {
+		for _, sddl := range []string{"O:BAD:NO_ACCESS_CONTROL", "O:BAG:BA"} {
+			ntsd, err := winacl.NewNtSecurityDescriptorFromSDDL(sddl)
+			r.NoError(err)
+			r.Equal([]string{"NULL_DACL:-1"}, lintRules(ntsd.Lint(winacl.FileObject)), sddl)
+
+			buf, err := ntsd.ToBuffer()
+			r.NoError(err)
+			parsed, err := winacl.NewNtSecurityDescriptor(buf.Bytes())
+			r.NoError(err)
+			r.Equal([]string{"NULL_DACL:-1"}, lintRules(parsed.Lint(winacl.FileObject)), sddl)
+		}
+	})
+
+	t.Run("Reports inherit-only broad writes as applying to children", func(t *testing.T) {
+		ntsd, err := winacl.NewNtSecurityDescriptorFromSDDL("O:BAG:BAD:(A;;0x1200a9;;;WD)(A;OICIIO;FA;;;WD)(A;;FA;;;SY)")
+		r.NoError(err)
+
+		findings := ntsd.Lint(winacl.FileObject)
+		r.Equal([]string{"BROAD_WRITE:1"}, lintRules(findings))
+		r.Contains(findings[0].Message, "on child objects")
+	})
+
+	t.Run("Does not count inherit-only create rights for CREATOR OWNER", func(t *testing.T) {
+		ntsd, err := winacl.NewNtSecurityDescriptorFromSDDL("O:BAG:BAD:(A;CIIO;0x6;;;BU)(A;OICIIO;FA;;;CO)(A;OICI;FA;;;SY)")
+		r.NoError(err)
+		r.NotContains(lintRules(ntsd.Lint(winacl.FileObject)), "CREATOR_OWNER_WRITE:1")
+	})
+}
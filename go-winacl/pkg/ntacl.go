@@ -0,0 +1,157 @@
+package winacl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// Hash types of a Samba NTACL
+const (
+	NTACLHashNone   = 0
+	NTACLHashSHA256 = 1
+)
+
+// NTACL is the content of the security.NTACL extended attribute, in which
+// Samba stores the Windows security descriptor of a file. Versions 2 and
+// up carry a hash of the descriptor, and version 4 a description of what
+// created it and a hash of the file's POSIX ACL
+//
+// https://github.com/samba-team/samba/blob/master/librpc/idl/xattr.idl
+type NTACL struct {
+	Version            uint16
+	HashType           uint16
+	Hash               []byte
+	Description        string
+	Time               time.Time
+	SysACLHash         []byte
+	SecurityDescriptor NtSecurityDescriptor
+}
+
+// ndrReader reads the little-endian NDR encoding of an xattr_NTACL
+type ndrReader struct {
+	data   []byte
+	offset int
+	err    error
+}
+
+func (r *ndrReader) align(n int) {
+	if pad := r.offset % n; pad != 0 {
+		r.offset += n - pad
+	}
+}
+
+func (r *ndrReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || r.offset+n > len(r.data) {
+		r.err = fmt.Errorf("truncated at offset %d", r.offset)
+		return nil
+	}
+	b := r.data[r.offset : r.offset+n]
+	r.offset += n
+	return b
+}
+
+// cstring reads a NUL terminated string
+func (r *ndrReader) cstring() string {
+	if r.err != nil {
+		return ""
+	}
+	end := bytes.IndexByte(r.data[r.offset:], 0)
+	if end < 0 {
+		r.err = fmt.Errorf("unterminated string at offset %d", r.offset)
+		return ""
+	}
+	s := string(r.data[r.offset : r.offset+end])
+	r.offset += end + 1
+	return s
+}
+
+func (r *ndrReader) uint16() uint16 {
+	b := r.next(2)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(b)
+}
+
+func (r *ndrReader) uint32() uint32 {
+	r.align(4)
+	b := r.next(4)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+// NewNTACL is a constructor that will parse out an NTACL from the bytes
+// of a security.NTACL extended attribute
+func NewNTACL(data []byte) (NTACL, error) {
+	ntacl := NTACL{}
+	r := &ndrReader{data: data}
+
+	ntacl.Version = r.uint16()
+	level := r.uint16()
+	if r.err == nil && level != ntacl.Version {
+		return ntacl, fmt.Errorf("NewNTACL: union level %d does not match version %d", level, ntacl.Version)
+	}
+	if r.err == nil && (ntacl.Version < 1 || ntacl.Version > 4) {
+		return ntacl, fmt.Errorf("NewNTACL: unsupported version %d", ntacl.Version)
+	}
+
+	// the union arm is a pointer, to either the descriptor or a hash
+	// structure, which in turn points to the descriptor
+	if r.uint32() == 0 {
+		return ntacl, fmt.Errorf("NewNTACL: NULL security descriptor")
+	}
+
+	if ntacl.Version > 1 {
+		sdPointer := r.uint32()
+		switch ntacl.Version {
+		case 2:
+			ntacl.Hash = r.next(16)
+		case 3, 4:
+			ntacl.HashType = r.uint16()
+			ntacl.Hash = r.next(64)
+		}
+
+		if ntacl.Version == 4 {
+			// the description is a [flag(STR_NULLTERM|STR_UTF8)] string,
+			// which NDR writes bare, without a size or alignment
+			ntacl.Description = r.cstring()
+
+			low, high := r.uint32(), r.uint32()
+			ntacl.Time = filetimeToTime(uint64(high)<<32 | uint64(low))
+			ntacl.SysACLHash = r.next(64)
+		}
+
+		if r.err == nil && sdPointer == 0 {
+			return ntacl, fmt.Errorf("NewNTACL: NULL security descriptor")
+		}
+	}
+
+	// the descriptor follows, as a self-relative security descriptor
+	r.align(4)
+	if r.err != nil {
+		return ntacl, fmt.Errorf("NewNTACL: %s", r.err)
+	}
+	if r.offset >= len(data) {
+		return ntacl, fmt.Errorf("NewNTACL: truncated before the security descriptor")
+	}
+
+	var err error
+	ntacl.SecurityDescriptor, err = NewNtSecurityDescriptor(data[r.offset:])
+	return ntacl, err
+}
+
+// filetimeToTime converts a count of 100ns intervals since 1601 to a Time
+func filetimeToTime(filetime uint64) time.Time {
+	if filetime == 0 {
+		return time.Time{}
+	}
+	const epochDelta = 116444736000000000
+	return time.Unix(0, (int64(filetime)-epochDelta)*100).UTC()
+}
@@ -0,0 +1,108 @@
+package winacl_test
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	winacl "github.com/kgoins/go-winacl/pkg"
+	"github.com/stretchr/testify/require"
+)
+
+// getTestNTACL reads a security.NTACL value from a testdata file in the
+// format getfattr -e hex -n security.NTACL prints. The ntacl_v*.hex files
+// are synthetic: testdata/mkntacl.py lays them out from Samba's xattr.idl
+// and pidl's alignment rules, so they only check the decoder against that
+// reading of the IDL. None is a capture from a Samba share, though one
+// can replace any of them as is
+func getTestNTACL(name string) ([]byte, error) {
+	f, err := os.Open(filepath.Join(getTestDataDir(), name))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if value := strings.TrimPrefix(scanner.Text(), "security.NTACL=0x"); value != scanner.Text() {
+			return hex.DecodeString(value)
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+func TestNewNTACL(t *testing.T) {
+	r := require.New(t)
+
+	const sddl = "O:S-1-5-21-2847349937-3296235417-2715427383-1000G:S-1-22-2-1000" +
+		"D:(A;;CCDCLCSWRPWPDTLOCRSDRCWDWO;;;S-1-5-21-2847349937-3296235417-2715427383-1000)" +
+		"(A;;CCSWWPLORC;;;S-1-22-2-1000)(A;;CCSWWPLORC;;;WD)(A;;CCDCLCSWRPWPDTLOCRSDRCWDWO;;;SY)"
+	hash, _ := hex.DecodeString("da08dfc32ff9736fd874b610dfa824b6c9859caaab6038148b07e771f7cc2863")
+	sysACLHash, _ := hex.DecodeString("e801112f38c61ac9fc779ce89b8a007e393bfd6f0ef97fc3a80432c23a30c073")
+
+	t.Run("Decodes every version", func(t *testing.T) {
+		for _, version := range []uint16{1, 3, 4} {
+			data, err := getTestNTACL(fmt.Sprintf("ntacl_v%d.hex", version))
+			r.NoError(err)
+			ntacl, err := winacl.NewNTACL(data)
+			r.NoError(err, "version %d", version)
+			r.Equal(version, ntacl.Version)
+			r.Equal(sddl, ntacl.SecurityDescriptor.ToSDDL(), "version %d", version)
+		}
+	})
+
+	t.Run("Decodes version 3 hashes", func(t *testing.T) {
+		data, err := getTestNTACL("ntacl_v3.hex")
+		r.NoError(err)
+		ntacl, err := winacl.NewNTACL(data)
+		r.NoError(err)
+		r.Equal(uint16(winacl.NTACLHashSHA256), ntacl.HashType)
+		r.Equal(hash, ntacl.Hash[:32])
+	})
+
+	t.Run("Decodes version 4 metadata", func(t *testing.T) {
+		data, err := getTestNTACL("ntacl_v4.hex")
+		r.NoError(err)
+		ntacl, err := winacl.NewNTACL(data)
+		r.NoError(err)
+		r.Equal(uint16(winacl.NTACLHashSHA256), ntacl.HashType)
+		r.Equal(hash, ntacl.Hash[:32])
+		r.Equal("posix_acl", ntacl.Description)
+		r.Equal(time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC), ntacl.Time)
+		r.Equal(sysACLHash, ntacl.SysACLHash[:32])
+	})
+
+	t.Run("Rejects malformed blobs", func(t *testing.T) {
+		valid, err := getTestNTACL("ntacl_v4.hex")
+		r.NoError(err)
+
+		_, err = winacl.NewNTACL(valid[:100])
+		r.Error(err)
+
+		mismatched := append([]byte{}, valid...)
+		mismatched[2] = 3
+		_, err = winacl.NewNTACL(mismatched)
+		r.Error(err)
+
+		unsupported := append([]byte{}, valid...)
+		unsupported[0], unsupported[2] = 5, 5
+		_, err = winacl.NewNTACL(unsupported)
+		r.Error(err)
+
+		// the description runs from offset 78 to its NUL
+		unterminated := append([]byte{}, valid[:78]...)
+		unterminated = append(unterminated, "posix_acl"...)
+		_, err = winacl.NewNTACL(unterminated)
+		r.Error(err)
+
+		null := append([]byte{}, valid...)
+		copy(null[4:8], []byte{0, 0, 0, 0})
+		_, err = winacl.NewNTACL(null)
+		r.Error(err)
+	})
+}
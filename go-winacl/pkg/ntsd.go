@@ -27,7 +27,8 @@ func (s NtSecurityDescriptor) String() string {
 }
 
 // NewNtSecurityDescriptor is a constructor that will parse out an
-// NtSecurityDescriptor from a byte buffer
+// NtSecurityDescriptor from a byte buffer. Components are located by
+// the offsets in the header, so any self-relative layout is accepted
 func NewNtSecurityDescriptor(ntsdBytes []byte) (NtSecurityDescriptor, error) {
 	var buf = bytes.NewBuffer(ntsdBytes)
 	var err error
@@ -38,16 +39,98 @@ func NewNtSecurityDescriptor(ntsdBytes []byte) (NtSecurityDescriptor, error) {
 		return ntsd, err
 	}
 
-	ntsd.DACL, err = NewACL(buf)
-	if err != nil {
-		return ntsd, err
+	if ntsd.Header.OffsetDacl != 0 {
+		section, err := sectionAt(ntsdBytes, ntsd.Header.OffsetDacl)
+		if err != nil {
+			return ntsd, err
+		}
+		ntsd.DACL, err = NewACL(section)
+		if err != nil {
+			return ntsd, err
+		}
 	}
 
-	sidSize := ntsd.Header.OffsetGroup - ntsd.Header.OffsetOwner
-	ntsd.Owner, err = NewSID(buf, int(sidSize))
-	if err != nil {
-		return ntsd, err
+	if ntsd.Header.OffsetSacl != 0 {
+		section, err := sectionAt(ntsdBytes, ntsd.Header.OffsetSacl)
+		if err != nil {
+			return ntsd, err
+		}
+		ntsd.SACL, err = NewACL(section)
+		if err != nil {
+			return ntsd, err
+		}
+	}
+
+	if ntsd.Header.OffsetOwner != 0 {
+		ntsd.Owner, err = sidAt(ntsdBytes, ntsd.Header.OffsetOwner)
+		if err != nil {
+			return ntsd, err
+		}
+	}
+
+	if ntsd.Header.OffsetGroup != 0 {
+		ntsd.Group, err = sidAt(ntsdBytes, ntsd.Header.OffsetGroup)
 	}
-	ntsd.Group, err = NewSID(buf, int(sidSize))
 	return ntsd, err
 }
+
+// refresh recomputes the sizes of both ACLs and the header offsets of each
+// component, laid out as Windows does: header, SACL, DACL, owner, group.
+// An ACL is laid out when its present flag is set, unless it is NULL, so a
+// parsed NULL DACL remains NULL
+func (s *NtSecurityDescriptor) refresh() {
+	if s.Header.Revision == 0 {
+		s.Header.Revision = 1
+	}
+	s.Header.Control |= SelfRelative
+
+	offset := uint32(20)
+	s.Header.OffsetSacl = 0
+	if s.Header.Control&SACLPresent != 0 && !s.SACL.isNull() {
+		s.SACL.refresh()
+		s.Header.OffsetSacl = offset
+		offset += uint32(s.SACL.Header.Size)
+	}
+
+	s.Header.OffsetDacl = 0
+	if s.Header.Control&DACLPresent != 0 && !s.DACL.isNull() {
+		s.DACL.refresh()
+		s.Header.OffsetDacl = offset
+		offset += uint32(s.DACL.Header.Size)
+	}
+
+	s.Header.OffsetOwner = 0
+	if len(s.Owner.Authority) == 6 {
+		s.Header.OffsetOwner = offset
+		offset += uint32(s.Owner.size())
+	}
+
+	s.Header.OffsetGroup = 0
+	if len(s.Group.Authority) == 6 {
+		s.Header.OffsetGroup = offset
+	}
+}
+
+// sectionAt returns a buffer over a descriptor's bytes, starting at offset
+func sectionAt(ntsdBytes []byte, offset uint32) (*bytes.Buffer, error) {
+	if int(offset) >= len(ntsdBytes) {
+		return nil, fmt.Errorf("offset %d is outside of the %d byte security descriptor", offset, len(ntsdBytes))
+	}
+	return bytes.NewBuffer(ntsdBytes[offset:]), nil
+}
+
+// sidAt parses the SID found at offset, sizing it by its sub-authority count
+func sidAt(ntsdBytes []byte, offset uint32) (SID, error) {
+	buf, err := sectionAt(ntsdBytes, offset)
+	if err != nil {
+		return SID{}, err
+	}
+	size := buf.Len()
+	if size >= 2 {
+		size = 8 + int(buf.Bytes()[1])*4
+	}
+	if size > buf.Len() {
+		return SID{}, fmt.Errorf("SID at offset %d needs %d bytes, but only %d remain", offset, size, buf.Len())
+	}
+	return NewSID(buf, size)
+}
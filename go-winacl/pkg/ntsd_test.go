@@ -1,6 +1,8 @@
 package winacl_test
 
 import (
+	"encoding/hex"
+	"math/rand"
 	"testing"
 
 	winacl "github.com/kgoins/go-winacl/pkg"
@@ -23,12 +25,61 @@ func TestNewNtSecurityDescriptor(t *testing.T) {
 		r.Equal(int(dacl.Header.AceCount), len(dacl.Aces))
 	})
 
+	t.Run("Locates components by their header offsets", func(t *testing.T) {
+		// O:BAG:SYD:(A;;0x1f01ff;;;WD), with the owner and group
+		// stored ahead of the DACL
+		ntsdBytes, err := hex.DecodeString(
+			"010004801400000024000000000000003000000001020000000000052000000020020000" +
+				"010100000000000512000000" +
+				"02001c000100000000001400ff011f00010100000000000100000000")
+		r.NoError(err)
+
+		ntsd, err := winacl.NewNtSecurityDescriptor(ntsdBytes)
+		r.NoError(err)
+		r.Equal("S-1-5-32-544", ntsd.Owner.String())
+		r.Equal("S-1-5-18", ntsd.Group.String())
+		r.Len(ntsd.DACL.Aces, 1)
+		r.Equal("S-1-1-0", ntsd.DACL.Aces[0].ObjectAce.GetPrincipal().String())
+	})
+
 	t.Run("Returns an error when given a malformed SD", func(t *testing.T) {
 		ntsdBytes := make([]byte, 10)
 		_, err := winacl.NewNtSecurityDescriptor(ntsdBytes)
 		r.Error(err)
 	})
 
+	t.Run("Returns an error when given a truncated SID", func(t *testing.T) {
+		for _, truncated := range []string{
+			// an owner counting one sub-authority, with none stored
+			"01000080140000000000000000000000000000000105000000000005",
+			// a group counting two, with one stored
+			"0100008000000000140000000000000000000000010200000000000520000000",
+			// an owner whose offset leaves no room for its header
+			"010000801400000000000000000000000000000001",
+		} {
+			ntsdBytes, err := hex.DecodeString(truncated)
+			r.NoError(err)
+			_, err = winacl.NewNtSecurityDescriptor(ntsdBytes)
+			r.Error(err, truncated)
+		}
+	})
+
+	t.Run("Does not panic on a truncated or corrupted SD", func(t *testing.T) {
+		ntsdBytes, err := getTestNtsdBytes()
+		r.NoError(err)
+		for n := 0; n <= len(ntsdBytes); n++ {
+			winacl.NewNtSecurityDescriptor(ntsdBytes[:n])
+		}
+		rng := rand.New(rand.NewSource(1))
+		for i := 0; i < 5000; i++ {
+			corrupted := append([]byte{}, ntsdBytes...)
+			for j := 0; j < 4; j++ {
+				corrupted[rng.Intn(len(corrupted))] = byte(rng.Intn(256))
+			}
+			winacl.NewNtSecurityDescriptor(corrupted[:rng.Intn(len(corrupted)+1)])
+		}
+	})
+
 }
 
 func TestToSDDL(t *testing.T) {
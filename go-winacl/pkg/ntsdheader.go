@@ -16,13 +16,60 @@ type NtSecurityDescriptorHeader struct {
 	OffsetDacl  uint32
 }
 
+// Security Descriptor Control flags
+//
+// https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-dtyp/7d4dac05-9cef-4563-a058-f108abecce1d
 const (
+	OwnerDefaulted     = 0x0001
+	GroupDefaulted     = 0x0002
+	DACLPresent        = 0x0004
+	DACLDefaulted      = 0x0008
+	SACLPresent        = 0x0010
+	SACLDefaulted      = 0x0020
+	DACLTrusted        = 0x0040
+	ServerSecurity     = 0x0080
 	DACLAutoInheritReq = 0x0100
+	SACLAutoInheritReq = 0x0200
 	DACLAutoInherited  = 0x0400
 	SACLAutoInherited  = 0x0800
 	DACLProtected      = 0x1000
+	SACLProtected      = 0x2000
+	RMControlValid     = 0x4000
+	SelfRelative       = 0x8000
 )
 
+// NtSecurityDescriptorControlLookup maps Control flags to human-readable labels
+var NtSecurityDescriptorControlLookup = map[uint16]string{
+	OwnerDefaulted:     "SE_OWNER_DEFAULTED",
+	GroupDefaulted:     "SE_GROUP_DEFAULTED",
+	DACLPresent:        "SE_DACL_PRESENT",
+	DACLDefaulted:      "SE_DACL_DEFAULTED",
+	SACLPresent:        "SE_SACL_PRESENT",
+	SACLDefaulted:      "SE_SACL_DEFAULTED",
+	DACLTrusted:        "SE_DACL_TRUSTED",
+	ServerSecurity:     "SE_SERVER_SECURITY",
+	DACLAutoInheritReq: "SE_DACL_AUTO_INHERIT_REQ",
+	SACLAutoInheritReq: "SE_SACL_AUTO_INHERIT_REQ",
+	DACLAutoInherited:  "SE_DACL_AUTO_INHERITED",
+	SACLAutoInherited:  "SE_SACL_AUTO_INHERITED",
+	DACLProtected:      "SE_DACL_PROTECTED",
+	SACLProtected:      "SE_SACL_PROTECTED",
+	RMControlValid:     "SE_RM_CONTROL_VALID",
+	SelfRelative:       "SE_SELF_RELATIVE",
+}
+
+// ControlStrings returns the human-readable labels of the Control flags set
+// in an NtSecurityDescriptorHeader, lowest bit first
+func (ndh NtSecurityDescriptorHeader) ControlStrings() []string {
+	var labels []string
+	for bit := uint16(1); bit != 0; bit <<= 1 {
+		if ndh.Control&bit != 0 {
+			labels = append(labels, NtSecurityDescriptorControlLookup[bit])
+		}
+	}
+	return labels
+}
+
 // NewNTSDHeader is a constructor that will parse out an
 // NtSecurityDescriptorHeader from a byte buffer
 func NewNTSDHeader(buf *bytes.Buffer) (header NtSecurityDescriptorHeader, err error) {
@@ -0,0 +1,283 @@
+package winacl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// sddlCompositeRights are the SDDL abbreviations that stand for several
+// rights at once, and so are absent from AceRightsSDDL
+var sddlCompositeRights = map[string]uint32{
+	"FA": 0x1f01ff,
+	"FR": 0x120089,
+	"FW": 0x120116,
+	"FX": 0x1200a0,
+	"KA": 0xf003f,
+	"KR": 0x20019,
+	"KW": 0x20006,
+	"KX": 0x20019,
+}
+
+// sddlExtraAceTypes are ACE type abbreviations with no entry in
+// AceHeaderTypeSDDL
+var sddlExtraAceTypes = map[string]AceType{
+	"ZA": AceTypeAccessAllowedCallbackObject,
+}
+
+// NewNtSecurityDescriptorFromSDDL is a constructor that will parse out an
+// NtSecurityDescriptor from an SDDL string. Domain-relative SID aliases,
+// such as DA, resolve to the placeholder S-1-5-21-0-0-0 domain used by
+// WellKnownSIDsSSDL, and the conditions of callback ACEs are discarded
+//
+// https://docs.microsoft.com/en-us/windows/win32/secauthz/security-descriptor-string-format
+func NewNtSecurityDescriptorFromSDDL(sddl string) (NtSecurityDescriptor, error) {
+	sd := NtSecurityDescriptor{}
+	sddl = strings.Join(strings.Fields(sddl), "")
+
+	for len(sddl) > 0 {
+		if len(sddl) < 2 || sddl[1] != ':' {
+			return sd, fmt.Errorf("NewNtSecurityDescriptorFromSDDL: unexpected %q", sddl)
+		}
+		component := sddl[0]
+		value := sddl[2:]
+		end := nextSDDLComponent(value)
+		value, sddl = value[:end], value[end:]
+
+		var err error
+		switch component {
+		case 'O':
+			sd.Owner, err = sidFromSDDL(value)
+		case 'G':
+			sd.Group, err = sidFromSDDL(value)
+		case 'D':
+			sd.DACL, err = aclFromSDDL(value, &sd.Header.Control, DACLPresent, DACLProtected, DACLAutoInherited, DACLAutoInheritReq)
+		case 'S':
+			sd.SACL, err = aclFromSDDL(value, &sd.Header.Control, SACLPresent, SACLProtected, SACLAutoInherited, SACLAutoInheritReq)
+		default:
+			err = fmt.Errorf("unknown component %q", component)
+		}
+		if err != nil {
+			return sd, fmt.Errorf("NewNtSecurityDescriptorFromSDDL: %s", err)
+		}
+	}
+
+	sd.refresh()
+	return sd, nil
+}
+
+// nextSDDLComponent returns the index at which the next "X:" component
+// starts, ignoring anything inside ACE parentheses
+func nextSDDLComponent(sddl string) int {
+	depth := 0
+	for i := 0; i < len(sddl); i++ {
+		switch sddl[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ':':
+			if depth == 0 && i > 0 {
+				return i - 1
+			}
+		}
+	}
+	return len(sddl)
+}
+
+// sidFromSDDL parses either a SID alias or an "S-1-" string
+func sidFromSDDL(value string) (SID, error) {
+	if len(value) == 2 {
+		for sid, alias := range WellKnownSIDsSSDL {
+			if alias == strings.ToUpper(value) {
+				return NewSIDFromString(sid)
+			}
+		}
+	}
+	return NewSIDFromString(value)
+}
+
+// aclFromSDDL parses the flags and ACE strings of a D: or S: component,
+// setting the matching Control bits
+func aclFromSDDL(value string, control *uint16, present, protected, autoInherited, autoInheritReq uint16) (ACL, error) {
+	acl := ACL{}
+	flags := value
+	if i := strings.IndexByte(value, '('); i >= 0 {
+		flags, value = value[:i], value[i:]
+	} else {
+		value = ""
+	}
+
+	*control |= present
+	for len(flags) > 0 {
+		switch {
+		case strings.HasPrefix(flags, "NO_ACCESS_CONTROL"):
+			// a NULL ACL, which has no revision
+			if value != "" {
+				return acl, fmt.Errorf("NO_ACCESS_CONTROL ACL holds ACEs")
+			}
+			return acl, nil
+		case strings.HasPrefix(flags, "AR"):
+			*control |= autoInheritReq
+			flags = flags[2:]
+		case strings.HasPrefix(flags, "AI"):
+			*control |= autoInherited
+			flags = flags[2:]
+		case strings.HasPrefix(flags, "P"):
+			*control |= protected
+			flags = flags[1:]
+		default:
+			return acl, fmt.Errorf("unknown ACL flags %q", flags)
+		}
+	}
+
+	acl = NewEmptyACL()
+	for len(value) > 0 {
+		if value[0] != '(' {
+			return acl, fmt.Errorf("unexpected %q", value)
+		}
+		depth, end := 0, -1
+		for i := 0; i < len(value) && end < 0; i++ {
+			switch value[i] {
+			case '(':
+				depth++
+			case ')':
+				depth--
+				if depth == 0 {
+					end = i
+				}
+			}
+		}
+		if end < 0 {
+			return acl, fmt.Errorf("unterminated ACE %q", value)
+		}
+
+		ace, err := aceFromSDDL(value[1:end])
+		if err != nil {
+			return acl, err
+		}
+		acl.Aces = append(acl.Aces, ace)
+		value = value[end+1:]
+	}
+	acl.refresh()
+	return acl, nil
+}
+
+// aceFromSDDL parses the fields of a single ACE string, without its
+// surrounding parentheses
+func aceFromSDDL(value string) (ACE, error) {
+	fields := strings.SplitN(value, ";", 7)
+	if len(fields) < 6 {
+		return ACE{}, fmt.Errorf("ACE %q has %d fields, want 6", value, len(fields))
+	}
+
+	aceType, ok := sddlExtraAceTypes[fields[0]]
+	if !ok {
+		found := false
+		for t, abbreviation := range AceHeaderTypeSDDL {
+			if abbreviation != "" && abbreviation == fields[0] {
+				aceType, found = t, true
+				break
+			}
+		}
+		if !found {
+			return ACE{}, fmt.Errorf("unsupported ACE type %q", fields[0])
+		}
+	}
+
+	var flags ACEHeaderFlags
+	for abbreviations := fields[1]; len(abbreviations) > 0; abbreviations = abbreviations[2:] {
+		if len(abbreviations) < 2 {
+			return ACE{}, fmt.Errorf("unknown ACE flags %q", abbreviations)
+		}
+		flag, ok := flagFromSDDL(abbreviations[:2])
+		if !ok {
+			return ACE{}, fmt.Errorf("unknown ACE flag %q", abbreviations[:2])
+		}
+		flags |= flag
+	}
+
+	mask, err := rightsFromSDDL(fields[2])
+	if err != nil {
+		return ACE{}, err
+	}
+
+	sid, err := sidFromSDDL(fields[5])
+	if err != nil {
+		return ACE{}, err
+	}
+
+	var objectType, inheritedObjectType *GUID
+	if fields[3] != "" {
+		guid, err := NewGUIDFromString(fields[3])
+		if err != nil {
+			return ACE{}, err
+		}
+		objectType = &guid
+	}
+	if fields[4] != "" {
+		guid, err := NewGUIDFromString(fields[4])
+		if err != nil {
+			return ACE{}, err
+		}
+		inheritedObjectType = &guid
+	}
+
+	ace := ACE{Header: ACEHeader{Type: aceType}}
+	if ace.isBasic() {
+		if objectType != nil || inheritedObjectType != nil {
+			return ACE{}, fmt.Errorf("%s ACE cannot hold object types", fields[0])
+		}
+		return BuildBasicAce(aceType, flags, mask, sid)
+	}
+	return BuildAdvancedAce(aceType, flags, mask, objectType, inheritedObjectType, sid)
+}
+
+// rightsFromSDDL parses an ACE's rights, given either as a number or as
+// concatenated abbreviations
+func rightsFromSDDL(rights string) (uint32, error) {
+	if strings.HasPrefix(rights, "0x") || strings.HasPrefix(rights, "0X") ||
+		(rights != "" && rights[0] >= '0' && rights[0] <= '9') {
+		mask, err := strconv.ParseUint(rights, 0, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid rights %q", rights)
+		}
+		return uint32(mask), nil
+	}
+
+	var mask uint32
+	for ; len(rights) > 0; rights = rights[2:] {
+		if len(rights) < 2 {
+			return 0, fmt.Errorf("unknown rights %q", rights)
+		}
+		right, ok := rightFromSDDL(rights[:2])
+		if !ok {
+			return 0, fmt.Errorf("unknown right %q", rights[:2])
+		}
+		mask |= right
+	}
+	return mask, nil
+}
+
+// flagFromSDDL finds the ACE flag of an SDDL abbreviation
+func flagFromSDDL(abbreviation string) (ACEHeaderFlags, bool) {
+	for flag, value := range AceHeaderFlagsSDDL {
+		if value == abbreviation {
+			return flag, true
+		}
+	}
+	return 0, false
+}
+
+// rightFromSDDL finds the right of an SDDL abbreviation
+func rightFromSDDL(abbreviation string) (uint32, bool) {
+	if right, ok := sddlCompositeRights[abbreviation]; ok {
+		return right, true
+	}
+	for right, value := range AceRightsSDDL {
+		if value == abbreviation {
+			return right, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,94 @@
+package winacl_test
+
+import (
+	"testing"
+
+	winacl "github.com/kgoins/go-winacl/pkg"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewNtSecurityDescriptorFromSDDL(t *testing.T) {
+	r := require.New(t)
+
+	t.Run("Round trips through ToSDDL", func(t *testing.T) {
+		sddl, err := getTestNtsdSDDLTestString()
+		r.NoError(err)
+
+		sd, err := winacl.NewNtSecurityDescriptorFromSDDL(sddl)
+		r.NoError(err)
+		r.Equal(sddl, sd.ToSDDL())
+	})
+
+	t.Run("Produces the same DACL as the binary form", func(t *testing.T) {
+		sddl, err := getTestNtsdSDDLTestString()
+		r.NoError(err)
+
+		fromSDDL, err := winacl.NewNtSecurityDescriptorFromSDDL(sddl)
+		r.NoError(err)
+		expected, err := newTestSD().DACL.ToBuffer()
+		r.NoError(err)
+		actual, err := fromSDDL.DACL.ToBuffer()
+		r.NoError(err)
+		r.Equal(expected.Bytes(), actual.Bytes())
+	})
+
+	t.Run("Parses aliases, composite rights and flags", func(t *testing.T) {
+		sd, err := winacl.NewNtSecurityDescriptorFromSDDL("O:BA G:SY D:PAI(A;OICI;FA;;;SY)(D;;0x10000;;;WD)S:(AU;SAFA;FW;;;WD)")
+		r.NoError(err)
+
+		r.Equal("S-1-5-32-544", sd.Owner.String())
+		r.Equal("S-1-5-18", sd.Group.String())
+		r.NotZero(sd.Header.Control & winacl.DACLProtected)
+		r.NotZero(sd.Header.Control & winacl.DACLAutoInherited)
+		r.NotZero(sd.Header.Control & winacl.SACLPresent)
+
+		r.Len(sd.DACL.Aces, 2)
+		r.Equal(uint32(0x1f01ff), sd.DACL.Aces[0].AccessMask.Raw())
+		r.Equal(winacl.ACEHeaderFlags(0x03), sd.DACL.Aces[0].Header.Flags)
+		r.Equal(winacl.AceTypeAccessDenied, sd.DACL.Aces[1].GetType())
+		r.Equal(uint32(0x10000), sd.DACL.Aces[1].AccessMask.Raw())
+		r.Equal(winacl.AceTypeSystemAudit, sd.SACL.Aces[0].GetType())
+
+		buf, err := sd.ToBuffer()
+		r.NoError(err)
+		parsed, err := winacl.NewNtSecurityDescriptor(buf.Bytes())
+		r.NoError(err)
+		r.Equal(sd.ToSDDL(), parsed.ToSDDL())
+		r.Len(parsed.SACL.Aces, 1)
+	})
+
+	t.Run("Keeps NULL and empty DACLs apart", func(t *testing.T) {
+		null, err := winacl.NewNtSecurityDescriptorFromSDDL("O:BAD:NO_ACCESS_CONTROL")
+		r.NoError(err)
+		r.Zero(null.Header.OffsetDacl)
+
+		empty, err := winacl.NewNtSecurityDescriptorFromSDDL("O:BAD:")
+		r.NoError(err)
+		r.NotZero(empty.Header.OffsetDacl)
+		r.Empty(empty.DACL.Aces)
+	})
+
+	t.Run("Discards callback conditions", func(t *testing.T) {
+		sd, err := winacl.NewNtSecurityDescriptorFromSDDL("D:(XA;;FX;;;WD;(Member_of {SID(BA)}))")
+		r.NoError(err)
+		r.Len(sd.DACL.Aces, 1)
+		r.Equal(winacl.AceTypeAccessAllowedCallback, sd.DACL.Aces[0].GetType())
+	})
+
+	t.Run("Rejects malformed SDDL", func(t *testing.T) {
+		for _, sddl := range []string{
+			"X:BA",
+			"O:ZZ",
+			"D:(A;;FA;;WD)",
+			"D:(A;;FA;;;WD",
+			"D:(Q;;FA;;;WD)",
+			"D:(A;QQ;FA;;;WD)",
+			"D:(A;;QQ;;;WD)",
+			"D:(A;;FA;not-a-guid;;WD)",
+			"D:(A;;FA;00299570-246d-11d0-a768-00aa006e0529;;WD)",
+		} {
+			_, err := winacl.NewNtSecurityDescriptorFromSDDL(sddl)
+			r.Error(err, sddl)
+		}
+	})
+}
@@ -110,7 +110,15 @@ func (s SID) String() string {
 		return ""
 	}
 
-	fmt.Fprintf(&sb, "S-%v-%v", s.Revision, int(s.Authority[5]))
+	var authority uint64
+	for _, b := range s.Authority {
+		authority = authority<<8 | uint64(b)
+	}
+	if authority >= 1<<32 {
+		fmt.Fprintf(&sb, "S-%v-0x%012X", s.Revision, authority)
+	} else {
+		fmt.Fprintf(&sb, "S-%v-%v", s.Revision, authority)
+	}
 	for i := 0; i < int(s.NumAuthorities); i++ {
 		fmt.Fprintf(&sb, "-%v", s.SubAuthorities[i])
 	}
@@ -118,16 +126,26 @@ func (s SID) String() string {
 	return sb.String()
 }
 
+// size returns the number of bytes the SID occupies when serialized
+func (s SID) size() int {
+	return 8 + 4*len(s.SubAuthorities)
+}
+
 // NewSID is a constructor that will parse out a SID from a byte buffer
 func NewSID(buf *bytes.Buffer, sidLength int) (SID, error) {
 	sid := SID{}
+	if sidLength < 8 {
+		return sid, SIDInvalidError{"invalid SID length"}
+	}
 	data := buf.Next(sidLength)
 
-	if revision := data[0]; revision != 1 {
+	if len(data) < 8 {
+		return sid, SIDInvalidError{"invalid SID length"}
+	} else if revision := data[0]; revision != 1 {
 		return sid, SIDInvalidError{"invalid SID revision"}
 	} else if numAuth := data[1]; numAuth > 15 {
 		return sid, SIDInvalidError{"invalid number of subauthorities"}
-	} else if ((int(numAuth) * 4) + 8) < len(data) {
+	} else if ((int(numAuth) * 4) + 8) != len(data) {
 		return sid, SIDInvalidError{"invalid SID length"}
 	} else {
 		authority := data[2:8]
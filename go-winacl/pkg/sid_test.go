@@ -20,4 +20,11 @@ func TestNewSID(t *testing.T) {
 		r.IsType(winacl.SIDInvalidError{}, err)
 	})
 
+	t.Run("Returns an error when given fewer sub-authorities than it counts", func(t *testing.T) {
+		// S-1-5-32-544 cut short of its second sub-authority
+		buf := bytes.NewBuffer([]byte{1, 2, 0, 0, 0, 0, 0, 5, 0x20, 0, 0, 0})
+		_, err := winacl.NewSID(buf, 16)
+		r.IsType(winacl.SIDInvalidError{}, err)
+	})
+
 }
@@ -0,0 +1,48 @@
+#!/usr/bin/env python3
+"""Generates the NTACL fixtures of ntacl_test.go.
+
+    python3 mkntacl.py
+
+The blobs are laid out by hand from Samba's librpc/idl/xattr.idl and pidl's
+NDR alignment rules, in the format getfattr -e hex -n security.NTACL
+prints. They are synthetic, not captures from a Samba share, so they test
+the decoder only against this reading of the IDL. A capture can replace
+any of them as is
+"""
+import struct, hashlib
+def sid(s):
+    p = s.split('-'); rev=int(p[1]); auth=int(p[2]); subs=[int(x) for x in p[3:]]
+    return struct.pack('<BB', rev, len(subs)) + auth.to_bytes(6,'big') + b''.join(struct.pack('<I',x) for x in subs)
+def ace(typ, flags, mask, s):
+    b = sid(s); return struct.pack('<BBHI', typ, flags, 8+len(b), mask) + b
+owner = sid('S-1-5-21-2847349937-3296235417-2715427383-1000')
+group = sid('S-1-22-2-1000')
+aces = [ace(0,0,0x1f01ff,'S-1-5-21-2847349937-3296235417-2715427383-1000'),
+        ace(0,0,0x1200a9,'S-1-22-2-1000'),
+        ace(0,0,0x1200a9,'S-1-1-0'),
+        ace(0,0,0x1f01ff,'S-1-5-18')]
+acl = struct.pack('<BBHHH', 2, 0, 8+sum(map(len,aces)), len(aces), 0) + b''.join(aces)
+# self-relative: header, owner, group, dacl as Samba orders them
+off_owner = 20; off_group = off_owner+len(owner); off_dacl = off_group+len(group)
+sd = struct.pack('<BBHIIII', 1, 0, 0x8004, off_owner, off_group, 0, off_dacl) + owner + group + acl
+sha = hashlib.sha256(sd).digest()
+
+def pad(b):
+    return b + b'\0'*((-len(b))%4)
+# version 1: version, union level, referent of the sd pointer, sd
+v1 = struct.pack('<HHI', 1, 1, 0x00020000) + sd
+# version 3: pointer to security_descriptor_hash_v3 {sd *, uint16 hash_type, uint8 hash[64]}
+v3 = struct.pack('<HHI', 3, 3, 0x00020000) + struct.pack('<IH', 0x00020004, 1) + sha.ljust(64, b'\0')
+v3 = pad(v3) + sd
+# version 4 adds a [flag(STR_NULLTERM|STR_UTF8)] string description, written
+# bare with its NUL, an NTTIME, aligned to 4, and uint8 sys_acl_hash[64]
+desc = b'posix_acl\0'
+nttime = 133497504000000000  # 2024-01-15T00:00:00Z
+sysacl = hashlib.sha256(b'posix acl of the file').digest()
+v4 = struct.pack('<HHI', 4, 4, 0x00020000) + struct.pack('<IH', 0x00020004, 1) + sha.ljust(64, b'\0')
+v4 = v4 + desc
+v4 = pad(v4) + struct.pack('<Q', nttime) + sysacl.ljust(64, b'\0')
+v4 = pad(v4) + sd
+for name, blob in (('v1', v1), ('v3', v3), ('v4', v4)):
+    with open('ntacl_%s.hex' % name, 'w') as f:
+        f.write('# synthetic: built by mkntacl.py from xattr.idl, not captured from a Samba share\nsecurity.NTACL=0x%s\n\n' % blob.hex())
@@ -4,14 +4,20 @@ go 1.18
 
 require (
 	github.com/Microsoft/go-winio v0.5.2
+	github.com/Velocidex/yaml/v2 v2.2.8
+	github.com/go-asn1-ber/asn1-ber v1.5.4
+	github.com/go-ldap/ldap/v3 v3.4.4
 	github.com/kgoins/go-winacl v0.2.0
-	golang.org/x/sys v0.0.0-20220503163025-988cb79eb6c6
+	golang.org/x/sys v0.1.0
 	www.velocidex.com/golang/binparsergen v0.1.0
+	www.velocidex.com/golang/go-ntfs v0.2.1
 	www.velocidex.com/golang/go-pe v0.1.1-0.20210201082132-138370e90206
 )
 
 require (
+	github.com/Azure/go-ntlmssp v0.0.0-20220621081337-cb9428e4ac1e // indirect
 	github.com/Velocidex/json v0.0.0-20220224052537-92f3c0326e5a // indirect
-	github.com/Velocidex/ordereddict v0.0.0-20220428153415-da46091cd216 // indirect
-	github.com/Velocidex/yaml/v2 v2.2.8 // indirect
+	github.com/Velocidex/ordereddict v0.0.0-20230909174157-2aa49cc5d11d // indirect
+	github.com/davecgh/go-spew v1.1.2-0.20180830191138-d8f796af33cc // indirect
+	golang.org/x/crypto v0.0.0-20220622213112-05595931fe9d // indirect
 )
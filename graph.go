@@ -0,0 +1,263 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Node labels and edge types of the graph of a dataset
+const (
+	labelDLL       = "DLL"
+	labelEXE       = "EXE"
+	labelDirectory = "Directory"
+
+	edgeImports      = "IMPORTS"
+	edgeDelayImports = "DELAY_IMPORTS"
+	edgeForwards     = "FORWARDS"
+	edgeContains     = "CONTAINS"
+)
+
+// graphNode is a PE, a directory, an API set, or a module that is
+// imported but not in the dataset, which is Missing
+type graphNode struct {
+	ID      string
+	Label   string
+	Name    string
+	Path    string
+	Dir     string
+	Class   string
+	ImpHash string
+	Exports []string
+	Signer  string
+	Missing bool
+	APISet  bool
+
+	// Report is nil for missing modules and API sets
+	Report *Report
+}
+
+// graphEdge is a directory containing a PE, or a PE importing from, or
+// forwarding exports to, another. Functions are those imported or
+// forwarded
+type graphEdge struct {
+	From      *graphNode
+	To        *graphNode
+	Type      string
+	Functions []string
+}
+
+// graph is the PEs of a dataset, their directories and the modules they
+// import, in the order they were first seen
+type graph struct {
+	nodes  []*graphNode
+	edges  []*graphEdge
+	byID   map[string]*graphNode
+	byEnds map[graphEnds]*graphEdge
+}
+
+// graphEnds identifies an edge by its type and ends
+type graphEnds struct {
+	from *graphNode
+	to   *graphNode
+	kind string
+}
+
+// newGraph builds the graph of a dataset. Imported modules are resolved
+// to the PEs of the dataset as moduleIndex.resolve does
+func newGraph(reports []*Report) *graph {
+	g := &graph{byID: make(map[string]*graphNode), byEnds: make(map[graphEnds]*graphEdge)}
+	index := newModuleIndex(reports)
+
+	for _, report := range reports {
+		if report.Type == "directory" {
+			g.directory(report.Path).Report = report
+		}
+	}
+	for _, report := range reports {
+		if report.Type != "file" {
+			continue
+		}
+		node := g.module(report)
+		g.edge(g.directory(report.Dir), node, edgeContains, nil)
+
+		links := []struct {
+			kind      string
+			functions []PEFunction
+		}{
+			{edgeImports, report.Imports},
+			{edgeDelayImports, report.DelayImports},
+			{edgeForwards, report.Forwards},
+		}
+		for _, link := range links {
+			// hosts come from a map, so are sorted for stable output
+			hosts := append([]PEFunction{}, link.functions...)
+			sort.Slice(hosts, func(i, j int) bool { return hosts[i].Host < hosts[j].Host })
+			for _, host := range hosts {
+				var to *graphNode
+				if isAPISet(host.Host) {
+					to = g.apiSet(host.Host)
+				} else if found := index.resolve(report, host.Host); found != nil {
+					to = g.module(found)
+				} else {
+					to = g.missing(host.Host)
+				}
+				g.edge(node, to, link.kind, host.Functions)
+			}
+		}
+	}
+	return g
+}
+
+func (g *graph) add(node *graphNode) *graphNode {
+	if found, ok := g.byID[node.ID]; ok {
+		return found
+	}
+	g.nodes = append(g.nodes, node)
+	g.byID[node.ID] = node
+	return node
+}
+
+// module returns the node of a PE, keyed by its path
+func (g *graph) module(report *Report) *graphNode {
+	return g.add(&graphNode{
+		ID:      strings.ToLower(report.Path),
+		Label:   moduleLabel(report),
+		Name:    report.Name,
+		Path:    report.Path,
+		Dir:     report.Dir,
+		Class:   report.Class,
+		ImpHash: report.ImpHash,
+		Exports: report.Exports,
+		Signer:  report.Signer,
+		Report:  report,
+	})
+}
+
+// missing returns the node of a module not in the dataset, keyed by its
+// name
+func (g *graph) missing(host string) *graphNode {
+	return g.add(&graphNode{
+		ID:      strings.ToLower(host),
+		Label:   labelDLL,
+		Name:    host,
+		Missing: true,
+	})
+}
+
+// apiSet returns the node of an API set contract, keyed by its name. It
+// is not resolved to the DLL that hosts it
+func (g *graph) apiSet(host string) *graphNode {
+	return g.add(&graphNode{
+		ID:     strings.ToLower(host),
+		Label:  labelDLL,
+		Name:   host,
+		APISet: true,
+	})
+}
+
+// directory returns the node of a directory, keyed by its path
+func (g *graph) directory(path string) *graphNode {
+	return g.add(&graphNode{
+		ID:    strings.ToLower(path),
+		Label: labelDirectory,
+		Name:  baseName(path),
+		Path:  path,
+	})
+}
+
+// edge adds an edge, merging the functions of edges of the same type
+// between the same nodes, as from a module imported by two names that
+// resolve to the same PE
+func (g *graph) edge(from, to *graphNode, kind string, functions []string) {
+	ends := graphEnds{from, to, kind}
+	if e, ok := g.byEnds[ends]; ok {
+		e.Functions = append(e.Functions, functions...)
+		return
+	}
+	e := &graphEdge{From: from, To: to, Type: kind, Functions: append([]string(nil), functions...)}
+	g.edges = append(g.edges, e)
+	g.byEnds[ends] = e
+}
+
+func graphMain(args []string) error {
+	flags := flag.NewFlagSet("graph", flag.ExitOnError)
+	newSelector := selectorFlags(flags)
+	format := flags.String("format", "cypher", "Output format: [neo4j-csv|cypher|graphml|dot|opengraph]")
+	out := flags.String("out", "", "Directory to write the CSV files of neo4j-csv to")
+	from := flags.String("from", "", "Only graph what this PE, a path or name, imports and forwards to")
+	depth := flags.Int("depth", -1, "Use with -from. Follow at most this many imports and forwards")
+	collapse := flags.Bool("collapse", false, "For graphml and dot, draw one edge weighted by the number of functions,\nrather than an edge for each")
+	colorBy := flags.String("color", colorByType, "For graphml and dot, colour nodes by [type|missing|signer]")
+	domain := flags.String("domain", "", "For opengraph, prefix well-known SIDs with this domain, as BloodHound does")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: ino graph [options] <dataset|path>...\n\n")
+		fmt.Fprintf(flags.Output(), "Print the graph of PEs, the directories holding them and the modules they\n")
+		fmt.Fprintf(flags.Output(), "import and forward to, for Neo4j, BloodHound, Gephi or Graphviz. Each\n")
+		fmt.Fprintf(flags.Output(), "argument is a dataset printed by ino as JSON, - for one on stdin, or a file\n")
+		fmt.Fprintf(flags.Output(), "or directory to scan\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	sel, err := newSelector()
+	if err != nil {
+		fmt.Fprintln(flags.Output(), err)
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	reports, err := loadReports(flags.Args(), sel)
+	if err != nil {
+		return err
+	}
+	g := newGraph(reports)
+	if *from != "" {
+		root := g.find(*from)
+		if root == nil {
+			if abs, err := filepath.Abs(*from); err == nil {
+				root = g.find(abs)
+			}
+		}
+		if root == nil {
+			return fmt.Errorf("%s is not in the graph", *from)
+		}
+		g = g.within(root, *depth)
+	}
+	color, err := nodeColors(g, *colorBy)
+	if err != nil {
+		fmt.Fprintln(flags.Output(), err)
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	switch *format {
+	case "neo4j-csv":
+		if *out == "" {
+			fmt.Fprintln(flags.Output(), "neo4j-csv needs -out")
+			flags.Usage()
+			os.Exit(2)
+		}
+		return writeNeo4jCSV(g, *out)
+	case "cypher":
+		return writeCypher(g, os.Stdout)
+	case "graphml":
+		return writeGraphML(g, os.Stdout, color, *collapse)
+	case "dot":
+		return writeDOT(g, os.Stdout, color, *collapse)
+	case "opengraph":
+		return writeOpenGraph(g, os.Stdout, *domain)
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+	return nil
+}
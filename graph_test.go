@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// peReport is the report of a PE at a Windows path, importing from hosts
+func peReport(path string, imports ...PEFunction) *Report {
+	dir := path[:strings.LastIndex(path, `\`)]
+	class := classDLL
+	if strings.HasSuffix(path, ".exe") {
+		class = "exe"
+	}
+	return &Report{Type: "file", Name: baseName(path), Path: path, Dir: dir, Class: class, Imports: imports}
+}
+
+func imports(host string, functions ...string) PEFunction {
+	return PEFunction{Host: host, Functions: functions}
+}
+
+// edgeStrings lists the edges of a graph as from -TYPE-> to [functions]
+func edgeStrings(g *graph) []string {
+	var edges []string
+	for _, e := range g.edges {
+		edges = append(edges, e.From.ID+" -"+e.Type+"-> "+e.To.ID+" "+strings.Join(e.Functions, ","))
+	}
+	return edges
+}
+
+func TestNewGraph(t *testing.T) {
+	tests := []struct {
+		name    string
+		reports []*Report
+		want    []string
+	}{
+		{"own directory first", []*Report{
+			peReport(`c:\app\app.exe`, imports("foo.dll", "Foo")),
+			peReport(`c:\windows\system32\foo.dll`),
+			peReport(`c:\app\foo.dll`),
+		}, []string{
+			`c:\app -CONTAINS-> c:\app\app.exe `,
+			`c:\app\app.exe -IMPORTS-> c:\app\foo.dll Foo`,
+			`c:\windows\system32 -CONTAINS-> c:\windows\system32\foo.dll `,
+			`c:\app -CONTAINS-> c:\app\foo.dll `,
+		}},
+		{"then system32", []*Report{
+			peReport(`c:\app\app.exe`, imports("FOO.DLL", "Foo")),
+			peReport(`c:\other\foo.dll`),
+			peReport(`c:\windows\system32\foo.dll`),
+		}, []string{
+			`c:\app -CONTAINS-> c:\app\app.exe `,
+			`c:\app\app.exe -IMPORTS-> c:\windows\system32\foo.dll Foo`,
+			`c:\other -CONTAINS-> c:\other\foo.dll `,
+			`c:\windows\system32 -CONTAINS-> c:\windows\system32\foo.dll `,
+		}},
+		{"then the first by path", []*Report{
+			peReport(`c:\app\app.exe`, imports("foo.dll", "Foo")),
+			peReport(`c:\z\foo.dll`),
+			peReport(`c:\b\foo.dll`),
+		}, []string{
+			`c:\app -CONTAINS-> c:\app\app.exe `,
+			`c:\app\app.exe -IMPORTS-> c:\b\foo.dll Foo`,
+			`c:\z -CONTAINS-> c:\z\foo.dll `,
+			`c:\b -CONTAINS-> c:\b\foo.dll `,
+		}},
+		{"missing modules", []*Report{
+			peReport(`c:\app\app.exe`, imports("Missing.dll", "Gone")),
+			peReport(`c:\app\b.exe`, imports("missing.DLL", "Lost")),
+		}, []string{
+			`c:\app -CONTAINS-> c:\app\app.exe `,
+			`c:\app\app.exe -IMPORTS-> missing.dll Gone`,
+			`c:\app -CONTAINS-> c:\app\b.exe `,
+			`c:\app\b.exe -IMPORTS-> missing.dll Lost`,
+		}},
+		// API sets are never resolved to a file, even one of their name
+		{"API sets", []*Report{
+			peReport(`c:\app\app.exe`, imports("api-ms-win-core-file-l1-1-0.dll", "CreateFileW"), imports("EXT-MS-WIN-GDI-DRAW-L1-1-0.DLL", "BitBlt")),
+			peReport(`c:\app\api-ms-win-core-file-l1-1-0.dll`),
+		}, []string{
+			`c:\app -CONTAINS-> c:\app\app.exe `,
+			`c:\app\app.exe -IMPORTS-> ext-ms-win-gdi-draw-l1-1-0.dll BitBlt`,
+			`c:\app\app.exe -IMPORTS-> api-ms-win-core-file-l1-1-0.dll CreateFileW`,
+			`c:\app -CONTAINS-> c:\app\api-ms-win-core-file-l1-1-0.dll `,
+		}},
+		// hosts are sorted, so FOO.dll's functions come first
+		{"edges to the same module are merged", []*Report{
+			peReport(`c:\app\app.exe`, imports("foo.dll", "A"), imports("FOO.dll", "B")),
+			peReport(`c:\app\foo.dll`),
+		}, []string{
+			`c:\app -CONTAINS-> c:\app\app.exe `,
+			`c:\app\app.exe -IMPORTS-> c:\app\foo.dll B,A`,
+			`c:\app -CONTAINS-> c:\app\foo.dll `,
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g := newGraph(test.reports)
+			if got := edgeStrings(g); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got edges\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+			}
+			for _, node := range g.nodes {
+				if node.Label == labelDirectory {
+					continue
+				}
+				if node.Missing || node.APISet {
+					if node.Missing == node.APISet || node.Report != nil || node.Label != labelDLL {
+						t.Errorf("node %s is Missing %t and APISet %t with report %v and label %s", node.ID, node.Missing, node.APISet, node.Report, node.Label)
+					}
+				} else if node.Report == nil {
+					t.Errorf("node %s has no report", node.ID)
+				}
+				if node.Report == nil && node.APISet != isAPISet(node.Name) {
+					t.Errorf("node %s is APISet %t", node.ID, node.APISet)
+				}
+			}
+		})
+	}
+
+	t.Run("directory reports", func(t *testing.T) {
+		dir := &Report{Type: "directory", Name: "app", Path: `c:\app`}
+		g := newGraph([]*Report{peReport(`c:\app\app.exe`), dir})
+		if node := g.byID[`c:\app`]; node == nil || node.Report != dir {
+			t.Errorf("directory node %+v does not hold its report", node)
+		}
+	})
+}
+
+func TestWriteCypher(t *testing.T) {
+	path := `c:\o'brien\app.exe`
+	report := peReport(path, imports("it's.dll", `a\b`, "c'd"))
+	report.Exports = []string{"Run"}
+
+	var b bytes.Buffer
+	if err := writeCypher(newGraph([]*Report{report}), &b); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`MERGE (n:EXE {id: 'c:\\o\'brien\\app.exe'}) SET n.name = 'app.exe', n.path = 'c:\\o\'brien\\app.exe', n.dir = 'c:\\o\'brien', n.class = 'exe', n.imphash = '', n.exports = ['Run'], n.signer = '', n.missing = false, n.apiset = false;`,
+		`MERGE (n:Directory {id: 'c:\\o\'brien'}) SET n.name = 'o\'brien', n.path = 'c:\\o\'brien';`,
+		`MERGE (n:DLL {id: 'it\'s.dll'}) SET n.name = 'it\'s.dll', n.path = '', n.dir = '', n.class = '', n.imphash = '', n.exports = [], n.signer = '', n.missing = true, n.apiset = false;`,
+		`MATCH (a:EXE {id: 'c:\\o\'brien\\app.exe'}), (b:DLL {id: 'it\'s.dll'}) MERGE (a)-[r:IMPORTS]->(b) SET r.functions = ['a\\b', 'c\'d'], r.count = 2;`,
+		`MATCH (a:Directory {id: 'c:\\o\'brien'}), (b:EXE {id: 'c:\\o\'brien\\app.exe'}) MERGE (a)-[r:CONTAINS]->(b);`,
+	} {
+		if !strings.Contains(b.String(), want+"\n") {
+			t.Errorf("missing statement %s in\n%s", want, b.String())
+		}
+	}
+}
+
+func TestWriteNeo4jCSV(t *testing.T) {
+	app := peReport(`c:\app\app.exe`, imports("foo.dll", "A", "B"), imports("gone.dll", "C"))
+	foo := peReport(`c:\app\foo.dll`)
+	foo.Exports = []string{"A", "?Run@@YAXXZ"}
+	foo.Forwards = []PEFunction{imports("bar.dll", "D")}
+
+	dir := t.TempDir()
+	stderr := os.Stderr
+	os.Stderr, _ = os.Open(os.DevNull)
+	err := writeNeo4jCSV(newGraph([]*Report{app, foo}), dir)
+	os.Stderr.Close()
+	os.Stderr = stderr
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(name string) [][]string {
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		records, err := csv.NewReader(f).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return records
+	}
+	files := map[string][][]string{
+		"dll.csv": {
+			{"id:ID", ":LABEL", "name", "path", "dir", "class", "imphash", "exports:string[]", "signer", "missing:boolean", "apiset:boolean"},
+			{`c:\app\foo.dll`, "DLL", "foo.dll", `c:\app\foo.dll`, `c:\app`, "dll", "", "A;?Run@@YAXXZ", "", "false", "false"},
+			{"gone.dll", "DLL", "gone.dll", "", "", "", "", "", "", "true", "false"},
+			{"bar.dll", "DLL", "bar.dll", "", "", "", "", "", "", "true", "false"},
+		},
+		"exe.csv": {
+			{"id:ID", ":LABEL", "name", "path", "dir", "class", "imphash", "exports:string[]", "signer", "missing:boolean", "apiset:boolean"},
+			{`c:\app\app.exe`, "EXE", "app.exe", `c:\app\app.exe`, `c:\app`, "exe", "", "", "", "false", "false"},
+		},
+		"directory.csv": {
+			{"id:ID", ":LABEL", "name", "path"},
+			{`c:\app`, "Directory", "app", `c:\app`},
+		},
+		"imports.csv": {
+			{":START_ID", ":END_ID", ":TYPE", "functions:string[]", "count:int"},
+			{`c:\app\app.exe`, `c:\app\foo.dll`, "IMPORTS", "A;B", "2"},
+			{`c:\app\app.exe`, "gone.dll", "IMPORTS", "C", "1"},
+		},
+		"delay_imports.csv": {
+			{":START_ID", ":END_ID", ":TYPE", "functions:string[]", "count:int"},
+		},
+		"forwards.csv": {
+			{":START_ID", ":END_ID", ":TYPE", "functions:string[]", "count:int"},
+			{`c:\app\foo.dll`, "bar.dll", "FORWARDS", "D", "1"},
+		},
+		"contains.csv": {
+			{":START_ID", ":END_ID", ":TYPE"},
+			{`c:\app`, `c:\app\app.exe`, "CONTAINS"},
+			{`c:\app`, `c:\app\foo.dll`, "CONTAINS"},
+		},
+	}
+	for name, want := range files {
+		if got := read(name); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s holds\n%q\nwant\n%q", name, got, want)
+		}
+	}
+}
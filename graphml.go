@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Node colourings of GraphML and DOT graphs
+const (
+	colorByType    = "type"
+	colorByMissing = "missing"
+	colorBySigner  = "signer"
+)
+
+// Colours of nodes, by label, by whether they are missing, and by signer
+var (
+	labelColors = map[string]string{
+		labelDLL: "#8ecae6",
+		labelEXE: "#ffb703",
+	}
+	missingColor  = "#e63946"
+	presentColor  = "#90be6d"
+	unsignedColor = "#d3d3d3"
+	signerColors  = []string{"#8ecae6", "#ffb703", "#90be6d", "#cdb4db", "#f4a261", "#2a9d8f", "#e9c46a", "#bde0fe"}
+)
+
+// nodeColors returns the colour of each node of a graph. Signers are given
+// colours in the order they are first seen, repeating when there are more
+// signers than colours
+func nodeColors(g *graph, by string) (func(node *graphNode) string, error) {
+	switch by {
+	case colorByType:
+		return func(node *graphNode) string { return labelColors[node.Label] }, nil
+	case colorByMissing:
+		return func(node *graphNode) string {
+			if node.Missing {
+				return missingColor
+			}
+			return presentColor
+		}, nil
+	case colorBySigner:
+		signers := make(map[string]string)
+		for _, node := range g.nodes {
+			if _, ok := signers[node.Signer]; node.Signer != "" && !ok {
+				signers[node.Signer] = signerColors[len(signers)%len(signerColors)]
+			}
+		}
+		return func(node *graphNode) string {
+			if color, ok := signers[node.Signer]; ok {
+				return color
+			}
+			return unsignedColor
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown colouring %q", by)
+}
+
+// within returns the part of a graph that a module reaches through its
+// imports and forwards, up to depth edges away, or any distance when
+// depth is negative, with the edges followed from modules short of that
+// depth. The directories of the modules reached are kept
+func (g *graph) within(root *graphNode, depth int) *graph {
+	out := make(map[*graphNode][]*graphEdge)
+	for _, e := range g.edges {
+		if e.Type != edgeContains {
+			out[e.From] = append(out[e.From], e)
+		}
+	}
+
+	reached := map[*graphNode]int{root: 0}
+	queue := []*graphNode{root}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		if depth >= 0 && reached[node] >= depth {
+			continue
+		}
+		for _, e := range out[node] {
+			if _, ok := reached[e.To]; !ok {
+				reached[e.To] = reached[node] + 1
+				queue = append(queue, e.To)
+			}
+		}
+	}
+
+	kept := func(node *graphNode) bool {
+		_, ok := reached[node]
+		return ok
+	}
+	sub := &graph{byID: make(map[string]*graphNode), byEnds: make(map[graphEnds]*graphEdge)}
+	for _, e := range g.edges {
+		if e.Type == edgeContains && kept(e.To) {
+			sub.add(e.From)
+		}
+	}
+	for _, node := range g.nodes {
+		if kept(node) {
+			sub.add(node)
+		}
+	}
+	for _, e := range g.edges {
+		switch {
+		case e.Type == edgeContains && kept(e.To),
+			kept(e.From) && kept(e.To) && (depth < 0 || reached[e.From] < depth):
+			sub.edges = append(sub.edges, e)
+		}
+	}
+	return sub
+}
+
+// find returns the module of a graph at a path, or else the first with
+// a name, ignoring case
+func (g *graph) find(name string) *graphNode {
+	if node, ok := g.byID[strings.ToLower(name)]; ok && node.Label != labelDirectory {
+		return node
+	}
+	for _, node := range g.nodes {
+		if node.Label != labelDirectory && strings.EqualFold(node.Name, name) {
+			return node
+		}
+	}
+	return nil
+}
+
+// modules returns the nodes and edges of a graph without its directories
+func (g *graph) modules() ([]*graphNode, []*graphEdge) {
+	var nodes []*graphNode
+	var edges []*graphEdge
+	for _, node := range g.nodes {
+		if node.Label != labelDirectory {
+			nodes = append(nodes, node)
+		}
+	}
+	for _, e := range g.edges {
+		if e.Type != edgeContains {
+			edges = append(edges, e)
+		}
+	}
+	return nodes, edges
+}
+
+// graphMLKeys are the attributes of GraphML nodes and edges. r, g and b
+// are the colour as Gephi reads it
+var graphMLKeys = []struct{ id, kind, typ string }{
+	{"label", "node", "string"},
+	{"type", "node", "string"},
+	{"path", "node", "string"},
+	{"class", "node", "string"},
+	{"signer", "node", "string"},
+	{"missing", "node", "boolean"},
+	{"apiset", "node", "boolean"},
+	{"color", "node", "string"},
+	{"r", "node", "int"},
+	{"g", "node", "int"},
+	{"b", "node", "int"},
+	{"relation", "edge", "string"},
+	{"function", "edge", "string"},
+	{"weight", "edge", "int"},
+}
+
+// writeGraphML prints the modules of a graph and the edges between them as
+// GraphML. Each function is an edge of its own, unless collapse makes
+// them a single edge weighted by their count
+func writeGraphML(g *graph, w io.Writer, color func(*graphNode) string, collapse bool) error {
+	out := bufio.NewWriter(w)
+	fmt.Fprintln(out, `<?xml version="1.0" encoding="UTF-8"?>`)
+	fmt.Fprintln(out, `<graphml xmlns="http://graphml.graphdrawing.org/xmlns">`)
+	for _, key := range graphMLKeys {
+		fmt.Fprintf(out, "  <key id=%q for=%q attr.name=%q attr.type=%q/>\n", key.id, key.kind, key.id, key.typ)
+	}
+	fmt.Fprintln(out, `  <graph id="ino" edgedefault="directed">`)
+
+	nodes, edges := g.modules()
+	ids := make(map[*graphNode]string)
+	for i, node := range nodes {
+		ids[node] = "n" + strconv.Itoa(i)
+		fmt.Fprintf(out, "    <node id=%q>\n", ids[node])
+		graphMLData(out, "label", node.Name)
+		graphMLData(out, "type", node.Label)
+		graphMLData(out, "path", node.Path)
+		graphMLData(out, "class", node.Class)
+		graphMLData(out, "signer", node.Signer)
+		graphMLData(out, "missing", strconv.FormatBool(node.Missing))
+		graphMLData(out, "apiset", strconv.FormatBool(node.APISet))
+		c := color(node)
+		graphMLData(out, "color", c)
+		var r, gr, b int
+		fmt.Sscanf(c, "#%02x%02x%02x", &r, &gr, &b)
+		graphMLData(out, "r", strconv.Itoa(r))
+		graphMLData(out, "g", strconv.Itoa(gr))
+		graphMLData(out, "b", strconv.Itoa(b))
+		fmt.Fprintln(out, "    </node>")
+	}
+
+	edge := func(e *graphEdge, function string, weight int) {
+		fmt.Fprintf(out, "    <edge source=%q target=%q>\n", ids[e.From], ids[e.To])
+		graphMLData(out, "relation", e.Type)
+		graphMLData(out, "function", function)
+		graphMLData(out, "weight", strconv.Itoa(weight))
+		fmt.Fprintln(out, "    </edge>")
+	}
+	for _, e := range edges {
+		if collapse {
+			edge(e, "", len(e.Functions))
+			continue
+		}
+		for _, function := range e.Functions {
+			edge(e, function, 1)
+		}
+	}
+
+	fmt.Fprintln(out, "  </graph>")
+	fmt.Fprintln(out, "</graphml>")
+	return out.Flush()
+}
+
+func graphMLData(w io.Writer, key, value string) {
+	if value == "" {
+		return
+	}
+	fmt.Fprintf(w, "      <data key=%q>", key)
+	xml.EscapeText(w, []byte(value))
+	fmt.Fprintln(w, "</data>")
+}
+
+// dotStyles draw delay imports dashed and forwards dotted
+var dotStyles = map[string]string{
+	edgeImports:      "solid",
+	edgeDelayImports: "dashed",
+	edgeForwards:     "dotted",
+}
+
+// writeDOT prints the modules of a graph and the edges between them as a
+// Graphviz digraph, with the same edges as writeGraphML
+func writeDOT(g *graph, w io.Writer, color func(*graphNode) string, collapse bool) error {
+	out := bufio.NewWriter(w)
+	fmt.Fprintln(out, "digraph ino {")
+	fmt.Fprintln(out, "  node [shape=box, style=filled];")
+
+	nodes, edges := g.modules()
+	ids := make(map[*graphNode]string)
+	for i, node := range nodes {
+		ids[node] = "n" + strconv.Itoa(i)
+		tooltip := node.Path
+		if node.Missing {
+			tooltip = "missing"
+		} else if node.APISet {
+			tooltip = "API set"
+		}
+		fmt.Fprintf(out, "  %s [label=%s, fillcolor=%s, tooltip=%s];\n", ids[node], dotString(node.Name), dotString(color(node)), dotString(tooltip))
+	}
+
+	for _, e := range edges {
+		if collapse {
+			count := len(e.Functions)
+			fmt.Fprintf(out, "  %s -> %s [style=%s, label=%d, weight=%d];\n", ids[e.From], ids[e.To], dotStyles[e.Type], count, count)
+			continue
+		}
+		for _, function := range e.Functions {
+			fmt.Fprintf(out, "  %s -> %s [style=%s, label=%s];\n", ids[e.From], ids[e.To], dotStyles[e.Type], dotString(function))
+		}
+	}
+
+	fmt.Fprintln(out, "}")
+	return out.Flush()
+}
+
+// dotString quotes a string as a DOT ID
+func dotString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
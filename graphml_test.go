@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// chainGraph is app.exe importing a.dll, importing b.dll, importing c.dll,
+// which imports a.dll back
+func chainGraph() *graph {
+	return newGraph([]*Report{
+		peReport(`c:\app\app.exe`, imports("a.dll", "A")),
+		peReport(`c:\app\a.dll`, imports("b.dll", "B")),
+		peReport(`c:\lib\b.dll`, imports("c.dll", "C")),
+		peReport(`c:\lib\c.dll`, imports("a.dll", "A")),
+	})
+}
+
+func TestGraphWithin(t *testing.T) {
+	tests := []struct {
+		depth int
+		want  []string
+	}{
+		{0, []string{
+			`c:\app -CONTAINS-> c:\app\app.exe `,
+		}},
+		{1, []string{
+			`c:\app -CONTAINS-> c:\app\app.exe `,
+			`c:\app\app.exe -IMPORTS-> c:\app\a.dll A`,
+			`c:\app -CONTAINS-> c:\app\a.dll `,
+		}},
+		{2, []string{
+			`c:\app -CONTAINS-> c:\app\app.exe `,
+			`c:\app\app.exe -IMPORTS-> c:\app\a.dll A`,
+			`c:\app -CONTAINS-> c:\app\a.dll `,
+			`c:\app\a.dll -IMPORTS-> c:\lib\b.dll B`,
+			`c:\lib -CONTAINS-> c:\lib\b.dll `,
+		}},
+		{-1, []string{
+			`c:\app -CONTAINS-> c:\app\app.exe `,
+			`c:\app\app.exe -IMPORTS-> c:\app\a.dll A`,
+			`c:\app -CONTAINS-> c:\app\a.dll `,
+			`c:\app\a.dll -IMPORTS-> c:\lib\b.dll B`,
+			`c:\lib -CONTAINS-> c:\lib\b.dll `,
+			`c:\lib\b.dll -IMPORTS-> c:\lib\c.dll C`,
+			`c:\lib -CONTAINS-> c:\lib\c.dll `,
+			`c:\lib\c.dll -IMPORTS-> c:\app\a.dll A`,
+		}},
+	}
+	for _, test := range tests {
+		g := chainGraph()
+		sub := g.within(g.find("APP.EXE"), test.depth)
+		if got := edgeStrings(sub); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("depth %d: got edges\n%s\nwant\n%s", test.depth, strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+		}
+	}
+
+	g := chainGraph()
+	if node := g.find(`C:\LIB\B.DLL`); node == nil || node.Path != `c:\lib\b.dll` {
+		t.Errorf("found %v by path", node)
+	}
+	if node := g.find(`c:\lib`); node != nil {
+		t.Errorf("found directory %s as a module", node.ID)
+	}
+}
+
+func TestWriteDOT(t *testing.T) {
+	report := peReport(`c:\app\"quoted"\app.exe`, imports("foo.dll", `a\b`, `c"d`))
+	g := newGraph([]*Report{report})
+	color, err := nodeColors(g, colorByMissing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	if err := writeDOT(g, &b, color, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`  n0 [label="app.exe", fillcolor="#90be6d", tooltip="c:\\app\\\"quoted\"\\app.exe"];`,
+		`  n1 [label="foo.dll", fillcolor="#e63946", tooltip="missing"];`,
+		`  n0 -> n1 [style=solid, label="a\\b"];`,
+		`  n0 -> n1 [style=solid, label="c\"d"];`,
+	} {
+		if !strings.Contains(b.String(), want+"\n") {
+			t.Errorf("missing %s in\n%s", want, b.String())
+		}
+	}
+
+	b.Reset()
+	if err := writeDOT(g, &b, color, true); err != nil {
+		t.Fatal(err)
+	}
+	if want := "  n0 -> n1 [style=solid, label=2, weight=2];\n"; !strings.Contains(b.String(), want) {
+		t.Errorf("missing collapsed edge %s in\n%s", want, b.String())
+	}
+}
+
+func TestWriteGraphML(t *testing.T) {
+	report := peReport(`c:\app\<&>\app.exe`, imports("foo.dll", "A<B>", "C&D"))
+	report.Signer = "Contoso & Co"
+	g := newGraph([]*Report{report})
+	color, err := nodeColors(g, colorByType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, collapse := range []bool{false, true} {
+		var b bytes.Buffer
+		if err := writeGraphML(g, &b, color, collapse); err != nil {
+			t.Fatal(err)
+		}
+
+		var doc struct {
+			Nodes []struct {
+				ID   string `xml:"id,attr"`
+				Data []struct {
+					Key   string `xml:"key,attr"`
+					Value string `xml:",chardata"`
+				} `xml:"data"`
+			} `xml:"graph>node"`
+			Edges []struct {
+				Data []struct {
+					Key   string `xml:"key,attr"`
+					Value string `xml:",chardata"`
+				} `xml:"data"`
+			} `xml:"graph>edge"`
+		}
+		if err := xml.Unmarshal(b.Bytes(), &doc); err != nil {
+			t.Fatalf("%s: %s", err, b.Bytes())
+		}
+		if len(doc.Nodes) != 2 {
+			t.Fatalf("got %d nodes, want app.exe and foo.dll", len(doc.Nodes))
+		}
+		data := make(map[string]string)
+		for _, d := range doc.Nodes[0].Data {
+			data[d.Key] = d.Value
+		}
+		if data["path"] != report.Path || data["signer"] != report.Signer || data["r"] != "255" {
+			t.Errorf("app.exe has data %v", data)
+		}
+
+		var edges []string
+		for _, e := range doc.Edges {
+			var edge []string
+			for _, d := range e.Data {
+				edge = append(edge, d.Key+"="+d.Value)
+			}
+			edges = append(edges, strings.Join(edge, " "))
+		}
+		want := []string{"relation=IMPORTS function=A<B> weight=1", "relation=IMPORTS function=C&D weight=1"}
+		if collapse {
+			want = []string{"relation=IMPORTS weight=2"}
+		}
+		if !reflect.DeepEqual(edges, want) {
+			t.Errorf("collapse %t: got edges %q, want %q", collapse, edges, want)
+		}
+	}
+}
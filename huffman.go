@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"sort"
+)
+
+// huffmanMaxLength is the longest codeword of the XPRESS and LZMS codes
+const huffmanMaxLength = 15
+
+// huffmanTableBits is the number of leading bits resolved by table lookup
+const huffmanTableBits = 9
+
+var errBadCodeword = errors.New("invalid Huffman codeword")
+
+// huffmanCode decodes a canonical Huffman code, in which codewords are
+// assigned in order of length and then of symbol. Short codewords are
+// looked up in a table and longer ones are found from the first
+// codeword of each length
+type huffmanCode struct {
+	table      [1 << huffmanTableBits]uint16
+	counts     [huffmanMaxLength + 1]uint32
+	firstCode  [huffmanMaxLength + 1]uint32
+	firstIndex [huffmanMaxLength + 1]uint32
+	symbols    []uint16
+}
+
+// build sets the code from the codeword length of each symbol, where a
+// length of zero means the symbol is unused
+func (h *huffmanCode) build(lengths []uint8) {
+	h.counts = [huffmanMaxLength + 1]uint32{}
+	h.symbols = h.symbols[:0]
+	for _, length := range lengths {
+		h.counts[length]++
+	}
+	h.counts[0] = 0
+
+	index := uint32(0)
+	code := uint32(0)
+	for length := 1; length <= huffmanMaxLength; length++ {
+		code = (code + h.counts[length-1]) << 1
+		h.firstCode[length] = code
+		h.firstIndex[length] = index
+		index += h.counts[length]
+	}
+	h.symbols = append(h.symbols, make([]uint16, index)...)
+
+	next := h.firstIndex
+	h.table = [1 << huffmanTableBits]uint16{}
+	for sym, length := range lengths {
+		if length == 0 {
+			continue
+		}
+		h.symbols[next[length]] = uint16(sym)
+		if length <= huffmanTableBits {
+			codeword := h.firstCode[length] + next[length] - h.firstIndex[length]
+			start := codeword << (huffmanTableBits - length)
+			for i := start; i < start+1<<(huffmanTableBits-length) && i < uint32(len(h.table)); i++ {
+				h.table[i] = uint16(sym)<<4 | uint16(length)
+			}
+		}
+		next[length]++
+	}
+}
+
+// decode returns the symbol at the start of bits, the next 15 bits of
+// input with the first in the most significant place, and the length of
+// its codeword
+func (h *huffmanCode) decode(bits uint32) (int, uint, error) {
+	if entry := h.table[bits>>(huffmanMaxLength-huffmanTableBits)]; entry != 0 {
+		return int(entry >> 4), uint(entry & 0xF), nil
+	}
+	for length := huffmanTableBits + 1; length <= huffmanMaxLength; length++ {
+		offset := bits>>(huffmanMaxLength-length) - h.firstCode[length]
+		if offset < h.counts[length] {
+			return int(h.symbols[h.firstIndex[length]+offset]), uint(length), nil
+		}
+	}
+	return 0, 0, errBadCodeword
+}
+
+// canonicalLengths computes length limited Huffman codeword lengths from
+// symbol frequencies. LZMS codes are not transmitted, so the decoder must
+// rebuild them exactly as the compressor does: the tree is built over
+// the symbols sorted by frequency and then by symbol, preferring leaves
+// on ties, and codewords too long are shortened by taking the longest
+// length still available
+func canonicalLengths(freqs []uint32, maxLength int, lengths []uint8) {
+	const symbolBits = 10
+	const symbolMask = 1<<symbolBits - 1
+
+	n := len(freqs)
+	if n < 2 {
+		for sym := range lengths {
+			lengths[sym] = 1
+		}
+		return
+	}
+	a := make([]uint32, n)
+	for sym, freq := range freqs {
+		a[sym] = freq<<symbolBits | uint32(sym)
+	}
+	sort.Slice(a, func(i, j int) bool { return a[i] < a[j] })
+
+	// parentless non-leaves are found at b, and allocated at e, in the
+	// same array as the sorted leaves
+	i, b, e := 0, 0, 0
+	for n-e > 1 {
+		var m, k int
+		if i != n && (b == e || a[i]>>symbolBits <= a[b]>>symbolBits) {
+			m, i = i, i+1
+		} else {
+			m, b = b, b+1
+		}
+		if i != n && (b == e || a[i]>>symbolBits <= a[b]>>symbolBits) {
+			k, i = i, i+1
+		} else {
+			k, b = b, b+1
+		}
+		freq := a[m]&^symbolMask + a[k]&^symbolMask
+		a[m] = a[m]&symbolMask | uint32(e)<<symbolBits
+		a[k] = a[k]&symbolMask | uint32(e)<<symbolBits
+		a[e] = a[e]&symbolMask | freq
+		e++
+	}
+
+	// walking the non-leaves from the root, parents before children,
+	// each turns a codeword of its depth into two one longer
+	counts := make([]int, maxLength+1)
+	counts[1] = 2
+	root := n - 2
+	a[root] &= symbolMask
+	for node := root - 1; node >= 0; node-- {
+		parent := a[node] >> symbolBits
+		depth := a[parent]>>symbolBits + 1
+		a[node] = a[node]&symbolMask | depth<<symbolBits
+		length := int(depth)
+		if length >= maxLength {
+			length = maxLength
+			for {
+				length--
+				if counts[length] != 0 {
+					break
+				}
+			}
+		}
+		counts[length]--
+		counts[length+1] += 2
+	}
+
+	// the longest codewords go to the least frequent symbols
+	i = 0
+	for length := maxLength; length >= 1; length-- {
+		for c := 0; c < counts[length]; c++ {
+			lengths[a[i]&symbolMask] = uint8(length)
+			i++
+		}
+	}
+}
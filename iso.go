@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+const isoSectorSize = 2048
+
+// ISO 9660 directory record flags
+const (
+	isoFlagDirectory   = 0x02
+	isoFlagMultiExtent = 0x80
+)
+
+// recogniseVolume reads the volume recognition sequence that follows the
+// system area of an optical disc, which names the file systems on it
+func recogniseVolume(r io.ReaderAt) (iso, udf bool) {
+	id := make([]byte, 6)
+	for sector := int64(16); sector < 16+64; sector++ {
+		_, err := r.ReadAt(id, sector*isoSectorSize)
+		if err != nil {
+			return
+		}
+		switch string(id[1:6]) {
+		case "CD001":
+			iso = true
+		case "NSR02", "NSR03":
+			udf = true
+		case "BEA01", "TEA01", "BOOT2", "CDW02":
+		default:
+			return
+		}
+	}
+	return
+}
+
+// isoFS is a read-only fs.FS over an ISO 9660 image, using the Joliet
+// names of its supplementary volume descriptor when there is one
+type isoFS struct {
+	r      io.ReaderAt
+	root   *isoEntry
+	joliet bool
+}
+
+func newISOFS(r io.ReaderAt, size int64) (*isoFS, error) {
+	var primary, joliet []byte
+	for sector := int64(16); sector*isoSectorSize < size; sector++ {
+		descriptor := make([]byte, isoSectorSize)
+		_, err := r.ReadAt(descriptor, sector*isoSectorSize)
+		if err != nil {
+			return nil, err
+		}
+		if string(descriptor[1:6]) != "CD001" || descriptor[0] == 255 {
+			break
+		}
+		switch descriptor[0] {
+		case 1:
+			primary = descriptor
+		case 2:
+			// Joliet is marked by the escape sequence of a UCS-2 level
+			escape := string(descriptor[88:91])
+			if escape == "%/@" || escape == "%/C" || escape == "%/E" {
+				joliet = descriptor
+			}
+		}
+	}
+
+	iso := &isoFS{r: r}
+	switch {
+	case joliet != nil:
+		iso.joliet = true
+		iso.root = iso.newEntry(joliet[156:190])
+	case primary != nil:
+		iso.root = iso.newEntry(primary[156:190])
+	default:
+		return nil, errors.New("ISO 9660 image has no primary volume descriptor")
+	}
+	iso.root.name = "/"
+	return iso, nil
+}
+
+// isoEntry is a file or directory, from its directory record
+type isoEntry struct {
+	name    string
+	extent  int64
+	size    int64
+	flags   byte
+	modTime time.Time
+}
+
+func (iso *isoFS) newEntry(record []byte) *isoEntry {
+	nameLength := int(record[32])
+	if 33+nameLength > len(record) {
+		nameLength = len(record) - 33
+	}
+	rawName := record[33 : 33+nameLength]
+
+	var name string
+	if iso.joliet {
+		units := make([]uint16, len(rawName)/2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(rawName[i*2:])
+		}
+		name = string(utf16.Decode(units))
+	} else {
+		name = string(rawName)
+	}
+
+	// names carry a version number, and ISO 9660 names without an
+	// extension keep the dot that separates it
+	if i := strings.LastIndexByte(name, ';'); i >= 0 {
+		name = name[:i]
+	}
+	if !iso.joliet {
+		name = strings.TrimSuffix(name, ".")
+	}
+
+	date := record[18:25]
+	offset := time.Duration(int8(date[6])) * 15 * time.Minute
+	return &isoEntry{
+		name:   name,
+		extent: int64(binary.LittleEndian.Uint32(record[2:])),
+		size:   int64(binary.LittleEndian.Uint32(record[10:])),
+		flags:  record[25],
+		modTime: time.Date(1900+int(date[0]), time.Month(date[1]), int(date[2]),
+			int(date[3]), int(date[4]), int(date[5]), 0, time.UTC).Add(-offset),
+	}
+}
+
+// readDir parses the records of a directory. Records do not cross
+// sector boundaries, and the rest of a sector is zero filled. A file
+// larger than 4GB is recorded as several extents of the same name
+func (iso *isoFS) readDir(dir *isoEntry) ([]*isoEntry, error) {
+	data := make([]byte, dir.size)
+	_, err := iso.r.ReadAt(data, dir.extent*isoSectorSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*isoEntry
+	continued := false
+	for i := 0; i < len(data); {
+		length := int(data[i])
+		if length == 0 {
+			i = (i/isoSectorSize + 1) * isoSectorSize
+			continue
+		}
+		if length < 34 || i+length > len(data) {
+			return entries, fmt.Errorf("directory record of %d bytes", length)
+		}
+		record := data[i : i+length]
+		i += length
+
+		// the first two records are the directory itself and its parent
+		if record[32] == 1 && (record[33] == 0 || record[33] == 1) {
+			continue
+		}
+		entry := iso.newEntry(record)
+		if continued {
+			entries[len(entries)-1].size += entry.size
+		} else {
+			entries = append(entries, entry)
+		}
+		continued = entry.flags&isoFlagMultiExtent != 0
+	}
+	return entries, nil
+}
+
+func (iso *isoFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	entry := iso.root
+	if name != "." {
+		for _, component := range strings.Split(name, "/") {
+			if !entry.IsDir() {
+				return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+			}
+			entries, err := iso.readDir(entry)
+			if err != nil {
+				return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+			}
+			var found *isoEntry
+			for _, child := range entries {
+				if strings.EqualFold(child.name, component) {
+					found = child
+					break
+				}
+			}
+			if found == nil {
+				return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+			}
+			entry = found
+		}
+	}
+
+	if entry.IsDir() {
+		return &isoDir{fsys: iso, entry: entry}, nil
+	}
+	return &isoFile{SectionReader: io.NewSectionReader(iso.r, entry.extent*isoSectorSize, entry.size), entry: entry}, nil
+}
+
+func (e *isoEntry) Name() string       { return e.name }
+func (e *isoEntry) ModTime() time.Time { return e.modTime }
+func (e *isoEntry) IsDir() bool        { return e.flags&isoFlagDirectory != 0 }
+func (e *isoEntry) Sys() interface{}   { return nil }
+
+func (e *isoEntry) Size() int64 {
+	if e.IsDir() {
+		return 0
+	}
+	return e.size
+}
+
+func (e *isoEntry) Mode() fs.FileMode {
+	if e.IsDir() {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+type isoFile struct {
+	*io.SectionReader
+	entry *isoEntry
+}
+
+func (f *isoFile) Stat() (fs.FileInfo, error) { return f.entry, nil }
+func (f *isoFile) Close() error               { return nil }
+
+type isoDir struct {
+	fsys    *isoFS
+	entry   *isoEntry
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (d *isoDir) Stat() (fs.FileInfo, error) { return d.entry, nil }
+func (d *isoDir) Close() error               { return nil }
+
+func (d *isoDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.entry.name, Err: errors.New("is a directory")}
+}
+
+func (d *isoDir) ReadDir(count int) ([]fs.DirEntry, error) {
+	if !d.read {
+		children, err := d.fsys.readDir(d.entry)
+		if err != nil {
+			return nil, err
+		}
+		for _, child := range children {
+			d.entries = append(d.entries, fs.FileInfoToDirEntry(child))
+		}
+		sort.Slice(d.entries, func(i, j int) bool {
+			return d.entries[i].Name() < d.entries[j].Name()
+		})
+		d.read = true
+	}
+	return readDirEntries(&d.entries, count)
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"strings"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ControlTypeSDFlags is the LDAP_SERVER_SD_FLAGS_OID control
+//
+// https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-adts/3888c2b7-35b9-45b7-afeb-b772aa932dd0
+const ControlTypeSDFlags = "1.2.840.113556.1.4.801"
+
+// Security information flags understood by the SD_FLAGS control
+const (
+	OwnerSecurityInformation = 0x1
+	GroupSecurityInformation = 0x2
+	DACLSecurityInformation  = 0x4
+	SACLSecurityInformation  = 0x8
+)
+
+// objectAttributes are the attributes requested for every ObjectReport
+var objectAttributes = []string{
+	"objectClass",
+	"objectSid",
+	"sAMAccountName",
+	"name",
+	"nTSecurityDescriptor",
+	"msDS-AllowedToActOnBehalfOfOtherIdentity",
+	"msDS-GroupMSAMembership",
+}
+
+// ControlSDFlags requests which parts of nTSecurityDescriptor are returned.
+// Without it, Active Directory also returns the SACL and so refuses the
+// attribute to anyone lacking SeSecurityPrivilege
+type ControlSDFlags struct {
+	Criticality bool
+	Flags       int64
+}
+
+// GetControlType returns the OID
+func (c *ControlSDFlags) GetControlType() string {
+	return ControlTypeSDFlags
+}
+
+// Encode returns the ber packet representation
+func (c *ControlSDFlags) Encode() *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Control")
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, ControlTypeSDFlags, "Control Type (SD Flags)"))
+	if c.Criticality {
+		packet.AppendChild(ber.NewBoolean(ber.ClassUniversal, ber.TypePrimitive, ber.TagBoolean, c.Criticality, "Criticality"))
+	}
+
+	value := ber.Encode(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, nil, "Control Value (SD Flags)")
+	seq := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "SDFlagsRequestValue")
+	seq.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, c.Flags, "Flags"))
+	value.AppendChild(seq)
+	packet.AppendChild(value)
+	return packet
+}
+
+// String returns a human-readable description
+func (c *ControlSDFlags) String() string {
+	return fmt.Sprintf("Control Type: %s (%q)  Criticality: %t  Flags: %#x",
+		"SD Flags", ControlTypeSDFlags, c.Criticality, c.Flags)
+}
+
+type ldapOptions struct {
+	url      string
+	user     string
+	password string
+	hash     string
+	domain   string
+	ntlm     bool
+	startTLS bool
+	insecure bool
+	base     string
+	filter   string
+	pageSize uint
+	resolve  bool
+	adcs     bool
+}
+
+func ldapMain(args []string) error {
+	opts := ldapOptions{}
+	flags := flag.NewFlagSet("ldap", flag.ExitOnError)
+	flags.StringVar(&opts.url, "url", "ldap://localhost:389", "Server URL, ldap:// or ldaps://")
+	flags.StringVar(&opts.user, "user", "", "Bind user. Binds anonymously when empty")
+	flags.StringVar(&opts.password, "password", "", "Bind password")
+	flags.StringVar(&opts.hash, "hash", "", "NT hash to bind with, instead of a password. Implies -ntlm")
+	flags.StringVar(&opts.domain, "domain", "", "Domain of the bind user, for NTLM")
+	flags.BoolVar(&opts.ntlm, "ntlm", false, "Bind with NTLM instead of a simple bind")
+	flags.BoolVar(&opts.startTLS, "starttls", false, "Upgrade the connection with StartTLS")
+	flags.BoolVar(&opts.insecure, "insecure", false, "Skip TLS certificate verification")
+	flags.StringVar(&opts.base, "base", "", "Search base. Defaults to the server's defaultNamingContext")
+	flags.StringVar(&opts.filter, "filter", "(objectClass=*)", "Search filter")
+	flags.UintVar(&opts.pageSize, "page", 500, "Page size")
+	flags.BoolVar(&opts.resolve, "resolve", true, "Resolve principals with the objectSids found in the default naming context")
+	flags.BoolVar(&opts.adcs, "adcs", false, "Analyse certificate templates and enrollment services for abusable enrollment rights")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: ino ldap [options]\n\n")
+		fmt.Fprintf(flags.Output(), "Print the parsed security descriptors of every object matching a search\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	conn, err := ldapConnect(opts)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	defaultContext, configContext, err := namingContexts(conn)
+	if err != nil {
+		return err
+	}
+
+	if opts.resolve && defaultContext != "" {
+		err = ldapPagedSearch(conn, defaultContext, "(objectSid=*)", []string{"objectSid", "sAMAccountName", "name"}, nil, opts.pageSize, learnEntrySID)
+		if err != nil {
+			return err
+		}
+	}
+
+	sdFlags := &ControlSDFlags{Flags: OwnerSecurityInformation | GroupSecurityInformation | DACLSecurityInformation}
+	controls := []ldap.Control{sdFlags}
+
+	if opts.adcs {
+		if opts.base == "" && configContext == "" {
+			return fmt.Errorf("ldap: no configuration naming context found, use -base with the DN of the Public Key Services container")
+		}
+		if opts.base == "" {
+			opts.base = "CN=Public Key Services,CN=Services," + configContext
+		}
+		filter := "(|(objectClass=pKICertificateTemplate)(objectClass=pKIEnrollmentService))"
+		analyzer := &adcsAnalyzer{}
+		err = ldapPagedSearch(conn, opts.base, filter, adcsAttributes, controls, opts.pageSize, analyzer.handle)
+		analyzer.print()
+		return err
+	}
+
+	if opts.base == "" {
+		opts.base = defaultContext
+	}
+	if opts.base == "" {
+		return fmt.Errorf("ldap: no naming context found, use -base")
+	}
+	return ldapPagedSearch(conn, opts.base, opts.filter, objectAttributes, controls, opts.pageSize, printObjectReport)
+}
+
+func ldapConnect(opts ldapOptions) (*ldap.Conn, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: opts.insecure}
+	conn, err := ldap.DialURL(opts.url, ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.startTLS {
+		err = conn.StartTLS(tlsConfig)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	switch {
+	case opts.user == "":
+		err = conn.UnauthenticatedBind("")
+	case opts.hash != "":
+		err = conn.NTLMBindWithHash(opts.domain, opts.user, opts.hash)
+	case opts.ntlm:
+		err = conn.NTLMBind(opts.domain, opts.user, opts.password)
+	default:
+		err = conn.Bind(opts.user, opts.password)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// namingContexts returns the default and configuration naming contexts
+// advertised by the RootDSE. Servers other than Active Directory only
+// advertise namingContexts, the first of which is taken as the default
+func namingContexts(conn *ldap.Conn) (string, string, error) {
+	req := ldap.NewSearchRequest("", ldap.ScopeBaseObject, ldap.NeverDerefAliases,
+		0, 0, false, "(objectClass=*)",
+		[]string{"defaultNamingContext", "configurationNamingContext", "namingContexts"}, nil)
+	res, err := conn.Search(req)
+	if err != nil {
+		return "", "", err
+	}
+	if len(res.Entries) == 0 {
+		return "", "", nil
+	}
+	defaultContext, configContext := rootDSEContexts(res.Entries[0])
+	return defaultContext, configContext, nil
+}
+
+// rootDSEContexts returns the default and configuration naming contexts of
+// a RootDSE entry
+func rootDSEContexts(rootDSE *ldap.Entry) (string, string) {
+	defaultContext := rootDSE.GetAttributeValue("defaultNamingContext")
+	if defaultContext == "" {
+		defaultContext = rootDSE.GetAttributeValue("namingContexts")
+	}
+	return defaultContext, rootDSE.GetAttributeValue("configurationNamingContext")
+}
+
+// ldapPagedSearch runs a subtree search, handing each entry to fn as each
+// page arrives, rather than holding the whole result set in memory
+func ldapPagedSearch(conn *ldap.Conn, base, filter string, attrs []string, controls []ldap.Control, pageSize uint, fn func(*directoryEntry)) error {
+	paging := ldap.NewControlPaging(uint32(pageSize))
+	for {
+		req := ldap.NewSearchRequest(base, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+			0, 0, false, filter, attrs, append(controls, paging))
+		res, err := conn.Search(req)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range res.Entries {
+			fn(newDirectoryEntry(entry))
+		}
+
+		pagingResult, ok := ldap.FindControl(res.Controls, ldap.ControlTypePaging).(*ldap.ControlPaging)
+		if !ok || len(pagingResult.Cookie) == 0 {
+			return nil
+		}
+		paging.SetCookie(pagingResult.Cookie)
+	}
+}
+
+func newDirectoryEntry(entry *ldap.Entry) *directoryEntry {
+	dirEntry := &directoryEntry{DN: entry.DN, Attrs: make(map[string][][]byte)}
+	for _, attr := range entry.Attributes {
+		name := strings.ToLower(attr.Name)
+		dirEntry.Attrs[name] = append(dirEntry.Attrs[name], attr.ByteValues...)
+	}
+	return dirEntry
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+	winacl "github.com/kgoins/go-winacl/pkg"
+)
+
+// testSIDBytes is S-1-5-21-1-2-3-1001 as objectSid holds it
+var testSIDBytes, _ = hex.DecodeString("010500000000000515000000010000000200000003000000e9030000")
+
+func TestControlSDFlags(t *testing.T) {
+	// SEQUENCE { OCTET STRING oid, [BOOLEAN], OCTET STRING { SEQUENCE { INTEGER flags } } }
+	oid := "0416" + hex.EncodeToString([]byte("1.2.840.113556.1.4.801"))
+	tests := []struct {
+		name    string
+		control ControlSDFlags
+		want    string
+	}{
+		{"owner, group and DACL", ControlSDFlags{Flags: OwnerSecurityInformation | GroupSecurityInformation | DACLSecurityInformation},
+			"301f" + oid + "04053003020107"},
+		{"critical SACL", ControlSDFlags{Criticality: true, Flags: SACLSecurityInformation},
+			"3022" + oid + "010101" + "04053003020108"},
+		{"every part", ControlSDFlags{Flags: 0xf},
+			"301f" + oid + "0405300302010f"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := hex.EncodeToString(test.control.Encode().Bytes()); got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+			if got := test.control.GetControlType(); got != ControlTypeSDFlags {
+				t.Errorf("got control type %s", got)
+			}
+		})
+	}
+}
+
+func TestRootDSEContexts(t *testing.T) {
+	tests := []struct {
+		name       string
+		attributes map[string][]string
+		def, conf  string
+	}{
+		{"Active Directory", map[string][]string{
+			"defaultNamingContext":       {"DC=corp,DC=local"},
+			"configurationNamingContext": {"CN=Configuration,DC=corp,DC=local"},
+			"namingContexts":             {"CN=Configuration,DC=corp,DC=local", "DC=corp,DC=local"},
+		}, "DC=corp,DC=local", "CN=Configuration,DC=corp,DC=local"},
+		{"namingContexts only", map[string][]string{
+			"namingContexts": {"dc=example,dc=org", "cn=config"},
+		}, "dc=example,dc=org", ""},
+		{"nothing advertised", map[string][]string{}, "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			def, conf := rootDSEContexts(ldap.NewEntry("", test.attributes))
+			if def != test.def || conf != test.conf {
+				t.Errorf("got %q and %q, want %q and %q", def, conf, test.def, test.conf)
+			}
+		})
+	}
+}
+
+func TestNewDirectoryEntry(t *testing.T) {
+	sd, err := winacl.NewNtSecurityDescriptorFromSDDL("O:S-1-5-21-1-2-3-512G:S-1-5-21-1-2-3-512D:(A;;GA;;;S-1-5-21-1-2-3-1001)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := sd.ToBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// attribute names are folded, and the values of repeated attributes,
+	// as from ranged retrieval, are joined
+	result := ldap.NewEntry("CN=jdoe,DC=corp,DC=local", map[string][]string{
+		"objectClass":          {"top", "person"},
+		"ObjectSid":            {string(testSIDBytes)},
+		"nTSecurityDescriptor": {raw.String()},
+		"sAMAccountName":       {"jdoe"},
+	})
+	result.Attributes = append(result.Attributes, ldap.NewEntryAttribute("OBJECTCLASS", []string{"user"}))
+
+	entry := newDirectoryEntry(result)
+	if got, want := entry.strings("objectClass"), []string{"top", "person", "user"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got objectClass %q, want %q", got, want)
+	}
+	if !bytes.Equal(entry.first("objectsid"), testSIDBytes) {
+		t.Errorf("got objectSid %x", entry.first("objectsid"))
+	}
+
+	report, err := newObjectReport(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.DN != result.DN || report.ObjectSid != "S-1-5-21-1-2-3-1001" {
+		t.Errorf("got DN %s and objectSid %s", report.DN, report.ObjectSid)
+	}
+	if len(report.DACL.Aces) != 1 || report.DACL.Aces[0].SID != "S-1-5-21-1-2-3-1001" {
+		t.Errorf("got DACL %+v", report.DACL)
+	}
+
+	t.Cleanup(func() { delete(knownSIDs, "S-1-5-21-1-2-3-1001") })
+	learnEntrySID(entry)
+	if name := knownSIDs["S-1-5-21-1-2-3-1001"]; name != "jdoe" {
+		t.Errorf("learned the name %q", name)
+	}
+}
+
+// TestLDAPSearch searches the directory at INO_LDAP_URL, binding with
+// INO_LDAP_USER and INO_LDAP_PASSWORD when they are set, and reads the
+// descriptor of its default naming context
+func TestLDAPSearch(t *testing.T) {
+	url := os.Getenv("INO_LDAP_URL")
+	if url == "" {
+		t.Skip("INO_LDAP_URL is not set")
+	}
+	conn, err := ldapConnect(ldapOptions{
+		url:      url,
+		user:     os.Getenv("INO_LDAP_USER"),
+		password: os.Getenv("INO_LDAP_PASSWORD"),
+		insecure: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	def, _, err := namingContexts(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if def == "" {
+		t.Fatal("no naming context advertised")
+	}
+
+	var entries []*directoryEntry
+	controls := []ldap.Control{&ControlSDFlags{Flags: OwnerSecurityInformation | GroupSecurityInformation | DACLSecurityInformation}}
+	err = ldapPagedSearch(conn, def, "(objectClass=*)", objectAttributes, controls, 2, func(entry *directoryEntry) {
+		if len(entries) < 5 {
+			entries = append(entries, entry)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) == 0 {
+		t.Fatalf("nothing found under %s", def)
+	}
+	for _, entry := range entries {
+		if _, err := newObjectReport(entry); err != nil {
+			t.Errorf("%s: %s", entry.DN, err)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ldifReader streams the records of an LDIF file, as described in RFC 2849
+type ldifReader struct {
+	scanner    *bufio.Scanner
+	pending    string
+	hasPending bool
+}
+
+func newLDIFReader(r io.Reader) *ldifReader {
+	scanner := bufio.NewScanner(r)
+	// unwrapped exports keep each value on a single, possibly huge, line
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	return &ldifReader{scanner: scanner}
+}
+
+// readLine returns the next logical line, with folded lines joined
+func (lr *ldifReader) readLine() (string, bool) {
+	line, ok := lr.pending, lr.hasPending
+	lr.hasPending = false
+	for lr.scanner.Scan() {
+		next := strings.TrimSuffix(lr.scanner.Text(), "\r")
+		if ok && line != "" && strings.HasPrefix(next, " ") {
+			line += next[1:]
+			continue
+		}
+		if ok {
+			lr.pending, lr.hasPending = next, true
+			return line, true
+		}
+		line, ok = next, true
+	}
+	return line, ok
+}
+
+// Next returns the next entry in the file, or io.EOF once exhausted.
+// Records without a dn, such as ldapsearch's trailing result summary,
+// are skipped
+func (lr *ldifReader) Next() (*directoryEntry, error) {
+	var entry *directoryEntry
+	skipping := false
+	for {
+		line, ok := lr.readLine()
+		if !ok {
+			break
+		}
+
+		if line == "" {
+			if entry != nil {
+				return entry, nil
+			}
+			skipping = false
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") || skipping {
+			continue
+		}
+
+		name, value, err := parseLDIFLine(line)
+		if err != nil {
+			return nil, err
+		}
+
+		if entry == nil {
+			switch name {
+			case "version":
+			case "dn":
+				entry = &directoryEntry{DN: string(value), Attrs: make(map[string][][]byte)}
+			default:
+				skipping = true
+			}
+			continue
+		}
+
+		if value != nil {
+			entry.Attrs[name] = append(entry.Attrs[name], value)
+		}
+	}
+
+	if err := lr.scanner.Err(); err != nil {
+		return nil, err
+	}
+	if entry != nil {
+		return entry, nil
+	}
+	return nil, io.EOF
+}
+
+// parseLDIFLine splits an attribute line into its lower-cased name, minus
+// any options, and its value. URL references are not followed and yield
+// a nil value
+func parseLDIFLine(line string) (string, []byte, error) {
+	sep := strings.IndexByte(line, ':')
+	if sep < 0 {
+		return "", nil, fmt.Errorf("ldif: malformed line %q", line)
+	}
+
+	name := strings.ToLower(line[:sep])
+	if opt := strings.IndexByte(name, ';'); opt >= 0 {
+		name = name[:opt]
+	}
+
+	value := line[sep+1:]
+	switch {
+	case strings.HasPrefix(value, ":"):
+		raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value[1:]))
+		if err != nil {
+			return "", nil, fmt.Errorf("ldif: %s: %s", name, err)
+		}
+		return name, raw, nil
+	case strings.HasPrefix(value, "<"):
+		return name, nil, nil
+	default:
+		return name, []byte(strings.TrimLeft(value, " ")), nil
+	}
+}
+
+func eachLDIFEntry(r io.Reader, fn func(*directoryEntry)) error {
+	lr := newLDIFReader(r)
+	for {
+		entry, err := lr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fn(entry)
+	}
+}
+
+func ldifMain(args []string) error {
+	flags := flag.NewFlagSet("ldif", flag.ExitOnError)
+	adcs := flags.Bool("adcs", false, "Analyse certificate templates and enrollment services for abusable enrollment rights")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: ino ldif <file.ldif|->\n\n")
+		fmt.Fprintf(flags.Output(), "Print the parsed security descriptors of every object in an LDIF export\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	var input io.ReadSeeker
+	if path := flags.Arg(0); path == "-" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		input = bytes.NewReader(data)
+	} else {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	if *adcs {
+		analyzer := &adcsAnalyzer{}
+		err := eachResolvedLDIFEntry(input, analyzer.handle)
+		analyzer.print()
+		return err
+	}
+	return eachResolvedLDIFEntry(input, printObjectReport)
+}
+
+// eachResolvedLDIFEntry reads an LDIF export twice, learning every SID in
+// it first, so that principals defined after the objects they hold rights
+// over still resolve
+func eachResolvedLDIFEntry(input io.ReadSeeker, fn func(*directoryEntry)) error {
+	err := eachLDIFEntry(input, learnEntrySID)
+	if err != nil {
+		return err
+	}
+
+	_, err = input.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	return eachLDIFEntry(input, fn)
+}
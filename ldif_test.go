@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/base64"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	winacl "github.com/kgoins/go-winacl/pkg"
+)
+
+func TestLDIFReader(t *testing.T) {
+	sid := base64.StdEncoding.EncodeToString(testSIDBytes)
+	tests := []struct {
+		name string
+		ldif string
+		want []*directoryEntry
+	}{
+		{"folded lines", "dn: CN=jdoe,\n DC=corp,DC=local\ndescription: a long\n  description\n\n",
+			[]*directoryEntry{{DN: "CN=jdoe,DC=corp,DC=local", Attrs: map[string][][]byte{
+				"description": {[]byte("a long description")},
+			}}}},
+		{"base64 values", "dn:: Q049asO8cmcsREM9Y29ycA==\nobjectSid:: " + sid + "\n",
+			[]*directoryEntry{{DN: "CN=jürg,DC=corp", Attrs: map[string][][]byte{
+				"objectsid": {testSIDBytes},
+			}}}},
+		{"comments, version and options", "version: 1\n\n# jdoe\ndn: CN=jdoe\n# about to list names\ncn;lang-en: jdoe\nCN: john\n",
+			[]*directoryEntry{{DN: "CN=jdoe", Attrs: map[string][][]byte{
+				"cn": {[]byte("jdoe"), []byte("john")},
+			}}}},
+		{"URL references", "dn: CN=jdoe\njpegPhoto:< file:///tmp/jdoe.jpg\nname: jdoe\n",
+			[]*directoryEntry{{DN: "CN=jdoe", Attrs: map[string][][]byte{
+				"name": {[]byte("jdoe")},
+			}}}},
+		{"CRLF line endings", "dn: CN=a\r\nname: a\r\n\r\ndn: CN=b\r\nname: b\r\n",
+			[]*directoryEntry{
+				{DN: "CN=a", Attrs: map[string][][]byte{"name": {[]byte("a")}}},
+				{DN: "CN=b", Attrs: map[string][][]byte{"name": {[]byte("b")}}},
+			}},
+		{"records without a dn", "dn: CN=a\nname: a\n\n# search result\nsearch: 2\nresult: 0 Success\n\n# numEntries: 1\n",
+			[]*directoryEntry{{DN: "CN=a", Attrs: map[string][][]byte{"name": {[]byte("a")}}}}},
+		{"empty", "# nothing\n\n", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []*directoryEntry
+			err := eachLDIFEntry(strings.NewReader(test.ldif), func(entry *directoryEntry) {
+				got = append(got, entry)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+
+	for _, ldif := range []string{"dn: CN=a\nno separator\n", "dn: CN=a\nobjectSid:: not base64!\n"} {
+		if _, err := newLDIFReader(strings.NewReader(ldif)).Next(); err == nil || err == io.EOF {
+			t.Errorf("read %q without an error", ldif)
+		}
+	}
+}
+
+// TestLDIFResolvesLaterPrincipals reads an export in which the user an ACE
+// grants rights to comes after the object holding the ACE
+func TestLDIFResolvesLaterPrincipals(t *testing.T) {
+	t.Cleanup(func() { delete(knownSIDs, testUserSID) })
+	sd, err := winacl.NewNtSecurityDescriptorFromSDDL("O:S-1-5-21-1-2-3-512G:S-1-5-21-1-2-3-512D:(A;;GA;;;" + testUserSID + ")")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := sd.ToBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ldif := "dn: CN=Computers,DC=corp,DC=local\n" +
+		"nTSecurityDescriptor:: " + base64.StdEncoding.EncodeToString(raw.Bytes()) + "\n\n" +
+		"dn: CN=John Doe,CN=Users,DC=corp,DC=local\n" +
+		"objectSid:: " + base64.StdEncoding.EncodeToString(testSIDBytes) + "\n" +
+		"sAMAccountName: jdoe\n"
+
+	var principals []string
+	err = eachResolvedLDIFEntry(strings.NewReader(ldif), func(entry *directoryEntry) {
+		report, err := newObjectReport(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, ace := range report.DACL.Aces {
+			principals = append(principals, ace.Principal)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"jdoe"}; !reflect.DeepEqual(principals, want) {
+		t.Errorf("got principals %q, want %q", principals, want)
+	}
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// LZMS is the compression of solid WIMs and ESDs. A range coder with
+// adaptive probabilities decides between literals, LZ matches and delta
+// matches, and whether offsets repeat, reading 16 bit words from the
+// start of the data. Literals, offsets and lengths are Huffman coded
+// with codes rebuilt from symbol frequencies, reading 16 bit words
+// backwards from the end
+const (
+	lzmsNumReps            = 3
+	lzmsProbabilityBits    = 6
+	lzmsInitialProbability = 48
+	lzmsInitialRecentBits  = 0x0000000055555555
+
+	lzmsNumLiteralSyms    = 256
+	lzmsNumLengthSyms     = 54
+	lzmsNumDeltaPowerSyms = 8
+	lzmsMaxNumOffsetSyms  = 799
+
+	lzmsLiteralRebuild     = 1024
+	lzmsLZOffsetRebuild    = 1024
+	lzmsLengthRebuild      = 512
+	lzmsDeltaOffsetRebuild = 1024
+	lzmsDeltaPowerRebuild  = 512
+
+	lzmsX86IDWindowSize         = 65535
+	lzmsX86MaxTranslationOffset = 1023
+)
+
+var (
+	lzmsOffsetSlotBase [lzmsMaxNumOffsetSyms + 1]uint32
+	lzmsOffsetBits     [lzmsMaxNumOffsetSyms]uint8
+	lzmsLengthSlotBase [lzmsNumLengthSyms + 1]uint32
+	lzmsLengthBits     [lzmsNumLengthSyms]uint8
+)
+
+// The difference between adjacent slot bases is a power of two that
+// grows along the slots, so the bases are stored as the number of slots
+// at each power
+func init() {
+	lzmsSlots(lzmsOffsetSlotBase[:], lzmsOffsetBits[:], []int{
+		9, 0, 9, 7, 10, 15, 15, 20, 20, 30, 33, 40, 42, 45, 60, 73, 80, 85, 95, 105, 6,
+	}, 0x7FFFFFFF)
+	lzmsSlots(lzmsLengthSlotBase[:], lzmsLengthBits[:], []int{
+		27, 4, 6, 4, 5, 2, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 1,
+	}, 0x400108AB)
+}
+
+func lzmsSlots(bases []uint32, extraBits []uint8, runs []int, final uint32) {
+	base, delta, slot := uint32(0), uint32(1), 0
+	for order, run := range runs {
+		for ; run > 0; run-- {
+			base += delta
+			if slot > 0 {
+				extraBits[slot-1] = uint8(order)
+			}
+			bases[slot] = base
+			slot++
+		}
+		delta <<= 1
+	}
+	extraBits[slot-1] = uint8(len(runs))
+	bases[slot] = final
+}
+
+// lzmsSlot returns the slot whose range holds value
+func lzmsSlot(bases []uint32, value uint32) int {
+	slot := 0
+	for slot+1 < len(bases)-1 && bases[slot+1] <= value {
+		slot++
+	}
+	return slot
+}
+
+// lzmsProbability tracks how many of the last 64 decisions were zero
+type lzmsProbability struct {
+	zeros  uint32
+	recent uint64
+}
+
+func (p *lzmsProbability) get() uint32 {
+	prob := p.zeros
+	if prob == 0 {
+		prob = 1
+	} else if prob == 1<<lzmsProbabilityBits {
+		prob--
+	}
+	return prob
+}
+
+func (p *lzmsProbability) update(bit uint32) {
+	p.zeros = p.zeros + uint32(p.recent>>63) - bit
+	p.recent = p.recent<<1 | uint64(bit)
+}
+
+// lzmsDecision is a binary decision whose probability depends on the
+// outcome of the same decision the last few times
+type lzmsDecision struct {
+	state uint32
+	probs []lzmsProbability
+}
+
+func newLZMSDecision(states int) *lzmsDecision {
+	d := &lzmsDecision{probs: make([]lzmsProbability, states)}
+	for i := range d.probs {
+		d.probs[i] = lzmsProbability{lzmsInitialProbability, lzmsInitialRecentBits}
+	}
+	return d
+}
+
+type lzmsRangeDecoder struct {
+	in   []byte
+	pos  int
+	rng  uint32
+	code uint32
+}
+
+func (r *lzmsRangeDecoder) word() uint32 {
+	if r.pos+2 > len(r.in) {
+		return 0
+	}
+	r.pos += 2
+	return uint32(binary.LittleEndian.Uint16(r.in[r.pos-2:]))
+}
+
+func (r *lzmsRangeDecoder) decode(d *lzmsDecision) uint32 {
+	prob := &d.probs[d.state]
+	d.state = d.state << 1 & uint32(len(d.probs)-1)
+
+	if r.rng&0xFFFF0000 == 0 {
+		r.rng <<= 16
+		r.code = r.code<<16 | r.word()
+	}
+	bound := (r.rng >> lzmsProbabilityBits) * prob.get()
+	if r.code < bound {
+		r.rng = bound
+		prob.update(0)
+		return 0
+	}
+	r.rng -= bound
+	r.code -= bound
+	prob.update(1)
+	d.state |= 1
+	return 1
+}
+
+// lzmsBits reads the Huffman coded part of LZMS data
+type lzmsBits struct {
+	in    []byte
+	end   int
+	value uint64
+	count uint
+}
+
+func (b *lzmsBits) ensure(n uint) {
+	for b.count < n {
+		var word uint64
+		if b.end >= 2 {
+			b.end -= 2
+			word = uint64(binary.LittleEndian.Uint16(b.in[b.end:]))
+		}
+		b.value |= word << (48 - b.count)
+		b.count += 16
+	}
+}
+
+func (b *lzmsBits) read(n uint) uint32 {
+	if n == 0 {
+		return 0
+	}
+	b.ensure(n)
+	v := uint32(b.value >> (64 - n))
+	b.value <<= n
+	b.count -= n
+	return v
+}
+
+// lzmsHuffman is a Huffman code rebuilt from the frequency of its
+// symbols after every rebuild symbols decoded
+type lzmsHuffman struct {
+	huffmanCode
+	freqs     []uint32
+	lengths   []uint8
+	rebuild   int
+	remaining int
+}
+
+func newLZMSHuffman(syms, rebuild int) *lzmsHuffman {
+	h := &lzmsHuffman{
+		freqs:   make([]uint32, syms),
+		lengths: make([]uint8, syms),
+		rebuild: rebuild,
+	}
+	for i := range h.freqs {
+		h.freqs[i] = 1
+	}
+	h.build()
+	return h
+}
+
+func (h *lzmsHuffman) build() {
+	canonicalLengths(h.freqs, huffmanMaxLength, h.lengths)
+	h.huffmanCode.build(h.lengths)
+	h.remaining = h.rebuild
+}
+
+func (h *lzmsHuffman) decode(bits *lzmsBits) (int, error) {
+	bits.ensure(huffmanMaxLength)
+	sym, n, err := h.huffmanCode.decode(uint32(bits.value >> (64 - huffmanMaxLength)))
+	if err != nil {
+		return 0, err
+	}
+	bits.value <<= n
+	bits.count -= n
+
+	h.freqs[sym]++
+	h.remaining--
+	if h.remaining == 0 {
+		h.build()
+		for i := range h.freqs {
+			h.freqs[i] = h.freqs[i]>>1 + 1
+		}
+	}
+	return sym, nil
+}
+
+func (h *lzmsHuffman) decodeSlot(bits *lzmsBits, bases []uint32, extraBits []uint8) (uint32, error) {
+	slot, err := h.decode(bits)
+	if err != nil {
+		return 0, err
+	}
+	return bases[slot] + bits.read(uint(extraBits[slot])), nil
+}
+
+var errLZMSCorrupt = errors.New("LZMS data corrupt")
+
+// lzmsDecompress decodes one chunk of LZMS data of a known decompressed
+// size
+func lzmsDecompress(in []byte, size int) ([]byte, error) {
+	if len(in) < 4 || len(in)%2 != 0 {
+		return nil, errLZMSCorrupt
+	}
+	if size == 0 {
+		return []byte{}, nil
+	}
+
+	rc := &lzmsRangeDecoder{in: in, rng: 0xFFFFFFFF}
+	rc.code = rc.word()<<16 | rc.word()
+	bits := &lzmsBits{in: in, end: len(in)}
+
+	offsetSyms := 0
+	if size >= 2 {
+		offsetSyms = lzmsSlot(lzmsOffsetSlotBase[:], uint32(size-1)) + 1
+	}
+	literals := newLZMSHuffman(lzmsNumLiteralSyms, lzmsLiteralRebuild)
+	lzOffsets := newLZMSHuffman(offsetSyms, lzmsLZOffsetRebuild)
+	lengths := newLZMSHuffman(lzmsNumLengthSyms, lzmsLengthRebuild)
+	deltaOffsets := newLZMSHuffman(offsetSyms, lzmsDeltaOffsetRebuild)
+	deltaPowers := newLZMSHuffman(lzmsNumDeltaPowerSyms, lzmsDeltaPowerRebuild)
+
+	mainDecision := newLZMSDecision(16)
+	matchDecision := newLZMSDecision(32)
+	lzDecision := newLZMSDecision(64)
+	deltaDecision := newLZMSDecision(64)
+	var lzReps, deltaReps [lzmsNumReps - 1]*lzmsDecision
+	for i := range lzReps {
+		lzReps[i] = newLZMSDecision(64)
+		deltaReps[i] = newLZMSDecision(64)
+	}
+
+	// the offset of a match only joins the recent offsets once another
+	// item has followed it, so a match cannot repeat the one before it
+	lzRecent := [lzmsNumReps + 1]uint64{1, 2, 3, 4}
+	deltaRecent := [lzmsNumReps + 1]uint64{1, 2, 3, 4}
+	var lzPending, deltaPending uint64
+	lzPendingAt, deltaPendingAt := -1, -1
+
+	out := make([]byte, 0, size)
+	for len(out) < size {
+		if rc.decode(mainDecision) == 0 {
+			sym, err := literals.decode(bits)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, byte(sym))
+			continue
+		}
+
+		if rc.decode(matchDecision) == 0 {
+			if lzPending != 0 && len(out) != lzPendingAt {
+				pushRecent(&lzRecent, lzPending)
+				lzPending = 0
+			}
+			var offset uint64
+			if rc.decode(lzDecision) == 0 {
+				explicit, err := lzOffsets.decodeSlot(bits, lzmsOffsetSlotBase[:], lzmsOffsetBits[:])
+				if err != nil {
+					return nil, err
+				}
+				offset = uint64(explicit)
+			} else {
+				offset = takeRecent(&lzRecent, rc, lzReps)
+			}
+			if lzPending != 0 {
+				pushRecent(&lzRecent, lzPending)
+			}
+			lzPending = offset
+
+			length, err := lengths.decodeSlot(bits, lzmsLengthSlotBase[:], lzmsLengthBits[:])
+			if err != nil {
+				return nil, err
+			}
+			if uint64(length) > uint64(size-len(out)) || offset > uint64(len(out)) {
+				return nil, errLZMSCorrupt
+			}
+			for i := uint32(0); i < length; i++ {
+				out = append(out, out[len(out)-int(offset)])
+			}
+			lzPendingAt = len(out)
+			continue
+		}
+
+		if deltaPending != 0 && len(out) != deltaPendingAt {
+			pushRecent(&deltaRecent, deltaPending)
+			deltaPending = 0
+		}
+		var pair uint64
+		if rc.decode(deltaDecision) == 0 {
+			power, err := deltaPowers.decode(bits)
+			if err != nil {
+				return nil, err
+			}
+			rawOffset, err := deltaOffsets.decodeSlot(bits, lzmsOffsetSlotBase[:], lzmsOffsetBits[:])
+			if err != nil {
+				return nil, err
+			}
+			pair = uint64(power)<<32 | uint64(rawOffset)
+		} else {
+			pair = takeRecent(&deltaRecent, rc, deltaReps)
+		}
+		if deltaPending != 0 {
+			pushRecent(&deltaRecent, deltaPending)
+		}
+		deltaPending = pair
+
+		length, err := lengths.decodeSlot(bits, lzmsLengthSlotBase[:], lzmsLengthBits[:])
+		if err != nil {
+			return nil, err
+		}
+		power := pair >> 32
+		if power >= 32 {
+			return nil, errLZMSCorrupt
+		}
+		span := uint64(1) << power
+		offset := uint64(uint32(pair)) << power
+		if uint64(length) > uint64(size-len(out)) || offset+span > uint64(len(out)) {
+			return nil, errLZMSCorrupt
+		}
+		for i := uint32(0); i < length; i++ {
+			n := len(out)
+			out = append(out, out[n-int(offset)]+out[n-int(span)]-out[n-int(offset)-int(span)])
+		}
+		deltaPendingAt = len(out)
+	}
+
+	lzmsX86Filter(out)
+	return out, nil
+}
+
+// pushRecent makes offset the most recent
+func pushRecent(recent *[lzmsNumReps + 1]uint64, offset uint64) {
+	copy(recent[1:], recent[:lzmsNumReps])
+	recent[0] = offset
+}
+
+// takeRecent removes and returns the recent offset the range coder
+// chooses
+func takeRecent(recent *[lzmsNumReps + 1]uint64, rc *lzmsRangeDecoder, reps [lzmsNumReps - 1]*lzmsDecision) uint64 {
+	i := 0
+	for i < len(reps) && rc.decode(reps[i]) == 1 {
+		i++
+	}
+	offset := recent[i]
+	copy(recent[i:], recent[i+1:])
+	return offset
+}
+
+// lzmsX86Filter undoes the translation of relative x86 call, jump and
+// load targets to absolute ones that the compressor makes to help match
+// them. Translation only happens near instructions that reference an
+// address referenced shortly before, which marks the data as likely code
+func lzmsX86Filter(data []byte) {
+	size := len(data)
+	if size <= 17 {
+		return
+	}
+
+	lastTargetUsages := make([]int, 65536)
+	for i := range lastTargetUsages {
+		lastTargetUsages[i] = -lzmsX86IDWindowSize - 1
+	}
+	lastX86Pos := -lzmsX86MaxTranslationOffset - 1
+
+	tail := size - 16
+	for p := 0; p < tail; {
+		maxTranslationOffset := lzmsX86MaxTranslationOffset
+		opcodeLength := 0
+		switch data[p] {
+		case 0x48:
+			if data[p+1] == 0x8B && (data[p+2] == 0x05 || data[p+2] == 0x0D) ||
+				data[p+1] == 0x8D && data[p+2]&0x7 == 0x5 {
+				opcodeLength = 3
+			}
+		case 0x4C:
+			if data[p+1] == 0x8D && data[p+2]&0x7 == 0x5 {
+				opcodeLength = 3
+			}
+		case 0xE8:
+			opcodeLength = 1
+			maxTranslationOffset >>= 1
+		case 0xE9:
+			p += 4
+		case 0xF0:
+			if data[p+1] == 0x83 && data[p+2] == 0x05 {
+				opcodeLength = 3
+			}
+		case 0xFF:
+			if data[p+1] == 0x15 {
+				opcodeLength = 2
+			}
+		}
+		if opcodeLength == 0 {
+			p++
+			continue
+		}
+
+		i := p
+		p += opcodeLength
+		if i-lastX86Pos <= maxTranslationOffset {
+			n := binary.LittleEndian.Uint32(data[p:])
+			binary.LittleEndian.PutUint32(data[p:], n-uint32(i))
+		}
+		target := uint16(i) + binary.LittleEndian.Uint16(data[p:])
+
+		i += opcodeLength + 4 - 1
+		if i-lastTargetUsages[target] <= lzmsX86IDWindowSize {
+			lastX86Pos = i
+		}
+		lastTargetUsages[target] = i
+		p += 4
+	}
+}
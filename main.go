@@ -1,12 +1,15 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
+	"io"
 	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"www.velocidex.com/golang/binparsergen/reader"
@@ -18,19 +21,89 @@ type PEFunction struct {
 	Functions []string `json:"Functions"`
 }
 
+// subcommands are alternate modes of operation, selected by the first
+// argument after any global flags
+var subcommands = map[string]func(args []string) error{
+	"ldif":  ldifMain,
+	"ldap":  ldapMain,
+	"acl":   aclMain,
+	"scan":  scanMain,
+	"graph": graphMain,
+	"deps":  depsMain,
+}
+
 var (
 	pePath        string
 	reDirPath     string
-	reType        string
+	fromFile      string
+	peSelector    *selector
 	printDef      string
 	printImpHash  bool
 	printImports  bool
 	printExports  bool
 	printForwards bool
 	verbose       bool
+
+	printDescriptor bool
+	writableBy      writableByUser
 )
 
-func init() {
+// writableByUser is the user of -writable-by, parsed from uid[:gid,...] as
+// the flag is set
+type writableByUser struct {
+	uid  uint32
+	gids []uint32
+	set  bool
+}
+
+func (w *writableByUser) String() string {
+	if w == nil || !w.set {
+		return ""
+	}
+	value := strconv.FormatUint(uint64(w.uid), 10)
+	if len(w.gids) > 0 {
+		gids := make([]string, len(w.gids))
+		for i, gid := range w.gids {
+			gids[i] = strconv.FormatUint(uint64(gid), 10)
+		}
+		value += ":" + strings.Join(gids, ",")
+	}
+	return value
+}
+
+func (w *writableByUser) Set(value string) error {
+	uid, gids, err := parseWritableBy(value)
+	if err != nil {
+		return err
+	}
+	*w = writableByUser{uid: uid, gids: gids, set: true}
+	return nil
+}
+
+// parseWritableBy parses a -writable-by value of the form uid[:gid,...]
+func parseWritableBy(value string) (uid uint32, gids []uint32, err error) {
+	parts := strings.SplitN(value, ":", 2)
+	id, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid uid %q", parts[0])
+	}
+	uid = uint32(id)
+
+	if len(parts) == 2 && parts[1] != "" {
+		for _, g := range strings.Split(parts[1], ",") {
+			id, err := strconv.ParseUint(g, 10, 32)
+			if err != nil {
+				return 0, nil, fmt.Errorf("invalid gid %q", g)
+			}
+			gids = append(gids, uint32(id))
+		}
+	}
+	return uid, gids, nil
+}
+
+// parseFlags parses the global flags. It is called by main rather than
+// from init, so that the package can be tested
+func parseFlags() {
 	log.SetPrefix("ERROR: ")
 	log.SetOutput(os.Stderr)
 	flag.StringVar(&printDef, "def", "", "Print .def file from a PEs imports of the given dllname")
@@ -39,35 +112,85 @@ func init() {
 	flag.BoolVar(&printExports, "exports", false, "Print Exports only")
 	flag.BoolVar(&printForwards, "forwards", false, "Print Forwards only")
 	flag.BoolVar(&verbose, "v", false, "Print additional fields")
+	flag.BoolVar(&printDescriptor, "sd", false, "Embed the full security descriptor in each DACL")
+	flag.Var(&writableBy, "writable-by", "Report whether `uid[:gid,...]` may write each file. Not on Windows")
 	flag.StringVar(&reDirPath, "dir", "", "Directory to recurse")
-	flag.StringVar(&reType, "type", "", "Use with --dir. Get [exe|dll]")
+	flag.StringVar(&fromFile, "from-file", "", "Scan the paths listed in this file, or - for stdin, one per line or NUL separated")
+	newSelector := selectorFlags(flag.CommandLine)
+	errorFlags(flag.CommandLine)
+	newOutput := outputFlags(flag.CommandLine)
+	symlinks := flag.String("symlinks", symlinksFiles, "Use with --dir. Symbolic links to [skip|files|follow]. Links to directories\nare only followed with follow")
 	flag.Parse()
 
-	if (reDirPath != "" && reType == "") || (reDirPath == "" && reType != "") {
-		log.Print("\n-dir and -type must be used together\n\n")
-		if (reType != "dll" && reType != "exe") || reDirPath == "" {
-			log.Printf("\n-type must be 'dll' or 'exe'\n\n")
-			flag.Usage()
-			os.Exit(1)
-		}
+	var err error
+	peSelector, err = newSelector()
+	if err != nil {
+		log.Printf("\n%s\n\n", err)
+		flag.Usage()
+		os.Exit(1)
+	}
+	err = checkFailOn()
+	if err == nil {
+		err = newOutput()
+	}
+	if err != nil {
+		log.Printf("\n%s\n\n", err)
+		flag.Usage()
+		os.Exit(1)
+	}
+	switch *symlinks {
+	case symlinksSkip, symlinksFiles, symlinksFollow:
+		peSelector.symlinks = *symlinks
+	default:
+		log.Printf("\n-symlinks must be 'skip', 'files' or 'follow'\n\n")
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	if flag.NArg() == 0 && reDirPath == "" {
+	if flag.NArg() == 0 && reDirPath == "" && fromFile == "" {
 		log.Fatal("\nPath to PE required\n\n")
 	}
 }
 
 func main() {
-	if reDirPath != "" {
-		peType := fmt.Sprintf("*.%s", reType)
-		absDirPath, _ := filepath.Abs(reDirPath)
-		walkFunction := walkFunctionGenerator(peType)
-		filepath.WalkDir(absDirPath, walkFunction)
+	parseFlags()
+	if subcommand, ok := subcommands[flag.Arg(0)]; ok && reDirPath == "" {
+		err := subcommand(flag.Args()[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
 		os.Exit(0)
 	}
 
+	// anything but a single path is walked as -dir is, with lists of
+	// paths read from stdin for -, as from find -print0 or xargs
+	if reDirPath != "" || fromFile != "" || flag.NArg() > 1 || flag.Arg(0) == "-" {
+		var paths []string
+		if reDirPath != "" {
+			paths = append(paths, reDirPath)
+		}
+		lists := []string{}
+		for _, arg := range flag.Args() {
+			if arg == "-" {
+				lists = append(lists, arg)
+			} else {
+				paths = append(paths, arg)
+			}
+		}
+		if fromFile != "" {
+			lists = append(lists, fromFile)
+		}
+		for _, list := range lists {
+			listed, err := readPathList(list)
+			if err != nil {
+				log.Fatal(err)
+			}
+			paths = append(paths, listed...)
+		}
+		scanPaths(paths, peSelector)
+		finishScan()
+	}
+
 	pePath = flag.Arg(0)
 	info, err := os.Stat(pePath)
 	if err != nil {
@@ -76,8 +199,12 @@ func main() {
 
 	var report *Report
 	if info.IsDir() {
-		report = newDirectoryReport(pePath)
-		jsPrint(report)
+		report, err = newDirectoryReport(pePath)
+		emit(report)
+		output.close()
+		if err != nil {
+			log.Fatalf("%s %s\n", report.Path, err)
+		}
 		os.Exit(0)
 	}
 
@@ -86,6 +213,7 @@ func main() {
 	if err != nil {
 		log.Fatalf("%s %s\n", report.Path, err)
 	}
+	report.Class = fileClass(report.Path)
 
 	if printImpHash {
 		tyrian := peFile.ImpHash()
@@ -118,6 +246,10 @@ func main() {
 	if err != nil {
 		log.Fatalf("%s %s\n", report.Path, err)
 	}
+	if f, err := os.Open(report.Path); err == nil {
+		populateTableFields(report, f)
+		f.Close()
+	}
 
 	if printDef != "" {
 		var defs []string
@@ -135,67 +267,229 @@ func main() {
 		os.Exit(0)
 	}
 
-	jsPrint(report)
+	emit(report)
+	output.close()
 }
 
-func walkFunctionGenerator(pattern string) fs.WalkDirFunc {
+// walkState is shared by the walks of each path given to scanPaths
+type walkState struct {
 	// use a set to track if a report for a PE's parent directory
 	// has already been printed
-	printedParentDir := make(map[string]bool)
-	return func(path string, info os.DirEntry, err error) error {
+	printedParentDir map[string]bool
+	// and the directories followed through symbolic links, so that
+	// each is walked once
+	followed map[string]bool
+}
+
+func newWalkState() *walkState {
+	return &walkState{
+		printedParentDir: make(map[string]bool),
+		followed:         make(map[string]bool),
+	}
+}
+
+// scanPaths reports the PEs in each path, walking directories as -dir
+// does. Files are reported as if found in their directory
+func scanPaths(paths []string, sel *selector) {
+	state := newWalkState()
+	for _, path := range paths {
+		absPath, _ := filepath.Abs(path)
+		info, err := os.Stat(absPath)
 		if err != nil {
-			log.Printf("HUH? %s\n", err)
+			printError(absPath, phaseOpen, err)
+			continue
+		}
+		root := absPath
+		if !info.IsDir() {
+			root = filepath.Dir(absPath)
 		}
+		filepath.WalkDir(absPath, walkFunctionGenerator(root, sel, state))
+	}
+}
 
-		if info.IsDir() {
-			return nil
+// readPathList reads the paths listed in a file, or stdin for -. Paths
+// are separated by NULs when there are any, and by lines otherwise
+func readPathList(name string) ([]string, error) {
+	var data []byte
+	var err error
+	if name == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	separator := "\n"
+	if bytes.IndexByte(data, 0) >= 0 {
+		separator = "\x00"
+	}
+	var paths []string
+	for _, path := range strings.Split(string(data), separator) {
+		path = strings.TrimSuffix(path, "\r")
+		if path != "" {
+			paths = append(paths, path)
 		}
+	}
+	return paths, nil
+}
 
-		matched, err := filepath.Match(pattern, filepath.Base(path))
+func walkFunctionGenerator(root string, sel *selector, state *walkState) fs.WalkDirFunc {
+	printedParentDir, followed := state.printedParentDir, state.followed
+
+	var walk fs.WalkDirFunc
+	walk = func(path string, info os.DirEntry, err error) error {
 		if err != nil {
-			log.Printf("#Match %s\n", err)
+			printError(path, phaseWalk, err)
+			if info == nil || info.IsDir() {
+				return nil
+			}
 		}
 
-		if matched {
-			parent := filepath.Dir(path)
-			if !printedParentDir[parent] {
-				// first time finding a PE in this directory
-				dirReport := newDirectoryReport(parent)
-				jsPrint(dirReport)
-				printedParentDir[parent] = true
+		rel, _ := filepath.Rel(root, path)
+		rel = filepath.ToSlash(rel)
+		if info.IsDir() {
+			if path != root && sel.skipDir(rel) {
+				return fs.SkipDir
 			}
+			return nil
+		}
 
-			report := newPEReport(path)
-			peFile, err := newPEFile(report.Path)
-			if err != nil {
-				log.Printf("#newPEFile - %s - %s\n", report.Path, err)
+		if info.Type()&fs.ModeSymlink != 0 {
+			if path != root && sel.symlinks == symlinksSkip {
 				return nil
 			}
-
-			err = populatePEReport(report, peFile)
+			target, err := os.Stat(path)
 			if err != nil {
-				log.Printf("#populateReport - %s\n", err)
+				printError(path, phaseOpen, err)
+				return nil
+			}
+			if target.IsDir() {
+				// the directory given to -dir is always walked
+				if path != root && (sel.symlinks != symlinksFollow || sel.skipDir(rel)) || !followLink(path, followed) {
+					return nil
+				}
+				// a trailing separator walks the target rather than the link
+				return filepath.WalkDir(path+string(filepath.Separator), walk)
+			}
+			if !target.Mode().IsRegular() {
 				return nil
 			}
+		} else if !info.Type().IsRegular() {
+			// opening a FIFO or device could block, or never end
+			return nil
+		}
+
+		container := isContainerName(path)
+		if container && sel.skipDir(rel) || !container && !sel.matchName(rel) {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			printError(path, phaseOpen, err)
+			return nil
+		}
+		defer f.Close()
+		stat, err := f.Stat()
+		if err != nil {
+			printError(path, phaseOpen, err)
+			return nil
+		}
+		if sel.tooLarge(stat.Size()) {
+			return nil
+		}
+
+		// installers and archives are scanned like directories, with the
+		// paths of the files within them following a !
+		if container {
+			scanContainer(f, path, sel.within(rel))
+			return nil
+		}
 
-			jsPrint(report)
+		class := peClass(f)
+		if !sel.match(path, class) {
+			return nil
+		}
 
+		parent := filepath.Dir(path)
+		if !printedParentDir[parent] {
+			// first time finding a PE in this directory
+			dirReport, err := newDirectoryReport(parent)
+			printReport(dirReport)
+			if err != nil {
+				printError(dirReport.Path, phaseDACL, err)
+			}
+			printedParentDir[parent] = true
 		}
+
+		report := newPEReport(path)
+		report.Class = class
+		reportPE(report, f, populateFilePerms)
 		return nil
 	}
+	return walk
 }
 
-func newDirectoryReport(path string) *Report {
+// followLink reports whether a symbolic link to a directory should be
+// followed: when its target has not been walked already, and is not a
+// directory above the link, which would loop
+func followLink(path string, followed map[string]bool) bool {
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		printError(path, phaseOpen, err)
+		return false
+	}
+	parent, err := filepath.EvalSymlinks(filepath.Dir(path))
+	if err != nil {
+		return false
+	}
+	if followed[target] || parent == target || strings.HasPrefix(parent, target+string(filepath.Separator)) {
+		return false
+	}
+	followed[target] = true
+	return true
+}
+
+// newDirectoryReport reports a directory. The report is returned even
+// when its permissions could not be read, with the error
+func newDirectoryReport(path string) (*Report, error) {
 	report := &Report{}
 	report.Name = filepath.Base(path)
 	report.Path, _ = filepath.Abs(path)
 	report.Type = "directory"
 	report.Dir = filepath.Dir(path)
 	err := handleDirPerms(report)
+	return report, err
+}
+
+// reportPE parses a PE, and prints its report, or an ErrorRecord when it
+// cannot be parsed. perms reads the permissions of the file, and when it
+// fails the report is printed anyway, followed by its error
+func reportPE(report *Report, r io.ReaderAt, perms func(report *Report) error) {
+	var permsErr error
+	err := runFile(func(job *fileJob) error {
+		r := job.reader(r)
+		job.setPhase(phaseParse)
+		peFile, err := newPEFileFromReader(r)
+		if err != nil {
+			return err
+		}
+		job.setPhase(phaseImports)
+		populatePEFields(report, peFile)
+		populateTableFields(report, r)
+		job.setPhase(phaseDACL)
+		permsErr = perms(report)
+		return nil
+	})
 	if err != nil {
-		return report
+		printError(report.Path, phaseParse, err)
+		return
+	}
+	printReport(report)
+	if permsErr != nil {
+		printError(report.Path, phaseDACL, permsErr)
 	}
-	return report
 }
 
 func newPEReport(path string) *Report {
@@ -203,6 +497,7 @@ func newPEReport(path string) *Report {
 	report.Name = filepath.Base(path)
 	report.Path, _ = filepath.Abs(path)
 	report.Type = "file"
+	report.Dir = filepath.Dir(report.Path)
 	return report
 }
 
@@ -212,7 +507,13 @@ func newPEFile(path string) (pefile *pe.PEFile, err error) {
 		return
 	}
 
-	peReader, err := reader.NewPagedReader(peFileH, 4096, 100)
+	return newPEFileFromReader(peFileH)
+}
+
+// newPEFileFromReader parses a PE that is not a file on the host, such
+// as one within a disk image
+func newPEFileFromReader(r io.ReaderAt) (pefile *pe.PEFile, err error) {
+	peReader, err := reader.NewPagedReader(r, 4096, 100)
 	if err != nil {
 		return
 	}
@@ -220,6 +521,26 @@ func newPEFile(path string) (pefile *pe.PEFile, err error) {
 	return pe.NewPEFile(peReader)
 }
 
+// populatePEFields fills in the parts of a report read from the PE itself
+func populatePEFields(report *Report, peFile *pe.PEFile) {
+	report.ImpHash = peFile.ImpHash()
+	report.Imports = genPEFunctions(peFile.Imports())
+	report.Forwards = genPEFunctions(patchForwards(peFile.Forwards()))
+	report.Exports = patchExports(peFile.Exports())
+
+	if verbose {
+		report.Sections = peFile.Sections
+		report.PDB = peFile.PDB
+	}
+}
+
+// populateTableFields fills in the parts of a report from tables go-pe
+// does not read
+func populateTableFields(report *Report, r io.ReaderAt) {
+	report.DelayImports = genPEFunctions(delayImports(r))
+	report.Signer = peSigner(r)
+}
+
 func makeDepFile(deps []string) string {
 	if len(deps) == 0 {
 		fmt.Fprintln(os.Stderr, "nothing to forward")
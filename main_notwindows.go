@@ -1,41 +1,130 @@
-//+build !windows
-
-package main
-
-import (
-	"www.velocidex.com/golang/go-pe"
-)
-
-// Report contains the parsed import and exports of the PE
-type Report struct {
-	Name     string       `json:"Name"`
-	Path     string       `json:"Path"`
-	Dir      string       `json:"Dir"`
-	Type     string       `json:"Type"`
-	ImpHash  string       `json:"ImpHash"`
-	Exports  []string     `json:"Exports"`
-	Imports  []PEFunction `json:"Imports"`
-	Forwards []PEFunction `json:"Forwards"`
-
-	GUIDAge  string        `json:",omitempty"`
-	PDB      string        `json:",omitempty"`
-	Sections []*pe.Section `json:",omitempty"`
-}
-
-func populatePEReport(report *Report, peFile *pe.PEFile) error {
-	report.ImpHash = peFile.ImpHash()
-	report.Imports = genPEFunctions(peFile.Imports())
-	report.Forwards = genPEFunctions(patchForwards(peFile.Forwards()))
-	report.Exports = patchExports(peFile.Exports())
-
-	if verbose {
-		report.Sections = peFile.Sections
-		report.PDB = peFile.PDB
-	}
-	return nil
-}
-
-func handleDirPerms(report *Report) error {
-	// not yet implementd
-	return nil
-}
+//+build !windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	winacl "github.com/kgoins/go-winacl/pkg"
+	"www.velocidex.com/golang/go-pe"
+)
+
+// Report contains the parsed import and exports of the PE
+type Report struct {
+	Name string `json:"Name"`
+	Path string `json:"Path"`
+	Dir  string `json:"Dir"`
+	Type string `json:"Type"`
+
+	// Class is the kind of PE a file is, from its headers: exe, dll,
+	// native or efi
+	Class string `json:",omitempty"`
+
+	ImpHash  string       `json:"ImpHash"`
+	Exports  []string     `json:"Exports"`
+	Imports  []PEFunction `json:"Imports"`
+	Forwards []PEFunction `json:"Forwards"`
+
+	// DelayImports are loaded on first use rather than with the PE
+	DelayImports []PEFunction `json:",omitempty"`
+
+	// Signer is who an embedded Authenticode signature claims signed
+	// the PE. It is not verified
+	Signer string `json:",omitempty"`
+
+	// DACL is only present when the file's security descriptor is
+	// exposed as an extended attribute, by ntfs-3g or Samba
+	DACL *DACL `json:"DACL,omitempty"`
+
+	// NewFileDACL is the DACL a file created in a directory would receive
+	NewFileDACL *DACL `json:",omitempty"`
+
+	POSIX *POSIXPerms `json:",omitempty"`
+
+	GUIDAge  string        `json:",omitempty"`
+	PDB      string        `json:",omitempty"`
+	Sections []*pe.Section `json:",omitempty"`
+}
+
+func populatePEReport(report *Report, peFile *pe.PEFile) error {
+	populatePEFields(report, peFile)
+	return populateFilePerms(report)
+}
+
+// populateFilePerms adds a file's POSIX permissions and, when it has
+// one, its DACL to its report. The report is filled in as far as it can
+// be, and the first error returned
+func populateFilePerms(report *Report) error {
+	posixErr := handlePOSIXPerms(report)
+
+	sd, err := securityDescriptorFor(report.Path)
+	if errors.Is(err, errNoXattr) {
+		return posixErr
+	} else if err != nil {
+		return err
+	}
+	dacl := newDACL(sd, winacl.FileObject)
+	report.DACL = &dacl
+	return posixErr
+}
+
+// Extended attributes holding a file's Windows security descriptor
+const (
+	// ntfsACLXattr is exposed by ntfs-3g, as a self-relative descriptor
+	ntfsACLXattr = "system.ntfs_acl"
+
+	// sambaNTACLXattr is written by Samba, as an NDR encoded xattr_NTACL
+	sambaNTACLXattr = "security.NTACL"
+)
+
+// readXattr reads an extended attribute, following symlinks. Tests replace
+// it, as neither attribute can be set outside ntfs-3g and Samba
+var readXattr = getxattr
+
+// securityDescriptorFor reads the security descriptor of a file on an
+// ntfs-3g mount, or on a Samba share or SYSVOL. errNoXattr is returned
+// when neither is present
+func securityDescriptorFor(path string) (winacl.NtSecurityDescriptor, error) {
+	sdBytes, err := readXattr(path, ntfsACLXattr)
+	if err == nil {
+		sd, err := winacl.NewNtSecurityDescriptor(sdBytes)
+		if err != nil {
+			return sd, fmt.Errorf("%s: %s", ntfsACLXattr, err)
+		}
+		return sd, nil
+	} else if !errors.Is(err, errNoXattr) {
+		return winacl.NtSecurityDescriptor{}, err
+	}
+
+	ntaclBytes, err := readXattr(path, sambaNTACLXattr)
+	if err != nil {
+		return winacl.NtSecurityDescriptor{}, err
+	}
+	ntacl, err := winacl.NewNTACL(ntaclBytes)
+	if err != nil {
+		return ntacl.SecurityDescriptor, fmt.Errorf("%s: %s", sambaNTACLXattr, err)
+	}
+	return ntacl.SecurityDescriptor, nil
+}
+
+func handleDirPerms(report *Report) error {
+	posixErr := handlePOSIXPerms(report)
+
+	sd, err := securityDescriptorFor(report.Path)
+	if errors.Is(err, errNoXattr) {
+		return posixErr
+	} else if err != nil {
+		return err
+	}
+	dacl := newDACL(sd, winacl.FileObject)
+	report.DACL = &dacl
+	newFileDACL := newFileDACL(sd)
+	report.NewFileDACL = &newFileDACL
+	return posixErr
+}
+
+func sidResolve(sid winacl.SID) string {
+	res, _ := knownSIDResolve(sid)
+	return res
+}
@@ -0,0 +1,144 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	winacl "github.com/kgoins/go-winacl/pkg"
+)
+
+// fakeXattrs replaces readXattr with a lookup of the attributes given,
+// for the length of a test
+func fakeXattrs(t *testing.T, xattrs map[string][]byte) {
+	t.Helper()
+	read := readXattr
+	t.Cleanup(func() { readXattr = read })
+	readXattr = func(path, name string) ([]byte, error) {
+		if value, ok := xattrs[name]; ok {
+			return value, nil
+		}
+		return nil, errNoXattr
+	}
+}
+
+// ntaclFixture reads the value of the security.NTACL dump in a go-winacl
+// testdata file
+func ntaclFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	f, err := os.Open(filepath.Join("go-winacl", "testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		if value := strings.TrimPrefix(scanner.Text(), sambaNTACLXattr+"=0x"); value != scanner.Text() {
+			data, err := hex.DecodeString(value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return data
+		}
+	}
+	t.Fatalf("%s: no %s value", name, sambaNTACLXattr)
+	return nil
+}
+
+func TestSecurityDescriptorFor(t *testing.T) {
+	ntfsSD, err := winacl.NewNtSecurityDescriptorFromSDDL("O:BAG:SYD:(A;;FA;;;SY)(A;;0x1200a9;;;BU)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ntfsACL, err := ntfsSD.ToBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ntacl := ntaclFixture(t, "ntacl_v1.hex")
+	sambaSD, err := winacl.NewNTACL(ntacl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		xattrs map[string][]byte
+		// want is the SDDL of the descriptor read, or the attribute named
+		// by the error
+		want string
+		ok   bool
+	}{
+		{"ntfs-3g", map[string][]byte{ntfsACLXattr: ntfsACL.Bytes()}, ntfsSD.ToSDDL(), true},
+		{"samba", map[string][]byte{sambaNTACLXattr: ntacl}, sambaSD.SecurityDescriptor.ToSDDL(), true},
+		{"ntfs-3g before samba", map[string][]byte{ntfsACLXattr: ntfsACL.Bytes(), sambaNTACLXattr: ntacl}, ntfsSD.ToSDDL(), true},
+		{"corrupt ntfs-3g", map[string][]byte{ntfsACLXattr: {1, 0, 4}, sambaNTACLXattr: ntacl}, ntfsACLXattr, false},
+		{"corrupt samba", map[string][]byte{sambaNTACLXattr: ntacl[:len(ntacl)/2]}, sambaNTACLXattr, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fakeXattrs(t, test.xattrs)
+			sd, err := securityDescriptorFor("a.dll")
+			switch {
+			case !test.ok:
+				if err == nil || !strings.HasPrefix(err.Error(), test.want+": ") {
+					t.Errorf("got error %v, want a %s decoding error", err, test.want)
+				}
+			case err != nil:
+				t.Fatal(err)
+			case sd.ToSDDL() != test.want:
+				t.Errorf("got %s, want %s", sd.ToSDDL(), test.want)
+			}
+		})
+	}
+
+	t.Run("neither", func(t *testing.T) {
+		fakeXattrs(t, nil)
+		if _, err := securityDescriptorFor("a.dll"); !errors.Is(err, errNoXattr) {
+			t.Errorf("got error %v, want %v", err, errNoXattr)
+		}
+	})
+
+	t.Run("read errors", func(t *testing.T) {
+		read := readXattr
+		t.Cleanup(func() { readXattr = read })
+		readXattr = func(path, name string) ([]byte, error) { return nil, syscall.EACCES }
+		if _, err := securityDescriptorFor("a.dll"); !errors.Is(err, syscall.EACCES) {
+			t.Errorf("got error %v, want %v", err, syscall.EACCES)
+		}
+	})
+}
+
+func TestPopulateFilePermsDACL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.dll")
+	if err := os.WriteFile(path, []byte("MZ"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fakeXattrs(t, nil)
+	report := &Report{Path: path}
+	if err := populateFilePerms(report); err != nil || report.DACL != nil {
+		t.Errorf("without a descriptor, got DACL %v and error %v", report.DACL, err)
+	}
+
+	fakeXattrs(t, map[string][]byte{sambaNTACLXattr: ntaclFixture(t, "ntacl_v1.hex")})
+	report = &Report{Path: path}
+	if err := populateFilePerms(report); err != nil || report.DACL == nil {
+		t.Errorf("with a security.NTACL, got DACL %v and error %v", report.DACL, err)
+	}
+
+	fakeXattrs(t, map[string][]byte{ntfsACLXattr: {0}})
+	report = &Report{Path: path}
+	if err := populateFilePerms(report); err == nil || report.POSIX == nil {
+		t.Errorf("with a corrupt system.ntfs_acl, got POSIX %v and error %v", report.POSIX, err)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadPathList(t *testing.T) {
+	tests := []struct {
+		name string
+		list string
+		want []string
+	}{
+		{"lines", "a.dll\nb dir/c.exe\n", []string{"a.dll", "b dir/c.exe"}},
+		{"CRLF lines", "a.dll\r\nb.dll\r\n", []string{"a.dll", "b.dll"}},
+		{"blank lines", "\na.dll\n\n\nb.dll", []string{"a.dll", "b.dll"}},
+		// as from find -print0, where names may hold newlines
+		{"NUL separated", "a.dll\x00new\nline.dll\x00", []string{"a.dll", "new\nline.dll"}},
+		{"empty", "", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			name := filepath.Join(t.TempDir(), "list")
+			if err := os.WriteFile(name, []byte(test.list), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readPathList(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %q from the file, want %q", got, test.want)
+			}
+
+			// - reads the list from stdin
+			stdin, err := os.Open(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer stdin.Close()
+			saved := os.Stdin
+			os.Stdin = stdin
+			got, err = readPathList("-")
+			os.Stdin = saved
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %q from stdin, want %q", got, test.want)
+			}
+		})
+	}
+
+	if _, err := readPathList(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("read a missing list")
+	}
+}
@@ -2,8 +2,6 @@ package main
 
 import (
 	"fmt"
-	"path/filepath"
-	"strings"
 
 	"github.com/Microsoft/go-winio"
 	winacl "github.com/kgoins/go-winacl/pkg"
@@ -13,63 +11,57 @@ import (
 
 // Report contains the parsed import and exports of the PE
 type Report struct {
-	Name     string       `json:"Name"`
-	Path     string       `json:"Path"`
-	Dir      string       `json:"Dir"`
-	Type     string       `json:"Type"`
+	Name string `json:"Name"`
+	Path string `json:"Path"`
+	Dir  string `json:"Dir"`
+	Type string `json:"Type"`
+
+	// Class is the kind of PE a file is, from its headers: exe, dll,
+	// native or efi
+	Class string `json:",omitempty"`
+
 	ImpHash  string       `json:"ImpHash"`
 	Exports  []string     `json:"Exports"`
 	Imports  []PEFunction `json:"Imports"`
 	Forwards []PEFunction `json:"Forwards"`
-	DACL     DACL         `json:"DACL"`
+
+	// DelayImports are loaded on first use rather than with the PE
+	DelayImports []PEFunction `json:",omitempty"`
+
+	// Signer is who an embedded Authenticode signature claims signed
+	// the PE. It is not verified
+	Signer string `json:",omitempty"`
+	DACL   *DACL  `json:"DACL"`
+
+	// NewFileDACL is the DACL a file created in a directory would receive
+	NewFileDACL *DACL `json:",omitempty"`
 
 	GUIDAge  string        `json:",omitempty"`
 	PDB      string        `json:",omitempty"`
 	Sections []*pe.Section `json:",omitempty"`
 }
 
-type DACL struct {
-	Owner string        `json:"Owner"`
-	Group string        `json:"Group"`
-	Aces  []ReadableAce `json:"Aces"`
-}
-
-type ReadableAce struct {
-	Principal string   `json:"Principal"`
-	Rights    []string `json:"Rights"`
+func populatePEReport(report *Report, peFile *pe.PEFile) error {
+	populatePEFields(report, peFile)
+	return populateFilePerms(report)
 }
 
-func populatePEReport(report *Report, peFile *pe.PEFile) error {
-	report.ImpHash = peFile.ImpHash()
-	report.Imports = genPEFunctions(peFile.Imports())
-	report.Forwards = genPEFunctions(patchForwards(peFile.Forwards()))
-	report.Exports = patchExports(peFile.Exports())
-	report.Dir = filepath.Dir(report.Path)
-
-	if verbose {
-		report.Sections = peFile.Sections
-		report.PDB = peFile.PDB
-	}
+// populateFilePerms adds a file's DACL to its report
+func populateFilePerms(report *Report) error {
 	dacl, err := pullDACL(report.Path)
 	if err != nil {
 		return err
 	}
-	report.DACL = dacl
+	report.DACL = &dacl
 	return nil
 }
 
 func pullDACL(path string) (DACL, error) {
-	dacl := DACL{}
 	sd, err := securityDescriptorFor(path)
 	if err != nil {
-		return dacl, err
-	}
-	dacl.Owner = sidResolve(sd.Owner)
-	dacl.Group = sidResolve(sd.Group)
-	for _, ace := range sd.DACL.Aces {
-		dacl.Aces = append(dacl.Aces, newReadableAce(ace))
+		return DACL{}, err
 	}
-	return dacl, err
+	return newDACL(sd, winacl.FileObject), err
 }
 
 func securityDescriptorFor(path string) (sd winacl.NtSecurityDescriptor, err error) {
@@ -89,27 +81,9 @@ func securityDescriptorFor(path string) (sd winacl.NtSecurityDescriptor, err err
 	return
 }
 
-func newReadableAce(ace winacl.ACE) ReadableAce {
-	var rAce ReadableAce
-
-	perms := ace.AccessMask.String()
-	rAce.Rights = strings.Split(perms, " ")
-
-	switch ace.ObjectAce.(type) {
-	case winacl.BasicAce:
-		rAce.Principal = sidResolve(ace.ObjectAce.GetPrincipal())
-
-	case winacl.AdvancedAce:
-		aa := ace.ObjectAce.(winacl.AdvancedAce)
-		sid := aa.GetPrincipal()
-		rAce.Principal = sidResolve(sid)
-	}
-	return rAce
-}
-
 func sidResolve(sid winacl.SID) string {
-	res := sid.Resolve()
-	if strings.HasPrefix(res, "S-1-") {
+	res, ok := knownSIDResolve(sid)
+	if !ok {
 		// failed to resolve
 		winSID, err := windows.StringToSid(sid.String())
 		if err != nil {
@@ -125,10 +99,13 @@ func sidResolve(sid winacl.SID) string {
 }
 
 func handleDirPerms(report *Report) error {
-	dacl, err := pullDACL(report.Path)
+	sd, err := securityDescriptorFor(report.Path)
 	if err != nil {
 		return err
 	}
-	report.DACL = dacl
+	dacl := newDACL(sd, winacl.FileObject)
+	report.DACL = &dacl
+	newFileDACL := newFileDACL(sd)
+	report.NewFileDACL = &newFileDACL
 	return nil
 }
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// MSI column types, from the Type column of _Columns
+const (
+	msiTypeValid    = 0x0100
+	msiTypeString   = 0x0800
+	msiTypeNullable = 0x1000
+	msiTypeSize     = 0x00FF
+)
+
+// msiTablePrefix starts the stream names of MSI database tables
+const msiTablePrefix = "\u4840"
+
+// newMSIFS opens the streams of an MSI package, or of a merge module or
+// patch, which are compound files. Cabinets among them are opened with
+// the names of the File table, rather than the keys they are stored by
+func newMSIFS(r io.ReaderAt, size int64) (*indexFS, error) {
+	c, err := newCFBFile(r)
+	if err != nil {
+		return nil, err
+	}
+
+	names, _ := msiFileNames(c)
+	msi := newIndexFS()
+	seen := make(map[uint32]bool)
+	var add func(storage *cfbEntry, dir string)
+	add = func(storage *cfbEntry, dir string) {
+		seen[storage.id] = true
+		for _, entry := range c.children(storage) {
+			name := msiStreamName(entry.name)
+			// tables, and streams like \x05SummaryInformation, are
+			// not files
+			if name == "" || name[0] < 0x20 || strings.HasPrefix(name, msiTablePrefix) {
+				continue
+			}
+			switch entry.kind {
+			case cfbTypeStorage:
+				if !seen[entry.id] {
+					add(entry, path.Join(dir, name))
+				}
+			case cfbTypeStream:
+				stream := entry
+				msi.add(path.Join(dir, name), &indexEntry{
+					size:    stream.size,
+					modTime: stream.modTime,
+					sys:     names,
+					open: func() (*io.SectionReader, error) {
+						return c.open(stream)
+					},
+				})
+			}
+		}
+	}
+	add(c.entries[0], "")
+	return msi, nil
+}
+
+// msiStreamName decodes the name of a stream in an MSI, which packs two
+// characters of [0-9A-Za-z._] into each of U+3800 to U+47FF and one into
+// each of U+4800 to U+483F
+func msiStreamName(name string) string {
+	var b strings.Builder
+	for _, c := range name {
+		switch {
+		case c >= 0x3800 && c < 0x4800:
+			c -= 0x3800
+			b.WriteByte(msiCharacter(c & 0x3F))
+			b.WriteByte(msiCharacter(c >> 6 & 0x3F))
+		case c >= 0x4800 && c < 0x4840:
+			b.WriteByte(msiCharacter(c - 0x4800))
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+func msiCharacter(c rune) byte {
+	switch {
+	case c < 10:
+		return byte('0' + c)
+	case c < 36:
+		return byte('A' + c - 10)
+	case c < 62:
+		return byte('a' + c - 36)
+	case c == 62:
+		return '.'
+	}
+	return '_'
+}
+
+// msiDatabase reads tables from the streams of an MSI. Strings are
+// stored once, in a pool, and referred to from tables by index
+type msiDatabase struct {
+	c          *cfbFile
+	streams    map[string]*cfbEntry
+	strings    []string
+	stringSize int
+}
+
+// msiFileNames maps the keys of an MSI's File table to the long names
+// of its files, which are stored in its cabinets by key
+func msiFileNames(c *cfbFile) (cabinetNames, error) {
+	db := &msiDatabase{c: c, streams: make(map[string]*cfbEntry)}
+	for _, entry := range c.children(c.entries[0]) {
+		if entry.kind == cfbTypeStream {
+			db.streams[msiStreamName(entry.name)] = entry
+		}
+	}
+	err := db.readStrings()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.table("File", "File", "FileName")
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(cabinetNames)
+	for _, row := range rows {
+		// FileName is the short name, then the long name after a |
+		fileName := row[1]
+		if i := strings.IndexByte(fileName, '|'); i >= 0 {
+			fileName = fileName[i+1:]
+		}
+		names[row[0]] = fileName
+	}
+	return names, nil
+}
+
+func (db *msiDatabase) stream(name string) ([]byte, error) {
+	entry := db.streams[msiTablePrefix+name]
+	if entry == nil {
+		return nil, fmt.Errorf("MSI has no %s table", name)
+	}
+	r, err := db.c.open(entry)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, entry.size)
+	_, err = io.ReadFull(r, data)
+	return data, err
+}
+
+// readStrings reads the string pool. Each string has a 16 bit length and
+// reference count, except strings of 64K or more, whose length is given
+// by the next entry after an entry of zero length
+func (db *msiDatabase) readStrings() error {
+	pool, err := db.stream("_StringPool")
+	if err != nil {
+		return err
+	}
+	data, err := db.stream("_StringData")
+	if err != nil {
+		return err
+	}
+	if len(pool) < 4 {
+		return errors.New("MSI string pool is empty")
+	}
+
+	db.stringSize = 2
+	if binary.LittleEndian.Uint16(pool[2:])&0x8000 != 0 {
+		db.stringSize = 3
+	}
+	db.strings = []string{""}
+	offset := 0
+	for i := 4; i+4 <= len(pool); {
+		length := int(binary.LittleEndian.Uint16(pool[i:]))
+		refs := binary.LittleEndian.Uint16(pool[i+2:])
+		i += 4
+		if length == 0 && refs != 0 {
+			if i+4 > len(pool) {
+				break
+			}
+			length = int(binary.LittleEndian.Uint16(pool[i+2:]))<<16 | int(binary.LittleEndian.Uint16(pool[i:]))
+			i += 4
+		}
+		if offset+length > len(data) {
+			return errors.New("MSI string pool is longer than its data")
+		}
+		s := string(data[offset : offset+length])
+		if !utf8.ValidString(s) {
+			s = latin1(s)
+		}
+		db.strings = append(db.strings, s)
+		offset += length
+	}
+	return nil
+}
+
+// msiColumn is a column of a table, from _Columns
+type msiColumn struct {
+	number int
+	name   string
+	size   int
+}
+
+// table reads the named columns of a table. Tables are stored column by
+// column, each value taking 2 or 4 bytes, or the size of a string index
+func (db *msiDatabase) table(name string, columnNames ...string) ([][]string, error) {
+	columnData, err := db.stream("_Columns")
+	if err != nil {
+		return nil, err
+	}
+	stringSize := db.stringSize
+	count := len(columnData) / (2*stringSize + 4)
+	var columns []msiColumn
+	for i := 0; i < count; i++ {
+		if db.stringAt(columnData, i*stringSize) != name {
+			continue
+		}
+		number := int(binary.LittleEndian.Uint16(columnData[count*stringSize+i*2:]) ^ 0x8000)
+		columnName := db.stringAt(columnData, count*(stringSize+2)+i*stringSize)
+		columnType := int(binary.LittleEndian.Uint16(columnData[count*(2*stringSize+2)+i*2:]) ^ 0x8000)
+
+		size := 4
+		switch {
+		case columnType&^msiTypeNullable == msiTypeString|msiTypeValid:
+			// binary columns hold the index of a stream
+			size = 2
+		case columnType&msiTypeString != 0:
+			size = stringSize
+		case columnType&msiTypeSize <= 2:
+			size = 2
+		}
+		columns = append(columns, msiColumn{number: number, name: columnName, size: size})
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("MSI has no %s table", name)
+	}
+	sort.Slice(columns, func(i, j int) bool {
+		return columns[i].number < columns[j].number
+	})
+
+	data, err := db.stream(name)
+	if err != nil {
+		return nil, err
+	}
+	rowSize := 0
+	for _, column := range columns {
+		rowSize += column.size
+	}
+	rowCount := len(data) / rowSize
+
+	offsets := make([]int, len(columnNames))
+	for i, columnName := range columnNames {
+		offsets[i] = -1
+		offset := 0
+		for _, column := range columns {
+			if column.name == columnName {
+				if column.size != stringSize {
+					return nil, fmt.Errorf("MSI column %s.%s is not a string", name, columnName)
+				}
+				offsets[i] = offset
+				break
+			}
+			offset += rowCount * column.size
+		}
+		if offsets[i] < 0 {
+			return nil, fmt.Errorf("MSI has no %s.%s column", name, columnName)
+		}
+	}
+
+	rows := make([][]string, rowCount)
+	for i := range rows {
+		rows[i] = make([]string, len(columnNames))
+		for j, offset := range offsets {
+			rows[i][j] = db.stringAt(data, offset+i*stringSize)
+		}
+	}
+	return rows, nil
+}
+
+// stringAt returns the string whose index is at offset
+func (db *msiDatabase) stringAt(data []byte, offset int) string {
+	index := int(binary.LittleEndian.Uint16(data[offset:]))
+	if db.stringSize == 3 {
+		index |= int(data[offset+2]) << 16
+	}
+	if index >= len(db.strings) {
+		return ""
+	}
+	return db.strings[index]
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// neo4jArrayDelimiter separates the elements of array fields, and is the
+// default of neo4j-admin
+const neo4jArrayDelimiter = ";"
+
+// neo4jFiles are the CSV files neo4j-csv writes, in the order they are
+// given to neo4j-admin, with their headers. Nodes and relationships of
+// each label and type are in a file of their own
+var neo4jFiles = []struct {
+	name   string
+	kind   string
+	header []string
+}{
+	{"dll.csv", labelDLL, []string{"id:ID", ":LABEL", "name", "path", "dir", "class", "imphash", "exports:string[]", "signer", "missing:boolean", "apiset:boolean"}},
+	{"exe.csv", labelEXE, []string{"id:ID", ":LABEL", "name", "path", "dir", "class", "imphash", "exports:string[]", "signer", "missing:boolean", "apiset:boolean"}},
+	{"directory.csv", labelDirectory, []string{"id:ID", ":LABEL", "name", "path"}},
+	{"imports.csv", edgeImports, []string{":START_ID", ":END_ID", ":TYPE", "functions:string[]", "count:int"}},
+	{"delay_imports.csv", edgeDelayImports, []string{":START_ID", ":END_ID", ":TYPE", "functions:string[]", "count:int"}},
+	{"forwards.csv", edgeForwards, []string{":START_ID", ":END_ID", ":TYPE", "functions:string[]", "count:int"}},
+	{"contains.csv", edgeContains, []string{":START_ID", ":END_ID", ":TYPE"}},
+}
+
+// writeNeo4jCSV writes a graph as the node and relationship CSV files of
+// neo4j-admin database import, and prints the command that imports them
+func writeNeo4jCSV(g *graph, dir string) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	writers := make(map[string]*csv.Writer)
+	command := []string{"neo4j-admin database import full"}
+	for _, file := range neo4jFiles {
+		f, err := os.Create(filepath.Join(dir, file.name))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w := csv.NewWriter(f)
+		defer w.Flush()
+		w.Write(file.header)
+		writers[file.kind] = w
+
+		argument := "--relationships="
+		if strings.HasSuffix(file.header[0], ":ID") {
+			argument = "--nodes="
+		}
+		command = append(command, argument+filepath.Join(dir, file.name))
+	}
+
+	for _, node := range g.nodes {
+		w := writers[node.Label]
+		if node.Label == labelDirectory {
+			w.Write([]string{node.ID, node.Label, node.Name, node.Path})
+			continue
+		}
+		w.Write([]string{node.ID, node.Label, node.Name, node.Path, node.Dir, node.Class, node.ImpHash,
+			strings.Join(node.Exports, neo4jArrayDelimiter), node.Signer, strconv.FormatBool(node.Missing),
+			strconv.FormatBool(node.APISet)})
+	}
+	for _, edge := range g.edges {
+		w := writers[edge.Type]
+		if edge.Type == edgeContains {
+			w.Write([]string{edge.From.ID, edge.To.ID, edge.Type})
+			continue
+		}
+		w.Write([]string{edge.From.ID, edge.To.ID, edge.Type,
+			strings.Join(edge.Functions, neo4jArrayDelimiter), strconv.Itoa(len(edge.Functions))})
+	}
+
+	for _, w := range writers {
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintln(os.Stderr, strings.Join(append(command, "neo4j"), " \\\n  "))
+	return nil
+}
+
+// writeCypher prints a graph as Cypher statements. Nodes are merged on
+// their ids, and relationships on their ends, so that loading a dataset
+// again, or one that overlaps it, updates the graph rather than
+// duplicating it
+func writeCypher(g *graph, w io.Writer) error {
+	out := bufio.NewWriter(w)
+	for _, label := range []string{labelDLL, labelEXE, labelDirectory} {
+		fmt.Fprintf(out, "CREATE CONSTRAINT ino_%s_id IF NOT EXISTS FOR (n:%s) REQUIRE n.id IS UNIQUE;\n", strings.ToLower(label), label)
+	}
+
+	for _, node := range g.nodes {
+		fmt.Fprintf(out, "MERGE (n:%s {id: %s}) SET n.name = %s, n.path = %s", node.Label, cypherString(node.ID), cypherString(node.Name), cypherString(node.Path))
+		if node.Label != labelDirectory {
+			fmt.Fprintf(out, ", n.dir = %s, n.class = %s, n.imphash = %s, n.exports = %s, n.signer = %s, n.missing = %t, n.apiset = %t",
+				cypherString(node.Dir), cypherString(node.Class), cypherString(node.ImpHash), cypherList(node.Exports), cypherString(node.Signer), node.Missing, node.APISet)
+		}
+		fmt.Fprintln(out, ";")
+	}
+
+	for _, edge := range g.edges {
+		fmt.Fprintf(out, "MATCH (a:%s {id: %s}), (b:%s {id: %s}) MERGE (a)-[r:%s]->(b)",
+			edge.From.Label, cypherString(edge.From.ID), edge.To.Label, cypherString(edge.To.ID), edge.Type)
+		if edge.Type != edgeContains {
+			fmt.Fprintf(out, " SET r.functions = %s, r.count = %d", cypherList(edge.Functions), len(edge.Functions))
+		}
+		fmt.Fprintln(out, ";")
+	}
+	return out.Flush()
+}
+
+// cypherString quotes a string as a Cypher literal
+func cypherString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `'`, `\'`)
+	return "'" + s + "'"
+}
+
+func cypherList(list []string) string {
+	quoted := make([]string, len(list))
+	for i, s := range list {
+		quoted[i] = cypherString(s)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
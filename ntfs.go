@@ -0,0 +1,455 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	winacl "github.com/kgoins/go-winacl/pkg"
+	"www.velocidex.com/golang/go-ntfs/parser"
+)
+
+// MFT records and attributes ino needs beyond those go-ntfs names
+const (
+	ntfsRootRecord   = 5
+	ntfsSecureRecord = 9
+
+	// attrTypeSecurityDescriptor holds a file's descriptor on volumes
+	// older than NTFS 3.0, which have no $Secure
+	attrTypeSecurityDescriptor = 0x50
+	attrTypeBitmap             = 0xB0
+)
+
+// errNoDescriptor is returned for files that carry no security descriptor
+var errNoDescriptor = errors.New("no security descriptor")
+
+// ntfsFS is a read-only fs.FS over an NTFS volume within a raw image or
+// block device. The volume is parsed directly, so nothing is mounted and
+// descriptors are exactly as stored on disk
+type ntfsFS struct {
+	ntfs   *parser.NTFSContext
+	secure *ntfsSecure
+
+	// secureErr is why $Secure could not be read, if it couldn't
+	secureErr error
+}
+
+func newNTFSFS(volume io.ReaderAt) (*ntfsFS, error) {
+	ntfs, err := parser.GetNTFSContext(volume, 0)
+	if err != nil {
+		return nil, err
+	}
+	fsys := &ntfsFS{ntfs: ntfs}
+	fsys.secure, fsys.secureErr = newNTFSSecure(ntfs)
+	return fsys, nil
+}
+
+func (n *ntfsFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	entry, err := n.ntfs.GetMFT(ntfsRootRecord)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if name != "." {
+		entry, err = entry.Open(n.ntfs, name)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+	}
+
+	info := n.fileInfo(entry, pathBase(name))
+	if info.IsDir() {
+		return &ntfsDir{fsys: n, entry: entry, info: info}, nil
+	}
+
+	data, err := parser.OpenStream(n.ntfs, entry, parser.ATTR_TYPE_DATA,
+		parser.WILDCARD_STREAM_ID, parser.WILDCARD_STREAM_NAME)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &ntfsFile{SectionReader: io.NewSectionReader(data, 0, info.size), info: info}, nil
+}
+
+// fileInfo describes an MFT entry found under name
+func (n *ntfsFS) fileInfo(entry *parser.MFT_ENTRY, name string) *ntfsFileInfo {
+	info := &ntfsFileInfo{
+		fsys:  n,
+		entry: entry,
+		name:  name,
+		dir:   entry.Flags().IsSet("DIRECTORY"),
+	}
+
+	for _, attr := range entry.EnumerateAttributes(n.ntfs) {
+		switch attr.Type().Value {
+		case parser.ATTR_TYPE_STANDARD_INFORMATION:
+			si := n.ntfs.Profile.STANDARD_INFORMATION(attr.Data(n.ntfs), 0)
+			info.modTime = si.File_altered_time().Time
+			info.securityID = si.Sid()
+		case parser.ATTR_TYPE_DATA:
+			// the unnamed stream, or its first run of VCNs
+			if attr.Name() == "" && (attr.IsResident() || attr.Runlist_vcn_start() == 0) {
+				info.size = attr.DataSize()
+			}
+		}
+	}
+	return info
+}
+
+// securityDescriptor looks up an entry's SecurityId in $Secure, falling
+// back to the $SECURITY_DESCRIPTOR attribute of NTFS 1.x and 2.x volumes
+func (n *ntfsFS) securityDescriptor(info *ntfsFileInfo) (winacl.NtSecurityDescriptor, error) {
+	if info.securityID != 0 {
+		if n.secure == nil {
+			return winacl.NtSecurityDescriptor{}, fmt.Errorf("$Secure: %s", n.secureErr)
+		}
+		return n.secure.lookup(info.securityID)
+	}
+
+	stream, err := parser.OpenStream(n.ntfs, info.entry, attrTypeSecurityDescriptor,
+		parser.WILDCARD_STREAM_ID, parser.WILDCARD_STREAM_NAME)
+	if err != nil {
+		return winacl.NtSecurityDescriptor{}, errNoDescriptor
+	}
+	sdBytes, err := readStream(stream, maxDescriptorSize)
+	if err != nil {
+		return winacl.NtSecurityDescriptor{}, err
+	}
+	return winacl.NewNtSecurityDescriptor(sdBytes)
+}
+
+// ntfsFileInfo is the fs.FileInfo of files on an ntfsFS. Sys returns
+// the ntfsFileInfo itself, so callers can ask for its descriptor
+type ntfsFileInfo struct {
+	fsys       *ntfsFS
+	entry      *parser.MFT_ENTRY
+	name       string
+	size       int64
+	dir        bool
+	modTime    time.Time
+	securityID uint32
+}
+
+func (i *ntfsFileInfo) Name() string       { return i.name }
+func (i *ntfsFileInfo) Size() int64        { return i.size }
+func (i *ntfsFileInfo) ModTime() time.Time { return i.modTime }
+func (i *ntfsFileInfo) IsDir() bool        { return i.dir }
+func (i *ntfsFileInfo) Sys() interface{}   { return i }
+
+func (i *ntfsFileInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+// SecurityDescriptor returns the descriptor the file has on disk
+func (i *ntfsFileInfo) SecurityDescriptor() (winacl.NtSecurityDescriptor, error) {
+	return i.fsys.securityDescriptor(i)
+}
+
+type ntfsFile struct {
+	*io.SectionReader
+	info *ntfsFileInfo
+}
+
+func (f *ntfsFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *ntfsFile) Close() error               { return nil }
+
+type ntfsDir struct {
+	fsys    *ntfsFS
+	entry   *parser.MFT_ENTRY
+	info    *ntfsFileInfo
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (d *ntfsDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *ntfsDir) Close() error               { return nil }
+
+func (d *ntfsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errors.New("is a directory")}
+}
+
+func (d *ntfsDir) ReadDir(count int) ([]fs.DirEntry, error) {
+	if !d.read {
+		d.entries = d.list()
+		d.read = true
+	}
+	return readDirEntries(&d.entries, count)
+}
+
+// list reads the directory's $I30 index. Each file appears once per
+// name, so DOS 8.3 aliases are dropped while hard links are kept
+func (d *ntfsDir) list() []fs.DirEntry {
+	self := uint64(d.entry.Record_number())
+	seen := make(map[string]bool)
+	var entries []fs.DirEntry
+	for _, record := range d.entry.Dir(d.fsys.ntfs) {
+		fileName := record.File()
+		ref := record.MftReference()
+		if ref == self || fileName.NameType().Name == "DOS" {
+			continue
+		}
+
+		name := fileName.Name()
+		key := fmt.Sprintf("%d/%s", ref, strings.ToLower(name))
+		if seen[key] || name == "" {
+			continue
+		}
+		seen[key] = true
+
+		child, err := d.fsys.ntfs.GetMFT(int64(ref))
+		if err != nil {
+			continue
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(d.fsys.fileInfo(child, name)))
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries
+}
+
+// sdsHeader precedes each descriptor in the $SDS stream, and is the
+// data of each $SII index entry
+type sdsHeader struct {
+	Hash       uint32
+	SecurityID uint32
+	Offset     uint64
+	Length     uint32
+}
+
+const sdsHeaderSize = 20
+
+// maxDescriptorSize bounds the descriptors read, whose lengths come from
+// the volume unchecked. Windows keeps security descriptors within 64K
+const maxDescriptorSize = 64 << 10
+
+// maxSDSEntrySize bounds the $SDS entries read, whose lengths come from
+// $SII
+const maxSDSEntrySize = sdsHeaderSize + maxDescriptorSize
+
+// maxIndexBlockSize bounds the INDX blocks of $SII, which are a few
+// clusters at most
+const maxIndexBlockSize = 64 << 10
+
+// ntfsSecure resolves SecurityIds through the $SII index of $Secure
+// into the descriptors stored in its $SDS stream. Lookups are locked, as
+// a report abandoned after -timeout may still be making one
+type ntfsSecure struct {
+	sds   io.ReaderAt
+	index map[uint32]sdsHeader
+
+	lock        sync.Mutex
+	descriptors map[uint32]winacl.NtSecurityDescriptor
+}
+
+func newNTFSSecure(ntfs *parser.NTFSContext) (*ntfsSecure, error) {
+	secure, err := ntfs.GetMFT(ntfsSecureRecord)
+	if err != nil {
+		return nil, err
+	}
+
+	sds, err := parser.OpenStream(ntfs, secure, parser.ATTR_TYPE_DATA,
+		parser.WILDCARD_STREAM_ID, "$SDS")
+	if err != nil {
+		return nil, fmt.Errorf("$SDS: %s", err)
+	}
+
+	s := &ntfsSecure{
+		sds:         sds,
+		index:       make(map[uint32]sdsHeader),
+		descriptors: make(map[uint32]winacl.NtSecurityDescriptor),
+	}
+	err = s.readSII(ntfs, secure)
+	if err != nil {
+		return nil, fmt.Errorf("$SII: %s", err)
+	}
+	return s, nil
+}
+
+// readSII collects every entry of the $SII index, from its root and from
+// each INDX block in use. The B+ tree is not walked: every entry is kept
+func (s *ntfsSecure) readSII(ntfs *parser.NTFSContext, secure *parser.MFT_ENTRY) error {
+	rootStream, err := parser.OpenStream(ntfs, secure, parser.ATTR_TYPE_INDEX_ROOT,
+		parser.WILDCARD_STREAM_ID, "$SII")
+	if err != nil {
+		return err
+	}
+	// the index root is resident, so within one MFT record
+	root, err := readStream(rootStream, ntfs.RecordSize)
+	if err != nil {
+		return err
+	}
+
+	// INDEX_ROOT is followed by the node header of the root node
+	if len(root) < 0x20 {
+		return errors.New("index root too short")
+	}
+	blockSize := int64(binary.LittleEndian.Uint32(root[8:]))
+	s.readIndexNode(root, 0x10)
+
+	allocation, err := parser.OpenStream(ntfs, secure, parser.ATTR_TYPE_INDEX_ALLOCATION,
+		parser.WILDCARD_STREAM_ID, "$SII")
+	if err != nil {
+		// small indexes fit within the root
+		return nil
+	}
+	if blockSize == 0 || blockSize > maxIndexBlockSize {
+		return fmt.Errorf("index block size %d is not valid", blockSize)
+	}
+	size := parser.RangeSize(allocation)
+	if volumeSize := ntfsVolumeSize(ntfs); size > volumeSize {
+		return fmt.Errorf("index allocation of %d bytes is larger than the volume", size)
+	}
+
+	// the bitmap has a bit for each block, rounded up to a cluster
+	var bitmap []byte
+	bitmapStream, err := parser.OpenStream(ntfs, secure, attrTypeBitmap,
+		parser.WILDCARD_STREAM_ID, "$SII")
+	if err == nil {
+		bitmap, _ = readStream(bitmapStream, (size/blockSize+7)/8+ntfs.ClusterSize)
+	}
+
+	for vcn := int64(0); (vcn+1)*blockSize <= size; vcn++ {
+		if bitmap != nil && (vcn/8 >= int64(len(bitmap)) || bitmap[vcn/8]&(1<<(vcn%8)) == 0) {
+			continue
+		}
+
+		block := make([]byte, blockSize)
+		_, err := allocation.ReadAt(block, vcn*blockSize)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if string(block[:4]) != "INDX" || applyFixups(block) != nil {
+			continue
+		}
+		s.readIndexNode(block, 0x18)
+	}
+	return nil
+}
+
+// readIndexNode adds the entries of the node whose header is at offset.
+// Each entry's key is a SecurityId and its data the matching sdsHeader
+func (s *ntfsSecure) readIndexNode(buf []byte, offset int) {
+	if offset+16 > len(buf) {
+		return
+	}
+	start := offset + int(binary.LittleEndian.Uint32(buf[offset:]))
+	end := offset + int(binary.LittleEndian.Uint32(buf[offset+4:]))
+	if end > len(buf) {
+		end = len(buf)
+	}
+
+	for pos := start; pos+16 <= end; {
+		dataOffset := int(binary.LittleEndian.Uint16(buf[pos:]))
+		dataLength := int(binary.LittleEndian.Uint16(buf[pos+2:]))
+		entryLength := int(binary.LittleEndian.Uint16(buf[pos+8:]))
+		flags := binary.LittleEndian.Uint16(buf[pos+12:])
+
+		// the last entry of a node carries no key
+		if flags&0x2 != 0 || entryLength == 0 {
+			return
+		}
+
+		data := pos + dataOffset
+		if dataLength >= sdsHeaderSize && data+sdsHeaderSize <= len(buf) {
+			var header sdsHeader
+			binary.Read(bytes.NewReader(buf[data:data+sdsHeaderSize]), binary.LittleEndian, &header)
+			s.index[header.SecurityID] = header
+		}
+		pos += entryLength
+	}
+}
+
+func (s *ntfsSecure) lookup(id uint32) (winacl.NtSecurityDescriptor, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if sd, ok := s.descriptors[id]; ok {
+		return sd, nil
+	}
+
+	header, ok := s.index[id]
+	if !ok || header.Length < sdsHeaderSize {
+		return winacl.NtSecurityDescriptor{}, fmt.Errorf("SecurityId %d not in $SII", id)
+	}
+	if header.Length > maxSDSEntrySize {
+		return winacl.NtSecurityDescriptor{}, fmt.Errorf("SecurityId %d: $SDS entry of %d bytes is too large", id, header.Length)
+	}
+
+	entry := make([]byte, header.Length)
+	_, err := s.sds.ReadAt(entry, int64(header.Offset))
+	if err != nil && err != io.EOF {
+		return winacl.NtSecurityDescriptor{}, err
+	}
+	if binary.LittleEndian.Uint32(entry[4:]) != id {
+		return winacl.NtSecurityDescriptor{}, fmt.Errorf("SecurityId %d: $SDS entry at %#x is for %d",
+			id, header.Offset, binary.LittleEndian.Uint32(entry[4:]))
+	}
+
+	sd, err := winacl.NewNtSecurityDescriptor(entry[sdsHeaderSize:])
+	if err != nil {
+		return sd, fmt.Errorf("SecurityId %d: %s", id, err)
+	}
+	s.descriptors[id] = sd
+	return sd, nil
+}
+
+// applyFixups restores the last two bytes of each 512 byte stride of a
+// multi-sector record from its update sequence array
+func applyFixups(record []byte) error {
+	usaOffset := int(binary.LittleEndian.Uint16(record[4:]))
+	usaCount := int(binary.LittleEndian.Uint16(record[6:]))
+	if usaCount == 0 || usaOffset+usaCount*2 > len(record) || (usaCount-1)*512 > len(record) {
+		return errors.New("bad update sequence array")
+	}
+
+	usn := record[usaOffset : usaOffset+2]
+	for i := 1; i < usaCount; i++ {
+		end := i*512 - 2
+		if !bytes.Equal(record[end:end+2], usn) {
+			return fmt.Errorf("torn write in sector %d", i-1)
+		}
+		copy(record[end:end+2], record[usaOffset+i*2:usaOffset+i*2+2])
+	}
+	return nil
+}
+
+// ntfsVolumeSize is the size of a volume in bytes, from its boot sector
+func ntfsVolumeSize(ntfs *parser.NTFSContext) int64 {
+	return ntfs.Boot.VolumeSize() * int64(ntfs.Boot.Sector_size())
+}
+
+// readStream reads a whole NTFS attribute stream of at most limit bytes.
+// Its size comes from a runlist, which a corrupt volume may make huge
+func readStream(stream parser.RangeReaderAt, limit int64) ([]byte, error) {
+	size := parser.RangeSize(stream)
+	if size < 0 || size > limit {
+		return nil, fmt.Errorf("stream of %d bytes is too large", size)
+	}
+	buf := make([]byte, size)
+	n, err := stream.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// pathBase is path.Base, except the root of an fs.FS is named "/"
+func pathBase(name string) string {
+	if name == "." {
+		return "/"
+	}
+	return name[strings.LastIndex(name, "/")+1:]
+}
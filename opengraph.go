@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strings"
+
+	winacl "github.com/kgoins/go-winacl/pkg"
+)
+
+// OpenGraph node and edge kinds. Principals are matched by SID, so keep the
+// kinds BloodHound gave them when collecting Active Directory
+const (
+	openGraphSourceKind = "Ino"
+
+	kindBinary    = "InoBinary"
+	kindDirectory = "InoDirectory"
+	kindPrincipal = "InoPrincipal"
+
+	kindImports           = "Imports"
+	kindDelayImports      = "DelayImports"
+	kindForwards          = "Forwards"
+	kindContainsFile      = "ContainsFile"
+	kindCanWriteFile      = "CanWriteFile"
+	kindCanReplaceBinary  = "CanReplaceBinary"
+	kindCanWriteDirectory = "CanWriteDirectory"
+)
+
+// openGraphEdgeKinds are the OpenGraph kinds of the edges of a graph
+var openGraphEdgeKinds = map[string]string{
+	edgeImports:      kindImports,
+	edgeDelayImports: kindDelayImports,
+	edgeForwards:     kindForwards,
+	edgeContains:     kindContainsFile,
+}
+
+// Rights that give each permission edge. Writing to a file changes the
+// binary in place, while taking control of its DACL lets it be swapped
+// for another. Deleting a file, or the children of its directory, only
+// swaps it when a file can also be added to the directory in its place.
+// Adding a file to a directory plants a DLL that is loaded in place of
+// one further down the search order
+const (
+	writeFileRights      = winacl.FileWriteData | winacl.FileAppendData
+	replaceBinaryRights  = winacl.AccessMaskWriteDACL | winacl.AccessMaskWriteOwner
+	writeDirectoryRights = winacl.FileAddFile | winacl.AccessMaskWriteDACL | winacl.AccessMaskWriteOwner
+)
+
+type openGraphFile struct {
+	Metadata struct {
+		SourceKind string `json:"source_kind"`
+	} `json:"metadata"`
+	Graph struct {
+		Nodes []openGraphNode `json:"nodes"`
+		Edges []openGraphEdge `json:"edges"`
+	} `json:"graph"`
+}
+
+type openGraphNode struct {
+	ID         string                 `json:"id"`
+	Kinds      []string               `json:"kinds"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type openGraphEdge struct {
+	Kind       string                 `json:"kind"`
+	Start      openGraphEnd           `json:"start"`
+	End        openGraphEnd           `json:"end"`
+	Properties map[string]interface{} `json:"properties,omitempty"`
+}
+
+type openGraphEnd struct {
+	Value   string `json:"value"`
+	MatchBy string `json:"match_by"`
+}
+
+// writeOpenGraph prints a graph as BloodHound OpenGraph JSON, with an
+// edge from each principal a DACL grants a dangerous right to the file or
+// directory it holds for. Well-known SIDs, which BloodHound prefixes with
+// the domain they were collected from, are prefixed with domain when one
+// is given
+func writeOpenGraph(g *graph, w io.Writer, domain string) error {
+	file := &openGraphFile{}
+	file.Metadata.SourceKind = openGraphSourceKind
+	file.Graph.Nodes = []openGraphNode{}
+	file.Graph.Edges = []openGraphEdge{}
+	nodes := &file.Graph.Nodes
+	edges := &file.Graph.Edges
+
+	edge := func(kind, from, to string, properties map[string]interface{}) {
+		*edges = append(*edges, openGraphEdge{
+			Kind:       kind,
+			Start:      openGraphEnd{Value: from, MatchBy: "id"},
+			End:        openGraphEnd{Value: to, MatchBy: "id"},
+			Properties: properties,
+		})
+	}
+
+	for _, node := range g.nodes {
+		kinds := []string{kindBinary, "Ino" + node.Label}
+		if node.Label == labelDirectory {
+			kinds = []string{kindDirectory}
+		}
+		*nodes = append(*nodes, openGraphNode{ID: node.ID, Kinds: kinds, Properties: openGraphProperties(map[string]interface{}{
+			"name":    node.Name,
+			"path":    node.Path,
+			"dir":     node.Dir,
+			"class":   node.Class,
+			"imphash": node.ImpHash,
+			"signer":  node.Signer,
+			"missing": node.Missing,
+			"apiset":  node.APISet,
+		})})
+	}
+
+	contents := make(map[*graphNode][]*graphNode)
+	parents := make(map[*graphNode]*graphNode)
+	for _, e := range g.edges {
+		if e.Type == edgeContains {
+			contents[e.From] = append(contents[e.From], e.To)
+			parents[e.To] = e.From
+		}
+		var properties map[string]interface{}
+		if e.Type != edgeContains {
+			properties = map[string]interface{}{"functions": e.Functions, "count": len(e.Functions)}
+		}
+		edge(openGraphEdgeKinds[e.Type], e.From.ID, e.To.ID, properties)
+	}
+
+	// the rights of each principal are needed on a file and on its
+	// directory, so are all summed before any edge is drawn
+	grants := make(map[*graphNode][]grant)
+	rightsOn := make(map[*graphNode]map[string]uint32)
+	principals := make(map[string]bool)
+	for _, node := range g.nodes {
+		if node.Report == nil || node.Report.DACL == nil {
+			continue
+		}
+		grants[node] = effectiveRights(node.Report.DACL)
+		rightsOn[node] = make(map[string]uint32)
+		for _, grant := range grants[node] {
+			id := principalID(grant, domain)
+			rightsOn[node][id] = grant.mask
+			if !principals[id] {
+				principals[id] = true
+				*nodes = append(*nodes, openGraphNode{ID: id, Kinds: []string{kindPrincipal}, Properties: openGraphProperties(map[string]interface{}{
+					"principal": grant.principal,
+				})})
+			}
+		}
+	}
+
+	for _, node := range g.nodes {
+		for _, grant := range grants[node] {
+			id := principalID(grant, domain)
+			rights := map[string]interface{}{"mask": grant.mask}
+
+			if node.Label != labelDirectory {
+				if grant.mask&writeFileRights != 0 {
+					edge(kindCanWriteFile, id, node.ID, rights)
+				}
+				if grant.mask&replaceBinaryRights != 0 {
+					edge(kindCanReplaceBinary, id, node.ID, rights)
+				} else if parent := parents[node]; grant.mask&winacl.AccessMaskDelete != 0 && parent != nil && rightsOn[parent][id]&winacl.FileAddFile != 0 {
+					edge(kindCanReplaceBinary, id, node.ID, map[string]interface{}{"mask": grant.mask, "via": parent.Path})
+				}
+				continue
+			}
+			if grant.mask&writeDirectoryRights != 0 {
+				edge(kindCanWriteDirectory, id, node.ID, rights)
+			}
+			// deleting a directory's children, and adding files in their
+			// place, replaces any of them
+			if grant.mask&winacl.FileDeleteChild != 0 && grant.mask&winacl.FileAddFile != 0 {
+				for _, child := range contents[node] {
+					edge(kindCanReplaceBinary, id, child.ID, map[string]interface{}{"mask": grant.mask, "via": node.Path})
+				}
+			}
+		}
+	}
+
+	out := bufio.NewWriter(w)
+	if err := json.NewEncoder(out).Encode(file); err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+// openGraphProperties drops empty strings and false flags, which
+// BloodHound would otherwise store on every node
+func openGraphProperties(properties map[string]interface{}) map[string]interface{} {
+	for key, value := range properties {
+		if value == "" || value == false {
+			delete(properties, key)
+		}
+	}
+	return properties
+}
+
+// grant is the rights a DACL gives a principal
+type grant struct {
+	sid       string
+	principal string
+	mask      uint32
+}
+
+// effectiveRights sums the rights each principal is allowed by a DACL, less
+// those it is denied, with generic rights mapped to file rights.
+// Inherit-only ACEs do not apply to the object itself and are skipped.
+// Group memberships are not known, so a right denied to a group is not
+// taken from its members. ACEs from datasets printed without masks grant
+// nothing
+func effectiveRights(dacl *DACL) []grant {
+	var grants []*grant
+	byKey := make(map[string]*grant)
+	denied := make(map[string]uint32)
+	for _, ace := range dacl.Aces {
+		if ace.InheritOnly || ace.Mask == 0 {
+			continue
+		}
+		key := ace.SID
+		if key == "" {
+			key = ace.Principal
+		}
+		mask := winacl.FileGenericMapping.Map(ace.Mask)
+		switch ace.Type {
+		case "ACCESS_ALLOWED":
+			g, ok := byKey[key]
+			if !ok {
+				g = &grant{sid: ace.SID, principal: ace.Principal}
+				byKey[key] = g
+				grants = append(grants, g)
+			}
+			g.mask |= mask
+		case "ACCESS_DENIED":
+			denied[key] |= mask
+		}
+	}
+
+	var effective []grant
+	for _, g := range grants {
+		key := g.sid
+		if key == "" {
+			key = g.principal
+		}
+		if mask := g.mask &^ denied[key]; mask != 0 {
+			effective = append(effective, grant{g.sid, g.principal, mask})
+		}
+	}
+	return effective
+}
+
+// principalID is the OpenGraph id of a principal: its SID, prefixed with
+// the domain for well-known SIDs as BloodHound names them, or its name
+// when the dataset has no SIDs
+func principalID(g grant, domain string) string {
+	if g.sid == "" {
+		return strings.ToUpper(g.principal)
+	}
+	if domain != "" && !strings.HasPrefix(g.sid, "S-1-5-21-") {
+		return strings.ToUpper(domain) + "-" + g.sid
+	}
+	return g.sid
+}
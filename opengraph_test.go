@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+
+	winacl "github.com/kgoins/go-winacl/pkg"
+)
+
+const (
+	testUserSID  = "S-1-5-21-1-2-3-1001"
+	testGroupSID = "S-1-5-32-545"
+)
+
+func allow(sid string, mask uint32) ReadableAce {
+	return ReadableAce{Type: "ACCESS_ALLOWED", Principal: sid, SID: sid, Mask: mask}
+}
+
+func deny(sid string, mask uint32) ReadableAce {
+	return ReadableAce{Type: "ACCESS_DENIED", Principal: sid, SID: sid, Mask: mask}
+}
+
+func TestEffectiveRights(t *testing.T) {
+	inheritOnly := allow(testUserSID, winacl.AccessMaskWriteDACL)
+	inheritOnly.InheritOnly = true
+	byName := ReadableAce{Type: "ACCESS_ALLOWED", Principal: "Everyone", Mask: winacl.FileWriteData}
+
+	tests := []struct {
+		name string
+		aces []ReadableAce
+		want []grant
+	}{
+		{"allowed rights are summed", []ReadableAce{
+			allow(testUserSID, winacl.FileWriteData),
+			allow(testGroupSID, winacl.AccessMaskDelete),
+			allow(testUserSID, winacl.FileAppendData),
+		}, []grant{
+			{testUserSID, testUserSID, winacl.FileWriteData | winacl.FileAppendData},
+			{testGroupSID, testGroupSID, winacl.AccessMaskDelete},
+		}},
+		{"denied rights are taken away", []ReadableAce{
+			deny(testUserSID, winacl.FileWriteData),
+			allow(testUserSID, winacl.FileWriteData|winacl.AccessMaskDelete),
+		}, []grant{{testUserSID, testUserSID, winacl.AccessMaskDelete}}},
+		{"wholly denied principals are dropped", []ReadableAce{
+			allow(testUserSID, winacl.FileWriteData),
+			deny(testUserSID, winacl.AccessMaskGenericAll),
+		}, nil},
+		{"group denials are not applied to other principals", []ReadableAce{
+			deny(testGroupSID, winacl.FileWriteData),
+			allow(testUserSID, winacl.FileWriteData),
+		}, []grant{{testUserSID, testUserSID, winacl.FileWriteData}}},
+		{"inherit-only ACEs are skipped", []ReadableAce{
+			inheritOnly,
+			allow(testUserSID, winacl.FileAppendData),
+		}, []grant{{testUserSID, testUserSID, winacl.FileAppendData}}},
+		{"generic rights are mapped", []ReadableAce{
+			allow(testUserSID, winacl.AccessMaskGenericWrite),
+			allow(testGroupSID, winacl.AccessMaskGenericAll),
+		}, []grant{
+			{testUserSID, testUserSID, winacl.FileGenericMapping.Write},
+			{testGroupSID, testGroupSID, winacl.FileGenericMapping.All},
+		}},
+		{"ACEs without masks are skipped", []ReadableAce{
+			{Type: "ACCESS_ALLOWED", Principal: "Everyone", Rights: []string{"GENERIC_ALL"}},
+		}, nil},
+		{"principals without SIDs are keyed by name", []ReadableAce{byName}, []grant{
+			{"", "Everyone", winacl.FileWriteData},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := effectiveRights(&DACL{Aces: test.aces})
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+// TestOpenGraphReplaceBinary draws the edges of a user's rights on a DLL
+// and on its directory
+func TestOpenGraphReplaceBinary(t *testing.T) {
+	const (
+		dir = "c:/app"
+		dll = "c:/app/a.dll"
+	)
+	tests := []struct {
+		name      string
+		dirRights uint32
+		dllRights uint32
+		want      []string
+	}{
+		{"delete alone", 0, winacl.AccessMaskDelete, nil},
+		{"delete with add file", winacl.FileAddFile, winacl.AccessMaskDelete, []string{
+			"CanWriteDirectory " + dir,
+			"CanReplaceBinary " + dll + " via " + dir,
+		}},
+		{"write DAC", 0, winacl.AccessMaskWriteDACL, []string{"CanReplaceBinary " + dll}},
+		{"write owner", 0, winacl.AccessMaskWriteOwner, []string{"CanReplaceBinary " + dll}},
+		{"write data", 0, winacl.FileWriteData, []string{"CanWriteFile " + dll}},
+		{"delete child alone", winacl.FileDeleteChild, 0, nil},
+		{"delete child with add file", winacl.FileDeleteChild | winacl.FileAddFile, 0, []string{
+			"CanWriteDirectory " + dir,
+			"CanReplaceBinary " + dll + " via " + dir,
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dacl := func(mask uint32) *DACL {
+				if mask == 0 {
+					return &DACL{}
+				}
+				return &DACL{Aces: []ReadableAce{allow(testUserSID, mask)}}
+			}
+			g := newGraph([]*Report{
+				{Type: "directory", Name: "app", Path: dir, DACL: dacl(test.dirRights)},
+				{Type: "file", Name: "a.dll", Path: dll, Dir: dir, Class: "dll", DACL: dacl(test.dllRights)},
+			})
+
+			var b bytes.Buffer
+			if err := writeOpenGraph(g, &b, ""); err != nil {
+				t.Fatal(err)
+			}
+			var file openGraphFile
+			if err := json.Unmarshal(b.Bytes(), &file); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, e := range file.Graph.Edges {
+				if e.Start.Value != testUserSID {
+					continue
+				}
+				edge := e.Kind + " " + e.End.Value
+				if via, ok := e.Properties["via"]; ok {
+					edge += " via " + via.(string)
+				}
+				got = append(got, edge)
+			}
+			sort.Strings(got)
+			sort.Strings(test.want)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got edges %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/Velocidex/yaml/v2"
+)
+
+// Output formats for reports and error records
+const (
+	formatJSONLines = "jsonl"
+	formatJSON      = "json"
+	formatArray     = "array"
+	formatCSV       = "csv"
+	formatYAML      = "yaml"
+	formatTemplate  = "template"
+)
+
+// encoder writes reports, and error records, in an output format. close
+// finishes formats that need it, like the closing bracket of an array
+type encoder interface {
+	encode(record interface{}) error
+	close() error
+}
+
+// output is the encoder reports and error records are printed with
+var output encoder = &jsonLinesEncoder{w: os.Stdout}
+
+// outputFlags adds the flags that choose the output format, returning a
+// function that sets output once they are parsed
+func outputFlags(flags *flag.FlagSet) func() error {
+	format := flags.String("format", formatJSONLines, "Print reports as [jsonl|json|array|csv|yaml|template]")
+	text := flags.String("template", "", "Go text/template to print each report with, or @file to read it from a file.\nImplies -format template")
+
+	return func() error {
+		if *text != "" {
+			*format = formatTemplate
+		}
+		switch *format {
+		case formatJSONLines:
+			output = &jsonLinesEncoder{w: os.Stdout}
+		case formatJSON:
+			output = &jsonEncoder{w: os.Stdout}
+		case formatArray:
+			output = &arrayEncoder{w: os.Stdout}
+		case formatCSV:
+			output = newCSVEncoder(os.Stdout)
+		case formatYAML:
+			output = &yamlEncoder{w: os.Stdout}
+		case formatTemplate:
+			if *text == "" {
+				return fmt.Errorf("-format template needs -template")
+			}
+			encoder, err := newTemplateEncoder(os.Stdout, *text)
+			if err != nil {
+				return err
+			}
+			output = encoder
+		default:
+			return fmt.Errorf("unknown format %q", *format)
+		}
+		return nil
+	}
+}
+
+// emit prints a record with output. A record that cannot be printed,
+// most likely by a template, ends the program, as would every other
+func emit(record interface{}) {
+	err := output.encode(record)
+	if err != nil {
+		output.close()
+		log.Fatal(err)
+	}
+}
+
+// jsonLinesEncoder prints each record as a line of compact JSON
+type jsonLinesEncoder struct {
+	w io.Writer
+}
+
+func (e *jsonLinesEncoder) encode(record interface{}) error {
+	serialized, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(e.w, string(serialized))
+	return err
+}
+
+func (e *jsonLinesEncoder) close() error { return nil }
+
+// jsonEncoder prints each record as indented JSON
+type jsonEncoder struct {
+	w io.Writer
+}
+
+func (e *jsonEncoder) encode(record interface{}) error {
+	serialized, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(e.w, string(serialized))
+	return err
+}
+
+func (e *jsonEncoder) close() error { return nil }
+
+// arrayEncoder prints every record as an element of a single indented
+// JSON array. The array is only valid JSON once closed
+type arrayEncoder struct {
+	w       io.Writer
+	started bool
+}
+
+func (e *arrayEncoder) encode(record interface{}) error {
+	serialized, err := json.MarshalIndent(record, "  ", "  ")
+	if err != nil {
+		return err
+	}
+	separator := ",\n"
+	if !e.started {
+		separator = "[\n"
+		e.started = true
+	}
+	_, err = fmt.Fprintf(e.w, "%s  %s", separator, serialized)
+	return err
+}
+
+func (e *arrayEncoder) close() error {
+	if !e.started {
+		_, err := fmt.Fprintln(e.w, "[]")
+		return err
+	}
+	_, err := fmt.Fprintln(e.w, "\n]")
+	return err
+}
+
+// yamlEncoder prints each record as a YAML document. Records are
+// converted through JSON, so that their keys and order are the same as
+// in the JSON formats
+type yamlEncoder struct {
+	w io.Writer
+}
+
+func (e *yamlEncoder) encode(record interface{}) error {
+	serialized, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	var document yaml.MapSlice
+	err = yaml.Unmarshal(serialized, &document)
+	if err != nil {
+		return err
+	}
+	serialized, err = yaml.Marshal(document)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(e.w, "---\n%s", serialized)
+	return err
+}
+
+func (e *yamlEncoder) close() error { return nil }
+
+// csvColumns are the columns of the CSV format. Reports are flattened
+// to a row for each function they import, delay import, export or
+// forward, with Relation import, delay-import, export or forward, or a
+// single row when they have none. Error records fill in Phase and Message
+var csvColumns = []string{"Type", "Name", "Path", "Dir", "Class", "ImpHash", "Relation", "Host", "Function", "Phase", "Message"}
+
+// csvEncoder prints records as CSV rows, under a header. The header is
+// written with the first record, or on close when there were none, so
+// that nothing is printed by commands that never use the encoder
+type csvEncoder struct {
+	w       *csv.Writer
+	started bool
+}
+
+func newCSVEncoder(w io.Writer) *csvEncoder {
+	return &csvEncoder{w: csv.NewWriter(w)}
+}
+
+func (e *csvEncoder) header() {
+	if !e.started {
+		e.w.Write(csvColumns)
+		e.started = true
+	}
+}
+
+func (e *csvEncoder) encode(record interface{}) error {
+	switch record.(type) {
+	case *Report, ErrorRecord:
+		e.header()
+	}
+	switch record := record.(type) {
+	case *Report:
+		row := func(relation, host, function string) {
+			e.w.Write([]string{record.Type, record.Name, record.Path, record.Dir, record.Class, record.ImpHash, relation, host, function, "", ""})
+		}
+		rows := 0
+		for _, imp := range record.Imports {
+			for _, function := range imp.Functions {
+				row("import", imp.Host, function)
+				rows++
+			}
+		}
+		for _, imp := range record.DelayImports {
+			for _, function := range imp.Functions {
+				row("delay-import", imp.Host, function)
+				rows++
+			}
+		}
+		for _, export := range record.Exports {
+			row("export", "", export)
+			rows++
+		}
+		for _, forward := range record.Forwards {
+			for _, function := range forward.Functions {
+				row("forward", forward.Host, function)
+				rows++
+			}
+		}
+		if rows == 0 {
+			row("", "", "")
+		}
+	case ErrorRecord:
+		e.w.Write([]string{record.Type, "", record.Path, "", "", "", "", "", "", record.Phase, record.Message})
+	default:
+		return fmt.Errorf("cannot print %T as CSV", record)
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvEncoder) close() error {
+	e.header()
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// templateEncoder prints each record with a Go text/template, given the
+// record as dot. Reports and error records are told apart by .Type
+type templateEncoder struct {
+	w        io.Writer
+	template *template.Template
+}
+
+// templateFuncs are the functions templates may call, beyond the
+// text/template builtins
+var templateFuncs = template.FuncMap{
+	"join":  strings.Join,
+	"lower": strings.ToLower,
+	"json": func(v interface{}) (string, error) {
+		serialized, err := json.Marshal(v)
+		return string(serialized), err
+	},
+}
+
+// newTemplateEncoder parses a template, or reads it from the file that
+// follows an @
+func newTemplateEncoder(w io.Writer, text string) (*templateEncoder, error) {
+	if strings.HasPrefix(text, "@") {
+		data, err := os.ReadFile(text[1:])
+		if err != nil {
+			return nil, err
+		}
+		text = string(data)
+	}
+	t, err := template.New("report").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &templateEncoder{w: w, template: t}, nil
+}
+
+// encode prints a record, followed by a newline unless the template
+// printed one. Records the template prints nothing for are skipped
+func (e *templateEncoder) encode(record interface{}) error {
+	var out bytes.Buffer
+	err := e.template.Execute(&out, record)
+	if err != nil || out.Len() == 0 {
+		return err
+	}
+	if !bytes.HasSuffix(out.Bytes(), []byte("\n")) {
+		out.WriteByte('\n')
+	}
+	_, err = e.w.Write(out.Bytes())
+	return err
+}
+
+func (e *templateEncoder) close() error { return nil }
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// testReport is a report with a row of each CSV relation
+func testReport() *Report {
+	return &Report{
+		Name:         "foo.dll",
+		Path:         "/bin/foo.dll",
+		Dir:          "/bin",
+		Type:         "file",
+		Class:        "dll",
+		ImpHash:      "0123",
+		Exports:      []string{"Foo"},
+		Imports:      []PEFunction{{Host: "kernel32.dll", Functions: []string{"LoadLibraryA", "GetProcAddress"}}},
+		DelayImports: []PEFunction{{Host: "user32.dll", Functions: []string{"MessageBoxA"}}},
+		Forwards:     []PEFunction{{Host: "bar.dll", Functions: []string{"Bar"}}},
+	}
+}
+
+var testError = ErrorRecord{Type: "error", Path: "/bin/bad.dll", Phase: "parse", Message: "not a PE, with \"quotes\""}
+
+func TestCSVEncoder(t *testing.T) {
+	const header = "Type,Name,Path,Dir,Class,ImpHash,Relation,Host,Function,Phase,Message\n"
+	tests := []struct {
+		name    string
+		records []interface{}
+		want    string
+	}{
+		{"no records", nil, header},
+		{"report", []interface{}{testReport()}, header +
+			"file,foo.dll,/bin/foo.dll,/bin,dll,0123,import,kernel32.dll,LoadLibraryA,,\n" +
+			"file,foo.dll,/bin/foo.dll,/bin,dll,0123,import,kernel32.dll,GetProcAddress,,\n" +
+			"file,foo.dll,/bin/foo.dll,/bin,dll,0123,delay-import,user32.dll,MessageBoxA,,\n" +
+			"file,foo.dll,/bin/foo.dll,/bin,dll,0123,export,,Foo,,\n" +
+			"file,foo.dll,/bin/foo.dll,/bin,dll,0123,forward,bar.dll,Bar,,\n"},
+		{"report without functions", []interface{}{&Report{Name: "bar.exe", Type: "file"}}, header +
+			"file,bar.exe,,,,,,,,,\n"},
+		{"error", []interface{}{testError}, header +
+			"error,,/bin/bad.dll,,,,,,,parse,\"not a PE, with \"\"quotes\"\"\"\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var b bytes.Buffer
+			e := newCSVEncoder(&b)
+			if b.Len() != 0 {
+				t.Fatalf("header printed before any record: %q", b.String())
+			}
+			for _, record := range test.records {
+				if err := e.encode(record); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := e.close(); err != nil {
+				t.Fatal(err)
+			}
+			if b.String() != test.want {
+				t.Errorf("got\n%s\nwant\n%s", b.String(), test.want)
+			}
+		})
+	}
+
+	t.Run("rejects other records", func(t *testing.T) {
+		var b bytes.Buffer
+		if err := newCSVEncoder(&b).encode(WIMImage{}); err == nil {
+			t.Error("encoded a WIMImage as CSV")
+		}
+		if b.Len() != 0 {
+			t.Errorf("printed %q for a rejected record", b.String())
+		}
+	})
+}
+
+func TestArrayEncoder(t *testing.T) {
+	for _, records := range [][]interface{}{nil, {testError}, {testReport(), testError}} {
+		var b bytes.Buffer
+		e := &arrayEncoder{w: &b}
+		for _, record := range records {
+			if err := e.encode(record); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := e.close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if records == nil {
+			if b.String() != "[]\n" {
+				t.Errorf("got %q for no records, want []", b.String())
+			}
+			continue
+		}
+		var decoded []map[string]interface{}
+		if err := json.Unmarshal(b.Bytes(), &decoded); err != nil {
+			t.Fatalf("%s: %s", err, b.Bytes())
+		}
+		if len(decoded) != len(records) {
+			t.Errorf("got %d elements, want %d", len(decoded), len(records))
+		}
+	}
+}
+
+func TestYAMLEncoder(t *testing.T) {
+	var b bytes.Buffer
+	e := &yamlEncoder{w: &b}
+	for _, record := range []interface{}{testError, testError} {
+		if err := e.encode(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// keys keep the order of the JSON formats
+	document := "---\nType: error\nPath: /bin/bad.dll\nPhase: parse\nMessage: not a PE, with \"quotes\"\n"
+	if want := document + document; b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestTemplateEncoder(t *testing.T) {
+	tests := []struct {
+		name, template, want string
+	}{
+		{"newline added", "{{.Path}}", "/bin/foo.dll\n/bin/bad.dll\n"},
+		{"newline kept", "{{.Path}}\n", "/bin/foo.dll\n/bin/bad.dll\n"},
+		{"records skipped", `{{if eq .Type "file"}}{{.Name}}{{end}}`, "foo.dll\n"},
+		{"functions", `{{if eq .Type "file"}}{{join .Exports ","}} {{lower .Class}} {{json .Forwards}}{{end}}`,
+			"Foo dll [{\"Host\":\"bar.dll\",\"Functions\":[\"Bar\"]}]\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var b bytes.Buffer
+			e, err := newTemplateEncoder(&b, test.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, record := range []interface{}{testReport(), testError} {
+				if err := e.encode(record); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if b.String() != test.want {
+				t.Errorf("got %q, want %q", b.String(), test.want)
+			}
+		})
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		if _, err := newTemplateEncoder(&bytes.Buffer{}, "{{.Path"); err == nil {
+			t.Error("parsed an unterminated action")
+		}
+		if _, err := newTemplateEncoder(&bytes.Buffer{}, "@"+t.TempDir()+"/missing"); err == nil {
+			t.Error("read a missing template file")
+		}
+		e, err := newTemplateEncoder(&bytes.Buffer{}, "{{.Exports}}")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := e.encode(testError); err == nil || !strings.Contains(err.Error(), "Exports") {
+			t.Errorf("got %v executing a missing field", err)
+		}
+	})
+}
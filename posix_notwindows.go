@@ -0,0 +1,290 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// POSIXPerms holds the ownership, mode and ACLs of a file
+type POSIXPerms struct {
+	UID    uint32 `json:"UID"`
+	GID    uint32 `json:"GID"`
+	Owner  string `json:"Owner,omitempty"`
+	Group  string `json:"Group,omitempty"`
+	Mode   string `json:"Mode"`
+	Perm   string `json:"Perm"`
+	Setuid bool   `json:"Setuid"`
+	Setgid bool   `json:"Setgid"`
+	Sticky bool   `json:"Sticky"`
+
+	ACL        []POSIXACLEntry `json:"ACL,omitempty"`
+	DefaultACL []POSIXACLEntry `json:"DefaultACL,omitempty"`
+
+	// Writable is set when -writable-by is given
+	Writable *bool `json:"Writable,omitempty"`
+
+	mode uint32
+}
+
+// POSIXACLEntry is a single entry of a POSIX ACL
+type POSIXACLEntry struct {
+	Tag  string  `json:"Tag"`
+	ID   *uint32 `json:"ID,omitempty"`
+	Name string  `json:"Name,omitempty"`
+	Perm string  `json:"Perm"`
+
+	tag  uint16
+	perm uint16
+}
+
+// Extended attributes holding a file's POSIX ACLs
+const (
+	posixACLAccessXattr  = "system.posix_acl_access"
+	posixACLDefaultXattr = "system.posix_acl_default"
+)
+
+// POSIX ACL entry tags, as stored in their extended attributes
+const (
+	aclUserObj  = 0x01
+	aclUser     = 0x02
+	aclGroupObj = 0x04
+	aclGroup    = 0x08
+	aclMask     = 0x10
+	aclOther    = 0x20
+)
+
+var aclTagLookup = map[uint16]string{
+	aclUserObj:  "user_obj",
+	aclUser:     "user",
+	aclGroupObj: "group_obj",
+	aclGroup:    "group",
+	aclMask:     "mask",
+	aclOther:    "other",
+}
+
+const (
+	aclXattrVersion = 2
+	aclWrite        = 0x2
+)
+
+// userNames and groupNames cache the names of IDs. A report abandoned
+// after -timeout may still be looking names up, so they are locked
+var (
+	namesLock  sync.Mutex
+	userNames  = make(map[uint32]string)
+	groupNames = make(map[uint32]string)
+)
+
+// newPOSIXPerms reads the ownership, mode and ACLs of a file. A symlink
+// is followed, as it is its target that is loaded
+func newPOSIXPerms(path string) (*POSIXPerms, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil, fmt.Errorf("no ownership information for %s", path)
+	}
+
+	mode := info.Mode()
+	perms := &POSIXPerms{
+		UID:    stat.Uid,
+		GID:    stat.Gid,
+		Owner:  userName(stat.Uid),
+		Group:  groupName(stat.Gid),
+		Mode:   mode.String(),
+		Perm:   fmt.Sprintf("%04o", uint32(stat.Mode)&07777),
+		Setuid: mode&os.ModeSetuid != 0,
+		Setgid: mode&os.ModeSetgid != 0,
+		Sticky: mode&os.ModeSticky != 0,
+		mode:   uint32(stat.Mode) & 07777,
+	}
+
+	perms.ACL, err = readPOSIXACL(path, posixACLAccessXattr)
+	if err != nil {
+		return perms, err
+	}
+	if info.IsDir() {
+		perms.DefaultACL, err = readPOSIXACL(path, posixACLDefaultXattr)
+	}
+	return perms, err
+}
+
+// readPOSIXACL decodes a POSIX ACL extended attribute. A file without one
+// has only the ACL implied by its mode, so nil is returned
+func readPOSIXACL(path, xattr string) ([]POSIXACLEntry, error) {
+	data, err := getxattr(path, xattr)
+	if errors.Is(err, errNoXattr) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return decodePOSIXACL(xattr, data)
+}
+
+// decodePOSIXACL decodes the value of a POSIX ACL extended attribute: a
+// version, followed by a tag, permissions and ID for each entry
+func decodePOSIXACL(xattr string, data []byte) ([]POSIXACLEntry, error) {
+	if len(data) < 4 || (len(data)-4)%8 != 0 {
+		return nil, fmt.Errorf("%s: invalid length %d", xattr, len(data))
+	}
+	if version := binary.LittleEndian.Uint32(data); version != aclXattrVersion {
+		return nil, fmt.Errorf("%s: unsupported version %d", xattr, version)
+	}
+
+	var entries []POSIXACLEntry
+	for offset := 4; offset < len(data); offset += 8 {
+		entry := POSIXACLEntry{
+			tag:  binary.LittleEndian.Uint16(data[offset:]),
+			perm: binary.LittleEndian.Uint16(data[offset+2:]),
+		}
+		entry.Tag = aclTagLookup[entry.tag]
+		if entry.Tag == "" {
+			entry.Tag = fmt.Sprintf("0x%x", entry.tag)
+		}
+		entry.Perm = rwx(entry.perm)
+
+		id := binary.LittleEndian.Uint32(data[offset+4:])
+		switch entry.tag {
+		case aclUser:
+			entry.ID = &id
+			entry.Name = userName(id)
+		case aclGroup:
+			entry.ID = &id
+			entry.Name = groupName(id)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func rwx(perm uint16) string {
+	sb := strings.Builder{}
+	for i, c := range "rwx" {
+		if perm&(4>>i) != 0 {
+			sb.WriteRune(c)
+		} else {
+			sb.WriteByte('-')
+		}
+	}
+	return sb.String()
+}
+
+// writableBy reports whether a user, with the given groups, may write to
+// the file, following the POSIX ACL access check algorithm
+func (p *POSIXPerms) writableBy(uid uint32, gids []uint32) bool {
+	if uid == 0 {
+		return true
+	}
+
+	acl := p.ACL
+	if acl == nil {
+		// the minimal ACL implied by the mode
+		acl = []POSIXACLEntry{
+			{tag: aclUserObj, perm: uint16(p.mode>>6) & 7},
+			{tag: aclGroupObj, perm: uint16(p.mode>>3) & 7},
+			{tag: aclOther, perm: uint16(p.mode) & 7},
+		}
+	}
+
+	mask := uint16(7)
+	for _, entry := range acl {
+		if entry.tag == aclMask {
+			mask = entry.perm
+		}
+	}
+
+	inGroup := func(gid uint32) bool {
+		for _, g := range gids {
+			if g == gid {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, entry := range acl {
+		switch {
+		case entry.tag == aclUserObj && uid == p.UID:
+			return entry.perm&aclWrite != 0
+		case entry.tag == aclUser && entry.ID != nil && *entry.ID == uid:
+			return entry.perm&mask&aclWrite != 0
+		}
+	}
+
+	groupMatched := false
+	for _, entry := range acl {
+		matched := (entry.tag == aclGroupObj && inGroup(p.GID)) ||
+			(entry.tag == aclGroup && entry.ID != nil && inGroup(*entry.ID))
+		if !matched {
+			continue
+		}
+		groupMatched = true
+		if entry.perm&mask&aclWrite != 0 {
+			return true
+		}
+	}
+	if groupMatched {
+		return false
+	}
+
+	for _, entry := range acl {
+		if entry.tag == aclOther {
+			return entry.perm&aclWrite != 0
+		}
+	}
+	return false
+}
+
+// handlePOSIXPerms adds the ownership, mode and ACLs of the report's file,
+// and whether the -writable-by user may write to it
+func handlePOSIXPerms(report *Report) error {
+	perms, err := newPOSIXPerms(report.Path)
+	report.POSIX = perms
+	if err != nil {
+		return err
+	}
+
+	if writableBy.set {
+		writable := perms.writableBy(writableBy.uid, writableBy.gids)
+		perms.Writable = &writable
+	}
+	return nil
+}
+
+func userName(uid uint32) string {
+	namesLock.Lock()
+	defer namesLock.Unlock()
+	name, ok := userNames[uid]
+	if !ok {
+		if u, err := user.LookupId(strconv.FormatUint(uint64(uid), 10)); err == nil {
+			name = u.Username
+		}
+		userNames[uid] = name
+	}
+	return name
+}
+
+func groupName(gid uint32) string {
+	namesLock.Lock()
+	defer namesLock.Unlock()
+	name, ok := groupNames[gid]
+	if !ok {
+		if g, err := user.LookupGroupId(strconv.FormatUint(uint64(gid), 10)); err == nil {
+			name = g.Name
+		}
+		groupNames[gid] = name
+	}
+	return name
+}
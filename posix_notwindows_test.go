@@ -0,0 +1,157 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestNamesRace(t *testing.T) {
+	raceAbandoned(t, func() {
+		userName(uint32(os.Getuid()))
+		groupName(uint32(os.Getgid()))
+	})
+}
+
+func TestDecodePOSIXACL(t *testing.T) {
+	// user_obj rwx, user 1001 r--, group_obj r-x, group 200 rw-, mask rw-, other r--
+	data, _ := hex.DecodeString("02000000" +
+		"01000700ffffffff" + "02000400e9030000" + "04000500ffffffff" +
+		"08000600c8000000" + "10000600ffffffff" + "20000400ffffffff")
+	entries, err := decodePOSIXACL(posixACLAccessXattr, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, entry := range entries {
+		s := entry.Tag + ":" + entry.Perm
+		if entry.ID != nil {
+			s += ":" + strconv.FormatUint(uint64(*entry.ID), 10)
+		}
+		got = append(got, s)
+	}
+	want := []string{"user_obj:rwx", "user:r--:1001", "group_obj:r-x", "group:rw-:200", "mask:rw-", "other:r--"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	for _, bad := range []string{"", "020000", "02000000010007", "03000000" + "01000700ffffffff"} {
+		data, _ := hex.DecodeString(bad)
+		if _, err := decodePOSIXACL(posixACLAccessXattr, data); err == nil {
+			t.Errorf("decoded %q", bad)
+		}
+	}
+}
+
+func TestWritableBy(t *testing.T) {
+	acl := func(mask uint16) []POSIXACLEntry {
+		id := func(id uint32) *uint32 { return &id }
+		return []POSIXACLEntry{
+			{tag: aclUserObj, perm: 6},
+			{tag: aclUser, ID: id(1001), perm: 6},
+			{tag: aclUser, ID: id(1002), perm: 4},
+			{tag: aclGroupObj, perm: 4},
+			{tag: aclGroup, ID: id(200), perm: 6},
+			{tag: aclMask, perm: mask},
+			{tag: aclOther, perm: 6},
+		}
+	}
+	tests := []struct {
+		name string
+		mode uint32
+		acl  []POSIXACLEntry
+		uid  uint32
+		gids []uint32
+		want bool
+	}{
+		{"root", 0444, nil, 0, nil, true},
+		{"owner by mode", 0644, nil, 1000, nil, true},
+		{"group by mode", 0664, nil, 1003, []uint32{100}, true},
+		{"group denied by mode", 0646, nil, 1003, []uint32{100}, false},
+		{"other by mode", 0646, nil, 1003, []uint32{300}, true},
+		{"other denied by mode", 0664, nil, 1003, nil, false},
+		{"owner is not masked", 0, acl(4), 1000, nil, true},
+		{"named user", 0, acl(6), 1001, nil, true},
+		{"named user masked", 0, acl(4), 1001, nil, false},
+		{"named user without write", 0, acl(6), 1002, []uint32{200}, false},
+		{"named group", 0, acl(6), 1003, []uint32{100, 200}, true},
+		{"named group masked", 0, acl(4), 1003, []uint32{200}, false},
+		{"owning group", 0, acl(6), 1003, []uint32{100}, false},
+		{"other", 0, acl(4), 1003, []uint32{300}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			perms := &POSIXPerms{UID: 1000, GID: 100, mode: test.mode, ACL: test.acl}
+			if got := perms.writableBy(test.uid, test.gids); got != test.want {
+				t.Errorf("got %t, want %t", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWritableByFlag(t *testing.T) {
+	tests := []struct {
+		value string
+		want  writableByUser
+		ok    bool
+	}{
+		{"1000", writableByUser{uid: 1000, set: true}, true},
+		{"1000:", writableByUser{uid: 1000, set: true}, true},
+		{"1000:100,200", writableByUser{uid: 1000, gids: []uint32{100, 200}, set: true}, true},
+		{"jdoe", writableByUser{}, false},
+		{"1000:users", writableByUser{}, false},
+		{"-1", writableByUser{}, false},
+	}
+	for _, test := range tests {
+		var w writableByUser
+		err := w.Set(test.value)
+		if (err == nil) != test.ok || !reflect.DeepEqual(w, test.want) {
+			t.Errorf("%q: got %+v and error %v", test.value, w, err)
+		}
+		if test.ok && w.String() != strings.TrimSuffix(test.value, ":") {
+			t.Errorf("%q: printed as %q", test.value, w.String())
+		}
+	}
+}
+
+func TestPOSIXPermsFollowsSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "target.dll")
+	if err := os.WriteFile(target, []byte("MZ"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(target, 0644); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "link.dll")
+	if err := os.Symlink(target, link); err != nil {
+		t.Skip(err)
+	}
+
+	set := writableBy
+	t.Cleanup(func() { writableBy = set })
+	writableBy = writableByUser{uid: uint32(os.Getuid()) + 1, set: true}
+
+	report := &Report{Path: link}
+	if err := handlePOSIXPerms(report); err != nil {
+		t.Fatal(err)
+	}
+	perms := report.POSIX
+	if perms.Mode != "-rw-r--r--" || perms.Perm != "0644" {
+		t.Errorf("got mode %s and perm %s, want the target's -rw-r--r-- and 0644", perms.Mode, perms.Perm)
+	}
+	if perms.UID != uint32(os.Getuid()) || perms.GID != uint32(os.Getgid()) {
+		t.Errorf("got owner %d:%d, want %d:%d", perms.UID, perms.GID, os.Getuid(), os.Getgid())
+	}
+	if perms.Writable == nil || *perms.Writable {
+		t.Errorf("another user may write to a 0644 target through a symlink")
+	}
+}
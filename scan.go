@@ -0,0 +1,430 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+
+	winacl "github.com/kgoins/go-winacl/pkg"
+)
+
+// securityDescribed is implemented by the fs.FileInfo.Sys of file
+// systems that store Windows security descriptors, like ntfsFS
+type securityDescribed interface {
+	SecurityDescriptor() (winacl.NtSecurityDescriptor, error)
+}
+
+func scanMain(args []string) error {
+	flags := flag.NewFlagSet("scan", flag.ExitOnError)
+	newSelector := selectorFlags(flags)
+	offset := flags.Int64("offset", 0, "Byte offset of a volume within the disk, instead of reading its partition table")
+	partitionIndex := flags.Int("partition", 0, "Only scan this partition, numbered from 1. All by default")
+	root := flags.String("root", "/", "Directory within each volume to scan")
+	imageIndex := flags.Int("image", 0, "Only scan this image of a WIM, numbered from 1. All by default")
+	list := flags.Bool("list", false, "List the images of a WIM instead of scanning them")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: ino scan [options] <image>\n\n")
+		fmt.Fprintf(flags.Output(), "Report the PEs on the NTFS and FAT volumes of a raw image, block device,\n")
+		fmt.Fprintf(flags.Output(), "VHD or VHDX, in the images of a WIM or ESD, or in a ZIP, ISO, UDF, CAB\n")
+		fmt.Fprintf(flags.Output(), "or MSI, without mounting or extracting it\n")
+		flags.PrintDefaults()
+	}
+	errorFlags(flags)
+	newOutput := outputFlags(flags)
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	sel, err := newSelector()
+	if err == nil {
+		err = checkFailOn()
+	}
+	if err == nil {
+		err = newOutput()
+	}
+	if _, csv := output.(*csvEncoder); err == nil && *list && csv {
+		err = errors.New("-list cannot be printed as CSV")
+	}
+	if err != nil {
+		fmt.Fprintln(flags.Output(), err)
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	imageName := flags.Arg(0)
+	image, err := os.Open(imageName)
+	if err != nil {
+		return err
+	}
+	defer image.Close()
+
+	tag := make([]byte, len(wimTag))
+	image.ReadAt(tag, 0)
+	if string(tag) == wimTag {
+		err = scanWIM(image, imageName, *imageIndex, *list, *root, sel)
+	} else {
+		err = scanImage(image, imageName, *offset, *partitionIndex, *root, sel)
+	}
+	if err != nil {
+		return err
+	}
+	if *list {
+		return output.close()
+	}
+	finishScan()
+	return nil
+}
+
+// scanImage scans a container, or the volumes of a disk. A malformed disk
+// or volume that panics outside of a walk is returned as an error
+func scanImage(image *os.File, imageName string, offset int64, partitionIndex int, root string, sel *selector) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			summary.Panics++
+			err = fmt.Errorf("%s panic: %v", imageName, r)
+		}
+	}()
+
+	info, err := image.Stat()
+	if err != nil {
+		return err
+	}
+	if fsys, err := openContainer(image, info.Size(), nil); err == nil {
+		return scanFS(fsys, root, imageName+"!", sel)
+	} else if !errors.Is(err, errNotContainer) {
+		return fmt.Errorf("%s %s", imageName, err)
+	}
+
+	disk, size, sectorSize, err := openDisk(image)
+	if err != nil {
+		return fmt.Errorf("%s %s", imageName, err)
+	}
+
+	var partitions []partition
+	if offset != 0 {
+		partitions = []partition{{Offset: offset, Size: size - offset}}
+	} else {
+		partitions, err = readPartitions(disk, size, sectorSize)
+		if err != nil {
+			return fmt.Errorf("%s %s", imageName, err)
+		}
+		if partitions == nil {
+			// an image of a single volume
+			partitions = []partition{{Size: size}}
+		}
+	}
+
+	if partitionIndex != 0 {
+		var selected []partition
+		for _, p := range partitions {
+			if p.Index == partitionIndex {
+				selected = append(selected, p)
+			}
+		}
+		if selected == nil {
+			return fmt.Errorf("%s has no partition %d", imageName, partitionIndex)
+		}
+		partitions = selected
+	}
+
+	for _, p := range partitions {
+		// paths are prefixed by their partition when there is a choice
+		prefix := ""
+		if len(partitions) > 1 {
+			prefix = fmt.Sprintf("/partition%d", p.Index)
+		}
+
+		fsys, err := openVolume(disk, p.Offset, p.Size)
+		if err != nil && len(partitions) == 1 {
+			return fmt.Errorf("%s %s", imageName, err)
+		} else if errors.Is(err, errUnknownFS) {
+			continue
+		} else if err != nil {
+			printError(imageName+prefix, phaseContainer, err)
+			continue
+		}
+		if ntfs, ok := fsys.(*ntfsFS); ok && ntfs.secureErr != nil {
+			printError(imageName+prefix, phaseDACL, ntfs.secureErr)
+		}
+
+		err = scanFS(fsys, root, prefix, sel)
+		if err != nil {
+			printError(imageName+prefix, phaseWalk, err)
+		}
+	}
+	return nil
+}
+
+// scanWIM scans the images of a WIM, or lists them
+func scanWIM(f *os.File, wimName string, index int, list bool, root string, sel *selector) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	wim, err := newWIMArchive(f, info.Size())
+	if err != nil {
+		return fmt.Errorf("%s %s", wimName, err)
+	}
+
+	if list {
+		for _, image := range wim.images {
+			emit(image)
+		}
+		return nil
+	}
+
+	indexes := []int{index}
+	if index == 0 {
+		indexes = nil
+		for i := range wim.metadata {
+			indexes = append(indexes, i+1)
+		}
+	}
+
+	for _, i := range indexes {
+		// paths are prefixed by their image when there is a choice
+		prefix := ""
+		if len(indexes) > 1 {
+			prefix = fmt.Sprintf("/image%d", i)
+		}
+
+		fsys, err := wim.image(i)
+		if err != nil && len(indexes) == 1 {
+			return fmt.Errorf("%s %s", wimName, err)
+		} else if err != nil {
+			printError(wimName+prefix, phaseContainer, err)
+			continue
+		}
+
+		err = scanFS(fsys, root, prefix, sel)
+		if err != nil {
+			printError(wimName+prefix, phaseWalk, err)
+		}
+	}
+	return nil
+}
+
+// scanContainer scans the files of a ZIP, ISO 9660 or UDF image, cabinet
+// or MSI, including those of containers within it. Their paths follow
+// the container's path and a !, as in setup.msi!/Data1.cab!/foo.dll. A
+// malformed container that panics is recorded, and the scan goes on, as
+// are containers nested more than maxContainerNesting deep
+func scanContainer(f fs.File, containerPath string, sel *selector) {
+	defer func() {
+		if r := recover(); r != nil {
+			summary.Panics++
+			printError(containerPath, phaseContainer, fmt.Errorf("panic: %v", r))
+		}
+	}()
+
+	if sel.nesting > maxContainerNesting {
+		printError(containerPath, phaseContainer, fmt.Errorf("containers are nested more than %d deep", maxContainerNesting))
+		return
+	}
+	info, err := f.Stat()
+	if err != nil {
+		printError(containerPath, phaseOpen, err)
+		return
+	}
+	r, release, err := readerOf(f)
+	if err != nil {
+		printError(containerPath, phaseOpen, err)
+		return
+	}
+	defer release()
+	fsys, err := openContainer(r, info.Size(), info.Sys())
+	if errors.Is(err, errNotContainer) {
+		return
+	} else if err != nil {
+		printError(containerPath, phaseContainer, err)
+		return
+	}
+
+	err = scanFS(fsys, ".", containerPath+"!", sel)
+	if err != nil {
+		printError(containerPath, phaseWalk, err)
+	}
+}
+
+// scanFS reports each PE beneath root that the selector chooses. As
+// with -dir, a report for the PE's directory is printed before the first
+// PE found in it. Reported paths are prefixed with prefix
+func scanFS(fsys fs.FS, root string, prefix string, sel *selector) error {
+	root = strings.Trim(path.Clean("/"+root), "/")
+	if root == "" {
+		root = "."
+	}
+
+	printedParentDir := make(map[string]bool)
+	return fs.WalkDir(fsys, root, func(name string, d fs.DirEntry, err error) (walkErr error) {
+		// a malformed file system that panics on one file is recorded,
+		// and the walk goes on
+		defer func() {
+			if r := recover(); r != nil {
+				summary.Panics++
+				printError(imagePath(prefix, name), phaseWalk, fmt.Errorf("panic: %v", r))
+				walkErr = nil
+			}
+		}()
+
+		if err != nil {
+			if d == nil {
+				return err
+			}
+			printError(imagePath(prefix, name), phaseWalk, err)
+			return nil
+		}
+
+		rel := name
+		if root != "." {
+			rel = strings.TrimPrefix(strings.TrimPrefix(name, root), "/")
+		}
+		if d.IsDir() {
+			if name != root && sel.skipDir(rel) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			printError(imagePath(prefix, name), phaseOpen, err)
+			return nil
+		}
+		container := isContainerName(name)
+		if sel.tooLarge(info.Size()) || container && sel.skipDir(rel) || !container && !sel.matchName(rel) {
+			return nil
+		}
+
+		f, err := fsys.Open(name)
+		if err != nil {
+			printError(imagePath(prefix, name), phaseOpen, err)
+			return nil
+		}
+		defer f.Close()
+		if container {
+			scanContainer(f, imagePath(prefix, name), sel.within(rel))
+			return nil
+		}
+
+		// PEs are parsed in place when the file system can seek, and read
+		// into memory otherwise
+		r, release, err := readerOf(f)
+		if err != nil {
+			printError(imagePath(prefix, name), phaseOpen, err)
+			return nil
+		}
+		defer release()
+		class := peClass(r)
+		if !sel.match(name, class) {
+			return nil
+		}
+
+		parent := path.Dir(name)
+		if !printedParentDir[parent] {
+			dirReport, err := newImageDirectoryReport(fsys, prefix, parent)
+			printReport(dirReport)
+			if err != nil {
+				printError(dirReport.Path, phaseDACL, err)
+			}
+			printedParentDir[parent] = true
+		}
+
+		report := newImagePEReport(prefix, name)
+		report.Class = class
+		reportPE(report, r, func(report *Report) error {
+			return populateImageFilePerms(report, f)
+		})
+		return nil
+	})
+}
+
+// imagePath is the path of a file within an image, as reported
+func imagePath(prefix, name string) string {
+	if name == "." {
+		if prefix == "" {
+			return "/"
+		}
+		return prefix
+	}
+	return prefix + "/" + name
+}
+
+// newImageDirectoryReport reports a directory of an image. The report is
+// returned even when its permissions could not be read, with the error,
+// which is a panic reading a malformed descriptor too
+func newImageDirectoryReport(fsys fs.FS, prefix, name string) (report *Report, err error) {
+	report = &Report{}
+	report.Name = pathBase(name)
+	report.Path = imagePath(prefix, name)
+	report.Type = "directory"
+	report.Dir = imagePath(prefix, path.Dir(name))
+	defer func() {
+		if r := recover(); r != nil {
+			summary.Panics++
+			report.DACL, report.NewFileDACL = nil, nil
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		return report, err
+	}
+
+	sd, err := imageSecurityDescriptor(info)
+	if errors.Is(err, errNoDescriptor) {
+		return report, nil
+	} else if err != nil {
+		return report, err
+	}
+	dacl := newDACL(sd, winacl.FileObject)
+	report.DACL = &dacl
+	newFileDACL := newFileDACL(sd)
+	report.NewFileDACL = &newFileDACL
+	return report, nil
+}
+
+func newImagePEReport(prefix, name string) *Report {
+	report := &Report{}
+	report.Name = path.Base(name)
+	report.Path = imagePath(prefix, name)
+	report.Type = "file"
+	report.Dir = imagePath(prefix, path.Dir(name))
+	return report
+}
+
+// populateImageFilePerms adds the DACL stored for a file in an image,
+// when its file system stores one
+func populateImageFilePerms(report *Report, f fs.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	sd, err := imageSecurityDescriptor(info)
+	if errors.Is(err, errNoDescriptor) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	dacl := newDACL(sd, winacl.FileObject)
+	report.DACL = &dacl
+	return nil
+}
+
+// imageSecurityDescriptor returns the descriptor stored for a file in
+// an image, or errNoDescriptor when its file system has none
+func imageSecurityDescriptor(info fs.FileInfo) (winacl.NtSecurityDescriptor, error) {
+	described, ok := info.Sys().(securityDescribed)
+	if !ok {
+		return winacl.NtSecurityDescriptor{}, errNoDescriptor
+	}
+	return described.SecurityDescriptor()
+}
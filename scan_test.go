@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io"
+	"io/fs"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	winacl "github.com/kgoins/go-winacl/pkg"
+)
+
+// panickingDescriptor is the FileInfo.Sys of a file system whose
+// descriptors cannot be read without a panic
+type panickingDescriptor struct{}
+
+func (panickingDescriptor) SecurityDescriptor() (winacl.NtSecurityDescriptor, error) {
+	panic("malformed descriptor")
+}
+
+// panickingFS panics opening one file
+type panickingFS struct {
+	fs.FS
+	name string
+}
+
+func (p panickingFS) Open(name string) (fs.File, error) {
+	if name == p.name {
+		panic("malformed entry")
+	}
+	return p.FS.Open(name)
+}
+
+// testPE reads the PE that the fixtures of testdata hold
+func testPE(t *testing.T) []byte {
+	t.Helper()
+	fsys, err := openContainer(openTestData(t, "reserve.cab.gz"), 1<<20, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := fsys.Open("foo.dll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestScanFSPanics(t *testing.T) {
+	pe := testPE(t)
+	fsys := panickingFS{fstest.MapFS{
+		"bad":        {Mode: fs.ModeDir, Sys: panickingDescriptor{}},
+		"bad/a.dll":  {Data: pe},
+		"broken.dll": {Data: pe},
+		"good/b.dll": {Data: pe},
+	}, "broken.dll"}
+
+	r := record(t)
+	if err := scanFS(fsys, "/", "/volume", &selector{maxDepth: -1}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"/volume/bad/a.dll", "/volume/good/b.dll"}
+	if got := r.paths(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	var errors []string
+	for _, record := range r.errors {
+		errors = append(errors, record.Phase+" "+record.Path+" "+strings.SplitN(record.Message, ":", 2)[0])
+	}
+	// the directory is still reported, without its DACL
+	wantErrors := []string{"dacl /volume/bad panic", "walk /volume/broken.dll panic"}
+	if !reflect.DeepEqual(errors, wantErrors) {
+		t.Errorf("got errors %q, want %q", errors, wantErrors)
+	}
+	for _, report := range r.reports {
+		if report.Path == "/volume/bad" && report.DACL != nil {
+			t.Errorf("%s has a DACL", report.Path)
+		}
+	}
+	if summary.Directories != 2 || summary.Panics != 2 {
+		t.Errorf("got %d directories and %d panics, want 2 and 2", summary.Directories, summary.Panics)
+	}
+}
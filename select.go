@@ -0,0 +1,178 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Policies for symbolic links met while walking a directory
+const (
+	symlinksSkip   = "skip"
+	symlinksFiles  = "files"
+	symlinksFollow = "follow"
+)
+
+// selector decides which files of a walk are reported. A file is
+// reported when it is a PE of one of the selected classes, or has one of
+// the selected extensions, and passes the globs, depth and size limits.
+// With no classes or extensions, every PE is reported
+type selector struct {
+	classes    map[string]bool
+	extensions map[string]bool
+	include    stringList
+	exclude    stringList
+	maxDepth   int
+	maxSize    int64
+	symlinks   string
+
+	// nesting is how many containers deep the files selected are
+	nesting int
+}
+
+// selectorFlags adds the flags that choose which files are reported,
+// returning a function that builds the selector once they are parsed
+func selectorFlags(flags *flag.FlagSet) func() (*selector, error) {
+	sel := &selector{symlinks: symlinksFiles}
+	peType := flags.String("type", "", "Comma separated PE classes [exe|dll|native|efi] and extensions [.cpl,.sys,...]\nto report. Every PE by default")
+	flags.Var(&sel.include, "include", "Only report files matching this glob. May be repeated")
+	flags.Var(&sel.exclude, "exclude", "Skip files and directories matching this glob. May be repeated")
+	flags.IntVar(&sel.maxDepth, "max-depth", -1, "Descend at most this many directories, or containers, below the root")
+	maxSize := flags.String("max-size", "", "Skip files larger than this size, like 64M")
+
+	return func() (*selector, error) {
+		for _, word := range strings.Split(strings.ToLower(*peType), ",") {
+			word = strings.TrimSpace(word)
+			switch {
+			case word == "":
+			case peClasses[word]:
+				if sel.classes == nil {
+					sel.classes = make(map[string]bool)
+				}
+				sel.classes[word] = true
+			default:
+				if sel.extensions == nil {
+					sel.extensions = make(map[string]bool)
+				}
+				sel.extensions["."+strings.TrimPrefix(word, ".")] = true
+			}
+		}
+		for _, glob := range append(append([]string{}, sel.include...), sel.exclude...) {
+			if _, err := path.Match(glob, ""); err != nil {
+				return nil, fmt.Errorf("bad glob %q", glob)
+			}
+		}
+		if *maxSize != "" {
+			size, err := parseSize(*maxSize)
+			if err != nil {
+				return nil, err
+			}
+			sel.maxSize = size
+		}
+		return sel, nil
+	}
+}
+
+// parseSize reads a size in bytes, with an optional K, M or G suffix
+func parseSize(s string) (int64, error) {
+	digits := s
+	multiplier := int64(1)
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		multiplier = 1 << 10
+	case "M":
+		multiplier = 1 << 20
+	case "G":
+		multiplier = 1 << 30
+	}
+	if multiplier != 1 {
+		digits = s[:len(s)-1]
+	}
+	size, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || size < 0 || size > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("bad size %q", s)
+	}
+	return size * multiplier, nil
+}
+
+// matchGlob reports whether a path, relative to the root of the walk,
+// matches a glob. Globs with a slash match the whole path, and others its
+// last element. Matching ignores case
+func matchGlob(glob, rel string) bool {
+	glob, rel = strings.ToLower(glob), strings.ToLower(rel)
+	if !strings.Contains(glob, "/") {
+		rel = path.Base(rel)
+	}
+	matched, _ := path.Match(glob, rel)
+	return matched
+}
+
+// depth is the number of directories a path is below the root
+func depth(rel string) int {
+	if rel == "." || rel == "" {
+		return 0
+	}
+	return strings.Count(rel, "/") + 1
+}
+
+// skipDir reports whether a directory should not be descended into
+func (s *selector) skipDir(rel string) bool {
+	return s.maxDepth >= 0 && depth(rel) > s.maxDepth || s.excluded(rel)
+}
+
+func (s *selector) excluded(rel string) bool {
+	for _, glob := range s.exclude {
+		if matchGlob(glob, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// tooLarge reports whether a file, or container, is skipped for its size
+func (s *selector) tooLarge(size int64) bool {
+	return s.maxSize > 0 && size > s.maxSize
+}
+
+// matchName reports whether a file may be reported by its path, before
+// its content is read
+func (s *selector) matchName(rel string) bool {
+	if s.excluded(rel) {
+		return false
+	}
+	if len(s.include) == 0 {
+		return true
+	}
+	for _, glob := range s.include {
+		if matchGlob(glob, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// match reports whether a file of a PE class is reported. class is ""
+// for files that are not PEs, which never are
+func (s *selector) match(name, class string) bool {
+	if class == "" {
+		return false
+	}
+	if s.classes == nil && s.extensions == nil {
+		return true
+	}
+	return s.classes[class] || s.extensions[strings.ToLower(path.Ext(name))]
+}
+
+// within returns the selector for the files of a container at rel, which
+// is walked as a directory. Its globs match paths within the container
+func (s *selector) within(rel string) *selector {
+	inner := *s
+	inner.nesting++
+	if s.maxDepth >= 0 {
+		inner.maxDepth = s.maxDepth - depth(rel)
+	}
+	return &inner
+}
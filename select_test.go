@@ -0,0 +1,147 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"testing"
+)
+
+// testSelector builds a selector from the flags of a command line
+func testSelector(t *testing.T, args ...string) (*selector, error) {
+	t.Helper()
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	newSelector := selectorFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return newSelector()
+}
+
+func TestSelectorMatch(t *testing.T) {
+	tests := []struct {
+		args        []string
+		name, class string
+		want        bool
+	}{
+		{nil, "a.dll", classDLL, true},
+		{nil, "notes.txt", "", false},
+		{[]string{"-type", "dll"}, "a.dll", classDLL, true},
+		{[]string{"-type", "dll"}, "a.exe", classEXE, false},
+		// classes come from the headers, not the extension
+		{[]string{"-type", "dll"}, "a.exe", classDLL, true},
+		{[]string{"-type", "EXE, native"}, "smss.exe", classNative, true},
+		{[]string{"-type", ".cpl"}, "desk.CPL", classDLL, true},
+		{[]string{"-type", "cpl"}, "desk.cpl", classDLL, true},
+		{[]string{"-type", "cpl"}, "a.dll", classDLL, false},
+		{[]string{"-type", "efi,.sys"}, "a.sys", classNative, true},
+		{[]string{"-type", "efi,.sys"}, "boot.efi", classEFI, true},
+		// an extension does not make a file that is not a PE one
+		{[]string{"-type", ".sys"}, "a.sys", "", false},
+	}
+	for _, test := range tests {
+		sel, err := testSelector(t, test.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := sel.match(test.name, test.class); got != test.want {
+			t.Errorf("%q: match(%s, %s) is %t, want %t", test.args, test.name, test.class, got, test.want)
+		}
+	}
+}
+
+func TestSelectorNames(t *testing.T) {
+	tests := []struct {
+		args []string
+		rel  string
+		want bool
+	}{
+		{nil, "a/b/c.dll", true},
+		{[]string{"-include", "*.dll"}, "a/b/C.DLL", true},
+		{[]string{"-include", "*.dll"}, "a/b/c.exe", false},
+		{[]string{"-include", "*.dll", "-include", "*.exe"}, "c.exe", true},
+		{[]string{"-include", "windows/*/*.dll"}, "Windows/System32/c.dll", true},
+		{[]string{"-include", "windows/*/*.dll"}, "c.dll", false},
+		{[]string{"-exclude", "winsxs"}, "windows/winsxs", false},
+		{[]string{"-exclude", "*.mui"}, "en-us/a.dll.mui", false},
+		// exclusions win over inclusions
+		{[]string{"-include", "*.dll", "-exclude", "a*"}, "x/a.dll", false},
+	}
+	for _, test := range tests {
+		sel, err := testSelector(t, test.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := sel.matchName(test.rel); got != test.want {
+			t.Errorf("%q: matchName(%s) is %t, want %t", test.args, test.rel, got, test.want)
+		}
+	}
+}
+
+func TestSelectorLimits(t *testing.T) {
+	sel, err := testSelector(t, "-max-depth", "2", "-max-size", "64K", "-exclude", "skip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for size, want := range map[int64]bool{0: false, 64 << 10: false, 64<<10 + 1: true} {
+		if got := sel.tooLarge(size); got != want {
+			t.Errorf("tooLarge(%d) is %t, want %t", size, got, want)
+		}
+	}
+	for rel, want := range map[string]bool{".": false, "a": false, "a/b": false, "a/b/c": true, "a/skip": true} {
+		if got := sel.skipDir(rel); got != want {
+			t.Errorf("skipDir(%s) is %t, want %t", rel, got, want)
+		}
+	}
+
+	// a container a directory deep has one directory left within it
+	inner := sel.within("a/c.zip")
+	if inner.maxDepth != 0 || inner.nesting != 1 || !inner.skipDir("x") || inner.skipDir(".") {
+		t.Errorf("within gave max depth %d and nesting %d", inner.maxDepth, inner.nesting)
+	}
+	if sel.maxDepth != 2 || sel.nesting != 0 {
+		t.Error("within changed the outer selector")
+	}
+
+	unlimited, err := testSelector(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unlimited.tooLarge(1<<40) || unlimited.skipDir("a/b/c/d/e") || unlimited.within("a/b.zip").maxDepth != -1 {
+		t.Error("the default selector has limits")
+	}
+}
+
+func TestSelectorFlagErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{"-include", "[a"},
+		{"-exclude", "a\\"},
+		{"-max-size", "big"},
+		{"-max-size", "-1M"},
+		{"-max-size", "M"},
+	} {
+		if _, err := testSelector(t, args...); err == nil {
+			t.Errorf("%q gave no error", args)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for s, want := range map[string]int64{"0": 0, "512": 512, "2k": 2 << 10, "64M": 64 << 20, "1G": 1 << 30} {
+		if got, err := parseSize(s); err != nil || got != want {
+			t.Errorf("parseSize(%s) is %d, %v, want %d", s, got, err, want)
+		}
+	}
+
+	for s, want := range map[string]int64{"8589934591G": 8589934591 << 30, "9223372036854775807": math.MaxInt64} {
+		if got, err := parseSize(s); err != nil || got != want {
+			t.Errorf("parseSize(%s) is %d, %v, want %d", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"99999999999G", "8589934592G", "9007199254740992M", "9223372036854775808", "-1K", "1.5G"} {
+		_, err := parseSize(s)
+		if want := fmt.Sprintf("bad size %q", s); err == nil || err.Error() != want {
+			t.Errorf("parseSize(%s) gave error %v, want %s", s, err, want)
+		}
+	}
+}
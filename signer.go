@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"io"
+	"math/big"
+)
+
+// Authenticode fields
+const (
+	imageDirectorySecurity    = 4
+	winCertTypePKCSSignedData = 2
+	maxSignatureSize          = 1 << 20
+)
+
+// contentInfo and signedData are the PKCS #7 structures of an
+// Authenticode signature, as far as they are needed to find its signer
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      asn1.RawValue
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type signerInfo struct {
+	Version         int
+	IssuerAndSerial issuerAndSerial
+}
+
+type issuerAndSerial struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+// peSigner returns the common name, or else the organisation, of the
+// certificate an embedded Authenticode signature was signed with, or ""
+// when a PE has none. The signature is not verified. Files signed
+// through a catalog, as most of Windows is, carry no signature
+func peSigner(r io.ReaderAt) string {
+	h, err := readPEHeaders(r)
+	if err != nil {
+		return ""
+	}
+	offset, size := h.directory(imageDirectorySecurity)
+	if offset == 0 || size < 8 || size > maxSignatureSize {
+		return ""
+	}
+
+	// the first WIN_CERTIFICATE of the table
+	table := make([]byte, size)
+	if n, _ := r.ReadAt(table, int64(offset)); n != len(table) {
+		return ""
+	}
+	length := binary.LittleEndian.Uint32(table)
+	if binary.LittleEndian.Uint16(table[6:]) != winCertTypePKCSSignedData || length < 8 || length > size {
+		return ""
+	}
+
+	var content contentInfo
+	if _, err := asn1.Unmarshal(table[8:length], &content); err != nil {
+		return ""
+	}
+	var signed signedData
+	if _, err := asn1.Unmarshal(content.Content.Bytes, &signed); err != nil || len(signed.SignerInfos) == 0 {
+		return ""
+	}
+	certificates, err := x509.ParseCertificates(signed.Certificates.Bytes)
+	if err != nil {
+		return ""
+	}
+
+	signer := signed.SignerInfos[0].IssuerAndSerial
+	for _, certificate := range certificates {
+		if certificate.SerialNumber.Cmp(signer.Serial) != 0 || !bytes.Equal(certificate.RawIssuer, signer.Issuer.FullBytes) {
+			continue
+		}
+		if certificate.Subject.CommonName != "" {
+			return certificate.Subject.CommonName
+		}
+		if len(certificate.Subject.Organization) > 0 {
+			return certificate.Subject.Organization[0]
+		}
+	}
+	return ""
+}
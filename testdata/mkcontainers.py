@@ -0,0 +1,314 @@
+#!/usr/bin/env python3
+"""Generates the container fixtures of container_test.go.
+
+    python3 mkcontainers.py <PE>
+
+  nested.zip   bin/app.exe, stored.dll, and pkg/inner.nupkg holding
+               lib/net45/inner.dll
+  plain.cab    an MSZIP cabinet of pad.bin and sub\\foo.dll, spanning
+               two data blocks
+  reserve.cab  an uncompressed cabinet with reserved header, folder and
+               data block fields
+  a.msi        an MSI whose Data1.cab stores fil1234 and fil5678, named
+               foo.dll and BAR.EXE by its File table
+  bundle.zip   installers/setup.msi, a copy of a.msi
+  plain.iso    ISO 9660 with 8.3 names
+  joliet.iso   the same with a Joliet tree of long Unicode names
+  disc.udf     UDF with a file in two extents, one inline in its entry,
+               and a deleted one
+
+Each PE is the one given. Images are written gzipped, like the other
+fixtures
+"""
+import gzip, io, os, struct, sys, zipfile, zlib, random
+
+random.seed(1)
+pe = open(sys.argv[1], 'rb').read()
+rnd = bytes(random.getrandbits(8) for _ in range(40000))
+
+def write(name, data):
+    with open(name + '.gz', 'wb') as f:
+        with gzip.GzipFile(filename=name, fileobj=f, mode='wb', mtime=0) as z:
+            z.write(data)
+
+def zipped(files, compress=zipfile.ZIP_DEFLATED):
+    b = io.BytesIO()
+    with zipfile.ZipFile(b, 'w', compress) as z:
+        for name, data, *method in files:
+            info = zipfile.ZipInfo(name, (2020, 5, 17, 12, 30, 10))
+            info.compress_type = method[0] if method else compress
+            z.writestr(info, data)
+    return b.getvalue()
+
+# ---------------- ZIP (nested) ----------------
+inner = zipped([('lib/net45/inner.dll', pe), ('readme.txt', b'hi')])
+write('nested.zip', zipped([('bin/app.exe', pe), ('pkg/inner.nupkg', inner), ('stored.dll', pe, zipfile.ZIP_STORED)]))
+
+# ---------------- CAB ----------------
+def cab(files, compress=1, reserve=False):
+    # files: list of (name, data)
+    folder = b''.join(d for _, d in files)
+    blocks = []
+    prev = b''
+    for i in range(0, len(folder), 32768):
+        chunk = folder[i:i+32768]
+        if compress == 1:
+            dict_ = prev[-32768:]
+            c = zlib.compressobj(9, zlib.DEFLATED, -15, zdict=dict_) if dict_ else zlib.compressobj(9, zlib.DEFLATED, -15)
+            data = b'CK' + c.compress(chunk) + c.flush()
+        else:
+            data = chunk
+        prev += chunk
+        blocks.append((data, len(chunk)))
+    hdr_len = 36 + (4 + 0 if reserve else 0)
+    folder_len = 8 + (4 if reserve else 0)
+    files_off = hdr_len + folder_len
+    fe = b''
+    off = 0
+    for name, d in files:
+        fe += struct.pack('<IIHHHH', len(d), off, 0, (2020-1980) << 9 | 5 << 5 | 17, 12 << 11 | 30 << 5 | 5, 0x20) + name.encode() + b'\0'
+        off += len(d)
+    data_off = files_off + len(fe)
+    db = b''
+    for data, ulen in blocks:
+        db += struct.pack('<IHH', 0, len(data), ulen) + (b'RSVD' if reserve else b'') + data
+    total = data_off + len(db)
+    flags = 4 if reserve else 0
+    h = b'MSCF' + struct.pack('<IIIIIBBHHHHH', 0, total, 0, files_off, 0, 3, 1, 1, len(files), flags, 0, 0)
+    if reserve:
+        h += struct.pack('<HBB', 0, 4, 4)
+    fo = struct.pack('<IHH', data_off, len(blocks), compress) + (b'FRSV' if reserve else b'')
+    return h + fo + fe + db
+
+write('plain.cab', cab([('pad.bin', rnd), ('sub\\foo.dll', pe)]))
+write('reserve.cab', cab([('pad.bin', rnd), ('foo.dll', pe)], compress=0, reserve=True))
+
+# ---------------- ISO 9660 + Joliet ----------------
+S = 2048
+def both16(v): return struct.pack('<H', v) + struct.pack('>H', v)
+def both32(v): return struct.pack('<I', v) + struct.pack('>I', v)
+def drec(name, extent, size, flags):
+    date = bytes([120, 5, 17, 12, 30, 5, 0])
+    r = bytes([0, 0]) + both32(extent) + both32(size) + date + bytes([flags, 0, 0]) + both16(1) + bytes([len(name)]) + name
+    if len(r) % 2: r += b'\0'
+    return bytes([len(r)]) + r[1:]
+def dirdata(recs):
+    data = b''
+    for r in recs:
+        if len(data) % S + len(r) > S:
+            data += b'\0' * (S - len(data) % S)
+        data += r
+    return data + b'\0' * (-len(data) % S)
+def vd(kind, root, joliet=False, total=0):
+    d = bytearray(S)
+    d[0] = kind; d[1:6] = b'CD001'; d[6] = 1
+    d[80:88] = both32(total)
+    if joliet: d[88:91] = b'%/E'
+    d[120:124] = both16(1); d[124:128] = both16(1); d[128:132] = both16(S)
+    d[156:190] = root
+    return bytes(d)
+
+def iso(joliet):
+    # sectors: 16 pvd, 17 svd, 18 term, 20 root, 21 sys, 22 jroot, 23 jsys, 24.. files
+    f1 = 24; f2 = f1 + (len(pe) + S - 1) // S
+    end = f2 + (len(pe) + S - 1) // S
+    def tree(j):
+        enc = (lambda s: s.encode('utf-16-be')) if j else (lambda s: s.upper().encode())
+        rootext, sysext = (22, 23) if j else (20, 21)
+        sys_recs = [drec(b'\0', sysext, S, 2), drec(b'\1', rootext, S, 2),
+                    drec(enc('kernel32.dll') + (b'' if j else b';1'), f1, len(pe), 0),
+                    drec(enc('Ünïcode Läng Name.dll') if j else b'UNICODE.DLL;1', f2, len(pe), 0)]
+        root_recs = [drec(b'\0', rootext, S, 2), drec(b'\1', rootext, S, 2), drec(enc('System32'), sysext, S, 2)]
+        return dirdata(root_recs), dirdata(sys_recs)
+    img = bytearray(end * S)
+    r, s = tree(False)
+    img[20*S:21*S] = r; img[21*S:22*S] = s
+    img[16*S:17*S] = vd(1, drec(b'\0', 20, S, 2), total=end)
+    if joliet:
+        r, s = tree(True)
+        img[22*S:23*S] = r; img[23*S:24*S] = s
+        img[17*S:18*S] = vd(2, drec(b'\0', 22, S, 2), True, end)
+        img[18*S:19*S] = bytes([255]) + b'CD001' + bytes([1]) + bytes(S-7)
+    else:
+        img[17*S:18*S] = bytes([255]) + b'CD001' + bytes([1]) + bytes(S-7)
+    img[f1*S:f1*S+len(pe)] = pe
+    img[f2*S:f2*S+len(pe)] = pe
+    return bytes(img)
+
+write('joliet.iso', iso(True))
+write('plain.iso', iso(False))
+
+# ---------------- UDF ----------------
+def tag(ident, body_len=0):
+    return struct.pack('<HHBBHHHI', ident, 2, 0, 0, 1, 0, body_len, 0)
+def ts():
+    return struct.pack('<HHBBBBBBBB', 0x1000 | 60, 2020, 5, 17, 12, 30, 5, 0, 0, 0)
+def long_ad(length, block, part=0):
+    return struct.pack('<IIH', length, block, part) + bytes(6)
+def cs0(s):
+    try:
+        s.encode('latin-1'); return bytes([8]) + s.encode('latin-1')
+    except UnicodeEncodeError:
+        return bytes([16]) + s.encode('utf-16-be')
+def fid(name, block, char):
+    n = cs0(name) if name else b''
+    body = tag(257) + struct.pack('<HBB', 1, char, len(n)) + long_ad(S, block) + struct.pack('<H', 0) + n
+    return body + bytes(-len(body) % 4)
+def fe(ftype, size, ads, adtype, ext=False):
+    b = bytearray(S)
+    b[0:16] = tag(266 if ext else 261)
+    b[16+11] = ftype
+    b[16+18:16+20] = struct.pack('<H', adtype)
+    b[56:64] = struct.pack('<Q', size)
+    if ext:
+        b[92:104] = ts()
+        b[208:212] = struct.pack('<I', 0); b[212:216] = struct.pack('<I', len(ads)); b[216:216+len(ads)] = ads
+    else:
+        b[84:96] = ts()
+        b[168:172] = struct.pack('<I', 0); b[172:176] = struct.pack('<I', len(ads)); b[176:176+len(ads)] = ads
+    return bytes(b)
+
+def udf():
+    P = 300  # partition start sector
+    img = bytearray((P + 40) * S)
+    for i, ident in enumerate([b'BEA01', b'NSR03', b'TEA01']):
+        img[(16+i)*S:(16+i)*S+7] = bytes([0]) + ident + bytes([1])
+    # AVDP
+    img[256*S:256*S+16] = tag(2)
+    img[256*S+16:256*S+24] = struct.pack('<II', 4*S, 32)
+    pd = bytearray(S); pd[0:16] = tag(5); pd[22:24] = struct.pack('<H', 0); pd[188:196] = struct.pack('<II', P, 40)
+    lvd = bytearray(S); lvd[0:16] = tag(6); lvd[212:216] = struct.pack('<I', S)
+    lvd[248:264] = long_ad(S, 0)
+    lvd[264:268] = struct.pack('<I', 6); lvd[268:272] = struct.pack('<I', 1)
+    lvd[440:446] = bytes([1, 6]) + struct.pack('<HH', 1, 0)
+    td = bytearray(S); td[0:16] = tag(8)
+    img[32*S:33*S] = pd; img[33*S:34*S] = lvd; img[34*S:35*S] = td
+    def blk(n): return (P + n) * S
+    fsd = bytearray(S); fsd[0:16] = tag(256); fsd[400:416] = long_ad(S, 1)
+    img[blk(0):blk(1)] = fsd
+    # root dir FE at 1, data at 2
+    root = fid('', 1, 0x0A) + fid('System32', 3, 0x02) + fid('gone.dll', 5, 0x04)
+    img[blk(1):blk(2)] = fe(4, len(root), struct.pack('<II', len(root), 2), 0)
+    img[blk(2):blk(2)+len(root)] = root
+    # sys dir FE (extended) at 3, data at 4
+    sysd = fid('', 1, 0x0A) + fid('kernel32.dll', 5, 0) + fid('Ünïcode Läng €.dll', 6, 0) + fid('tiny.dll', 7, 0)
+    img[blk(3):blk(4)] = fe(4, len(sysd), long_ad(len(sysd), 4), 1, ext=True)
+    img[blk(4):blk(4)+len(sysd)] = sysd
+    # kernel32 at 5: two short_ads, first 4096 bytes at 10, rest at 20
+    a, b = 4096, len(pe) - 4096
+    img[blk(5):blk(6)] = fe(5, len(pe), struct.pack('<II', a, 10) + struct.pack('<II', b, 20), 0)
+    img[blk(10):blk(10)+a] = pe[:a]; img[blk(20):blk(20)+b] = pe[a:]
+    # unicode at 6: contiguous at 25
+    img[blk(6):blk(7)] = fe(5, len(pe), struct.pack('<II', len(pe), 25), 0)
+    img[blk(25):blk(25)+len(pe)] = pe
+    # tiny at 7: inline
+    tiny = b'MZ' + b'x' * 100
+    img[blk(7):blk(8)] = fe(5, len(tiny), tiny, 3)
+    return bytes(img)
+
+write('disc.udf', udf())
+
+# ---------------- MSI (CFB) ----------------
+def mime(c):
+    if '0' <= c <= '9': return ord(c) - 48
+    if 'A' <= c <= 'Z': return ord(c) - 55
+    if 'a' <= c <= 'z': return ord(c) - 61
+    if c == '.': return 62
+    if c == '_': return 63
+    return -1
+def encname(name, table=False):
+    out = '䡀' if table else ''
+    i = 0
+    while i < len(name):
+        a = mime(name[i])
+        if a < 0:
+            out += name[i]; i += 1; continue
+        if i + 1 < len(name) and mime(name[i+1]) >= 0:
+            out += chr(0x3800 + (mime(name[i+1]) << 6) + a); i += 2
+        else:
+            out += chr(0x4800 + a); i += 1
+    return out
+
+strings = ['File', 'Component_', 'FileName', 'FileSize', 'fil1234', 'FOO~1.DLL|foo.dll', 'comp1', 'fil5678', 'BAR.EXE']
+sid = {s: i + 1 for i, s in enumerate(strings)}
+pool = struct.pack('<HH', 1252, 0) + b''.join(struct.pack('<HH', len(s.encode()), 1) for s in strings)
+sdata = b''.join(s.encode() for s in strings)
+cols = [('File', 1, 'File', 0x2948), ('File', 2, 'Component_', 0x0948), ('File', 3, 'FileName', 0x0BFF), ('File', 4, 'FileSize', 0x0104)]
+coldata = b''.join(struct.pack('<H', sid[c[0]]) for c in cols) + b''.join(struct.pack('<H', c[1] ^ 0x8000) for c in cols) \
+    + b''.join(struct.pack('<H', sid[c[2]]) for c in cols) + b''.join(struct.pack('<H', c[3] ^ 0x8000) for c in cols)
+rows = [('fil1234', 'comp1', 'FOO~1.DLL|foo.dll', len(pe)), ('fil5678', 'comp1', 'BAR.EXE', len(pe))]
+filedata = b''.join(struct.pack('<H', sid[r[0]]) for r in rows) + b''.join(struct.pack('<H', sid[r[1]]) for r in rows) \
+    + b''.join(struct.pack('<H', sid[r[2]]) for r in rows) + b''.join(struct.pack('<I', r[3] ^ 0x80000000) for r in rows)
+datacab = cab([('fil1234', pe), ('fil5678', pe)])
+
+streams = [
+    (encname('_StringPool', True), pool),
+    (encname('_StringData', True), sdata),
+    (encname('_Columns', True), coldata),
+    (encname('File', True), filedata),
+    ('\x05SummaryInformation', b'\0' * 100),
+    (encname('Data1.cab'), datacab),
+    (encname('Binary.icon'), b'\0' * 10),
+]
+
+def cfb(streams):
+    SS, MS, CUT = 512, 64, 4096
+    END, FREE, FATSECT = 0xFFFFFFFE, 0xFFFFFFFF, 0xFFFFFFFD
+    sectors = []  # list of bytes
+    fat = []
+    def alloc(data):
+        if not data: return END
+        n = (len(data) + SS - 1) // SS
+        start = len(sectors)
+        for i in range(n):
+            sectors.append(data[i*SS:(i+1)*SS].ljust(SS, b'\0'))
+            fat.append(start + i + 1 if i < n - 1 else END)
+        return start
+    # reserve FAT sectors at 0,1
+    for _ in range(2):
+        sectors.append(None); fat.append(FATSECT)
+    ministream = b''
+    minifat = []
+    entries = []
+    for name, data in streams:
+        if len(data) < CUT:
+            start = len(ministream) // MS
+            n = (len(data) + MS - 1) // MS
+            for i in range(n):
+                minifat.append(start + i + 1 if i < n - 1 else END)
+            ministream += data.ljust(n * MS, b'\0')
+            entries.append((name, start, len(data)))
+        else:
+            entries.append((name, None, data))
+    big = []
+    for name, start, d in entries:
+        if start is None:
+            big.append((name, alloc(d), len(d)))
+        else:
+            big.append((name, start, d))
+    ms_start = alloc(ministream)
+    mf = b''.join(struct.pack('<I', x) for x in minifat)
+    mf_start = alloc(mf)
+    def dirent(name, kind, child, right, start, size):
+        n = (name + '\0').encode('utf-16-le')
+        e = n.ljust(64, b'\0') + struct.pack('<HBBIII', len(n), kind, 1, FREE, right, child) + bytes(16) + struct.pack('<I', 0) + struct.pack('<QQ', 0, 132539328000000000) + struct.pack('<II', start, size) + struct.pack('<I', 0)
+        assert len(e) == 128
+        return e
+    d = dirent('Root Entry', 5, 1, FREE, ms_start, len(ministream))
+    for i, (name, start, size) in enumerate(big):
+        right = i + 2 if i + 1 < len(big) else FREE
+        d += dirent(name, 2, FREE, right, start, size)
+    d += bytes(-len(d) % SS)
+    dir_start = alloc(d)
+    assert len(fat) <= 256
+    fatb = b''.join(struct.pack('<I', x) for x in fat).ljust(2 * SS, b'\xff')
+    sectors[0] = fatb[:SS]; sectors[1] = fatb[SS:]
+    h = bytes.fromhex('D0CF11E0A1B11AE1') + bytes(16) + struct.pack('<HHHHH', 0x3E, 3, 0xFFFE, 9, 6) + bytes(6)
+    h += struct.pack('<IIIIIIIII', 0, 2, dir_start, 0, CUT, mf_start, (len(mf) + SS - 1) // SS, END, 0)
+    h += struct.pack('<II', 0, 1) + b'\xff' * (4 * 107)
+    assert len(h) == 512
+    return h + b''.join(sectors)
+
+msi = cfb(streams)
+write('a.msi', msi)
+write('bundle.zip', zipped([('installers/setup.msi', msi)], zipfile.ZIP_STORED))
@@ -0,0 +1,322 @@
+#!/usr/bin/env python3
+"""Generates the disk image fixtures of disk_test.go.
+
+    python3 mkdisks.py <go-ntfs parser/test_data/test.ntfs.dd> <PE>
+
+The NTFS volume is the go-ntfs test volume, with the PE written over the
+data of /Folder A/Folder B/Hello world text document.txt. The FAT volumes
+hold the PE as /BOOT.EXE, /Windows/System32/hello.exe and
+/Windows/System32/Long File Name Library.dll. Images are written
+gzipped, as they are mostly empty:
+
+  fixed.vhd   fixed VHD, MBR: FAT16, and FAT12 in an extended partition
+  dynamic.vhd dynamic VHD, GPT: FAT32 and NTFS
+  mbr.vhdx    VHDX, MBR: as fixed.vhd
+  gpt.vhdx    VHDX, GPT: as dynamic.vhd
+"""
+import gzip, struct, sys, uuid, zlib
+
+def short_name(name, idx):
+    base, _, ext = name.rpartition('.') if '.' in name else (name, '', '')
+    fits = len(base) <= 8 and len(ext) <= 3 and base.upper()==base and ' ' not in name
+    if fits:
+        return (base.ljust(8) + ext.ljust(3)).encode(), False
+    b = ''.join(c for c in base.upper() if c.isalnum())[:6] + '~%d' % idx
+    return (b.ljust(8) + ext.upper()[:3].ljust(3)).encode(), True
+
+def lfn_entries(name, sn):
+    csum = 0
+    for c in sn:
+        csum = (((csum & 1) << 7) | (csum >> 1)) + c & 0xFF
+    units = name.encode('utf-16-le')
+    units += b'\x00\x00'
+    while len(units) % 26: units += b'\xff\xff'
+    parts = [units[i:i+26] for i in range(0, len(units), 26)]
+    out = []
+    for i, p in enumerate(parts):
+        ordv = i + 1
+        if i == len(parts) - 1: ordv |= 0x40
+        e = bytes([ordv]) + p[0:10] + bytes([0x0F, 0, csum]) + p[10:22] + b'\0\0' + p[22:26]
+        out.append(e)
+    return b''.join(reversed(out))
+
+def direntry(sn, attr, cluster, size):
+    return sn + bytes([attr, 0, 0]) + b'\0'*6 + struct.pack('<H', cluster >> 16) + struct.pack('<HH', 0x6000, 0x5A21) + struct.pack('<HI', cluster & 0xFFFF, size)
+
+def make_fat(tree, total_sectors, fat_bits, spc=4):
+    bps = 512
+    reserved = 1 if fat_bits != 32 else 32
+    root_entries = 512 if fat_bits != 32 else 0
+    clusters_est = total_sectors // spc
+    fat_bytes = (clusters_est + 2) * fat_bits // 8 + 1
+    fat_sectors = (fat_bytes + bps - 1) // bps
+    root_sectors = root_entries * 32 // bps
+    data_start = reserved + 2 * fat_sectors + root_sectors
+    img = bytearray(total_sectors * bps)
+    csize = spc * bps
+    fat = {}
+    nextc = [2 if fat_bits != 32 else 3]
+    def alloc(data):
+        n = max(1, (len(data) + csize - 1) // csize)
+        first = nextc[0]
+        for i in range(n):
+            c = first + i
+            fat[c] = c + 1 if i < n - 1 else 0xFFFFFFF
+            off = (data_start + (c - 2) * spc) * bps
+            chunk = data[i*csize:(i+1)*csize]
+            img[off:off+len(chunk)] = chunk
+        nextc[0] += n
+        return first
+    def build_dir(node, self_c, parent_c):
+        # two passes: allocate children first
+        entries = b''
+        if self_c is not None:
+            entries += direntry(b'.          ', 0x10, self_c, 0) + direntry(b'..         ', 0x10, parent_c, 0)
+        idx = 1
+        for name, val in node.items():
+            sn, needs = short_name(name, idx); idx += 1
+            if name.lower()==name and not needs:
+                pass
+            if needs or sn.decode().strip() != name.replace('.', '').ljust(0).upper() and True:
+                entries += lfn_entries(name, sn)
+            if isinstance(val, dict):
+                c = nextc[0]
+                # reserve one cluster for the dir now
+                fat[c] = 0xFFFFFFF; nextc[0] += 1
+                data = build_dir(val, c, self_c or 0)
+                off = (data_start + (c - 2) * spc) * bps
+                assert len(data) <= csize
+                img[off:off+len(data)] = data
+                entries += direntry(sn, 0x10, c, 0)
+            else:
+                c = alloc(val)
+                entries += direntry(sn, 0x20, c, len(val))
+        return entries
+    if fat_bits == 32:
+        rootc = 2; fat[2] = 0xFFFFFFF
+        root = build_dir(tree, None, 0)
+        off = (data_start) * bps
+        img[off:off+len(root)] = root
+    else:
+        root = build_dir(tree, None, 0)
+        off = (reserved + 2*fat_sectors) * bps
+        img[off:off+len(root)] = root
+    # FAT table
+    fatb = bytearray(fat_sectors * bps)
+    def setfat(c, v):
+        if fat_bits == 12:
+            v &= 0xFFF
+            i = c + c // 2
+            cur = struct.unpack_from('<H', fatb, i)[0]
+            if c & 1: cur = (cur & 0x000F) | (v << 4)
+            else: cur = (cur & 0xF000) | v
+            struct.pack_into('<H', fatb, i, cur)
+        elif fat_bits == 16:
+            struct.pack_into('<H', fatb, c*2, v & 0xFFFF)
+        else:
+            struct.pack_into('<I', fatb, c*4, v & 0x0FFFFFFF)
+    setfat(0, 0xFFFFFF8); setfat(1, 0xFFFFFFF)
+    for c, v in fat.items(): setfat(c, v)
+    for i in range(2):
+        off = (reserved + i*fat_sectors) * bps
+        img[off:off+len(fatb)] = fatb
+    bs = bytearray(512)
+    bs[0:3] = b'\xEB\x3C\x90'; bs[3:11] = b'MSDOS5.0'
+    struct.pack_into('<HBHBHHBHHHII', bs, 11, bps, spc, reserved, 2, root_entries,
+        total_sectors if total_sectors < 65536 and fat_bits != 32 else 0, 0xF8,
+        fat_sectors if fat_bits != 32 else 0, 63, 255, 0, total_sectors if total_sectors >= 65536 or fat_bits == 32 else 0)
+    if fat_bits == 32:
+        struct.pack_into('<IHHI', bs, 36, fat_sectors, 0, 0, 2)
+        bs[82:90] = b'FAT32   '
+    else:
+        bs[54:62] = b'FAT%d   ' % fat_bits
+    bs[510:512] = b'\x55\xAA'
+    img[0:512] = bs
+    # sanity: count clusters
+    return bytes(img)
+
+
+def mbr(parts, disk_sectors):
+    m = bytearray(512)
+    for i, (typ, start, count) in enumerate(parts):
+        struct.pack_into('<B3sB3sII', m, 446+i*16, 0, b'\0\0\0', typ, b'\0\0\0', start, count)
+    m[510:512] = b'\x55\xAA'
+    return bytes(m)
+
+def gpt(parts, disk_sectors):
+    ents = bytearray(128*128)
+    for i, (tguid, start, count) in enumerate(parts):
+        struct.pack_into('<16s16sQQQ', ents, i*128, uuid.UUID(tguid).bytes_le, uuid.uuid5(uuid.NAMESPACE_OID, 'part%d' % i).bytes_le, start, start+count-1, 0)
+    hdr = bytearray(92)
+    struct.pack_into('<8sIIIIQQQQ16sQIII', hdr, 0, b'EFI PART', 0x10000, 92, 0, 0, 1, disk_sectors-1, 34, disk_sectors-34, uuid.uuid5(uuid.NAMESPACE_OID, 'disk').bytes_le, 2, 128, 128, zlib.crc32(ents))
+    struct.pack_into('<I', hdr, 16, zlib.crc32(hdr))
+    pm = mbr([(0xEE, 1, disk_sectors-1)], disk_sectors)
+    return pm, bytes(hdr), bytes(ents)
+
+
+def vhd_footer(size, dtype, data_offset):
+    f = bytearray(512)
+    struct.pack_into('>8sIIQI4sI4sQQIII', f, 0, b'conectix', 2, 0x10000, data_offset, 0, b'ino ', 0x10000, b'Wi2k', size, size, 0, dtype, 0)
+    csum = (~sum(f)) & 0xFFFFFFFF
+    struct.pack_into('>I', f, 64, csum)
+    return bytes(f)
+
+def vhd_fixed(raw):
+    return raw + vhd_footer(len(raw), 2, 0xFFFFFFFFFFFFFFFF)
+
+def vhd_dynamic(raw, block=2*1024*1024):
+    size = (len(raw) + 511)//512*512
+    nblocks = (size + block - 1)//block
+    footer = vhd_footer(size, 3, 512)
+    dh = bytearray(1024)
+    bat_off = 512 + 1024
+    struct.pack_into('>8sQQIII', dh, 0, b'cxsparse', 0xFFFFFFFFFFFFFFFF, bat_off, 0x10000, nblocks, block)
+    bat_size = (nblocks*4 + 511)//512*512
+    out = bytearray(footer + bytes(dh) + b'\xff'*bat_size)
+    bitmap = 512
+    for b in range(nblocks):
+        chunk = raw[b*block:(b+1)*block]
+        if chunk.strip(b'\0') == b'': continue
+        sector = len(out)//512
+        struct.pack_into('>I', out, bat_off + b*4, sector)
+        out += b'\xff'*bitmap + chunk.ljust(block, b'\0')
+    out += footer
+    return bytes(out)
+
+def vhdx(raw, block=1024*1024, sector=512):
+    MB = 1024*1024
+    size = len(raw)
+    out = bytearray(4*MB)
+    out[0:8] = b'vhdxfile'
+    def region_table():
+        t = bytearray(64*1024)
+        struct.pack_into('<4sIII', t, 0, b'regi', 0, 2, 0)
+        struct.pack_into('<16sQII', t, 16, uuid.UUID('2dc27766-f623-4200-9d64-115e9bfd4a08').bytes_le, 2*MB, MB, 1)
+        struct.pack_into('<16sQII', t, 48, uuid.UUID('8b7ca206-4790-4b9a-b8fe-575f050f886e').bytes_le, 3*MB, MB, 1)
+        return t
+    for off in (192*1024, 256*1024):
+        out[off:off+64*1024] = region_table()
+    md = bytearray(MB)
+    md[0:8] = b'metadata'
+    struct.pack_into('<HH', md, 8, 0, 3)
+    items = [('caa16737-fa36-4d43-b3b6-33f0aa44e76b', struct.pack('<II', block, 0)),
+             ('2fa54224-cd1b-4876-b211-5dbed83bf4b8', struct.pack('<Q', size)),
+             ('8141bf1d-a96f-4709-ba47-f233a8faab5f', struct.pack('<I', sector))]
+    off = 64*1024
+    for i, (g, data) in enumerate(items):
+        struct.pack_into('<16sIII', md, 32+i*32, uuid.UUID(g).bytes_le, off, len(data), 0)
+        md[off:off+len(data)] = data
+        off += 4096
+    out[3*MB:4*MB] = md
+    chunk_ratio = (1<<23)*sector//block
+    nblocks = (size + block - 1)//block
+    bat = bytearray(MB)
+    for b in range(nblocks):
+        chunk = raw[b*block:(b+1)*block]
+        idx = b + b//chunk_ratio
+        if chunk.strip(b'\0') == b'':
+            struct.pack_into('<Q', bat, idx*8, 0)
+            continue
+        pos = len(out)
+        out += chunk.ljust(block, b'\0')
+        struct.pack_into('<Q', bat, idx*8, (pos//MB) << 20 | 6)
+    out[2*MB:3*MB] = bat
+    return bytes(out)
+
+
+def ntfs_with_pe(src, pe):
+    d = bytearray(open(src, 'rb').read())
+    bps, spc = struct.unpack_from('<HB', d, 11)
+    cs = bps*spc
+    mft = struct.unpack_from('<Q', d, 48)[0]*cs
+    recsize = 1024
+    def record(i):
+        off = mft + i*recsize
+        r = bytearray(d[off:off+recsize])
+        usa, n = struct.unpack_from('<HH', r, 4)
+        for k in range(1, n):
+            r[k*512-2:k*512] = r[usa+2*k:usa+2*k+2]
+        return off, r
+    def store(off, r):
+        usa, n = struct.unpack_from('<HH', r, 4)
+        seq = r[usa:usa+2]
+        for k in range(1, n):
+            r[usa+2*k:usa+2*k+2] = r[k*512-2:k*512]
+            r[k*512-2:k*512] = seq
+        d[off:off+recsize] = r
+
+    # free clusters: the last run of zeroed clusters before the backup boot sector
+    n = (len(pe) + cs - 1)//cs
+    total = len(d)//cs
+    lcn = None
+    for c in range(total - 2 - n, 0, -1):
+        if d[c*cs:(c+n)*cs].strip(b'\0') == b'':
+            lcn = c; break
+    d[lcn*cs:lcn*cs+len(pe)] = pe
+
+    off, r = record(46)
+    a = struct.unpack_from('<H', r, 20)[0]
+    while True:
+        t, ln = struct.unpack_from('<II', r, a)
+        if t == 0xFFFFFFFF: raise SystemExit('no $DATA')
+        if t == 0x80 and r[a+9] == 0:
+            break
+        a += ln
+    attr_id = struct.unpack_from('<H', r, a+14)[0]
+    # a run of n clusters at lcn: header 0x21|0x31.. with 1 byte length, 2/3 byte offset
+    lb = (lcn.bit_length() + 8)//8
+    runlist = bytes([lb << 4 | 1, n]) + lcn.to_bytes(lb, 'little', signed=True) + b'\0'
+    runlist += b'\0'*((-len(runlist)) % 8)
+    hdr = struct.pack('<IIBBHHH', 0x80, 64 + len(runlist), 1, 0, 64, 0, attr_id)
+    hdr += struct.pack('<QQHH4xQQQ', 0, n-1, 64, 0, n*cs, len(pe), len(pe))
+    attr = hdr + runlist
+    used = struct.unpack_from('<I', r, 24)[0]
+    rest = r[a+ln:used]
+    r[a:a+len(attr)+len(rest)] = attr + rest
+    struct.pack_into('<I', r, 24, a + len(attr) + len(rest))
+    store(off, r)
+    return bytes(d)
+
+
+def write(name, data):
+    with open(name + '.gz', 'wb') as f:
+        with gzip.GzipFile(filename=name, fileobj=f, mode='wb', mtime=0) as z:
+            z.write(data)
+
+def main():
+    ntfs_src, pe_path = sys.argv[1:3]
+    PE = open(pe_path, 'rb').read()
+    tree = {
+        'Windows': {'System32': {'hello.exe': PE, 'Long File Name Library.dll': PE, 'notes.txt': b'hi'}},
+        'BOOT.EXE': PE,
+    }
+    ntfs = ntfs_with_pe(ntfs_src, PE)
+    fat12 = make_fat(tree, 2048, 12, spc=1)
+    fat16 = make_fat(tree, 8192, 16, spc=1)
+    fat32 = make_fat(tree, 70000, 32, spc=1)
+
+    # MBR: FAT16, then an extended partition holding FAT12
+    s1 = 2048; n1 = len(fat16)//512
+    ext = s1 + n1; n3 = len(fat12)//512
+    total = ext + 1 + n3 + 64
+    mbrdisk = bytearray(total*512)
+    mbrdisk[0:512] = mbr([(0x06, s1, n1), (0x0F, ext, 1+n3)], total)
+    mbrdisk[s1*512:s1*512+len(fat16)] = fat16
+    mbrdisk[ext*512:ext*512+512] = mbr([(0x01, 1, n3)], 0)
+    mbrdisk[(ext+1)*512:(ext+1)*512+len(fat12)] = fat12
+
+    # GPT: FAT32 as the EFI system partition, then NTFS
+    s1 = 2048; n1 = len(fat32)//512; s2 = s1 + n1; n2 = len(ntfs)//512
+    total = s2 + n2 + 64
+    gptdisk = bytearray(total*512)
+    pm, hdr, ents = gpt([('c12a7328-f81f-11d2-ba4b-00a0c93ec93b', s1, n1), ('ebd0a0a2-b9e5-4433-87c0-68b6b7299a4c', s2, n2)], total)
+    gptdisk[0:512] = pm; gptdisk[512:512+92] = hdr; gptdisk[1024:1024+len(ents)] = ents
+    gptdisk[s1*512:s1*512+len(fat32)] = fat32
+    gptdisk[s2*512:s2*512+len(ntfs)] = ntfs
+
+    write('fixed.vhd', vhd_fixed(bytes(mbrdisk)))
+    write('dynamic.vhd', vhd_dynamic(bytes(gptdisk)))
+    write('mbr.vhdx', vhdx(bytes(mbrdisk)))
+    write('gpt.vhdx', vhdx(bytes(gptdisk)))
+
+main()
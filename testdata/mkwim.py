@@ -0,0 +1,585 @@
+#!/usr/bin/env python3
+"""Generates the WIM fixtures of wim_test.go.
+
+    python3 mkwim.py <PE>
+
+Each WIM holds the same two images, stored uncompressed (none.wim), in
+XPRESS chunks (xpress.wim), in LZMS chunks (lzms.wim) and in a solid LZMS
+resource (lzms-solid.wim). The compressors are written from wimlib's, so
+the WIMs test ino's decompressors against this script's output rather
+than against wimlib's. big.dll is text, random bytes and x86 calls
+followed by the PE, so it is not reported, but it spans many chunks and
+exercises literals, matches and the x86 filter. Images are written
+gzipped, like the other fixtures
+"""
+import gzip, struct, hashlib, heapq, sys, random
+
+# ---------------------------------------------------------------- Huffman
+
+def canonical_lengths(freqs, max_len):
+    """Port of wimlib make_canonical_huffman_code length computation."""
+    n = len(freqs)
+    if n < 2:
+        return [1] * n
+    SB = 10
+    MASK = (1 << SB) - 1
+    a = sorted((f << SB) | s for s, f in enumerate(freqs))
+    i = b = e = 0
+    while n - e > 1:
+        if i != n and (b == e or (a[i] >> SB) <= (a[b] >> SB)):
+            m = i; i += 1
+        else:
+            m = b; b += 1
+        if i != n and (b == e or (a[i] >> SB) <= (a[b] >> SB)):
+            k = i; i += 1
+        else:
+            k = b; b += 1
+        freq = (a[m] & ~MASK) + (a[k] & ~MASK)
+        a[m] = (a[m] & MASK) | (e << SB)
+        a[k] = (a[k] & MASK) | (e << SB)
+        a[e] = (a[e] & MASK) | freq
+        e += 1
+    counts = [0] * (max_len + 2)
+    counts[1] = 2
+    root = n - 2
+    a[root] &= MASK
+    for node in range(root - 1, -1, -1):
+        parent = a[node] >> SB
+        depth = (a[parent] >> SB) + 1
+        a[node] = (a[node] & MASK) | (depth << SB)
+        length = depth
+        if length >= max_len:
+            length = max_len
+            while True:
+                length -= 1
+                if counts[length]:
+                    break
+        counts[length] -= 1
+        counts[length + 1] += 2
+    lens = [0] * n
+    i = 0
+    for length in range(max_len, 0, -1):
+        for _ in range(counts[length]):
+            lens[a[i] & MASK] = length
+            i += 1
+    return lens
+
+
+def canonical_codes(lens):
+    codes = [0] * len(lens)
+    code = 0
+    for length in range(1, 16):
+        for s, l in enumerate(lens):
+            if l == length:
+                codes[s] = code
+                code += 1
+        code <<= 1
+    return codes
+
+
+def lz_parse(data, max_off, min_len=3, max_len=None):
+    """Greedy LZ77: list of ('lit', byte) / ('match', off, len)."""
+    out = []
+    table = {}
+    i = 0
+    n = len(data)
+    while i < n:
+        best = (0, 0)
+        if i + min_len <= n:
+            key = data[i:i + min_len]
+            for j in reversed(table.get(key, [])[-16:]):
+                off = i - j
+                if off > max_off:
+                    continue
+                l = 0
+                lim = n - i if max_len is None else min(n - i, max_len)
+                while l < lim and data[j + l] == data[i + l]:
+                    l += 1
+                if l > best[1]:
+                    best = (off, l)
+        if best[1] >= min_len:
+            out.append(('match', best[0], best[1]))
+            for k in range(i, i + best[1]):
+                if k + min_len <= n:
+                    table.setdefault(data[k:k + min_len], []).append(k)
+            i += best[1]
+        else:
+            out.append(('lit', data[i]))
+            if i + min_len <= n:
+                table.setdefault(data[i:i + min_len], []).append(i)
+            i += 1
+    return out
+
+# ---------------------------------------------------------------- XPRESS
+
+def xpress_compress(data):
+    items = lz_parse(data, 65535)
+    syms = []
+    for it in items:
+        if it[0] == 'lit':
+            syms.append((it[1], None))
+        else:
+            off, ln = it[1], it[2]
+            lo = off.bit_length() - 1
+            L = ln - 3
+            syms.append((256 + (lo << 4) + min(L, 15), (off, L, lo)))
+    freqs = [0] * 512
+    for s, _ in syms:
+        freqs[s] += 1
+    # length limited lengths via the same routine; unused symbols get 0
+    used = [s for s in range(512) if freqs[s]]
+    if len(used) == 1:
+        used.append(0 if used[0] else 1)
+        freqs[used[1]] = 1
+    sub = canonical_lengths([freqs[s] for s in used], 15)
+    lens = [0] * 512
+    for s, l in zip(used, sub):
+        lens[s] = l
+    codes = canonical_codes(lens)
+
+    out = bytearray(bytes((lens[2 * i] | (lens[2 * i + 1] << 4)) for i in range(256)))
+    # bitstream with two reserved word slots
+    slots = [len(out), len(out) + 2]
+    out += b'\0\0\0\0'
+    state = {'buf': 0, 'count': 0}
+
+    def write_bits(v, n):
+        state['buf'] = (state['buf'] << n) | v
+        state['count'] += n
+        if state['count'] > 16:
+            state['count'] -= 16
+            word = (state['buf'] >> state['count']) & 0xFFFF
+            struct.pack_into('<H', out, slots[0], word)
+            slots[0] = slots[1]
+            slots[1] = len(out)
+            out.extend(b'\0\0')
+
+    for s, extra in syms:
+        write_bits(codes[s], lens[s])
+        if extra:
+            off, L, lo = extra
+            if L >= 15:
+                if L - 15 < 255:
+                    out.append(L - 15)
+                else:
+                    out.append(255)
+                    out.extend(struct.pack('<H', L))
+            write_bits(off - (1 << lo), lo)
+    # flush
+    write_bits(0, 16)
+    return bytes(out)
+
+# ---------------------------------------------------------------- LZMS
+
+def lzms_slots(runs, final):
+    bases, extra = [], []
+    base, delta, slot = 0, 1, 0
+    for order, run in enumerate(runs):
+        for _ in range(run):
+            base += delta
+            if slot > 0:
+                extra[slot - 1] = order
+            bases.append(base)
+            extra.append(0)
+            slot += 1
+        delta <<= 1
+    extra[slot - 1] = len(runs)
+    bases.append(final)
+    return bases, extra
+
+OFF_BASE, OFF_BITS = lzms_slots([9, 0, 9, 7, 10, 15, 15, 20, 20, 30, 33, 40, 42, 45, 60, 73, 80, 85, 95, 105, 6], 0x7FFFFFFF)
+LEN_BASE, LEN_BITS = lzms_slots([27, 4, 6, 4, 5, 2, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 1], 0x400108AB)
+
+
+def slot_of(bases, v):
+    s = 0
+    while s + 1 < len(bases) - 1 and bases[s + 1] <= v:
+        s += 1
+    return s
+
+
+class Prob:
+    def __init__(self):
+        self.zeros = 48
+        self.recent = 0x55555555
+
+    def get(self):
+        p = self.zeros
+        if p == 0:
+            p = 1
+        elif p == 64:
+            p = 63
+        return p
+
+    def update(self, bit):
+        self.zeros += (self.recent >> 63) - bit
+        self.recent = ((self.recent << 1) | bit) & 0xFFFFFFFFFFFFFFFF
+
+
+class Decision:
+    def __init__(self, n):
+        self.state = 0
+        self.n = n
+        self.probs = [Prob() for _ in range(n)]
+
+
+class RangeEncoder:
+    def __init__(self):
+        self.low = 0
+        self.range = 0xFFFFFFFF
+        self.cache = 0
+        self.cache_size = 1
+        self.out = []
+        self.first = True
+
+    def shift_low(self):
+        if (self.low & 0xFFFFFFFF) < 0xFFFF0000 or (self.low >> 32) != 0:
+            while True:
+                v = (self.cache + (self.low >> 32)) & 0xFFFF
+                if self.first:
+                    self.first = False
+                else:
+                    self.out.append(v)
+                self.cache = 0xFFFF
+                self.cache_size -= 1
+                if self.cache_size == 0:
+                    break
+            self.cache = (self.low >> 16) & 0xFFFF
+        self.cache_size += 1
+        self.low = (self.low & 0xFFFF) << 16
+
+    def encode(self, d, bit):
+        p = d.probs[d.state]
+        d.state = ((d.state << 1) | bit) & (d.n - 1)
+        bound = (self.range >> 6) * p.get()
+        if bit == 0:
+            self.range = bound
+        else:
+            self.low += bound
+            self.range -= bound
+        p.update(bit)
+        if self.range <= 0xFFFF:
+            self.range <<= 16
+            self.shift_low()
+
+    def flush(self):
+        for _ in range(4):
+            self.shift_low()
+
+
+class BitWriter:
+    def __init__(self):
+        self.buf = 0
+        self.count = 0
+        self.words = []
+
+    def write(self, v, n):
+        for i in range(n - 1, -1, -1):
+            self.buf = (self.buf << 1) | ((v >> i) & 1)
+            self.count += 1
+            if self.count == 16:
+                self.words.append(self.buf)
+                self.buf = 0
+                self.count = 0
+
+    def flush(self):
+        if self.count:
+            self.words.append(self.buf << (16 - self.count))
+            self.buf = self.count = 0
+
+
+class AdaptiveHuffman:
+    def __init__(self, n, rebuild):
+        self.freqs = [1] * n
+        self.rebuild = rebuild
+        self.build()
+
+    def build(self):
+        self.lens = canonical_lengths(self.freqs, 15)
+        self.codes = canonical_codes(self.lens)
+        self.remaining = self.rebuild
+
+    def encode(self, bw, sym):
+        bw.write(self.codes[sym], self.lens[sym])
+        self.freqs[sym] += 1
+        self.remaining -= 1
+        if self.remaining == 0:
+            self.build()
+            self.freqs = [(f >> 1) + 1 for f in self.freqs]
+
+
+def x86_filter_forward(data):
+    data = bytearray(data)
+    size = len(data)
+    if size <= 17:
+        return bytes(data)
+    last_target = [-65535 - 1] * 65536
+    last_x86 = -1023 - 1
+    tail = size - 16
+    p = 0
+    while p < tail:
+        maxoff = 1023
+        n = 0
+        c = data[p]
+        if c == 0x48:
+            if (data[p + 1] == 0x8B and data[p + 2] in (5, 0xD)) or (data[p + 1] == 0x8D and data[p + 2] & 7 == 5):
+                n = 3
+        elif c == 0x4C:
+            if data[p + 1] == 0x8D and data[p + 2] & 7 == 5:
+                n = 3
+        elif c == 0xE8:
+            n = 1
+            maxoff >>= 1
+        elif c == 0xE9:
+            p += 4
+        elif c == 0xF0:
+            if data[p + 1] == 0x83 and data[p + 2] == 5:
+                n = 3
+        elif c == 0xFF:
+            if data[p + 1] == 0x15:
+                n = 2
+        if n == 0:
+            p += 1
+            continue
+        i = p
+        p += n
+        target = (i + struct.unpack_from('<H', data, p)[0]) & 0xFFFF
+        if i - last_x86 <= maxoff:
+            v = struct.unpack_from('<I', data, p)[0]
+            struct.pack_into('<I', data, p, (v + i) & 0xFFFFFFFF)
+        i += n + 4 - 1
+        if i - last_target[target] <= 65535:
+            last_x86 = i
+        last_target[target] = i
+        p += 4
+    return bytes(data)
+
+
+def lzms_compress(data):
+    size = len(data)
+    data = x86_filter_forward(data)
+    rc = RangeEncoder()
+    bw = BitWriter()
+    nslots = slot_of(OFF_BASE, size - 1) + 1
+    lit = AdaptiveHuffman(256, 1024)
+    lzoff = AdaptiveHuffman(nslots, 1024)
+    length = AdaptiveHuffman(54, 512)
+    AdaptiveHuffman(nslots, 1024)  # delta offsets, unused
+    AdaptiveHuffman(8, 512)  # delta powers, unused
+    main, match, lz = Decision(16), Decision(32), Decision(64)
+    for it in lz_parse(data, size, min_len=3):
+        if it[0] == 'lit':
+            rc.encode(main, 0)
+            lit.encode(bw, it[1])
+        else:
+            off, ln = it[1], it[2]
+            rc.encode(main, 1)
+            rc.encode(match, 0)
+            rc.encode(lz, 0)
+            s = slot_of(OFF_BASE, off)
+            lzoff.encode(bw, s)
+            bw.write(off - OFF_BASE[s], OFF_BITS[s])
+            s = slot_of(LEN_BASE, ln)
+            length.encode(bw, s)
+            bw.write(ln - LEN_BASE[s], LEN_BITS[s])
+    rc.flush()
+    bw.flush()
+    out = b''.join(struct.pack('<H', w) for w in rc.out)
+    out += b''.join(struct.pack('<H', w) for w in reversed(bw.words))
+    return out
+
+# ---------------------------------------------------------------- WIM
+
+
+def sid(rev_auth, subs):
+    return struct.pack('<BB', 1, len(subs)) + rev_auth.to_bytes(6, 'big') + b''.join(struct.pack('<I', s) for s in subs)
+
+
+def security_descriptor():
+    owner = sid(5, [32, 544])
+    group = sid(5, [18])
+    aces = b''
+    for mask, s in [(0x1F01FF, sid(5, [18])), (0x1200A9, sid(5, [11])), (0x1301BF, sid(1, [0]))]:
+        aces += struct.pack('<BBHI', 0, 0, 8 + len(s), mask) + s
+    acl = struct.pack('<BBHHH', 2, 0, 8 + len(aces), 3, 0) + aces
+    off_owner = 20
+    off_group = off_owner + len(owner)
+    off_dacl = off_group + len(group)
+    return struct.pack('<BBHIIII', 1, 0, 0x8004, off_owner, off_group, 0, off_dacl) + owner + group + acl
+
+
+def utf16(s):
+    return s.encode('utf-16-le')
+
+
+def dentry(name, attrs, secid, subdir, hash_, streams=()):
+    n = utf16(name)
+    length = 102 + (len(n) + 2 if n else 0)
+    length = (length + 7) & ~7
+    ft = 132000000000000000
+    d = struct.pack('<QIIqqqQQQ20sIqHHH', length, attrs, secid, subdir, 0, 0, ft, ft, ft, hash_, 0, 0, len(streams), 0, len(n))
+    d += n + (b'\0\0' if n else b'')
+    d += b'\0' * (length - len(d))
+    for sname, shash in streams:
+        sn = utf16(sname)
+        slen = (38 + (len(sn) + 2 if sn else 0) + 7) & ~7
+        s = struct.pack('<Qq20sH', slen, 0, shash, len(sn)) + sn + (b'\0\0' if sn else b'')
+        d += s + b'\0' * (slen - len(s))
+    return d
+
+
+def build_metadata(tree, blob_hash):
+    """tree: dict name -> bytes (file) or dict (dir). Returns metadata bytes."""
+    sd = security_descriptor()
+    sec = struct.pack('<II', 0, 1) + struct.pack('<Q', len(sd)) + sd
+    sec = bytearray(sec)
+    struct.pack_into('<I', sec, 0, len(sec))
+    sec += b'\0' * (((len(sec) + 7) & ~7) - len(sec))
+    meta = bytearray(sec)
+    # layout: root dentry, end marker, then directories breadth first
+    root_off = len(meta)
+    meta += dentry('', 0x10, 0, 0, b'\0' * 20)
+    meta += b'\0' * 8
+    queue = [(root_off, tree)]
+    while queue:
+        parent_off, children = queue.pop(0)
+        start = len(meta)
+        struct.pack_into('<q', meta, parent_off + 16, start)
+        offs = []
+        for name in sorted(children):
+            v = children[name]
+            off = len(meta)
+            if isinstance(v, dict):
+                meta += dentry(name, 0x10, 0, 0, b'\0' * 20)
+                offs.append((off, v))
+            elif name.endswith('.exe'):
+                # data as an unnamed stream entry plus a named stream
+                meta += dentry(name, 0x20, 0, 0, b'\0' * 20,
+                               streams=[('', blob_hash(v)), ('Zone.Identifier', blob_hash(b'[ZoneTransfer]\r\nZoneId=3\r\n'))])
+            else:
+                meta += dentry(name, 0x20, 0xFFFFFFFF if name.endswith('.txt') else 0, 0, blob_hash(v))
+        meta += b'\0' * 8
+        queue.extend(offs)
+    return bytes(meta)
+
+
+def xml_data(images):
+    x = '<WIM><TOTALBYTES>0</TOTALBYTES>'
+    for i, name in enumerate(images, 1):
+        x += ('<IMAGE INDEX="%d"><NAME>%s</NAME><DESCRIPTION>%s test</DESCRIPTION>'
+              '<WINDOWS><ARCH>9</ARCH><EDITIONID>Professional</EDITIONID>'
+              '<VERSION><MAJOR>10</MAJOR><MINOR>0</MINOR><BUILD>19041</BUILD><SPBUILD>%d</SPBUILD></VERSION></WINDOWS></IMAGE>') % (i, name, name, i)
+    x += '</WIM>'
+    return b'\xff\xfe' + utf16(x)
+
+
+def reshdr(flags, size, offset, orig):
+    return struct.pack('<QqQ', (flags << 56) | size, offset, orig)
+
+
+def chunked(data, chunk, compress):
+    """Non-solid compressed resource: chunk table of offsets then chunks."""
+    chunks = []
+    for i in range(0, len(data), chunk):
+        raw = data[i:i + chunk]
+        c = compress(raw)
+        chunks.append(c if len(c) < len(raw) else raw)
+    big = len(data) > 0xFFFFFFFF
+    table = b''
+    off = 0
+    for c in chunks[:-1]:
+        off += len(c)
+        table += struct.pack('<Q' if big else '<I', off)
+    return table + b''.join(chunks)
+
+
+def write_wim(path, images, mode):
+    chunk = 4096
+    out = bytearray(b'\0' * 208)
+    entries = []
+    blobs = {}
+
+    def blob_hash(data):
+        h = hashlib.sha1(data).digest()
+        blobs[h] = data
+        return h
+
+    metas = [build_metadata(tree, blob_hash) for _, tree in images]
+
+    if mode == 'none':
+        flags, fmt = 0, None
+    elif mode == 'xpress':
+        flags, fmt = 0x2 | 0x20000, xpress_compress
+    else:
+        flags, fmt = 0x2 | 0x80000, lzms_compress
+
+    def put(data, rflags):
+        off = len(out)
+        if fmt is None or len(data) == 0:
+            out.extend(data)
+            return reshdr(rflags, len(data), off, len(data))
+        c = chunked(data, chunk, fmt)
+        if len(c) >= len(data):
+            out.extend(data)
+            return reshdr(rflags, len(data), off, len(data))
+        out.extend(c)
+        return reshdr(rflags | 0x4, len(c), off, len(data))
+
+    if mode == 'lzms-solid':
+        # blob entries first, then the solid resource entry they index into
+        solid = b''
+        blob_entries = []
+        for h, data in blobs.items():
+            blob_entries.append(reshdr(0x10, len(data), len(solid), len(data)) + struct.pack('<HI', 1, 1) + h)
+            solid += data
+        schunk = 16384
+        chunks = []
+        for i in range(0, len(solid), schunk):
+            raw = solid[i:i + schunk]
+            c = lzms_compress(raw)
+            chunks.append(c if len(c) < len(raw) else raw)
+        body = struct.pack('<QII', len(solid), schunk, 3) + b''.join(struct.pack('<I', len(c)) for c in chunks) + b''.join(chunks)
+        off = len(out)
+        out.extend(body)
+        entries.append(reshdr(0x10, len(body), off, 0x100000000) + struct.pack('<HI', 1, 1) + b'\0' * 20)
+        entries.extend(blob_entries)
+    else:
+        for h, data in blobs.items():
+            entries.append(put(data, 0) + struct.pack('<HI', 1, 1) + h)
+    for m in metas:
+        entries.append(put(m, 0x2) + struct.pack('<HI', 1, 1) + hashlib.sha1(m).digest())
+
+    table = b''.join(entries)
+    table_off = len(out)
+    out.extend(table)
+    xml = xml_data([n for n, _ in images])
+    xml_off = len(out)
+    out.extend(xml)
+
+    version = 0xE00 if mode == 'lzms-solid' else 0x10D00
+    hdr = struct.pack('<8sIIII16sHHI', b'MSWIM\0\0\0', 208, version, flags, chunk, b'\x11' * 16, 1, 1, len(images))
+    hdr += reshdr(0x2, len(table), table_off, len(table))
+    hdr += reshdr(0, len(xml), xml_off, len(xml))
+    hdr += reshdr(0, 0, 0, 0)
+    hdr += struct.pack('<II', 1, 0)
+    hdr += reshdr(0, 0, 0, 0)
+    hdr += b'\0' * (208 - len(hdr))
+    out[:208] = hdr
+    with open(path + '.gz', 'wb') as f:
+        with gzip.GzipFile(filename=path, fileobj=f, mode='wb', mtime=0) as z:
+            z.write(out)
+
+
+if __name__ == '__main__':
+    pe = open(sys.argv[1], 'rb').read()
+    random.seed(1)
+    noise = bytes(random.getrandbits(8) for _ in range(3000))
+    calls = b''.join(b'\xe8' + struct.pack('<i', 0x1000 - (i * 37 % 500)) + b'\x90\x90' for i in range(3000))
+    big = (b'the quick brown fox jumps over the lazy dog. ' * 900) + noise + calls + pe
+    tree1 = {'Windows': {'System32': {'kernel32.dll': pe, 'notepad.exe': pe, 'readme.txt': b'hello\r\n', 'big.dll': big},
+                         'explorer.exe': pe}, 'setup.exe': b'MZ not really'}
+    tree2 = {'Windows': {'System32': {'ntdll.dll': pe}}}
+    images = [('Windows 10 Pro', tree1), ('Windows PE', tree2)]
+    print(hashlib.sha256(big).hexdigest(), 'big.dll')
+    for mode in ['none', 'xpress', 'lzms', 'lzms-solid']:
+        write_wim('%s.wim' % mode, images, mode)
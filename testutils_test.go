@@ -0,0 +1,84 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// recorder is an encoder that keeps the records of a scan for a test
+type recorder struct {
+	reports []*Report
+	errors  []ErrorRecord
+}
+
+func (r *recorder) encode(record interface{}) error {
+	switch record := record.(type) {
+	case *Report:
+		r.reports = append(r.reports, record)
+	case ErrorRecord:
+		r.errors = append(r.errors, record)
+	}
+	return nil
+}
+
+func (r *recorder) close() error { return nil }
+
+// paths are the sorted paths of the file reports recorded
+func (r *recorder) paths() []string {
+	var paths []string
+	for _, report := range r.reports {
+		if report.Type != "directory" {
+			paths = append(paths, report.Path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// record sends the output of a test to a recorder, restoring the
+// previous encoder and summary when the test ends
+func record(t *testing.T) *recorder {
+	t.Helper()
+	printed, counted := output, summary
+	r := &recorder{}
+	output = r
+	summary = Summary{Type: "summary", Phases: make(map[string]int)}
+	t.Cleanup(func() {
+		output, summary = printed, counted
+	})
+	return r
+}
+
+// openTestData opens a file of testdata, decompressing it to a
+// temporary directory first when it is gzipped
+func openTestData(t *testing.T, name string) *os.File {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(name, ".gz") {
+		t.Cleanup(func() { f.Close() })
+		return f
+	}
+	defer f.Close()
+
+	z, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.Create(filepath.Join(t.TempDir(), strings.TrimSuffix(name, ".gz")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { out.Close() })
+	if _, err := io.Copy(out, z); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
@@ -0,0 +1,534 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// UDF descriptor tag identifiers
+const (
+	udfTagAnchor              = 2
+	udfTagPartition           = 5
+	udfTagLogicalVolume       = 6
+	udfTagTerminating         = 8
+	udfTagFileSet             = 256
+	udfTagFileIdentifier      = 257
+	udfTagAllocationExtent    = 258
+	udfTagFileEntry           = 261
+	udfTagExtendedFileEntry   = 266
+	udfFileCharDirectory      = 0x02
+	udfFileCharDeleted        = 0x04
+	udfFileCharParent         = 0x08
+	udfFileTypeDirectory      = 4
+	udfExtentNotRecorded      = 1
+	udfExtentNextDescriptors  = 3
+	udfAllocationShort        = 0
+	udfAllocationLong         = 1
+	udfAllocationExtended     = 2
+	udfAllocationInline       = 3
+	udfMetadataPartitionMapID = "*UDF Metadata Partition"
+)
+
+// udfFS is a read-only fs.FS over a UDF volume, as found on DVD and
+// Blu-ray images, including Windows installation media
+type udfFS struct {
+	r          io.ReaderAt
+	blockSize  int64
+	partitions []udfPartition
+	root       *udfEntry
+}
+
+// udfPartition maps the logical blocks of a partition to offsets in the
+// image. Physical partitions are contiguous, while the blocks of a
+// metadata partition are those of its metadata file
+type udfPartition struct {
+	start    int64
+	metadata *udfExtents
+}
+
+func newUDFFS(r io.ReaderAt, size int64) (*udfFS, error) {
+	anchor := make([]byte, isoSectorSize)
+	_, err := r.ReadAt(anchor, 256*isoSectorSize)
+	if err != nil || binary.LittleEndian.Uint16(anchor) != udfTagAnchor {
+		return nil, errors.New("no UDF anchor volume descriptor pointer")
+	}
+	sequenceLength := int64(binary.LittleEndian.Uint32(anchor[16:]))
+	sequenceStart := int64(binary.LittleEndian.Uint32(anchor[20:]))
+
+	// the volume descriptor sequence gives the physical partitions, and the
+	// logical volume that maps partition references to them
+	partitionStarts := make(map[uint16]int64)
+	var volume []byte
+	for i := int64(0); i < sequenceLength/isoSectorSize; i++ {
+		descriptor := make([]byte, isoSectorSize)
+		_, err := r.ReadAt(descriptor, (sequenceStart+i)*isoSectorSize)
+		if err != nil {
+			return nil, err
+		}
+		tag := binary.LittleEndian.Uint16(descriptor)
+		if tag == udfTagTerminating {
+			break
+		}
+		switch tag {
+		case udfTagPartition:
+			number := binary.LittleEndian.Uint16(descriptor[22:])
+			partitionStarts[number] = int64(binary.LittleEndian.Uint32(descriptor[188:]))
+		case udfTagLogicalVolume:
+			volume = descriptor
+		}
+	}
+	if volume == nil {
+		return nil, errors.New("no UDF logical volume descriptor")
+	}
+
+	udf := &udfFS{r: r, blockSize: int64(binary.LittleEndian.Uint32(volume[212:]))}
+	if udf.blockSize == 0 || udf.blockSize > 64*1024 {
+		return nil, fmt.Errorf("UDF block size of %d", udf.blockSize)
+	}
+
+	maps := volume[440:]
+	count := int(binary.LittleEndian.Uint32(volume[268:]))
+	var metadataMaps []int
+	for i, offset := 0, 0; i < count && offset+2 <= len(maps); i++ {
+		mapType, mapLength := maps[offset], int(maps[offset+1])
+		if mapLength < 6 || offset+mapLength > len(maps) {
+			return nil, errors.New("UDF partition map is corrupt")
+		}
+		entry := maps[offset : offset+mapLength]
+		offset += mapLength
+
+		// type 2 maps, like sparable and metadata partitions, name the
+		// physical partition they are in at the same place
+		number := binary.LittleEndian.Uint16(entry[4:])
+		if mapType == 2 {
+			if mapLength < 44 {
+				return nil, errors.New("UDF partition map is corrupt")
+			}
+			number = binary.LittleEndian.Uint16(entry[38:])
+			if strings.HasPrefix(string(entry[5:28]), udfMetadataPartitionMapID) {
+				metadataMaps = append(metadataMaps, i)
+			}
+		}
+		start, ok := partitionStarts[number]
+		if !ok {
+			return nil, fmt.Errorf("UDF partition %d is not described", number)
+		}
+		udf.partitions = append(udf.partitions, udfPartition{start: start})
+	}
+
+	for _, i := range metadataMaps {
+		location := binary.LittleEndian.Uint32(maps[i*64+40:])
+		file, err := udf.readFileEntry(uint16(i), location)
+		if err != nil {
+			return nil, fmt.Errorf("UDF metadata file: %s", err)
+		}
+		udf.partitions[i].metadata = file.extents
+	}
+
+	fileSet, err := udf.readBlock(udf.longAd(volume[248:]))
+	if err != nil || binary.LittleEndian.Uint16(fileSet) != udfTagFileSet {
+		return nil, errors.New("no UDF file set descriptor")
+	}
+	partition, block := udf.longAd(fileSet[400:])
+	udf.root, err = udf.readFileEntry(partition, block)
+	if err != nil {
+		return nil, fmt.Errorf("UDF root directory: %s", err)
+	}
+	udf.root.name = "/"
+	return udf, nil
+}
+
+// longAd reads the partition and block of a long allocation descriptor
+func (u *udfFS) longAd(b []byte) (uint16, uint32) {
+	return binary.LittleEndian.Uint16(b[8:]), binary.LittleEndian.Uint32(b[4:])
+}
+
+// offset returns where a logical block of a partition is in the image
+func (u *udfFS) offset(partition uint16, block uint32) (int64, error) {
+	if int(partition) >= len(u.partitions) {
+		return 0, fmt.Errorf("UDF partition reference %d is out of range", partition)
+	}
+	p := u.partitions[partition]
+	if p.metadata != nil {
+		return p.metadata.offset(int64(block) * u.blockSize)
+	}
+	return (p.start + int64(block)) * u.blockSize, nil
+}
+
+func (u *udfFS) readBlock(partition uint16, block uint32) ([]byte, error) {
+	offset, err := u.offset(partition, block)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, u.blockSize)
+	_, err = u.r.ReadAt(data, offset)
+	return data, err
+}
+
+// readFileEntry reads the file entry, or extended file entry, of an ICB
+func (u *udfFS) readFileEntry(partition uint16, block uint32) (*udfEntry, error) {
+	fe, err := u.readBlock(partition, block)
+	if err != nil {
+		return nil, err
+	}
+
+	var timeOffset, eaLengthOffset int
+	switch binary.LittleEndian.Uint16(fe) {
+	case udfTagFileEntry:
+		timeOffset, eaLengthOffset = 84, 168
+	case udfTagExtendedFileEntry:
+		timeOffset, eaLengthOffset = 92, 208
+	default:
+		return nil, fmt.Errorf("no file entry at block %d", block)
+	}
+	eaLength := int(binary.LittleEndian.Uint32(fe[eaLengthOffset:]))
+	adLength := int(binary.LittleEndian.Uint32(fe[eaLengthOffset+4:]))
+	adStart := eaLengthOffset + 8 + eaLength
+	if adStart+adLength > len(fe) {
+		return nil, fmt.Errorf("file entry at block %d is corrupt", block)
+	}
+
+	entry := &udfEntry{
+		dir:     fe[16+11] == udfFileTypeDirectory,
+		size:    int64(binary.LittleEndian.Uint64(fe[56:])),
+		modTime: udfTime(fe[timeOffset:]),
+	}
+	entry.extents, err = u.readExtents(partition, binary.LittleEndian.Uint16(fe[16+18:])&7, fe[adStart:adStart+adLength], entry.size)
+	if err != nil {
+		return nil, fmt.Errorf("file entry at block %d: %s", block, err)
+	}
+	return entry, nil
+}
+
+// readExtents follows the allocation descriptors of a file entry, and
+// any allocation extent descriptors they continue in
+func (u *udfFS) readExtents(partition uint16, adType uint16, ads []byte, size int64) (*udfExtents, error) {
+	extents := &udfExtents{r: u.r, size: size}
+	if adType == udfAllocationInline {
+		extents.inline = ads
+		return extents, nil
+	}
+
+	for seen := 0; len(ads) > 0 && seen < 1024; {
+		var length int64
+		var kind uint32
+		var ref uint16
+		var block uint32
+		var adSize int
+		switch adType {
+		case udfAllocationShort:
+			adSize = 8
+		case udfAllocationLong:
+			adSize = 16
+		case udfAllocationExtended:
+			adSize = 20
+		default:
+			return nil, fmt.Errorf("allocation descriptor type %d", adType)
+		}
+		if len(ads) < adSize {
+			break
+		}
+		raw := binary.LittleEndian.Uint32(ads)
+		length, kind = int64(raw&0x3FFFFFFF), raw>>30
+		switch adType {
+		case udfAllocationShort:
+			ref, block = partition, binary.LittleEndian.Uint32(ads[4:])
+		case udfAllocationLong:
+			ref, block = binary.LittleEndian.Uint16(ads[8:]), binary.LittleEndian.Uint32(ads[4:])
+		case udfAllocationExtended:
+			ref, block = binary.LittleEndian.Uint16(ads[16:]), binary.LittleEndian.Uint32(ads[12:])
+		}
+		ads = ads[adSize:]
+		if length == 0 {
+			break
+		}
+
+		if kind == udfExtentNextDescriptors {
+			next, err := u.readBlock(ref, block)
+			if err != nil {
+				return nil, err
+			}
+			if binary.LittleEndian.Uint16(next) != udfTagAllocationExtent {
+				return nil, errors.New("no allocation extent descriptor")
+			}
+			nextLength := int(binary.LittleEndian.Uint32(next[20:]))
+			if 24+nextLength > len(next) {
+				return nil, errors.New("allocation extent descriptor is corrupt")
+			}
+			ads = next[24 : 24+nextLength]
+			seen++
+			continue
+		}
+
+		extent := udfExtent{length: length, sparse: kind == udfExtentNotRecorded || kind == 2}
+		if !extent.sparse {
+			offset, err := u.offset(ref, block)
+			if err != nil {
+				return nil, err
+			}
+			extent.offset = offset
+		}
+		extents.list = append(extents.list, extent)
+	}
+	return extents, nil
+}
+
+// udfTime reads a timestamp, whose time zone is in minutes from UTC
+func udfTime(b []byte) time.Time {
+	typeAndZone := binary.LittleEndian.Uint16(b)
+	zone := int16(typeAndZone<<4) >> 4
+	t := time.Date(int(binary.LittleEndian.Uint16(b[2:])), time.Month(b[4]), int(b[5]),
+		int(b[6]), int(b[7]), int(b[8]), int(b[9])*10*int(time.Millisecond), time.UTC)
+	if typeAndZone>>12 == 1 && zone != -2047 {
+		t = t.Add(-time.Duration(zone) * time.Minute)
+	}
+	return t
+}
+
+// udfName decodes an OSTA compressed unicode name, whose first byte
+// gives the size of its characters
+func udfName(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	switch b[0] {
+	case 8:
+		runes := make([]rune, len(b)-1)
+		for i, c := range b[1:] {
+			runes[i] = rune(c)
+		}
+		return string(runes)
+	case 16:
+		units := make([]uint16, (len(b)-1)/2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(b[1+i*2:])
+		}
+		return string(utf16.Decode(units))
+	}
+	return ""
+}
+
+// readDir parses the file identifier descriptors of a directory
+func (u *udfFS) readDir(dir *udfEntry) ([]*udfEntry, error) {
+	data := make([]byte, dir.size)
+	_, err := dir.extents.ReadAt(data, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	var entries []*udfEntry
+	for i := 0; i+38 <= len(data); {
+		fid := data[i:]
+		if binary.LittleEndian.Uint16(fid) != udfTagFileIdentifier {
+			return entries, fmt.Errorf("no file identifier descriptor at %d", i)
+		}
+		characteristics := fid[18]
+		nameLength := int(fid[19])
+		implLength := int(binary.LittleEndian.Uint16(fid[36:]))
+		length := (38 + implLength + nameLength + 3) &^ 3
+		if i+38+implLength+nameLength > len(data) {
+			return entries, errors.New("file identifier descriptor is corrupt")
+		}
+		name := udfName(fid[38+implLength : 38+implLength+nameLength])
+		partition, block := u.longAd(fid[20:])
+		i += length
+
+		if characteristics&(udfFileCharDeleted|udfFileCharParent) != 0 {
+			continue
+		}
+		entry, err := u.readFileEntry(partition, block)
+		if err != nil {
+			return entries, err
+		}
+		entry.name = name
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (u *udfFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	entry := u.root
+	if name != "." {
+		for _, component := range strings.Split(name, "/") {
+			if !entry.IsDir() {
+				return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+			}
+			entries, err := u.readDir(entry)
+			if err != nil {
+				return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+			}
+			var found *udfEntry
+			for _, child := range entries {
+				if child.name == component {
+					found = child
+					break
+				}
+				if found == nil && strings.EqualFold(child.name, component) {
+					found = child
+				}
+			}
+			if found == nil {
+				return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+			}
+			entry = found
+		}
+	}
+
+	if entry.IsDir() {
+		return &udfDir{fsys: u, entry: entry}, nil
+	}
+	return &udfFile{SectionReader: io.NewSectionReader(entry.extents, 0, entry.size), entry: entry}, nil
+}
+
+// udfEntry is a file or directory, from its file entry
+type udfEntry struct {
+	name    string
+	dir     bool
+	size    int64
+	modTime time.Time
+	extents *udfExtents
+}
+
+func (e *udfEntry) Name() string       { return e.name }
+func (e *udfEntry) ModTime() time.Time { return e.modTime }
+func (e *udfEntry) IsDir() bool        { return e.dir }
+func (e *udfEntry) Sys() interface{}   { return nil }
+
+func (e *udfEntry) Size() int64 {
+	if e.dir {
+		return 0
+	}
+	return e.size
+}
+
+func (e *udfEntry) Mode() fs.FileMode {
+	if e.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+// udfExtents reads the data of a file from its extents, or from its
+// file entry when it is small enough to be stored inline
+type udfExtents struct {
+	r      io.ReaderAt
+	size   int64
+	inline []byte
+	list   []udfExtent
+}
+
+// udfExtent is a run of a file's data. Sparse extents read as zeros
+type udfExtent struct {
+	offset int64
+	length int64
+	sparse bool
+}
+
+// offset returns where the byte at off of the file is in the image
+func (x *udfExtents) offset(off int64) (int64, error) {
+	for _, extent := range x.list {
+		if off < extent.length {
+			if extent.sparse {
+				break
+			}
+			return extent.offset + off, nil
+		}
+		off -= extent.length
+	}
+	return 0, errors.New("block is outside the metadata file")
+}
+
+func (x *udfExtents) ReadAt(p []byte, off int64) (int, error) {
+	if x.inline != nil {
+		if off >= int64(len(x.inline)) {
+			return 0, io.EOF
+		}
+		n := copy(p, x.inline[off:])
+		if n < len(p) {
+			return n, io.EOF
+		}
+		return n, nil
+	}
+
+	n := 0
+	position := int64(0)
+	for _, extent := range x.list {
+		if n == len(p) {
+			break
+		}
+		end := position + extent.length
+		if off+int64(n) < end {
+			start := off + int64(n) - position
+			want := p[n:]
+			if int64(len(want)) > extent.length-start {
+				want = want[:extent.length-start]
+			}
+			if extent.sparse {
+				for i := range want {
+					want[i] = 0
+				}
+			} else {
+				_, err := x.r.ReadAt(want, extent.offset+start)
+				if err != nil {
+					return n, err
+				}
+			}
+			n += len(want)
+		}
+		position = end
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+type udfFile struct {
+	*io.SectionReader
+	entry *udfEntry
+}
+
+func (f *udfFile) Stat() (fs.FileInfo, error) { return f.entry, nil }
+func (f *udfFile) Close() error               { return nil }
+
+type udfDir struct {
+	fsys    *udfFS
+	entry   *udfEntry
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (d *udfDir) Stat() (fs.FileInfo, error) { return d.entry, nil }
+func (d *udfDir) Close() error               { return nil }
+
+func (d *udfDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.entry.name, Err: errors.New("is a directory")}
+}
+
+func (d *udfDir) ReadDir(count int) ([]fs.DirEntry, error) {
+	if !d.read {
+		children, err := d.fsys.readDir(d.entry)
+		if err != nil {
+			return nil, err
+		}
+		for _, child := range children {
+			d.entries = append(d.entries, fs.FileInfoToDirEntry(child))
+		}
+		sort.Slice(d.entries, func(i, j int) bool {
+			return d.entries[i].Name() < d.entries[j].Name()
+		})
+		d.read = true
+	}
+	return readDirEntries(&d.entries, count)
+}
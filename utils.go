@@ -41,7 +41,19 @@ func genPEFunctions(list []string) []PEFunction {
 	return funcs
 }
 
-func jsPrint(report *Report) {
+func jsPrint(report interface{}) {
 	serialized, _ := json.Marshal(report)
 	fmt.Println(string(serialized))
 }
+
+// stringList is a flag that may be given more than once
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// VHD disk types, from the hard disk footer
+const (
+	vhdFixed        = 2
+	vhdDynamic      = 3
+	vhdDifferencing = 4
+)
+
+const vhdUnallocated = 0xFFFFFFFF
+
+// newVHDDisk returns the virtual disk within a VHD, whose last 512 bytes
+// must be its footer. Differencing disks are rejected, as their parents
+// can't be found from within an image alone
+func newVHDDisk(f io.ReaderAt, fileSize int64) (io.ReaderAt, int64, error) {
+	if fileSize < 512 {
+		return nil, 0, errors.New("too small for a VHD")
+	}
+	footer := make([]byte, 512)
+	_, err := f.ReadAt(footer, fileSize-512)
+	if err != nil {
+		return nil, 0, err
+	}
+	if string(footer[:8]) != "conectix" {
+		return nil, 0, errors.New("no VHD footer")
+	}
+
+	size := int64(binary.BigEndian.Uint64(footer[48:]))
+	if size < 0 {
+		return nil, 0, fmt.Errorf("bad VHD size %d", uint64(size))
+	}
+	switch diskType := binary.BigEndian.Uint32(footer[60:]); diskType {
+	case vhdFixed:
+		return io.NewSectionReader(f, 0, size), size, nil
+	case vhdDynamic:
+		disk, err := newDynamicVHD(f, fileSize, int64(binary.BigEndian.Uint64(footer[16:])), size)
+		return disk, size, err
+	case vhdDifferencing:
+		return nil, 0, errors.New("differencing VHDs are not supported, scan the parent")
+	default:
+		return nil, 0, fmt.Errorf("unknown VHD disk type %d", diskType)
+	}
+}
+
+// dynamicVHD reads a dynamic VHD through its block allocation table.
+// Unallocated blocks read as zeros
+type dynamicVHD struct {
+	f          io.ReaderAt
+	size       int64
+	blockSize  int64
+	bitmapSize int64
+	bat        []uint32
+}
+
+// newDynamicVHD reads the dynamic disk header and block allocation table
+// of a VHD of fileSize bytes. The table must lie within the file
+func newDynamicVHD(f io.ReaderAt, fileSize, headerOffset, size int64) (*dynamicVHD, error) {
+	header := make([]byte, 1024)
+	_, err := f.ReadAt(header, headerOffset)
+	if err != nil {
+		return nil, err
+	}
+	if string(header[:8]) != "cxsparse" {
+		return nil, errors.New("no VHD dynamic disk header")
+	}
+
+	tableOffset := int64(binary.BigEndian.Uint64(header[16:]))
+	entries := int64(binary.BigEndian.Uint32(header[28:]))
+	blockSize := int64(binary.BigEndian.Uint32(header[32:]))
+	if blockSize == 0 || blockSize%512 != 0 || entries < (size+blockSize-1)/blockSize {
+		return nil, fmt.Errorf("bad VHD block size %d for %d blocks", blockSize, entries)
+	}
+	if tableOffset < 0 || tableOffset > fileSize || entries*4 > fileSize-tableOffset {
+		return nil, fmt.Errorf("VHD block allocation table of %d entries at %d is outside the %d byte file", entries, tableOffset, fileSize)
+	}
+
+	table := make([]byte, entries*4)
+	_, err = f.ReadAt(table, tableOffset)
+	if err != nil {
+		return nil, fmt.Errorf("VHD block allocation table: %s", err)
+	}
+
+	disk := &dynamicVHD{
+		f:         f,
+		size:      size,
+		blockSize: blockSize,
+		bat:       make([]uint32, entries),
+	}
+	for i := range disk.bat {
+		disk.bat[i] = binary.BigEndian.Uint32(table[i*4:])
+	}
+
+	// each block is preceded by a bitmap of its sectors, padded to a
+	// whole sector
+	disk.bitmapSize = (blockSize/512/8 + 511) / 512 * 512
+	return disk, nil
+}
+
+func (d *dynamicVHD) ReadAt(p []byte, off int64) (int, error) {
+	return readBlocks(p, off, d.size, d.blockSize, func(block int64) (int64, bool) {
+		sector := d.bat[block]
+		if sector == vhdUnallocated {
+			return 0, false
+		}
+		return int64(sector)*512 + d.bitmapSize, true
+	}, d.f)
+}
+
+// readBlocks reads from a virtual disk made of fixed size blocks, each of
+// which locate places in f, or reports as absent to read as zeros
+func readBlocks(p []byte, off, size, blockSize int64, locate func(block int64) (int64, bool), f io.ReaderAt) (int, error) {
+	if off >= size {
+		return 0, io.EOF
+	}
+
+	n := 0
+	for n < len(p) && off < size {
+		block := off / blockSize
+		within := off % blockSize
+		chunk := blockSize - within
+		if remaining := int64(len(p) - n); chunk > remaining {
+			chunk = remaining
+		}
+		if chunk > size-off {
+			chunk = size - off
+		}
+
+		buf := p[n : n+int(chunk)]
+		if start, ok := locate(block); ok {
+			_, err := f.ReadAt(buf, start+within)
+			if err != nil && err != io.EOF {
+				return n, err
+			}
+		} else {
+			for i := range buf {
+				buf[i] = 0
+			}
+		}
+		n += int(chunk)
+		off += chunk
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	winacl "github.com/kgoins/go-winacl/pkg"
+)
+
+// VHDX region and metadata item GUIDs
+const (
+	vhdxBATRegion      = "2dc27766-f623-4200-9d64-115e9bfd4a08"
+	vhdxMetadataRegion = "8b7ca206-4790-4b9a-b8fe-575f050f886e"
+
+	vhdxFileParameters    = "caa16737-fa36-4d43-b3b6-33f0aa44e76b"
+	vhdxVirtualDiskSize   = "2fa54224-cd1b-4876-b211-5dbed83bf4b8"
+	vhdxLogicalSectorSize = "8141bf1d-a96f-4709-ba47-f233a8faab5f"
+)
+
+// Limits on a VHDX's geometry, from the VHDX specification
+const (
+	vhdxMinBlockSize = 1 << 20
+	vhdxMaxBlockSize = 256 << 20
+
+	// vhdxMetadataHeaderSize is the size of the metadata table header
+	vhdxMetadataHeaderSize = 32
+
+	// vhdxMaxMetadataSize is as much of the metadata region as is read.
+	// Its table and items are in its first 64 KiB in practice
+	vhdxMaxMetadataSize = 1 << 20
+)
+
+// VHDX payload block states. Partially present blocks only occur in
+// differencing disks
+const (
+	vhdxBlockFullyPresent     = 6
+	vhdxBlockPartiallyPresent = 7
+)
+
+// vhdxDisk reads the virtual disk within a VHDX through its BAT. Blocks
+// that are not present read as zeros. The log is not replayed, so a disk
+// that was not cleanly detached may read as it was before its last writes
+type vhdxDisk struct {
+	f          io.ReaderAt
+	size       int64
+	blockSize  int64
+	chunkRatio int64
+	sectorSize int64
+	bat        []uint64
+}
+
+// newVHDXDisk returns the virtual disk within a VHDX of fileSize bytes.
+// Its regions must lie within the file
+func newVHDXDisk(f io.ReaderAt, fileSize int64) (*vhdxDisk, error) {
+	ident := make([]byte, 8)
+	_, err := f.ReadAt(ident, 0)
+	if err != nil {
+		return nil, err
+	}
+	if string(ident) != "vhdxfile" {
+		return nil, errors.New("no VHDX file identifier")
+	}
+
+	// the two copies of the region table are identical when valid
+	var regions map[string][]uint64
+	for _, offset := range []int64{192 * 1024, 256 * 1024} {
+		regions, err = readVHDXRegionTable(f, offset)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	batRegion, metadataRegion := regions[vhdxBATRegion], regions[vhdxMetadataRegion]
+	if batRegion == nil || metadataRegion == nil {
+		return nil, errors.New("VHDX is missing its BAT or metadata region")
+	}
+	err = checkVHDXRegion("metadata", metadataRegion, vhdxMetadataHeaderSize, fileSize)
+	if err != nil {
+		return nil, err
+	}
+	err = checkVHDXRegion("BAT", batRegion, 8, fileSize)
+	if err != nil {
+		return nil, err
+	}
+
+	disk := &vhdxDisk{f: f}
+	err = disk.readMetadata(int64(metadataRegion[0]), int64(metadataRegion[1]))
+	if err != nil {
+		return nil, err
+	}
+
+	// only the entries of blocks within the virtual disk are read
+	entries := int64(batRegion[1]) / 8
+	if blocks := (disk.size + disk.blockSize - 1) / disk.blockSize; blocks > 0 {
+		if needed := blocks + (blocks-1)/disk.chunkRatio; needed < entries {
+			entries = needed
+		}
+	}
+	table := make([]byte, entries*8)
+	_, err = f.ReadAt(table, int64(batRegion[0]))
+	if err != nil {
+		return nil, fmt.Errorf("VHDX BAT: %s", err)
+	}
+	disk.bat = make([]uint64, len(table)/8)
+	for i := range disk.bat {
+		disk.bat[i] = binary.LittleEndian.Uint64(table[i*8:])
+	}
+	return disk, nil
+}
+
+// checkVHDXRegion rejects a region shorter than minLength, or one that
+// runs past the end of the file
+func checkVHDXRegion(name string, region []uint64, minLength, fileSize int64) error {
+	offset, length := region[0], region[1]
+	if length < uint64(minLength) || offset > uint64(fileSize) || length > uint64(fileSize)-offset {
+		return fmt.Errorf("VHDX %s region of %d bytes at %d is outside the %d byte file", name, length, offset, fileSize)
+	}
+	return nil
+}
+
+// readVHDXRegionTable returns the offset and length of each region
+func readVHDXRegionTable(f io.ReaderAt, offset int64) (map[string][]uint64, error) {
+	table := make([]byte, 64*1024)
+	_, err := f.ReadAt(table, offset)
+	if err != nil {
+		return nil, err
+	}
+	if string(table[:4]) != "regi" {
+		return nil, errors.New("no VHDX region table")
+	}
+
+	count := int(binary.LittleEndian.Uint32(table[8:]))
+	if 16+count*32 > len(table) {
+		return nil, fmt.Errorf("VHDX region table has %d entries", count)
+	}
+	regions := make(map[string][]uint64)
+	for i := 0; i < count; i++ {
+		entry := table[16+i*32:]
+		regions[guidAt(entry)] = []uint64{
+			binary.LittleEndian.Uint64(entry[16:]),
+			uint64(binary.LittleEndian.Uint32(entry[24:])),
+		}
+	}
+	return regions, nil
+}
+
+func (d *vhdxDisk) readMetadata(offset, length int64) error {
+	if length > vhdxMaxMetadataSize {
+		length = vhdxMaxMetadataSize
+	}
+	region := make([]byte, length)
+	_, err := d.f.ReadAt(region, offset)
+	if err != nil {
+		return fmt.Errorf("VHDX metadata: %s", err)
+	}
+	if string(region[:8]) != "metadata" {
+		return errors.New("no VHDX metadata table")
+	}
+
+	items := make(map[string][]byte)
+	count := int(binary.LittleEndian.Uint16(region[10:]))
+	for i := 0; i < count && 32+(i+1)*32 <= len(region); i++ {
+		entry := region[32+i*32:]
+		itemOffset := int(binary.LittleEndian.Uint32(entry[16:]))
+		itemLength := int(binary.LittleEndian.Uint32(entry[20:]))
+		if itemOffset+itemLength <= len(region) {
+			items[guidAt(entry)] = region[itemOffset : itemOffset+itemLength]
+		}
+	}
+
+	parameters, size, sectorSize := items[vhdxFileParameters], items[vhdxVirtualDiskSize], items[vhdxLogicalSectorSize]
+	if len(parameters) < 8 || len(size) < 8 || len(sectorSize) < 4 {
+		return errors.New("VHDX metadata is incomplete")
+	}
+	if binary.LittleEndian.Uint32(parameters[4:])&0x2 != 0 {
+		return errors.New("differencing VHDXs are not supported, scan the parent")
+	}
+
+	d.blockSize = int64(binary.LittleEndian.Uint32(parameters))
+	d.size = int64(binary.LittleEndian.Uint64(size))
+	d.sectorSize = int64(binary.LittleEndian.Uint32(sectorSize))
+	if d.sectorSize != 512 && d.sectorSize != 4096 {
+		return fmt.Errorf("bad VHDX logical sector size %d", d.sectorSize)
+	}
+	if d.blockSize < vhdxMinBlockSize || d.blockSize > vhdxMaxBlockSize || d.blockSize&(d.blockSize-1) != 0 {
+		return fmt.Errorf("bad VHDX block size %d", d.blockSize)
+	}
+	if d.size < 0 {
+		return fmt.Errorf("bad VHDX virtual disk size %d", uint64(d.size))
+	}
+
+	// a sector bitmap entry follows every chunkRatio payload entries
+	d.chunkRatio = (1 << 23) * d.sectorSize / d.blockSize
+	return nil
+}
+
+func (d *vhdxDisk) ReadAt(p []byte, off int64) (int, error) {
+	return readBlocks(p, off, d.size, d.blockSize, func(block int64) (int64, bool) {
+		index := block + block/d.chunkRatio
+		if index >= int64(len(d.bat)) {
+			return 0, false
+		}
+		entry := d.bat[index]
+		switch entry & 0x7 {
+		case vhdxBlockFullyPresent, vhdxBlockPartiallyPresent:
+			return int64(entry>>20) << 20, true
+		}
+		return 0, false
+	}, d.f)
+}
+
+// guidAt reads the little endian GUID at the start of b
+func guidAt(b []byte) string {
+	guid, _ := winacl.NewGUID(bytes.NewBuffer(b[:16]))
+	return guid.String()
+}
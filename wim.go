@@ -0,0 +1,797 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Microsoft/go-winio/wim/lzx"
+	winacl "github.com/kgoins/go-winacl/pkg"
+)
+
+// wimTag starts every WIM and ESD
+const wimTag = "MSWIM\x00\x00\x00"
+
+// WIM header flags
+const (
+	wimFlagCompressed = 0x2
+	wimFlagXPRESS     = 0x20000
+	wimFlagLZX        = 0x40000
+	wimFlagLZMS       = 0x80000
+)
+
+// compression formats, as numbered in solid resource headers
+const (
+	wimFormatNone = iota
+	wimFormatXPRESS
+	wimFormatLZX
+	wimFormatLZMS
+)
+
+// resource header flags
+const (
+	wimResourceMetadata   = 0x2
+	wimResourceCompressed = 0x4
+	wimResourceSolid      = 0x10
+)
+
+const (
+	wimHeaderSize      = 208
+	wimLookupEntrySize = 50
+	wimDentrySize      = 102
+
+	// wimSolidMagic is the original size of the lookup table entries that
+	// describe solid resources, rather than the blobs within them
+	wimSolidMagic = 0x100000000
+
+	wimNoSecurity       = 0xFFFFFFFF
+	wimAttrDirectory    = 0x10
+	wimAttrReparsePoint = 0x400
+	wimDefaultChunkSize = 32768
+	wimMinChunkSize     = 1 << 12
+)
+
+// wimMaxChunkSize is the largest chunk each compression format allows
+var wimMaxChunkSize = map[int]int64{
+	wimFormatXPRESS: 1 << 16,
+	wimFormatLZX:    1 << 21,
+	wimFormatLZMS:   1 << 30,
+}
+
+// checkChunkSize checks a chunk size is a power of two the compression
+// format allows, as every chunk is decompressed whole into memory
+func checkChunkSize(format int, chunkSize int64) error {
+	if chunkSize < wimMinChunkSize || chunkSize > wimMaxChunkSize[format] || chunkSize&(chunkSize-1) != 0 {
+		return fmt.Errorf("chunk size %d is not valid for compression %d", chunkSize, format)
+	}
+	return nil
+}
+
+// wimResource is a resource header: where a resource is in the WIM, and
+// its size before compression
+type wimResource struct {
+	flags        byte
+	size         int64
+	offset       int64
+	originalSize int64
+}
+
+func newWIMResource(b []byte) wimResource {
+	sizeAndFlags := binary.LittleEndian.Uint64(b)
+	return wimResource{
+		flags:        byte(sizeAndFlags >> 56),
+		size:         int64(sizeAndFlags & (1<<56 - 1)),
+		offset:       int64(binary.LittleEndian.Uint64(b[8:])),
+		originalSize: int64(binary.LittleEndian.Uint64(b[16:])),
+	}
+}
+
+// wimArchive is a WIM or ESD, holding one or more images. Files are
+// stored once, as blobs found by their SHA-1 in the lookup table, and
+// each image is a metadata resource of directory entries and security
+// descriptors
+type wimArchive struct {
+	f         io.ReaderAt
+	size      int64
+	format    int
+	chunkSize int64
+	images    []WIMImage
+	metadata  []*io.SectionReader
+	blobs     map[[20]byte]*io.SectionReader
+}
+
+func newWIMArchive(f io.ReaderAt, size int64) (*wimArchive, error) {
+	header := make([]byte, wimHeaderSize)
+	_, err := f.ReadAt(header, 0)
+	if err != nil {
+		return nil, err
+	}
+	if string(header[:8]) != wimTag {
+		return nil, errors.New("no WIM tag")
+	}
+	if parts := binary.LittleEndian.Uint16(header[42:]); parts > 1 {
+		return nil, fmt.Errorf("WIM is split in %d parts, join them first", parts)
+	}
+
+	w := &wimArchive{
+		f:         f,
+		size:      size,
+		chunkSize: int64(binary.LittleEndian.Uint32(header[20:])),
+		blobs:     make(map[[20]byte]*io.SectionReader),
+	}
+	if w.chunkSize == 0 {
+		w.chunkSize = wimDefaultChunkSize
+	}
+	flags := binary.LittleEndian.Uint32(header[16:])
+	if flags&wimFlagCompressed != 0 {
+		switch {
+		case flags&wimFlagXPRESS != 0:
+			w.format = wimFormatXPRESS
+		case flags&wimFlagLZX != 0:
+			w.format = wimFormatLZX
+		case flags&wimFlagLZMS != 0:
+			w.format = wimFormatLZMS
+		default:
+			return nil, fmt.Errorf("WIM compression flags %#x are not supported", flags)
+		}
+		err = checkChunkSize(w.format, w.chunkSize)
+		if err != nil {
+			return nil, fmt.Errorf("WIM %s", err)
+		}
+	}
+
+	err = w.readLookupTable(newWIMResource(header[48:]))
+	if err != nil {
+		return nil, fmt.Errorf("WIM lookup table: %s", err)
+	}
+	err = w.readXML(newWIMResource(header[72:]))
+	if err != nil {
+		return nil, fmt.Errorf("WIM XML data: %s", err)
+	}
+	return w, nil
+}
+
+// readLookupTable finds the blobs and the metadata resource of each image
+func (w *wimArchive) readLookupTable(table wimResource) error {
+	err := w.checkResource(table)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(io.NewSectionReader(w.resource(table), 0, table.originalSize))
+	if err != nil {
+		return err
+	}
+
+	// the blobs of solid resources are addressed within the run of solid
+	// resource entries that precedes them, as if it were one resource
+	type solidBlob struct {
+		entry []byte
+		run   int
+	}
+	var runs [][]*wimChunked
+	var solidBlobs []solidBlob
+	inRun := false
+	for i := 0; i+wimLookupEntrySize <= len(data); i += wimLookupEntrySize {
+		entry := data[i : i+wimLookupEntrySize]
+		resource := newWIMResource(entry)
+		if resource.flags&wimResourceSolid == 0 || resource.originalSize == wimSolidMagic {
+			err := w.checkResource(resource)
+			if err != nil {
+				return err
+			}
+		}
+		switch {
+		case resource.flags&wimResourceSolid != 0 && resource.originalSize == wimSolidMagic:
+			if !inRun {
+				runs = append(runs, nil)
+				inRun = true
+			}
+			solid, err := w.newSolidResource(resource)
+			if err != nil {
+				return err
+			}
+			runs[len(runs)-1] = append(runs[len(runs)-1], solid)
+			continue
+		case resource.flags&wimResourceSolid != 0:
+			run := len(runs) - 1
+			if run < 0 {
+				run = 0
+			}
+			solidBlobs = append(solidBlobs, solidBlob{entry, run})
+		default:
+			w.addBlob(entry, io.NewSectionReader(w.resource(resource), 0, resource.originalSize))
+		}
+		inRun = false
+	}
+
+	for _, blob := range solidBlobs {
+		if blob.run >= len(runs) {
+			return errors.New("blob in a solid resource that does not exist")
+		}
+		resource := newWIMResource(blob.entry)
+		w.addBlob(blob.entry, io.NewSectionReader(newWIMConcat(runs[blob.run]), resource.offset, resource.originalSize))
+	}
+	return nil
+}
+
+func (w *wimArchive) addBlob(entry []byte, blob *io.SectionReader) {
+	if entry[7]&wimResourceMetadata != 0 {
+		w.metadata = append(w.metadata, blob)
+		return
+	}
+	var hash [20]byte
+	copy(hash[:], entry[30:])
+	w.blobs[hash] = blob
+}
+
+// checkResource checks a resource lies within the archive, which bounds
+// the chunk table read from it
+func (w *wimArchive) checkResource(r wimResource) error {
+	if r.offset < 0 || r.offset > w.size || r.size > w.size-r.offset {
+		return fmt.Errorf("resource of %d bytes at %d runs past the end of the archive", r.size, r.offset)
+	}
+	return nil
+}
+
+// resource reads a resource that is stored whole or in compressed chunks
+func (w *wimArchive) resource(r wimResource) io.ReaderAt {
+	if r.flags&wimResourceCompressed == 0 || w.format == wimFormatNone {
+		return io.NewSectionReader(w.f, r.offset, r.originalSize)
+	}
+	return &wimChunked{
+		f:         w.f,
+		offset:    r.offset,
+		stored:    r.size,
+		size:      r.originalSize,
+		chunkSize: w.chunkSize,
+		format:    w.format,
+	}
+}
+
+// newSolidResource reads the header of a solid resource, which gives the
+// size, chunk size and compression of its data
+func (w *wimArchive) newSolidResource(r wimResource) (*wimChunked, error) {
+	if r.size < 16 {
+		return nil, fmt.Errorf("solid resource of %d bytes is shorter than its header", r.size)
+	}
+	header := make([]byte, 16)
+	_, err := w.f.ReadAt(header, r.offset)
+	if err != nil {
+		return nil, fmt.Errorf("solid resource header: %s", err)
+	}
+	solid := &wimChunked{
+		f:         w.f,
+		offset:    r.offset + 16,
+		stored:    r.size - 16,
+		size:      int64(binary.LittleEndian.Uint64(header)),
+		chunkSize: int64(binary.LittleEndian.Uint32(header[8:])),
+		format:    int(binary.LittleEndian.Uint32(header[12:])),
+		solid:     true,
+	}
+	if solid.format == wimFormatNone || solid.format > wimFormatLZMS {
+		return nil, fmt.Errorf("solid resource has compression %d", solid.format)
+	}
+	err = checkChunkSize(solid.format, solid.chunkSize)
+	if err != nil {
+		return nil, fmt.Errorf("solid resource %s", err)
+	}
+	return solid, nil
+}
+
+// WIMImage is the description of an image in a WIM, from its XML data
+type WIMImage struct {
+	Index        int    `xml:"INDEX,attr"`
+	Name         string `xml:"NAME"`
+	Description  string `xml:"DESCRIPTION" json:",omitempty"`
+	Edition      string `xml:"WINDOWS>EDITIONID" json:",omitempty"`
+	Version      string `xml:"-" json:",omitempty"`
+	Architecture string `xml:"-" json:",omitempty"`
+
+	Arch    *int `xml:"WINDOWS>ARCH" json:"-"`
+	Release *struct {
+		Major   int `xml:"MAJOR"`
+		Minor   int `xml:"MINOR"`
+		Build   int `xml:"BUILD"`
+		SPBuild int `xml:"SPBUILD"`
+	} `xml:"WINDOWS>VERSION" json:"-"`
+}
+
+// wimArchitectures names the PROCESSOR_ARCHITECTURE values of images
+var wimArchitectures = map[int]string{
+	0:  "x86",
+	5:  "arm",
+	6:  "ia64",
+	9:  "amd64",
+	12: "arm64",
+}
+
+func (w *wimArchive) readXML(r wimResource) error {
+	err := w.checkResource(r)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(io.NewSectionReader(w.resource(r), 0, r.originalSize))
+	if err != nil {
+		return err
+	}
+	if len(data) >= 2 && data[0] == 0xFF && data[1] == 0xFE {
+		data = data[2:]
+	}
+	var document struct {
+		Images []WIMImage `xml:"IMAGE"`
+	}
+	decoder := xml.NewDecoder(strings.NewReader(utf16String(data)))
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		// the XML declares itself UTF-16, but it is already decoded
+		return input, nil
+	}
+	err = decoder.Decode(&document)
+	if err != nil {
+		return err
+	}
+
+	for _, image := range document.Images {
+		if image.Release != nil {
+			image.Version = fmt.Sprintf("%d.%d.%d.%d", image.Release.Major, image.Release.Minor,
+				image.Release.Build, image.Release.SPBuild)
+		}
+		if image.Arch != nil {
+			image.Architecture = wimArchitectures[*image.Arch]
+		}
+		w.images = append(w.images, image)
+	}
+	sort.Slice(w.images, func(i, j int) bool {
+		return w.images[i].Index < w.images[j].Index
+	})
+	return nil
+}
+
+// image opens an image, numbered from 1, as a file system
+func (w *wimArchive) image(index int) (*wimFS, error) {
+	if index < 1 || index > len(w.metadata) {
+		return nil, fmt.Errorf("WIM has no image %d", index)
+	}
+	metadata, err := io.ReadAll(w.metadata[index-1])
+	if err != nil {
+		return nil, fmt.Errorf("image %d metadata: %s", index, err)
+	}
+
+	fsys := &wimFS{archive: w, metadata: metadata}
+	rootOffset, err := fsys.readSecurity()
+	if err != nil {
+		return nil, fmt.Errorf("image %d security data: %s", index, err)
+	}
+	fsys.root, _, err = fsys.readDentry(rootOffset)
+	if err != nil {
+		return nil, fmt.Errorf("image %d root directory: %s", index, err)
+	}
+	if fsys.root == nil {
+		return nil, fmt.Errorf("image %d has no root directory", index)
+	}
+	fsys.root.name = "/"
+	return fsys, nil
+}
+
+// wimFS is a read-only fs.FS over one image of a WIM. The security
+// descriptors of its files are available from their FileInfo.Sys
+type wimFS struct {
+	archive     *wimArchive
+	metadata    []byte
+	descriptors [][]byte
+	root        *wimDentry
+}
+
+// readSecurity reads the image's security descriptors, which precede
+// its root directory entry
+func (w *wimFS) readSecurity() (int64, error) {
+	if len(w.metadata) < 8 {
+		return 0, errors.New("metadata is truncated")
+	}
+	length := int64(binary.LittleEndian.Uint32(w.metadata))
+	count := int64(binary.LittleEndian.Uint32(w.metadata[4:]))
+	if length < 8 {
+		length = 8
+	}
+	if length > int64(len(w.metadata)) || 8+count*8 > length {
+		return 0, fmt.Errorf("%d descriptors in %d bytes", count, length)
+	}
+
+	offset := 8 + count*8
+	for i := int64(0); i < count; i++ {
+		size := int64(binary.LittleEndian.Uint64(w.metadata[8+i*8:]))
+		if size > length-offset {
+			return 0, fmt.Errorf("descriptor %d overruns the security data", i)
+		}
+		w.descriptors = append(w.descriptors, w.metadata[offset:offset+size])
+		offset += size
+	}
+	return (length + 7) &^ 7, nil
+}
+
+// readDentry parses the directory entry at offset, returning nil at the
+// end of a directory, and the offset of the entry that follows it
+func (w *wimFS) readDentry(offset int64) (*wimDentry, int64, error) {
+	meta := w.metadata
+	if offset < 0 || offset+8 > int64(len(meta)) {
+		return nil, 0, errors.New("directory entry is out of bounds")
+	}
+	length := int64(binary.LittleEndian.Uint64(meta[offset:]))
+	if length == 0 {
+		return nil, 0, nil
+	}
+	if length < wimDentrySize || length > int64(len(meta))-offset {
+		return nil, 0, fmt.Errorf("directory entry of %d bytes", length)
+	}
+	raw := meta[offset : offset+length]
+
+	d := &wimDentry{
+		fsys:       w,
+		attributes: binary.LittleEndian.Uint32(raw[8:]),
+		securityID: binary.LittleEndian.Uint32(raw[12:]),
+		subdir:     int64(binary.LittleEndian.Uint64(raw[16:])),
+		modTime:    filetimeToTime(binary.LittleEndian.Uint64(raw[56:])),
+	}
+	// the hash of a reparse point is that of its reparse data
+	if d.attributes&wimAttrReparsePoint == 0 {
+		copy(d.hash[:], raw[64:])
+	}
+	streams := int(binary.LittleEndian.Uint16(raw[96:]))
+	nameLength := int64(binary.LittleEndian.Uint16(raw[100:]))
+	if wimDentrySize+nameLength > length {
+		return nil, 0, errors.New("directory entry name is out of bounds")
+	}
+	d.name = utf16String(raw[wimDentrySize : wimDentrySize+nameLength])
+
+	// named streams follow the entry. An unnamed one, if any, replaces
+	// the entry's own hash as its data
+	next := (offset + length + 7) &^ 7
+	for i := 0; i < streams; i++ {
+		if next+38 > int64(len(meta)) {
+			return nil, 0, errors.New("stream entry is out of bounds")
+		}
+		streamLength := int64(binary.LittleEndian.Uint64(meta[next:]))
+		if streamLength < 38 {
+			return nil, 0, fmt.Errorf("stream entry of %d bytes", streamLength)
+		}
+		if binary.LittleEndian.Uint16(meta[next+36:]) == 0 {
+			copy(d.hash[:], meta[next+16:])
+		}
+		next = (next + streamLength + 7) &^ 7
+	}
+	return d, next, nil
+}
+
+// children lists the entries of a directory
+func (w *wimFS) children(dir *wimDentry) ([]*wimDentry, error) {
+	var children []*wimDentry
+	if dir.subdir == 0 {
+		return nil, nil
+	}
+	for offset := dir.subdir; ; {
+		child, next, err := w.readDentry(offset)
+		if err != nil {
+			return children, err
+		}
+		if child == nil {
+			return children, nil
+		}
+		children = append(children, child)
+		offset = next
+	}
+}
+
+func (w *wimFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	entry := w.root
+	if name != "." {
+		for _, component := range strings.Split(name, "/") {
+			children, err := w.children(entry)
+			if err != nil {
+				return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+			}
+			// Windows names are case insensitive, but an exact match wins
+			var found *wimDentry
+			for _, child := range children {
+				if child.name == component {
+					found = child
+					break
+				}
+				if found == nil && strings.EqualFold(child.name, component) {
+					found = child
+				}
+			}
+			if found == nil {
+				return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+			}
+			entry = found
+		}
+	}
+
+	if entry.IsDir() {
+		return &wimDir{entry: entry}, nil
+	}
+	blob := entry.blob()
+	if blob == nil {
+		blob = io.NewSectionReader(bytes.NewReader(nil), 0, 0)
+	}
+	return &wimFile{SectionReader: io.NewSectionReader(blob, 0, blob.Size()), entry: entry}, nil
+}
+
+// wimDentry is a file or directory of a WIM image, and its fs.FileInfo
+type wimDentry struct {
+	fsys       *wimFS
+	name       string
+	attributes uint32
+	securityID uint32
+	subdir     int64
+	modTime    time.Time
+	hash       [20]byte
+}
+
+// blob returns the file's data, or nil when it is empty
+func (d *wimDentry) blob() *io.SectionReader {
+	return d.fsys.archive.blobs[d.hash]
+}
+
+func (d *wimDentry) Name() string       { return d.name }
+func (d *wimDentry) ModTime() time.Time { return d.modTime }
+func (d *wimDentry) IsDir() bool        { return d.attributes&wimAttrDirectory != 0 }
+func (d *wimDentry) Sys() interface{}   { return d }
+
+func (d *wimDentry) Size() int64 {
+	if blob := d.blob(); blob != nil && !d.IsDir() {
+		return blob.Size()
+	}
+	return 0
+}
+
+func (d *wimDentry) Mode() fs.FileMode {
+	if d.IsDir() {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+// SecurityDescriptor returns the descriptor the file was captured with
+func (d *wimDentry) SecurityDescriptor() (winacl.NtSecurityDescriptor, error) {
+	if d.securityID == wimNoSecurity || int(d.securityID) >= len(d.fsys.descriptors) {
+		return winacl.NtSecurityDescriptor{}, errNoDescriptor
+	}
+	return winacl.NewNtSecurityDescriptor(d.fsys.descriptors[d.securityID])
+}
+
+type wimFile struct {
+	*io.SectionReader
+	entry *wimDentry
+}
+
+func (f *wimFile) Stat() (fs.FileInfo, error) { return f.entry, nil }
+func (f *wimFile) Close() error               { return nil }
+
+type wimDir struct {
+	entry   *wimDentry
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (d *wimDir) Stat() (fs.FileInfo, error) { return d.entry, nil }
+func (d *wimDir) Close() error               { return nil }
+
+func (d *wimDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.entry.name, Err: errors.New("is a directory")}
+}
+
+func (d *wimDir) ReadDir(count int) ([]fs.DirEntry, error) {
+	if !d.read {
+		children, err := d.entry.fsys.children(d.entry)
+		if err != nil {
+			return nil, err
+		}
+		for _, child := range children {
+			d.entries = append(d.entries, fs.FileInfoToDirEntry(child))
+		}
+		sort.Slice(d.entries, func(i, j int) bool {
+			return d.entries[i].Name() < d.entries[j].Name()
+		})
+		d.read = true
+	}
+	return readDirEntries(&d.entries, count)
+}
+
+// wimChunked reads a compressed resource. Its data is split in chunks
+// that are compressed independently, each stored as is when compressing
+// it would not save space. A chunk table gives the offsets of all but
+// the first chunk of a resource, or the sizes of all chunks of a solid
+// resource. Reads are locked, as a blob may be shared by a file being
+// reported and one abandoned after -timeout
+type wimChunked struct {
+	f         io.ReaderAt
+	offset    int64
+	stored    int64
+	size      int64
+	chunkSize int64
+	format    int
+	solid     bool
+
+	// chunks holds the offset of each chunk, and of the end of the last
+	lock   sync.Mutex
+	chunks []int64
+	cached int64
+	cache  []byte
+}
+
+func (c *wimChunked) readChunkTable() error {
+	count := (c.size + c.chunkSize - 1) / c.chunkSize
+	entrySize := int64(4)
+	entries := count - 1
+	if c.solid {
+		entries = count
+	} else if c.size > 0xFFFFFFFF {
+		entrySize = 8
+	}
+	if entries < 0 || entries*entrySize > c.stored {
+		return errors.New("chunk table is larger than its resource")
+	}
+
+	table := make([]byte, entries*entrySize)
+	_, err := c.f.ReadAt(table, c.offset)
+	if err != nil {
+		return fmt.Errorf("chunk table: %s", err)
+	}
+	start := c.offset + int64(len(table))
+	c.chunks = make([]int64, count+1)
+	for i := int64(0); i < entries; i++ {
+		var value int64
+		if entrySize == 8 {
+			value = int64(binary.LittleEndian.Uint64(table[i*8:]))
+		} else {
+			value = int64(binary.LittleEndian.Uint32(table[i*4:]))
+		}
+		if c.solid {
+			c.chunks[i+1] = c.chunks[i] + value
+		} else {
+			c.chunks[i+1] = value
+		}
+	}
+	if !c.solid {
+		c.chunks[count] = c.stored - int64(len(table))
+	}
+	for i := range c.chunks {
+		c.chunks[i] += start
+	}
+	c.cached = -1
+	return nil
+}
+
+// chunk decompresses a chunk, keeping the last one for the next read
+func (c *wimChunked) chunk(index int64) ([]byte, error) {
+	if c.chunks == nil {
+		err := c.readChunkTable()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if index == c.cached {
+		return c.cache, nil
+	}
+
+	size := c.chunkSize
+	if remaining := c.size - index*c.chunkSize; remaining < size {
+		size = remaining
+	}
+	stored := c.chunks[index+1] - c.chunks[index]
+	if stored <= 0 || stored > size {
+		return nil, fmt.Errorf("chunk %d is %d bytes for %d", index, stored, size)
+	}
+	in := make([]byte, stored)
+	_, err := c.f.ReadAt(in, c.chunks[index])
+	if err != nil {
+		return nil, err
+	}
+
+	out := in
+	if stored < size {
+		out, err = decompressChunk(c.format, in, int(size))
+		if err != nil {
+			return nil, fmt.Errorf("chunk %d: %s", index, err)
+		}
+	}
+	c.cached, c.cache = index, out
+	return out, nil
+}
+
+func (c *wimChunked) ReadAt(p []byte, off int64) (int, error) {
+	if off >= c.size {
+		return 0, io.EOF
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	n := 0
+	for n < len(p) && off < c.size {
+		index := off / c.chunkSize
+		chunk, err := c.chunk(index)
+		if err != nil {
+			return n, err
+		}
+		copied := copy(p[n:], chunk[off-index*c.chunkSize:])
+		n += copied
+		off += int64(copied)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func decompressChunk(format int, in []byte, size int) ([]byte, error) {
+	switch format {
+	case wimFormatXPRESS:
+		return xpressDecompress(in, size)
+	case wimFormatLZX:
+		r, err := lzx.NewReader(bytes.NewReader(in), size)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]byte, size)
+		_, err = io.ReadFull(r, out)
+		return out, err
+	case wimFormatLZMS:
+		return lzmsDecompress(in, size)
+	}
+	return nil, fmt.Errorf("compression %d is not supported", format)
+}
+
+// wimConcat joins a run of solid resources
+type wimConcat struct {
+	parts []*wimChunked
+}
+
+func newWIMConcat(parts []*wimChunked) io.ReaderAt {
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	return &wimConcat{parts: parts}
+}
+
+func (c *wimConcat) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for _, part := range c.parts {
+		if n == len(p) {
+			break
+		}
+		if off >= part.size {
+			off -= part.size
+			continue
+		}
+		read, err := part.ReadAt(p[n:], off)
+		n += read
+		off = 0
+		if err != nil && err != io.EOF {
+			return n, err
+		}
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// filetimeToTime converts 100ns intervals since 1601 to a time
+func filetimeToTime(filetime uint64) time.Time {
+	const epochDifference = 116444736000000000
+	if filetime < epochDifference {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(filetime-epochDifference)*100).UTC()
+}
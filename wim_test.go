@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// The WIMs of testdata are generated by testdata/mkwim.py, each holding
+// the same two images in another compression. Their hashes are of the
+// data the script stored, so each is a known answer for a decompressor
+const (
+	peSHA256     = "a939363feed3acf6732f95cd718a5237f31e955b6e97e903e0f097f1acf9675c"
+	bigDLLSHA256 = "c0ccf4240771bfae14a3d47610f73a823a36300ffa6f9dc86fbe14655b782133"
+	bigDLLSize   = 73204
+
+	// solidSHA256 is of the data of lzms-solid.wim's solid resource, the
+	// five blobs of its images one after the other
+	solidSHA256 = "b2615e5c6977201a466a6f78ac46cee0de35f6e42e7e2cf538cbdd7906c3a8c6"
+	solidSize   = 81954
+)
+
+var wimTests = []struct {
+	image  string
+	format int
+}{
+	{"none.wim.gz", wimFormatNone},
+	{"xpress.wim.gz", wimFormatXPRESS},
+	{"lzms.wim.gz", wimFormatLZMS},
+	{"lzms-solid.wim.gz", wimFormatLZMS},
+}
+
+func openTestWIM(t *testing.T, name string) *wimArchive {
+	t.Helper()
+	f := openTestData(t, name)
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := newWIMArchive(f, info.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestWIMDecompression(t *testing.T) {
+	files := map[string]string{
+		"Windows/System32/kernel32.dll": peSHA256,
+		"Windows/System32/big.dll":      bigDLLSHA256,
+		"Windows/explorer.exe":          peSHA256,
+	}
+	for _, test := range wimTests {
+		t.Run(test.image, func(t *testing.T) {
+			w := openTestWIM(t, test.image)
+			if w.format != test.format {
+				t.Fatalf("got format %d, want %d", w.format, test.format)
+			}
+			fsys, err := w.image(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range files {
+				data, err := fs.ReadFile(fsys, name)
+				if err != nil {
+					t.Fatalf("%s: %s", name, err)
+				}
+				if got := sha256Hex(data); got != want {
+					t.Errorf("%s has SHA-256 %s, want %s", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+// TestWIMChunks decodes the first chunk of big.dll, which is text, on
+// its own, so that a failing decompressor is told from a bad chunk table
+func TestWIMChunks(t *testing.T) {
+	text := strings.Repeat("the quick brown fox jumps over the lazy dog. ", 900)
+	for _, test := range wimTests[1:3] {
+		t.Run(test.image, func(t *testing.T) {
+			w := openTestWIM(t, test.image)
+			entry := lookupEntryOfSize(t, w, bigDLLSize)
+			resource := newWIMResource(entry)
+			if resource.flags&wimResourceCompressed == 0 {
+				t.Fatal("big.dll is not compressed")
+			}
+			chunked := w.resource(resource).(*wimChunked)
+			chunk, err := chunked.chunk(0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored := chunked.chunks[1] - chunked.chunks[0]; stored >= w.chunkSize {
+				t.Fatalf("first chunk is stored in %d bytes, uncompressed", stored)
+			}
+			if int64(len(chunk)) != w.chunkSize || !bytes.Equal(chunk, []byte(text[:w.chunkSize])) {
+				t.Errorf("first chunk is %q", chunk)
+			}
+		})
+	}
+}
+
+func TestWIMSolidResource(t *testing.T) {
+	w := openTestWIM(t, "lzms-solid.wim.gz")
+	solid, err := w.newSolidResource(newWIMResource(lookupEntryOfSize(t, w, wimSolidMagic)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if solid.size != solidSize || solid.chunkSize != 16384 || solid.format != wimFormatLZMS {
+		t.Fatalf("got size %d, chunk size %d and format %d", solid.size, solid.chunkSize, solid.format)
+	}
+
+	// the chunk table gives the size of each of the six chunks, and the
+	// chunks end where the resource does
+	if err := solid.readChunkTable(); err != nil {
+		t.Fatal(err)
+	}
+	if len(solid.chunks) != 7 {
+		t.Fatalf("got %d chunk offsets, want 7", len(solid.chunks))
+	}
+	if end := solid.offset + solid.stored; solid.chunks[6] != end || solid.chunks[0] != solid.offset+6*4 {
+		t.Errorf("chunks span %d to %d, want %d to %d", solid.chunks[0], solid.chunks[6], solid.offset+6*4, end)
+	}
+
+	data, err := io.ReadAll(io.NewSectionReader(solid, 0, solid.size))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sha256Hex(data); got != solidSHA256 {
+		t.Errorf("solid resource has SHA-256 %s, want %s", got, solidSHA256)
+	}
+}
+
+// lookupEntryOfSize finds the entry of the lookup table of a WIM whose
+// resource has an original size
+func lookupEntryOfSize(t *testing.T, w *wimArchive, size int64) []byte {
+	t.Helper()
+	header := make([]byte, wimHeaderSize)
+	if _, err := w.f.ReadAt(header, 0); err != nil {
+		t.Fatal(err)
+	}
+	table := newWIMResource(header[48:])
+	data, err := io.ReadAll(io.NewSectionReader(w.resource(table), 0, table.originalSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+wimLookupEntrySize <= len(data); i += wimLookupEntrySize {
+		entry := data[i : i+wimLookupEntrySize]
+		if newWIMResource(entry).originalSize == size {
+			return entry
+		}
+	}
+	t.Fatalf("no resource of %d bytes", size)
+	return nil
+}
+
+func TestScanWIM(t *testing.T) {
+	want := []string{
+		"/image1/Windows/System32/kernel32.dll",
+		"/image1/Windows/System32/notepad.exe",
+		"/image1/Windows/explorer.exe",
+		"/image2/Windows/System32/ntdll.dll",
+	}
+	for _, test := range wimTests {
+		t.Run(test.image, func(t *testing.T) {
+			r := record(t)
+			if err := scanWIM(openTestData(t, test.image), test.image, 0, false, "/", &selector{maxDepth: -1}); err != nil {
+				t.Fatal(err)
+			}
+			if got := r.paths(); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			if len(r.errors) != 0 {
+				t.Errorf("got errors %v", r.errors)
+			}
+		})
+	}
+}
+
+func TestWIMImages(t *testing.T) {
+	w := openTestWIM(t, "xpress.wim.gz")
+	if len(w.images) != 2 {
+		t.Fatalf("got %d images, want 2", len(w.images))
+	}
+	image := w.images[0]
+	if image.Name != "Windows 10 Pro" || image.Edition != "Professional" ||
+		image.Version != "10.0.19041.1" || image.Architecture != "amd64" {
+		t.Errorf("got %+v", image)
+	}
+}
+
+// TestWIMConcurrentReads reads files that share a blob at once, as a
+// report abandoned after -timeout may while the next is read. Run it
+// with -race
+func TestWIMConcurrentReads(t *testing.T) {
+	data, err := os.ReadFile(openTestData(t, "xpress.wim.gz").Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := newWIMArchive(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := w.image(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for _, name := range []string{"Windows/System32/kernel32.dll", "Windows/System32/notepad.exe", "Windows/explorer.exe"} {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			data, err := fs.ReadFile(fsys, name)
+			if err != nil {
+				t.Error(err)
+			} else if got := sha256Hex(data); got != peSHA256 {
+				t.Errorf("%s has SHA-256 %s, want %s", name, got, peSHA256)
+			}
+		}(name)
+	}
+	wg.Wait()
+}
+
+// TestWIMList lists the images of a WIM in the format chosen
+func TestWIMList(t *testing.T) {
+	record(t)
+	var b bytes.Buffer
+	output = &arrayEncoder{w: &b}
+	if err := scanWIM(openTestData(t, "lzms.wim.gz"), "lzms.wim", 0, true, "/", &selector{maxDepth: -1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := output.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var images []WIMImage
+	if err := json.Unmarshal(b.Bytes(), &images); err != nil {
+		t.Fatalf("%s: %s", err, b.Bytes())
+	}
+	var names []string
+	for _, image := range images {
+		names = append(names, image.Name)
+	}
+	if want := []string{"Windows 10 Pro", "Windows PE"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got images %q, want %q", names, want)
+	}
+}
+
+func TestMalformedWIM(t *testing.T) {
+	// the lookup table of lzms-solid.wim, and its solid resource
+	const lookupTable, solidResource = 16974, 208
+	set32 := func(field int, value uint32) func([]byte) {
+		return func(image []byte) { binary.LittleEndian.PutUint32(image[field:], value) }
+	}
+	set64 := func(field int, value uint64) func([]byte) {
+		return func(image []byte) { binary.LittleEndian.PutUint64(image[field:], value) }
+	}
+
+	tests := []struct {
+		name  string
+		image string
+		patch func(image []byte)
+	}{
+		{"a chunk size that is not a power of two", "xpress.wim.gz", set32(20, 3<<12)},
+		{"an XPRESS chunk size over 64K", "xpress.wim.gz", set32(20, 1<<20)},
+		{"an LZMS chunk size over 1G", "lzms.wim.gz", set32(20, 1<<31)},
+		{"a lookup table past the end", "xpress.wim.gz", set64(56, 1<<40)},
+		{"a negative lookup table offset", "xpress.wim.gz", set64(56, 1<<63)},
+		{"XML data past the end", "xpress.wim.gz", set64(72, 1<<40)},
+		{"a metadata resource past the end", "lzms-solid.wim.gz", set64(lookupTable+6*wimLookupEntrySize+8, 1<<40)},
+		{"a solid resource past the end", "lzms-solid.wim.gz", set64(lookupTable, 0x10<<56|1<<40)},
+		{"a solid resource shorter than its header", "lzms-solid.wim.gz", set64(lookupTable, 0x10<<56|8)},
+		{"a solid chunk size of 0", "lzms-solid.wim.gz", set32(solidResource+8, 0)},
+		{"a solid chunk size over 1G", "lzms-solid.wim.gz", set32(solidResource+8, 1<<31)},
+		{"an uncompressed solid resource", "lzms-solid.wim.gz", set32(solidResource+12, wimFormatNone)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			image := patchedImage(t, test.image, test.patch)
+			info, err := image.Stat()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := newWIMArchive(image, info.Size()); err == nil {
+				t.Error("the WIM was not rejected")
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// errNoXattr is returned when a file lacks an extended attribute, or its
+// filesystem does not support them
+var errNoXattr = errors.New("no such extended attribute")
+
+// getxattr reads an extended attribute of a file, following a final
+// symlink to the file it names
+func getxattr(path, name string) ([]byte, error) {
+	size := 256
+	for {
+		buf := make([]byte, size)
+		n, err := unix.Getxattr(path, name, buf)
+		switch {
+		case err == unix.ERANGE:
+			// the attribute grew, or the buffer was too small; ask for its size
+			n, err = unix.Getxattr(path, name, nil)
+			if err != nil {
+				return nil, translateXattrErr(err)
+			}
+			size = n
+			continue
+		case err != nil:
+			return nil, translateXattrErr(err)
+		}
+		return buf[:n], nil
+	}
+}
+
+func translateXattrErr(err error) error {
+	if err == unix.ENODATA || err == unix.ENOTSUP || err == unix.EOPNOTSUPP {
+		return errNoXattr
+	}
+	return err
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestGetxattrFollowsSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "target.dll")
+	if err := os.WriteFile(target, []byte("MZ"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Setxattr(target, "user.ino", []byte("target"), 0); err != nil {
+		t.Skipf("user xattrs are not supported here: %v", err)
+	}
+	link := filepath.Join(dir, "link.dll")
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := getxattr(link, "user.ino")
+	if err != nil || string(value) != "target" {
+		t.Errorf("got %q and error %v, want the target's attribute", value, err)
+	}
+	if _, err := getxattr(link, "user.missing"); err != errNoXattr {
+		t.Errorf("got error %v for a missing attribute, want errNoXattr", err)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package main
+
+import "errors"
+
+// errNoXattr is returned when a file lacks an extended attribute, or its
+// filesystem does not support them
+var errNoXattr = errors.New("no such extended attribute")
+
+// getxattr is only implemented for Linux, where ntfs-3g and Samba expose
+// Windows security descriptors
+func getxattr(path, name string) ([]byte, error) {
+	return nil, errNoXattr
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// xpressDecompress decodes one chunk of XPRESS Huffman (MS-XCA LZ77 plus
+// Huffman) data of a known decompressed size. The chunk starts with the
+// 4 bit codeword lengths of its 512 symbols: 256 literals, then matches
+// whose symbol holds the log2 of their offset and the length less 3
+func xpressDecompress(in []byte, size int) ([]byte, error) {
+	if len(in) < 256 {
+		return nil, errors.New("XPRESS chunk is shorter than its Huffman table")
+	}
+	lengths := make([]uint8, 512)
+	for i, b := range in[:256] {
+		lengths[2*i] = b & 0xF
+		lengths[2*i+1] = b >> 4
+	}
+	var code huffmanCode
+	code.build(lengths)
+
+	bits := &xpressBits{in: in, pos: 256}
+	bits.fill()
+	bits.fill()
+
+	out := make([]byte, 0, size)
+	for len(out) < size {
+		sym, n, err := code.decode(bits.peek(huffmanMaxLength))
+		if err != nil {
+			return nil, err
+		}
+		bits.consume(n)
+		if sym < 256 {
+			out = append(out, byte(sym))
+			continue
+		}
+
+		// extra length bytes are read from the input after the bits held
+		length := sym & 0xF
+		offsetBits := uint(sym>>4) & 0xF
+		if length == 0xF {
+			length += int(bits.byte())
+			if length == 0xF+0xFF {
+				length = int(bits.uint16())
+				if length < 0xF {
+					return nil, errors.New("XPRESS match length is corrupt")
+				}
+			}
+		}
+		length += 3
+		offset := 1<<offsetBits | int(bits.peek(offsetBits))
+		bits.consume(offsetBits)
+
+		if offset > len(out) || length > size-len(out) {
+			return nil, errors.New("XPRESS match is out of bounds")
+		}
+		for i := 0; i < length; i++ {
+			out = append(out, out[len(out)-offset])
+		}
+	}
+	return out, nil
+}
+
+// xpressBits reads the bits of XPRESS data from 16 bit little endian
+// words, most significant bit first, keeping between 16 and 32 bits
+// loaded so that match length bytes can be read from the words beyond
+type xpressBits struct {
+	in    []byte
+	pos   int
+	value uint64
+	count uint
+}
+
+func (b *xpressBits) fill() {
+	var word uint64
+	if b.pos+2 <= len(b.in) {
+		word = uint64(binary.LittleEndian.Uint16(b.in[b.pos:]))
+	}
+	b.pos += 2
+	b.value |= word << (48 - b.count)
+	b.count += 16
+}
+
+func (b *xpressBits) peek(n uint) uint32 {
+	if n == 0 {
+		return 0
+	}
+	return uint32(b.value >> (64 - n))
+}
+
+func (b *xpressBits) consume(n uint) {
+	b.value <<= n
+	b.count -= n
+	if b.count < 16 {
+		b.fill()
+	}
+}
+
+func (b *xpressBits) byte() byte {
+	if b.pos >= len(b.in) {
+		return 0
+	}
+	b.pos++
+	return b.in[b.pos-1]
+}
+
+func (b *xpressBits) uint16() uint16 {
+	if b.pos+2 > len(b.in) {
+		return 0
+	}
+	b.pos += 2
+	return binary.LittleEndian.Uint16(b.in[b.pos-2:])
+}